@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
@@ -22,6 +23,8 @@ var (
 		manifestsDir   string
 		destinationDir string
 		pullSecretFile string
+		verify         bool
+		verifyTimeout  time.Duration
 	}
 )
 
@@ -30,6 +33,8 @@ func init() {
 	bootstrapCmd.PersistentFlags().StringVar(&bootstrapOpts.destinationDir, "dest-dir", "", "The destination dir where MCC writes the generated machineconfigs and machineconfigpools.")
 	bootstrapCmd.PersistentFlags().StringVar(&bootstrapOpts.manifestsDir, "manifest-dir", "", "The dir where MCC reads the controllerconfig, machineconfigpools and user-defined machineconfigs.")
 	bootstrapCmd.PersistentFlags().StringVar(&bootstrapOpts.pullSecretFile, "pull-secret", "", "The pull secret file.")
+	bootstrapCmd.PersistentFlags().BoolVar(&bootstrapOpts.verify, "verify", false, "Re-render the manifests with the in-cluster controllers and fail if any pool's rendered config differs from the bootstrap one.")
+	bootstrapCmd.PersistentFlags().DurationVar(&bootstrapOpts.verifyTimeout, "verify-timeout", 3*time.Minute, "How long --verify waits for the in-cluster controllers to render the pools.")
 }
 
 func runbootstrapCmd(cmd *cobra.Command, args []string) {
@@ -43,7 +48,23 @@ func runbootstrapCmd(cmd *cobra.Command, args []string) {
 		glog.Fatalf("--dest-dir or --manifest-dir not set")
 	}
 
-	if err := bootstrap.New(rootOpts.templates, bootstrapOpts.manifestsDir, bootstrapOpts.pullSecretFile).Run(bootstrapOpts.destinationDir); err != nil {
+	b := bootstrap.New(rootOpts.templates, bootstrapOpts.manifestsDir, bootstrapOpts.pullSecretFile)
+	if err := b.Run(bootstrapOpts.destinationDir); err != nil {
 		glog.Fatalf("error running MCC[BOOTSTRAP]: %v", err)
 	}
+
+	if !bootstrapOpts.verify {
+		return
+	}
+	diffs, err := b.Verify(bootstrapOpts.destinationDir, bootstrapOpts.verifyTimeout)
+	if err != nil {
+		glog.Fatalf("error verifying MCC[BOOTSTRAP]: %v", err)
+	}
+	if len(diffs) > 0 {
+		for _, diff := range diffs {
+			glog.Errorf("Rendered config mismatch: %s", diff.String())
+		}
+		glog.Fatalf("bootstrap rendered configs differ from the in-cluster render for %d pool(s); nodes would reboot into the in-cluster config after joining", len(diffs))
+	}
+	glog.Info("Bootstrap rendered configs match the in-cluster render")
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	provenanceCmd = &cobra.Command{
+		Use:   "provenance [path|unit|karg]...",
+		Short: "Show which MachineConfig sets a file, unit or kernel argument in a pool",
+		Long: `Looks up the provenance recorded on the rendered MachineConfig targeted by a pool.
+Items are file, directory or link paths (/etc/foo), unit names (crio.service),
+unit dropins (<unit>/<dropin>) or kernel argument keys (hugepages). Without
+items, the provenance of everything in the rendered config is printed.`,
+		Run: runProvenanceCmd,
+	}
+
+	provenanceOpts struct {
+		kubeconfig string
+		pool       string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(provenanceCmd)
+	provenanceCmd.PersistentFlags().StringVar(&provenanceOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster")
+	provenanceCmd.PersistentFlags().StringVar(&provenanceOpts.pool, "pool", ctrlcommon.MachineConfigPoolWorker, "MachineConfigPool to query")
+}
+
+func runProvenanceCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	if err := printProvenance(provenanceOpts.kubeconfig, provenanceOpts.pool, args); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printProvenance(kubeconfig, poolName string, items []string) error {
+	cb, err := clients.NewBuilder(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("creating clients: %w", err)
+	}
+	client := cb.MachineConfigClientOrDie(componentName)
+
+	pool, err := client.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), poolName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	rendered := pool.Spec.Configuration.Name
+	if rendered == "" {
+		return fmt.Errorf("pool %s has no rendered config yet", poolName)
+	}
+	mc, err := client.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), rendered, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	provenance, err := ctrlcommon.GetProvenance(mc)
+	if err != nil {
+		return err
+	}
+	if provenance == nil {
+		return fmt.Errorf("%s has no provenance recorded, it was rendered by an older controller", rendered)
+	}
+
+	fmt.Printf("Pool %s, rendered config %s\n", poolName, rendered)
+	if len(items) == 0 {
+		for _, section := range []struct {
+			name    string
+			entries map[string]ctrlcommon.ProvenanceEntry
+		}{
+			{"Paths", provenance.Paths},
+			{"Units", provenance.Units},
+			{"Kernel arguments", provenance.KernelArguments},
+		} {
+			if len(section.entries) == 0 {
+				continue
+			}
+			fmt.Printf("%s:\n", section.name)
+			keys := []string{}
+			for k := range section.entries {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Printf("  %s\n", formatProvenanceEntry(k, section.entries[k]))
+			}
+		}
+		return nil
+	}
+
+	missing := []string{}
+	for _, item := range items {
+		entry, ok := provenance.Lookup(item)
+		if !ok {
+			missing = append(missing, item)
+			continue
+		}
+		fmt.Println(formatProvenanceEntry(item, entry))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not set by any MachineConfig in pool %s: %s", poolName, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func formatProvenanceEntry(item string, entry ctrlcommon.ProvenanceEntry) string {
+	verb := "set by"
+	if entry.Deleted {
+		verb = "deleted by"
+	}
+	s := fmt.Sprintf("%s: %s %s", item, verb, entry.Source)
+	if len(entry.Overridden) > 0 {
+		s += fmt.Sprintf(" (overrides %s)", strings.Join(entry.Overridden, ", "))
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	bundleCmd = &cobra.Command{
+		Use:   "bundle RENDERED-CONFIG",
+		Short: "Write an offline bundle of a rendered MachineConfig",
+		Long: `Writes the named rendered config, its OS image and, if it has extensions or
+the realtime kernel, its extensions container as OCI archives to --dir. The
+bundle is applied without access to any registry with
+"machine-config-daemon start --once-from <dir>". Creating it needs skopeo and
+access to the cluster and to the registries of the images.`,
+		Args: cobra.ExactArgs(1),
+		Run:  runBundleCmd,
+	}
+
+	bundleOpts struct {
+		kubeconfig string
+		dir        string
+		authFile   string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.PersistentFlags().StringVar(&bundleOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster")
+	bundleCmd.PersistentFlags().StringVar(&bundleOpts.dir, "dir", "", "Directory to write the bundle to")
+	bundleCmd.PersistentFlags().StringVar(&bundleOpts.authFile, "authfile", "", "Pull secret of the images, by default the kubelet's if present")
+}
+
+func runBundleCmd(cmd *cobra.Command, args []string) {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if err := writeBundle(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func writeBundle(name string) error {
+	if bundleOpts.dir == "" {
+		return fmt.Errorf("--dir is required")
+	}
+	cb, err := clients.NewBuilder(bundleOpts.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("creating clients: %w", err)
+	}
+	mc, err := cb.MachineConfigClientOrDie(componentName).MachineconfigurationV1().MachineConfigs().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return daemon.CreateOfflineBundle(mc, bundleOpts.dir, bundleOpts.authFile)
+}
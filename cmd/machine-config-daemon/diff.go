@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	diffCmd = &cobra.Command{
+		Use:   "diff RENDERED-CONFIG",
+		Short: "Show the changes updating the local node to a rendered MachineConfig makes",
+		Long: `Prints the files, units, kernel arguments, extensions, OS image and kernel
+type that change between the config on disk of the node and the named rendered
+config, along with the actions the daemon takes to apply them. It must run on
+the node, e.g. in the daemon pod, with the host filesystem mounted at --root-mount.`,
+		Args: cobra.ExactArgs(1),
+		Run:  runDiffCmd,
+	}
+
+	diffOpts struct {
+		kubeconfig string
+		rootMount  string
+		output     string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.PersistentFlags().StringVar(&diffOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster")
+	diffCmd.PersistentFlags().StringVar(&diffOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted")
+	diffCmd.PersistentFlags().StringVarP(&diffOpts.output, "output", "o", "json", "Output format, one of json or yaml")
+}
+
+func runDiffCmd(cmd *cobra.Command, args []string) {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if err := printDiff(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printDiff(name string) error {
+	// Read from the cluster before the chroot hides the service account
+	cb, err := clients.NewBuilder(diffOpts.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("creating clients: %w", err)
+	}
+	mc, err := cb.MachineConfigClientOrDie(componentName).MachineconfigurationV1().MachineConfigs().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	dn, err := newLocalDaemon(diffOpts.rootMount)
+	if err != nil {
+		return err
+	}
+	diff, err := dn.DiffConfigOnDisk(mc)
+	if err != nil {
+		return err
+	}
+	return printObject(diff, diffOpts.output)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "Show the history of MachineConfig updates of a node",
+		Long: `Prints the updates recorded by the daemon, oldest first. Without --node the
+history is read from the host filesystem mounted at --root-mount, e.g. when run
+in the daemon pod. With --node it is read from the node's annotation.`,
+		Args: cobra.NoArgs,
+		Run:  runHistoryCmd,
+	}
+
+	historyOpts struct {
+		kubeconfig string
+		nodeName   string
+		rootMount  string
+		output     string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.PersistentFlags().StringVar(&historyOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster")
+	historyCmd.PersistentFlags().StringVar(&historyOpts.nodeName, "node", "", "Read the history published on this node instead of the local one")
+	historyCmd.PersistentFlags().StringVar(&historyOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted")
+	historyCmd.PersistentFlags().StringVarP(&historyOpts.output, "output", "o", "table", "Output format, one of table or json")
+}
+
+func runHistoryCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	if err := printHistory(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printHistory() error {
+	var history []daemon.UpdateHistoryEntry
+	var err error
+	if historyOpts.nodeName != "" {
+		cb, err := clients.NewBuilder(historyOpts.kubeconfig)
+		if err != nil {
+			return fmt.Errorf("creating clients: %w", err)
+		}
+		node, err := cb.KubeClientOrDie(componentName).CoreV1().Nodes().Get(context.TODO(), historyOpts.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		history, err = daemon.GetNodeUpdateHistory(node)
+		if err != nil {
+			return err
+		}
+	} else {
+		history, err = daemon.ReadUpdateHistory(filepath.Join(historyOpts.rootMount, daemon.UpdateHistoryPath))
+		if err != nil {
+			return err
+		}
+	}
+	if history == nil {
+		history = []daemon.UpdateHistoryEntry{}
+	}
+
+	switch historyOpts.output {
+	case "json":
+		out, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "STARTED\tFROM\tTO\tACTIONS\tSTAGE\tDRAIN\tREBOOT\tDURATION\tOUTCOME\tMESSAGE")
+		for _, e := range history {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.StartTime.UTC().Format("2006-01-02T15:04:05Z"), e.FromConfig, e.ToConfig, formatActions(e.Actions),
+				formatDuration(e.StageDuration), formatDuration(e.DrainDuration), formatDuration(e.RebootDuration), formatElapsed(e), formatOutcome(e), e.Message)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", historyOpts.output)
+	}
+}
+
+func formatActions(actions []string) string {
+	if len(actions) == 0 {
+		return "-"
+	}
+	return strings.Join(actions, ",")
+}
+
+func formatDuration(d *metav1.Duration) string {
+	if d == nil {
+		return "-"
+	}
+	return d.Duration.String()
+}
+
+func formatElapsed(e daemon.UpdateHistoryEntry) string {
+	if e.EndTime == nil {
+		return "-"
+	}
+	return e.EndTime.Sub(e.StartTime.Time).String()
+}
+
+func formatOutcome(e daemon.UpdateHistoryEntry) string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("%s (%d attempts)", e.Outcome, e.Attempts)
+	}
+	return string(e.Outcome)
+}
@@ -38,6 +38,7 @@ var (
 		hypershiftDesiredConfigMap string
 		onceFrom                   string
 		skipReboot                 bool
+		allowUnsignedBundle        bool
 		fromIgnition               bool
 		kubeletHealthzEnabled      bool
 		kubeletHealthzEndpoint     string
@@ -51,8 +52,9 @@ func init() {
 	startCmd.PersistentFlags().StringVar(&startOpts.nodeName, "node-name", "", "kubernetes node name daemon is managing.")
 	startCmd.PersistentFlags().StringVar(&startOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted for chroot and file manipulation.")
 	startCmd.PersistentFlags().StringVar(&startOpts.hypershiftDesiredConfigMap, "desired-configmap", "", "Runs the daemon for a Hypershift hosted cluster node. Requires a configmap with desired config as input.")
-	startCmd.PersistentFlags().StringVar(&startOpts.onceFrom, "once-from", "", "Runs the daemon once using a provided file path or URL endpoint as its machine config or ignition (.ign) file source")
+	startCmd.PersistentFlags().StringVar(&startOpts.onceFrom, "once-from", "", "Runs the daemon once using a provided file path or URL endpoint as its machine config or ignition (.ign) file source, or an offline bundle directory")
 	startCmd.PersistentFlags().BoolVar(&startOpts.skipReboot, "skip-reboot", false, "Skips reboot after a sync, applies only in once-from")
+	startCmd.PersistentFlags().BoolVar(&startOpts.allowUnsignedBundle, "allow-unsigned-bundle", false, "Applies an offline bundle whose config requires signed OS images, whose signatures can't be checked offline")
 	startCmd.PersistentFlags().BoolVar(&startOpts.kubeletHealthzEnabled, "kubelet-healthz-enabled", true, "kubelet healthz endpoint monitoring")
 	startCmd.PersistentFlags().StringVar(&startOpts.kubeletHealthzEndpoint, "kubelet-healthz-endpoint", "http://localhost:10248/healthz", "healthz endpoint to check health")
 	startCmd.PersistentFlags().StringVar(&startOpts.promMetricsURL, "metrics-url", "127.0.0.1:8797", "URL for prometheus metrics listener")
@@ -157,7 +159,7 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 	// If we are asked to run once and it's a valid file system path use
 	// the bare Daemon
 	if startOpts.onceFrom != "" {
-		err = dn.RunOnceFrom(startOpts.onceFrom, startOpts.skipReboot)
+		err = dn.RunOnceFrom(startOpts.onceFrom, startOpts.skipReboot, startOpts.allowUnsignedBundle)
 		if err != nil {
 			glog.Fatalf("%v", err)
 		}
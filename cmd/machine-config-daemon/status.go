@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/ghodss/yaml"
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the MachineConfig state of the local node",
+		Long: `Prints the booted and staged deployments, the current, desired and pending
+configs, the state and reason and whether the on-disk state drifted from the
+current config. It must run on the node, e.g. in the daemon pod, with the host
+filesystem mounted at --root-mount. The annotations are read from the node
+named by --node-name or $NODE_NAME, if any.`,
+		Args: cobra.NoArgs,
+		Run:  runStatusCmd,
+	}
+
+	statusOpts struct {
+		kubeconfig string
+		nodeName   string
+		rootMount  string
+		output     string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.PersistentFlags().StringVar(&statusOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster")
+	statusCmd.PersistentFlags().StringVar(&statusOpts.nodeName, "node-name", "", "kubernetes node name to read the annotations of")
+	statusCmd.PersistentFlags().StringVar(&statusOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted")
+	statusCmd.PersistentFlags().StringVarP(&statusOpts.output, "output", "o", "json", "Output format, one of json or yaml")
+}
+
+func runStatusCmd(cmd *cobra.Command, args []string) {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if err := printStatus(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printStatus() error {
+	if statusOpts.nodeName == "" {
+		statusOpts.nodeName = os.Getenv("NODE_NAME")
+	}
+	// Read from the cluster before the chroot hides the service account
+	var node *corev1.Node
+	if statusOpts.nodeName != "" {
+		cb, err := clients.NewBuilder(statusOpts.kubeconfig)
+		if err != nil {
+			return fmt.Errorf("creating clients: %w", err)
+		}
+		node, err = cb.KubeClientOrDie(componentName).CoreV1().Nodes().Get(context.TODO(), statusOpts.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	dn, err := newLocalDaemon(statusOpts.rootMount)
+	if err != nil {
+		return err
+	}
+	status, err := dn.GetNodeStatus(node)
+	if err != nil {
+		return err
+	}
+	return printObject(status, statusOpts.output)
+}
+
+// newLocalDaemon chroots into the host filesystem mounted at rootMount and
+// returns a daemon that reads the state of the host.
+func newLocalDaemon(rootMount string) (*daemon.Daemon, error) {
+	if err := syscall.Chroot(rootMount); err != nil {
+		return nil, fmt.Errorf("unable to chroot to %s: %w", rootMount, err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return nil, fmt.Errorf("unable to change directory to /: %w", err)
+	}
+	return daemon.New(daemon.NewNodeUpdaterClient(), nil)
+}
+
+// printObject prints obj to stdout as json or yaml.
+func printObject(obj interface{}, output string) error {
+	var out []byte
+	var err error
+	switch output {
+	case "json":
+		out, err = json.MarshalIndent(obj, "", "  ")
+		out = append(out, '\n')
+	case "yaml":
+		out, err = yaml.Marshal(obj)
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 
 	"github.com/golang/glog"
 	"github.com/openshift/machine-config-operator/pkg/server"
@@ -18,8 +19,10 @@ var (
 	}
 
 	bootstrapOpts struct {
-		serverBaseDir    string
-		serverKubeConfig string
+		serverBaseDir        string
+		serverKubeConfig     string
+		pools                []string
+		metricsListenAddress string
 	}
 )
 
@@ -27,6 +30,8 @@ func init() {
 	rootCmd.AddCommand(bootstrapCmd)
 	bootstrapCmd.PersistentFlags().StringVar(&bootstrapOpts.serverBaseDir, "server-basedir", "/etc/mcs/bootstrap", "base directory on the host, relative to which machine-configs and pools can be found.")
 	bootstrapCmd.PersistentFlags().StringVar(&bootstrapOpts.serverKubeConfig, "bootstrap-kubeconfig", "/etc/kubernetes/kubeconfig", "path to bootstrap kubeconfig served by the bootstrap server.")
+	bootstrapCmd.PersistentFlags().StringSliceVar(&bootstrapOpts.pools, "pools", []string{"master"}, fmt.Sprintf("pools the bootstrap server may serve, if rendered in the base directory; %q allows every rendered pool.", server.AllBootstrapPools))
+	bootstrapCmd.PersistentFlags().StringVar(&bootstrapOpts.metricsListenAddress, "metrics-listen-address", "127.0.0.1:8797", "Listen address for prometheus metrics listener")
 }
 
 func runBootstrapCmd(cmd *cobra.Command, args []string) {
@@ -36,7 +41,7 @@ func runBootstrapCmd(cmd *cobra.Command, args []string) {
 	// To help debugging, immediately log version
 	glog.Infof("Version: %+v (%s)", version.Raw, version.Hash)
 
-	bs, err := server.NewBootstrapServer(bootstrapOpts.serverBaseDir, bootstrapOpts.serverKubeConfig)
+	bs, err := server.NewBootstrapServer(bootstrapOpts.serverBaseDir, bootstrapOpts.serverKubeConfig, bootstrapOpts.pools)
 
 	if err != nil {
 		glog.Exitf("Machine Config Server exited with error: %v", err)
@@ -47,6 +52,7 @@ func runBootstrapCmd(cmd *cobra.Command, args []string) {
 	insecureServer := server.NewAPIServer(apiHandler, rootOpts.isport, true, "", "")
 
 	stopCh := make(chan struct{})
+	go server.StartMetricsListener(bootstrapOpts.metricsListenAddress, stopCh)
 	go secureServer.Serve()
 	go insecureServer.Serve()
 	<-stopCh
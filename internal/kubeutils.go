@@ -18,8 +18,8 @@ import (
 // It will attempt to update the node by applying f to it up to DefaultBackoff
 // number of times.
 // f will be called each time since the node object will likely have changed if
-// a retry is necessary.
-func UpdateNodeRetry(client corev1client.NodeInterface, lister corev1lister.NodeLister, nodeName string, f func(*corev1.Node)) (*corev1.Node, error) {
+// a retry is necessary. The patch is sent as fieldManager.
+func UpdateNodeRetry(client corev1client.NodeInterface, lister corev1lister.NodeLister, nodeName, fieldManager string, f func(*corev1.Node)) (*corev1.Node, error) {
 	var node *corev1.Node
 	if err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		n, err := lister.Get(nodeName)
@@ -44,7 +44,7 @@ func UpdateNodeRetry(client corev1client.NodeInterface, lister corev1lister.Node
 			return fmt.Errorf("failed to create patch for node %q: %w", nodeName, err)
 		}
 
-		node, err = client.Patch(context.TODO(), nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+		node, err = client.Patch(context.TODO(), nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{FieldManager: fieldManager})
 		return err
 	}); err != nil {
 		// may be conflict if max retries were hit
@@ -52,3 +52,21 @@ func UpdateNodeRetry(client corev1client.NodeInterface, lister corev1lister.Node
 	}
 	return node, nil
 }
+
+// PatchNodeAnnotations sets annotations on a node with a strategic merge patch
+// that only names those annotations, sent as fieldManager. Unlike UpdateNodeRetry
+// it doesn't read the node first, so it can't overwrite concurrent changes to
+// the rest of the node and never has to retry on conflicts.
+func PatchNodeAnnotations(client corev1client.NodeInterface, nodeName, fieldManager string, annotations map[string]string) (*corev1.Node, error) {
+	patchBytes, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch for node %q: %w", nodeName, err)
+	}
+	node, err := client.Patch(context.TODO(), nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return nil, fmt.Errorf("unable to patch annotations of node %q: %w", nodeName, err)
+	}
+	return node, nil
+}
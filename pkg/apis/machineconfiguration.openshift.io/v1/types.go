@@ -205,10 +205,23 @@ type MachineConfigSpec struct {
 
 	// +nullable
 	KernelArguments []string `json:"kernelArguments"`
-	Extensions      []string `json:"extensions"`
+	// KernelArgumentsToDelete lists kernel arguments to remove from the
+	// node. An entry of the form key=value removes that exact argument,
+	// while a bare key removes every argument with that key. Entries
+	// apply to the arguments of MachineConfigs sorted before this one as
+	// well as to the arguments already present on the booted system.
+	// +optional
+	KernelArgumentsToDelete []string `json:"kernelArgumentsToDelete,omitempty"`
+	Extensions              []string `json:"extensions"`
 
 	FIPS       bool   `json:"fips"`
 	KernelType string `json:"kernelType"`
+
+	// BaseOSExtensionsContainerImage is the extensions container matching
+	// the OS image. Nodes running a layered OS image install extensions and
+	// switch kernels from it. It is set on rendered MachineConfigs only.
+	// +optional
+	BaseOSExtensionsContainerImage string `json:"baseOSExtensionsContainerImage,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -245,6 +258,12 @@ type MachineConfigPoolSpec struct {
 	// nodeSelector specifies a label selector for Machines
 	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
 
+	// parent is the name of a pool whose MachineConfigs this pool inherits, in addition to the
+	// ones selected by machineConfigSelector. Parents may have parents of their own, but may not
+	// form a cycle. Inherited and own MachineConfigs are merged in the usual order of their names.
+	// +optional
+	Parent string `json:"parent,omitempty"`
+
 	// paused specifies whether or not changes to this machine config pool should be stopped.
 	// This includes generating new desiredMachineConfig and update of machines.
 	Paused bool `json:"paused"`
@@ -262,10 +281,240 @@ type MachineConfigPoolSpec struct {
 	// maxUnavailable is greater than one.
 	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
 
+	// osImageVerification is the policy the OS image of the pool must satisfy before
+	// a rendered config is generated and before a node rebases to it. Pools without
+	// a policy inherit the policy of their parent; without any, OS images are not verified.
+	// +optional
+	OSImageVerification *OSImageVerification `json:"osImageVerification,omitempty"`
+
+	// kernelArgumentTuning lists the kernel arguments that may be tuned on individual
+	// nodes of the pool through their machineconfiguration.openshift.io/kernelArgumentTuning
+	// annotation. Pools without it inherit the setting of their parent; without any,
+	// only nosmt can be tuned on RHCOS, and any argument on FCOS.
+	// +optional
+	KernelArgumentTuning *KernelArgumentTuning `json:"kernelArgumentTuning,omitempty"`
+
+	// ioTuning is the I/O tuning of the nodes of the pool. Only the block device holding
+	// the root filesystem is tuned, other block devices are left alone. Pools without it
+	// inherit the tuning of their parent; without any, control plane nodes switch their
+	// root device to the bfq scheduler during OS updates and enable ostree per-object
+	// fsync, and other nodes aren't tuned.
+	// +optional
+	IOTuning *IOTuning `json:"ioTuning,omitempty"`
+
+	// interactiveAccessPolicy is what happens to nodes of the pool after an interactive
+	// login, e.g. over SSH. Pools without it inherit the policy of their parent; without
+	// any, logins are only recorded.
+	// +optional
+	InteractiveAccessPolicy *InteractiveAccessPolicy `json:"interactiveAccessPolicy,omitempty"`
+
+	// packageOS maps the OS image, extensions and kernel type of the pool to packages
+	// for its nodes that aren't CoreOS variants, e.g. RHEL workers, which install them
+	// with dnf. Pools without it inherit the mapping of their parent; without any, the
+	// OS packages of such nodes are left alone.
+	// +optional
+	PackageOS *PackageOS `json:"packageOS,omitempty"`
+
 	// The targeted MachineConfig object for the machine config pool.
 	Configuration MachineConfigPoolStatusConfiguration `json:"configuration"`
 }
 
+// OSImageVerificationPolicyType is how OS images are verified.
+type OSImageVerificationPolicyType string
+
+const (
+	// OSImageVerificationUnverified accepts any OS image. It can be used to opt a
+	// pool out of the policy of its parent.
+	OSImageVerificationUnverified OSImageVerificationPolicyType = "Unverified"
+	// OSImageVerificationDigestPinned requires OS images to be referenced by digest,
+	// and the digest to be one of allowedDigests if any are listed.
+	OSImageVerificationDigestPinned OSImageVerificationPolicyType = "DigestPinned"
+	// OSImageVerificationSigned requires OS images to be referenced by digest and
+	// signed by one of keys.
+	OSImageVerificationSigned OSImageVerificationPolicyType = "Signed"
+)
+
+// OSImageVerificationKeyType is the kind of key OS image signatures are checked with.
+type OSImageVerificationKeyType string
+
+const (
+	// OSImageVerificationKeyGPG keys check simple signing signatures, as found
+	// through the registries.d lookaside configuration of the node.
+	OSImageVerificationKeyGPG OSImageVerificationKeyType = "GPG"
+	// OSImageVerificationKeySigstore keys check sigstore signatures, as found
+	// through the registries.d sigstore attachment configuration of the node.
+	OSImageVerificationKeySigstore OSImageVerificationKeyType = "Sigstore"
+)
+
+// OSImageVerification is a verification policy for OS images.
+type OSImageVerification struct {
+	// policy is how OS images are verified.
+	Policy OSImageVerificationPolicyType `json:"policy"`
+
+	// allowedDigests are the only digests OS images may have under the DigestPinned
+	// policy, e.g. sha256:<hex>. If empty, any digest is allowed.
+	// +optional
+	AllowedDigests []string `json:"allowedDigests,omitempty"`
+
+	// keys are the keys OS images may be signed with under the Signed policy.
+	// A valid signature from any one of them is enough.
+	// +optional
+	Keys []OSImageVerificationKey `json:"keys,omitempty"`
+}
+
+// OSImageVerificationKey is a public key OS image signatures are checked with.
+type OSImageVerificationKey struct {
+	// type is the kind of key.
+	Type OSImageVerificationKeyType `json:"type"`
+
+	// publicKey is an ASCII-armored GPG public keyring for GPG keys, or a PEM
+	// encoded public key for Sigstore keys.
+	PublicKey string `json:"publicKey"`
+}
+
+// KernelArgumentTuning is an allowlist of kernel arguments that can be tuned per node.
+type KernelArgumentTuning struct {
+	// allowedArguments are the kernel arguments nodes may add or delete. An entry
+	// of the form key=value allows only that exact argument, while a bare key
+	// allows the key on its own as well as with any value.
+	AllowedArguments []string `json:"allowedArguments"`
+}
+
+// BlockDeviceClass is a kind of block device.
+type BlockDeviceClass string
+
+const (
+	// BlockDeviceClassNVMe is an NVMe device.
+	BlockDeviceClassNVMe BlockDeviceClass = "NVMe"
+	// BlockDeviceClassSSD is a non-rotational device other than NVMe.
+	BlockDeviceClassSSD BlockDeviceClass = "SSD"
+	// BlockDeviceClassHDD is a rotational device.
+	BlockDeviceClassHDD BlockDeviceClass = "HDD"
+)
+
+// IOTuning is the I/O tuning of the root block device and ostree repository of nodes.
+// It is applied whenever the daemon starts, as the block device settings don't persist
+// across reboots, and when it changes. Block devices other than the one holding the
+// root filesystem, e.g. those of local volumes, are not tuned.
+type IOTuning struct {
+	// schedulers are the I/O schedulers to use for the root block device, by device
+	// class. The first entry matching the class of the device applies; if none does,
+	// the scheduler is left alone. The schedulers of other block devices are never changed.
+	// +optional
+	Schedulers []IOScheduler `json:"schedulers,omitempty"`
+
+	// queueDepth is the number of requests the root block device queue holds,
+	// i.e. its nr_requests. If unset, it is left alone.
+	// +optional
+	QueueDepth *int32 `json:"queueDepth,omitempty"`
+
+	// ostree are the fsync settings of the ostree repository.
+	// +optional
+	Ostree *OstreeFsync `json:"ostree,omitempty"`
+}
+
+// IOScheduler is the I/O scheduler for the root block device of a class.
+type IOScheduler struct {
+	// deviceClass is the class of root device the scheduler is for. If empty, the
+	// scheduler is for a root device of any class.
+	// +optional
+	DeviceClass BlockDeviceClass `json:"deviceClass,omitempty"`
+
+	// scheduler is the name of the I/O scheduler, e.g. bfq, mq-deadline or none.
+	Scheduler string `json:"scheduler"`
+}
+
+// OstreeFsync are fsync settings of the ostree repository. Unset settings are left alone.
+type OstreeFsync struct {
+	// fsync sets core.fsync, whether ostree syncs writes to disk at all.
+	// +optional
+	Fsync *bool `json:"fsync,omitempty"`
+
+	// perObjectFsync sets core.per-object-fsync, whether ostree syncs each object
+	// as it is written rather than all at the end, which avoids latency spikes for etcd.
+	// +optional
+	PerObjectFsync *bool `json:"perObjectFsync,omitempty"`
+}
+
+// InteractiveAccessAction is what happens to a node after an interactive login.
+type InteractiveAccessAction string
+
+const (
+	// InteractiveAccessActionNone only records logins. It can be used to opt a pool
+	// out of the policy of its parent.
+	InteractiveAccessActionNone InteractiveAccessAction = "None"
+	// InteractiveAccessActionRevalidate checks the on-disk state of the node against
+	// its config after each login, and degrades the node if it drifted.
+	InteractiveAccessActionRevalidate InteractiveAccessAction = "Revalidate"
+	// InteractiveAccessActionReprovision marks the node for re-provisioning after a
+	// login, through its machineconfiguration.openshift.io/reprovisionRequested annotation.
+	InteractiveAccessActionReprovision InteractiveAccessAction = "Reprovision"
+)
+
+// InteractiveAccessPolicy is what happens to nodes after an interactive login.
+type InteractiveAccessPolicy struct {
+	// action is taken after each login.
+	Action InteractiveAccessAction `json:"action"`
+}
+
+// PackageOS maps the OS fields of MachineConfigs to packages for package-based nodes.
+type PackageOS struct {
+	// osImages are the package sets OS images stand for. Nodes install the package set
+	// of the OS image of their config; if there is none, their OS packages are left alone.
+	// +optional
+	OSImages []PackageSet `json:"osImages,omitempty"`
+
+	// extensions map extensions to the packages that provide them. Extensions without
+	// an entry map to the packages that provide them on RHCOS.
+	// +optional
+	Extensions []PackageMapping `json:"extensions,omitempty"`
+
+	// kernelTypes map kernel types to the packages that provide them. The first package
+	// provides the kernel image. Kernel types without an entry map to the packages that
+	// provide them on RHCOS.
+	// +optional
+	KernelTypes []PackageMapping `json:"kernelTypes,omitempty"`
+}
+
+// PackageSet is the set of packages an OS image stands for on package-based nodes.
+type PackageSet struct {
+	// osImageURL is the OS image the package set stands for.
+	OSImageURL string `json:"osImageURL"`
+
+	// repos are the repositories, e.g. snapshots, that packages are installed from.
+	// If any are listed, the other repositories of the node are disabled and the
+	// installed packages are synchronized with them.
+	// +optional
+	Repos []PackageRepo `json:"repos,omitempty"`
+
+	// packages are installed on top, pinned e.g. as name-version-release.
+	// +optional
+	Packages []string `json:"packages,omitempty"`
+}
+
+// PackageRepo is a package repository.
+type PackageRepo struct {
+	// name is the ID of the repository.
+	Name string `json:"name"`
+
+	// baseURL is the URL of the repository.
+	BaseURL string `json:"baseURL"`
+
+	// gpgKeyURL is the URL of the key packages of the repository are signed with.
+	// If unset, they are checked against the keys the node trusts already.
+	// +optional
+	GPGKeyURL string `json:"gpgKeyURL,omitempty"`
+}
+
+// PackageMapping maps a name to the packages that provide it.
+type PackageMapping struct {
+	// name is the extension or kernel type.
+	Name string `json:"name"`
+
+	// packages provide it.
+	Packages []string `json:"packages"`
+}
+
 // MachineConfigPoolStatus is the status for MachineConfigPool resource.
 type MachineConfigPoolStatus struct {
 	// observedGeneration represents the generation observed by the controller.
@@ -292,11 +541,58 @@ type MachineConfigPoolStatus struct {
 	// A node is marked degraded if applying a configuration failed..
 	DegradedMachineCount int32 `json:"degradedMachineCount"`
 
+	// updatePhaseCounts is the number of machines updating to the pool's configuration in each
+	// update phase reported by the machine config daemon, in the order the phases are passed.
+	// +optional
+	UpdatePhaseCounts []MachineConfigPoolUpdatePhaseCount `json:"updatePhaseCounts,omitempty"`
+
+	// updateBudget reports the cluster-wide node update budget shared by all pools,
+	// if one is configured.
+	// +optional
+	UpdateBudget *MachineConfigPoolUpdateBudget `json:"updateBudget,omitempty"`
+
 	// conditions represents the latest available observations of current state.
 	// +optional
 	Conditions []MachineConfigPoolCondition `json:"conditions"`
 }
 
+// MachineConfigPoolUpdatePhaseCount is the number of machines in an update phase.
+type MachineConfigPoolUpdatePhaseCount struct {
+	// phase is the update phase, one of Drained, FilesWritten, OSUpdateStaged, Rebooting, Validating or Done.
+	Phase string `json:"phase"`
+
+	// machineCount is the number of machines in the phase.
+	MachineCount int32 `json:"machineCount"`
+}
+
+// MachineConfigPoolUpdateBudget is the state of the cluster-wide node update budget.
+type MachineConfigPoolUpdateBudget struct {
+	// maxUnavailable is the maximum number of machines across all pools that may be unavailable at once.
+	MaxUnavailable int32 `json:"maxUnavailable"`
+
+	// unavailableMachineCount is the number of machines across all pools currently counted against the budget.
+	UnavailableMachineCount int32 `json:"unavailableMachineCount"`
+
+	// domains is the state of the per-zone and per-failure-domain budgets, if configured.
+	// +optional
+	Domains []MachineConfigPoolUpdateBudgetDomain `json:"domains,omitempty"`
+}
+
+// MachineConfigPoolUpdateBudgetDomain is the state of the node update budget for a single zone or failure domain.
+type MachineConfigPoolUpdateBudgetDomain struct {
+	// label is the node label the domain is keyed on.
+	Label string `json:"label"`
+
+	// value is the value of the label shared by the machines in the domain.
+	Value string `json:"value"`
+
+	// maxUnavailable is the maximum number of machines in the domain that may be unavailable at once.
+	MaxUnavailable int32 `json:"maxUnavailable"`
+
+	// unavailableMachineCount is the number of machines in the domain currently counted against the budget.
+	UnavailableMachineCount int32 `json:"unavailableMachineCount"`
+}
+
 // MachineConfigPoolStatusConfiguration stores the current configuration for the pool, and
 // optionally also stores the list of MachineConfig objects used to generate the configuration.
 type MachineConfigPoolStatusConfiguration struct {
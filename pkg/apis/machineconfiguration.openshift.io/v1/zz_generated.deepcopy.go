@@ -332,6 +332,90 @@ func (in *ControllerConfigStatusCondition) DeepCopy() *ControllerConfigStatusCon
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IOScheduler) DeepCopyInto(out *IOScheduler) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IOScheduler.
+func (in *IOScheduler) DeepCopy() *IOScheduler {
+	if in == nil {
+		return nil
+	}
+	out := new(IOScheduler)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IOTuning) DeepCopyInto(out *IOTuning) {
+	*out = *in
+	if in.Schedulers != nil {
+		in, out := &in.Schedulers, &out.Schedulers
+		*out = make([]IOScheduler, len(*in))
+		copy(*out, *in)
+	}
+	if in.QueueDepth != nil {
+		in, out := &in.QueueDepth, &out.QueueDepth
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Ostree != nil {
+		in, out := &in.Ostree, &out.Ostree
+		*out = new(OstreeFsync)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IOTuning.
+func (in *IOTuning) DeepCopy() *IOTuning {
+	if in == nil {
+		return nil
+	}
+	out := new(IOTuning)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InteractiveAccessPolicy) DeepCopyInto(out *InteractiveAccessPolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InteractiveAccessPolicy.
+func (in *InteractiveAccessPolicy) DeepCopy() *InteractiveAccessPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(InteractiveAccessPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KernelArgumentTuning) DeepCopyInto(out *KernelArgumentTuning) {
+	*out = *in
+	if in.AllowedArguments != nil {
+		in, out := &in.AllowedArguments, &out.AllowedArguments
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KernelArgumentTuning.
+func (in *KernelArgumentTuning) DeepCopy() *KernelArgumentTuning {
+	if in == nil {
+		return nil
+	}
+	out := new(KernelArgumentTuning)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeletConfig) DeepCopyInto(out *KubeletConfig) {
 	*out = *in
@@ -630,6 +714,31 @@ func (in *MachineConfigPoolSpec) DeepCopyInto(out *MachineConfigPoolSpec) {
 		*out = new(intstr.IntOrString)
 		**out = **in
 	}
+	if in.OSImageVerification != nil {
+		in, out := &in.OSImageVerification, &out.OSImageVerification
+		*out = new(OSImageVerification)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KernelArgumentTuning != nil {
+		in, out := &in.KernelArgumentTuning, &out.KernelArgumentTuning
+		*out = new(KernelArgumentTuning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IOTuning != nil {
+		in, out := &in.IOTuning, &out.IOTuning
+		*out = new(IOTuning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InteractiveAccessPolicy != nil {
+		in, out := &in.InteractiveAccessPolicy, &out.InteractiveAccessPolicy
+		*out = new(InteractiveAccessPolicy)
+		**out = **in
+	}
+	if in.PackageOS != nil {
+		in, out := &in.PackageOS, &out.PackageOS
+		*out = new(PackageOS)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Configuration.DeepCopyInto(&out.Configuration)
 	return
 }
@@ -648,6 +757,16 @@ func (in *MachineConfigPoolSpec) DeepCopy() *MachineConfigPoolSpec {
 func (in *MachineConfigPoolStatus) DeepCopyInto(out *MachineConfigPoolStatus) {
 	*out = *in
 	in.Configuration.DeepCopyInto(&out.Configuration)
+	if in.UpdatePhaseCounts != nil {
+		in, out := &in.UpdatePhaseCounts, &out.UpdatePhaseCounts
+		*out = make([]MachineConfigPoolUpdatePhaseCount, len(*in))
+		copy(*out, *in)
+	}
+	if in.UpdateBudget != nil {
+		in, out := &in.UpdateBudget, &out.UpdateBudget
+		*out = new(MachineConfigPoolUpdateBudget)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]MachineConfigPoolCondition, len(*in))
@@ -690,6 +809,59 @@ func (in *MachineConfigPoolStatusConfiguration) DeepCopy() *MachineConfigPoolSta
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineConfigPoolUpdateBudget) DeepCopyInto(out *MachineConfigPoolUpdateBudget) {
+	*out = *in
+	if in.Domains != nil {
+		in, out := &in.Domains, &out.Domains
+		*out = make([]MachineConfigPoolUpdateBudgetDomain, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineConfigPoolUpdateBudget.
+func (in *MachineConfigPoolUpdateBudget) DeepCopy() *MachineConfigPoolUpdateBudget {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineConfigPoolUpdateBudget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineConfigPoolUpdateBudgetDomain) DeepCopyInto(out *MachineConfigPoolUpdateBudgetDomain) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineConfigPoolUpdateBudgetDomain.
+func (in *MachineConfigPoolUpdateBudgetDomain) DeepCopy() *MachineConfigPoolUpdateBudgetDomain {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineConfigPoolUpdateBudgetDomain)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineConfigPoolUpdatePhaseCount) DeepCopyInto(out *MachineConfigPoolUpdatePhaseCount) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineConfigPoolUpdatePhaseCount.
+func (in *MachineConfigPoolUpdatePhaseCount) DeepCopy() *MachineConfigPoolUpdatePhaseCount {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineConfigPoolUpdatePhaseCount)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MachineConfigSpec) DeepCopyInto(out *MachineConfigSpec) {
 	*out = *in
@@ -699,6 +871,11 @@ func (in *MachineConfigSpec) DeepCopyInto(out *MachineConfigSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.KernelArgumentsToDelete != nil {
+		in, out := &in.KernelArgumentsToDelete, &out.KernelArgumentsToDelete
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Extensions != nil {
 		in, out := &in.Extensions, &out.Extensions
 		*out = make([]string, len(*in))
@@ -737,3 +914,171 @@ func (in *NetworkInfo) DeepCopy() *NetworkInfo {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OstreeFsync) DeepCopyInto(out *OstreeFsync) {
+	*out = *in
+	if in.Fsync != nil {
+		in, out := &in.Fsync, &out.Fsync
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PerObjectFsync != nil {
+		in, out := &in.PerObjectFsync, &out.PerObjectFsync
+		*out = new(bool)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OstreeFsync.
+func (in *OstreeFsync) DeepCopy() *OstreeFsync {
+	if in == nil {
+		return nil
+	}
+	out := new(OstreeFsync)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSImageVerification) DeepCopyInto(out *OSImageVerification) {
+	*out = *in
+	if in.AllowedDigests != nil {
+		in, out := &in.AllowedDigests, &out.AllowedDigests
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]OSImageVerificationKey, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSImageVerification.
+func (in *OSImageVerification) DeepCopy() *OSImageVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(OSImageVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSImageVerificationKey) DeepCopyInto(out *OSImageVerificationKey) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSImageVerificationKey.
+func (in *OSImageVerificationKey) DeepCopy() *OSImageVerificationKey {
+	if in == nil {
+		return nil
+	}
+	out := new(OSImageVerificationKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageMapping) DeepCopyInto(out *PackageMapping) {
+	*out = *in
+	if in.Packages != nil {
+		in, out := &in.Packages, &out.Packages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageMapping.
+func (in *PackageMapping) DeepCopy() *PackageMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageOS) DeepCopyInto(out *PackageOS) {
+	*out = *in
+	if in.OSImages != nil {
+		in, out := &in.OSImages, &out.OSImages
+		*out = make([]PackageSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Extensions != nil {
+		in, out := &in.Extensions, &out.Extensions
+		*out = make([]PackageMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KernelTypes != nil {
+		in, out := &in.KernelTypes, &out.KernelTypes
+		*out = make([]PackageMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageOS.
+func (in *PackageOS) DeepCopy() *PackageOS {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageOS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageRepo) DeepCopyInto(out *PackageRepo) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageRepo.
+func (in *PackageRepo) DeepCopy() *PackageRepo {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageRepo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageSet) DeepCopyInto(out *PackageSet) {
+	*out = *in
+	if in.Repos != nil {
+		in, out := &in.Repos, &out.Repos
+		*out = make([]PackageRepo, len(*in))
+		copy(*out, *in)
+	}
+	if in.Packages != nil {
+		in, out := &in.Packages, &out.Packages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageSet.
+func (in *PackageSet) DeepCopy() *PackageSet {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageSet)
+	in.DeepCopyInto(out)
+	return out
+}
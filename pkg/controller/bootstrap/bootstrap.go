@@ -46,23 +46,24 @@ func New(templatesDir, manifestDir, pullSecretFile string) *Bootstrap {
 	}
 }
 
-// Run runs boostrap for Machine Config Controller
-// It writes all the assets to destDir
-// nolint:gocyclo
-func (b *Bootstrap) Run(destDir string) error {
-	infos, err := ioutil.ReadDir(b.manifestDir)
-	if err != nil {
-		return err
-	}
-
-	psfraw, err := ioutil.ReadFile(b.pullSecretFile)
-	if err != nil {
-		return err
-	}
+// bootstrapManifests are the objects read from the manifest dir.
+type bootstrapManifests struct {
+	cconfig     *mcfgv1.ControllerConfig
+	featureGate *apicfgv1.FeatureGate
+	nodeConfig  *apicfgv1.Node
+	kconfigs    []*mcfgv1.KubeletConfig
+	pools       []*mcfgv1.MachineConfigPool
+	configs     []*mcfgv1.MachineConfig
+	crconfigs   []*mcfgv1.ContainerRuntimeConfig
+	icspRules   []*apioperatorsv1alpha1.ImageContentSourcePolicy
+	imgCfg      *apicfgv1.Image
+}
 
-	psraw, err := getPullSecretFromSecret(psfraw)
+// loadManifests reads the manifests from the manifest dir.
+func (b *Bootstrap) loadManifests() (*bootstrapManifests, error) {
+	infos, err := ioutil.ReadDir(b.manifestDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	scheme := runtime.NewScheme()
@@ -72,15 +73,7 @@ func (b *Bootstrap) Run(destDir string) error {
 	codecFactory := serializer.NewCodecFactory(scheme)
 	decoder := codecFactory.UniversalDecoder(mcfgv1.GroupVersion, apioperatorsv1alpha1.GroupVersion, apicfgv1.GroupVersion)
 
-	var cconfig *mcfgv1.ControllerConfig
-	var featureGate *apicfgv1.FeatureGate
-	var nodeConfig *apicfgv1.Node
-	var kconfigs []*mcfgv1.KubeletConfig
-	var pools []*mcfgv1.MachineConfigPool
-	var configs []*mcfgv1.MachineConfig
-	var crconfigs []*mcfgv1.ContainerRuntimeConfig
-	var icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy
-	var imgCfg *apicfgv1.Image
+	m := &bootstrapManifests{}
 	for _, info := range infos {
 		if info.IsDir() {
 			continue
@@ -88,54 +81,78 @@ func (b *Bootstrap) Run(destDir string) error {
 
 		file, err := os.Open(filepath.Join(b.manifestDir, info.Name()))
 		if err != nil {
-			return fmt.Errorf("error opening %s: %w", file.Name(), err)
+			return nil, fmt.Errorf("error opening %s: %w", file.Name(), err)
 		}
 		defer file.Close()
 
 		manifests, err := parseManifests(file.Name(), file)
 		if err != nil {
-			return fmt.Errorf("error parsing manifests from %s: %w", file.Name(), err)
+			return nil, fmt.Errorf("error parsing manifests from %s: %w", file.Name(), err)
 		}
 
-		for idx, m := range manifests {
-			obji, err := runtime.Decode(decoder, m.Raw)
+		for idx, manifest := range manifests {
+			obji, err := runtime.Decode(decoder, manifest.Raw)
 			if err != nil {
 				if runtime.IsNotRegisteredError(err) {
 					// don't care
 					glog.V(4).Infof("skipping path %q [%d] manifest because it is not part of expected api group: %v", file.Name(), idx+1, err)
 					continue
 				}
-				return fmt.Errorf("error parsing %q [%d] manifest: %w", file.Name(), idx+1, err)
+				return nil, fmt.Errorf("error parsing %q [%d] manifest: %w", file.Name(), idx+1, err)
 			}
 
 			switch obj := obji.(type) {
 			case *mcfgv1.MachineConfigPool:
-				pools = append(pools, obj)
+				m.pools = append(m.pools, obj)
 			case *mcfgv1.MachineConfig:
-				configs = append(configs, obj)
+				m.configs = append(m.configs, obj)
 			case *mcfgv1.ControllerConfig:
-				cconfig = obj
+				m.cconfig = obj
 			case *mcfgv1.ContainerRuntimeConfig:
-				crconfigs = append(crconfigs, obj)
+				m.crconfigs = append(m.crconfigs, obj)
 			case *mcfgv1.KubeletConfig:
-				kconfigs = append(kconfigs, obj)
+				m.kconfigs = append(m.kconfigs, obj)
 			case *apioperatorsv1alpha1.ImageContentSourcePolicy:
-				icspRules = append(icspRules, obj)
+				m.icspRules = append(m.icspRules, obj)
 			case *apicfgv1.Image:
-				imgCfg = obj
+				m.imgCfg = obj
 			case *apicfgv1.FeatureGate:
 				if obj.GetName() == ctrlcommon.ClusterFeatureInstanceName {
-					featureGate = obj
+					m.featureGate = obj
 				}
 			case *apicfgv1.Node:
 				if obj.GetName() == ctrlcommon.ClusterNodeInstanceName {
-					nodeConfig = obj
+					m.nodeConfig = obj
 				}
 			default:
 				glog.Infof("skipping %q [%d] manifest because of unhandled %T", file.Name(), idx+1, obji)
 			}
 		}
 	}
+	return m, nil
+}
+
+// Run runs boostrap for Machine Config Controller
+// It writes all the assets to destDir
+// nolint:gocyclo
+func (b *Bootstrap) Run(destDir string) error {
+	psfraw, err := ioutil.ReadFile(b.pullSecretFile)
+	if err != nil {
+		return err
+	}
+
+	psraw, err := getPullSecretFromSecret(psfraw)
+	if err != nil {
+		return err
+	}
+
+	m, err := b.loadManifests()
+	if err != nil {
+		return err
+	}
+	cconfig, featureGate, nodeConfig := m.cconfig, m.featureGate, m.nodeConfig
+	kconfigs, pools, configs := m.kconfigs, m.pools, m.configs
+	crconfigs, icspRules, imgCfg := m.crconfigs, m.icspRules, m.imgCfg
 
 	if cconfig == nil {
 		return fmt.Errorf("error: no controllerconfig found in dir: %q", destDir)
@@ -183,11 +200,14 @@ func (b *Bootstrap) Run(destDir string) error {
 		configs = append(configs, kconfigs...)
 	}
 
-	fpools, gconfigs, err := render.RunBootstrap(pools, configs, cconfig)
+	fpools, gconfigs, err := render.RunBootstrap(pools, configs, cconfig, psraw)
 	if err != nil {
 		return err
 	}
 
+	scheme := runtime.NewScheme()
+	mcfgv1.Install(scheme)
+	codecFactory := serializer.NewCodecFactory(scheme)
 	serializer := json.NewYAMLSerializer(json.DefaultMetaFactory, scheme, scheme)
 	encoder := codecFactory.EncoderForVersion(serializer, mcfgv1.GroupVersion)
 
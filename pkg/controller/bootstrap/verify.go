@@ -0,0 +1,521 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	kscheme "k8s.io/client-go/kubernetes/scheme"
+
+	apicfgv1 "github.com/openshift/api/config/v1"
+	fakeconfigclientset "github.com/openshift/client-go/config/clientset/versioned/fake"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	fakeoperatorclientset "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	operatorinformers "github.com/openshift/client-go/operator/informers/externalversions"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	containerruntimeconfig "github.com/openshift/machine-config-operator/pkg/controller/container-runtime-config"
+	kubeletconfig "github.com/openshift/machine-config-operator/pkg/controller/kubelet-config"
+	"github.com/openshift/machine-config-operator/pkg/controller/render"
+	"github.com/openshift/machine-config-operator/pkg/controller/template"
+	fakemcfgclientset "github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
+	mcfginformers "github.com/openshift/machine-config-operator/pkg/generated/informers/externalversions"
+)
+
+// openshiftConfigNamespace holds the pull secret the template controller reads.
+const openshiftConfigNamespace = "openshift-config"
+
+var (
+	// verifyPollInterval is how often the in-cluster render is checked during Verify.
+	verifyPollInterval = time.Second
+	// verifySettlePeriod is how long the in-cluster render has to match the bootstrap
+	// render before it is considered consistent. The controllers batch changes for a few
+	// seconds, so a match may still be replaced until they have all gone quiet.
+	verifySettlePeriod = 15 * time.Second
+)
+
+// ConfigDiffChange describes how an item differs between the bootstrap and the
+// in-cluster rendered config of a pool.
+type ConfigDiffChange string
+
+const (
+	// ConfigDiffBootstrapOnly items are only in the bootstrap rendered config.
+	ConfigDiffBootstrapOnly ConfigDiffChange = "BootstrapOnly"
+	// ConfigDiffClusterOnly items are only in the in-cluster rendered config.
+	ConfigDiffClusterOnly ConfigDiffChange = "ClusterOnly"
+	// ConfigDiffChanged items are in both rendered configs, but differ.
+	ConfigDiffChanged ConfigDiffChange = "Changed"
+)
+
+// ConfigDiffItem is a file, unit, kernel argument or other setting that differs.
+type ConfigDiffItem struct {
+	Name   string           `json:"name"`
+	Change ConfigDiffChange `json:"change"`
+	// Detail names the attributes that differ for changed items.
+	Detail string `json:"detail,omitempty"`
+}
+
+// PoolDiff is the difference between the bootstrap and in-cluster rendered configs of a pool.
+type PoolDiff struct {
+	Pool            string           `json:"pool"`
+	BootstrapConfig string           `json:"bootstrapConfig"`
+	ClusterConfig   string           `json:"clusterConfig"`
+	Files           []ConfigDiffItem `json:"files,omitempty"`
+	Units           []ConfigDiffItem `json:"units,omitempty"`
+	KernelArguments []ConfigDiffItem `json:"kernelArguments,omitempty"`
+	Other           []ConfigDiffItem `json:"other,omitempty"`
+}
+
+// String formats the diff for logs.
+func (d *PoolDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "pool %s: bootstrap rendered %s, cluster rendered %s\n", d.Pool, d.BootstrapConfig, d.ClusterConfig)
+	for _, section := range []struct {
+		name  string
+		items []ConfigDiffItem
+	}{
+		{"file", d.Files},
+		{"unit", d.Units},
+		{"kernel argument", d.KernelArguments},
+		{"other", d.Other},
+	} {
+		for _, item := range section.items {
+			fmt.Fprintf(&b, "  %s %s: %s", section.name, item.Name, item.Change)
+			if item.Detail != "" {
+				fmt.Fprintf(&b, " (%s)", item.Detail)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// Verify re-renders the manifests with the in-cluster controllers, running against
+// in-memory clients, and compares the result with the rendered configs that Run wrote
+// to destDir. It returns the differences of every pool whose rendered configs don't
+// match; an empty result means the cluster will pick up exactly the bootstrap render.
+func (b *Bootstrap) Verify(destDir string, timeout time.Duration) ([]PoolDiff, error) {
+	bootstrapConfigs, err := readBootstrapRenderedConfigs(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading bootstrap rendered configs: %w", err)
+	}
+
+	clusterConfigs, err := b.renderInCluster(bootstrapConfigs, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("rendering in-cluster: %w", err)
+	}
+
+	var pools []string
+	for pool := range bootstrapConfigs {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+
+	var diffs []PoolDiff
+	for _, pool := range pools {
+		bootstrapMC, clusterMC := bootstrapConfigs[pool], clusterConfigs[pool]
+		if bootstrapMC.Name == clusterMC.Name {
+			continue
+		}
+		diff, err := diffRenderedConfigs(bootstrapMC, clusterMC)
+		if err != nil {
+			return nil, fmt.Errorf("comparing rendered configs of pool %s: %w", pool, err)
+		}
+		diff.Pool = pool
+		diffs = append(diffs, *diff)
+	}
+	return diffs, nil
+}
+
+// readBootstrapRenderedConfigs returns the rendered config of each pool written by Run.
+func readBootstrapRenderedConfigs(destDir string) (map[string]*mcfgv1.MachineConfig, error) {
+	scheme := runtime.NewScheme()
+	mcfgv1.Install(scheme)
+	decoder := serializer.NewCodecFactory(scheme).UniversalDecoder(mcfgv1.GroupVersion)
+
+	poolPaths, err := filepath.Glob(filepath.Join(destDir, "machine-pools", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(poolPaths) == 0 {
+		return nil, fmt.Errorf("no pools found in %s", destDir)
+	}
+	configs := make(map[string]*mcfgv1.MachineConfig)
+	for _, path := range poolPaths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := runtime.Decode(decoder, data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		pool, ok := obj.(*mcfgv1.MachineConfigPool)
+		if !ok {
+			return nil, fmt.Errorf("expected MachineConfigPool in %s, found %T", path, obj)
+		}
+
+		path = filepath.Join(destDir, "machine-configs", pool.Spec.Configuration.Name+".yaml")
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		obj, err = runtime.Decode(decoder, data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		mc, ok := obj.(*mcfgv1.MachineConfig)
+		if !ok {
+			return nil, fmt.Errorf("expected MachineConfig in %s, found %T", path, obj)
+		}
+		configs[pool.Name] = mc
+	}
+	return configs, nil
+}
+
+// renderInCluster runs the controllers that render configs in-cluster against
+// in-memory clients seeded with the manifests. It returns the rendered config of
+// each pool once they all match expected for verifySettlePeriod, or the latest
+// rendered configs once timeout passes.
+// nolint:gocyclo
+func (b *Bootstrap) renderInCluster(expected map[string]*mcfgv1.MachineConfig, timeout time.Duration) (map[string]*mcfgv1.MachineConfig, error) {
+	m, err := b.loadManifests()
+	if err != nil {
+		return nil, err
+	}
+	if m.cconfig == nil {
+		return nil, fmt.Errorf("no controllerconfig found in dir: %q", b.manifestDir)
+	}
+
+	psfraw, err := ioutil.ReadFile(b.pullSecretFile)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := runtime.Decode(kscheme.Codecs.UniversalDecoder(corev1.SchemeGroupVersion), psfraw)
+	if err != nil {
+		return nil, err
+	}
+	pullSecret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return nil, fmt.Errorf("expected *corev1.Secret found %T", obj)
+	}
+	// The template controller only watches secrets in openshift-config
+	pullSecret.Namespace, pullSecret.Name = openshiftConfigNamespace, "pull-secret"
+	m.cconfig.Spec.PullSecret = &corev1.ObjectReference{Namespace: pullSecret.Namespace, Name: pullSecret.Name}
+
+	var mcfgObjs []runtime.Object
+	mcfgObjs = append(mcfgObjs, m.cconfig)
+	for _, obj := range m.pools {
+		mcfgObjs = append(mcfgObjs, obj)
+	}
+	for _, obj := range m.configs {
+		mcfgObjs = append(mcfgObjs, obj)
+	}
+	for _, obj := range m.kconfigs {
+		mcfgObjs = append(mcfgObjs, obj)
+	}
+	for _, obj := range m.crconfigs {
+		mcfgObjs = append(mcfgObjs, obj)
+	}
+	// The container runtime config controller waits for the release image to be known
+	configObjs := []runtime.Object{&apicfgv1.ClusterVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "version"},
+		Status:     apicfgv1.ClusterVersionStatus{Desired: apicfgv1.Release{Image: m.cconfig.Spec.ReleaseImage}},
+	}}
+	if m.featureGate != nil {
+		configObjs = append(configObjs, m.featureGate)
+	}
+	if m.nodeConfig != nil {
+		configObjs = append(configObjs, m.nodeConfig)
+	}
+	if m.imgCfg != nil {
+		configObjs = append(configObjs, m.imgCfg)
+	}
+	var operatorObjs []runtime.Object
+	for _, obj := range m.icspRules {
+		operatorObjs = append(operatorObjs, obj)
+	}
+
+	mcfgClient := fakemcfgclientset.NewSimpleClientset(mcfgObjs...)
+	kubeClient := k8sfake.NewSimpleClientset(pullSecret)
+	configClient := fakeconfigclientset.NewSimpleClientset(configObjs...)
+	operatorClient := fakeoperatorclientset.NewSimpleClientset(operatorObjs...)
+
+	informerFactory := mcfginformers.NewSharedInformerFactory(mcfgClient, 0)
+	openShiftConfigInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithNamespace(openshiftConfigNamespace))
+	configInformerFactory := configinformers.NewSharedInformerFactory(configClient, 0)
+	operatorInformerFactory := operatorinformers.NewSharedInformerFactory(operatorClient, 0)
+
+	// The same controllers, wired the same way, as machine-config-controller start
+	controllers := []ctrlcommon.Controller{
+		template.New(
+			b.templatesDir,
+			informerFactory.Machineconfiguration().V1().ControllerConfigs(),
+			informerFactory.Machineconfiguration().V1().MachineConfigs(),
+			openShiftConfigInformerFactory.Core().V1().Secrets(),
+			configInformerFactory.Config().V1().FeatureGates(),
+			openShiftConfigInformerFactory.Core().V1().ConfigMaps(),
+			kubeClient,
+			mcfgClient,
+		),
+		kubeletconfig.New(
+			b.templatesDir,
+			informerFactory.Machineconfiguration().V1().MachineConfigPools(),
+			informerFactory.Machineconfiguration().V1().ControllerConfigs(),
+			informerFactory.Machineconfiguration().V1().KubeletConfigs(),
+			configInformerFactory.Config().V1().FeatureGates(),
+			configInformerFactory.Config().V1().Nodes(),
+			configInformerFactory.Config().V1().APIServers(),
+			kubeClient,
+			mcfgClient,
+			configClient,
+		),
+		containerruntimeconfig.New(
+			b.templatesDir,
+			informerFactory.Machineconfiguration().V1().MachineConfigPools(),
+			informerFactory.Machineconfiguration().V1().ControllerConfigs(),
+			informerFactory.Machineconfiguration().V1().ContainerRuntimeConfigs(),
+			configInformerFactory.Config().V1().Images(),
+			operatorInformerFactory.Operator().V1alpha1().ImageContentSourcePolicies(),
+			configInformerFactory.Config().V1().ClusterVersions(),
+			kubeClient,
+			mcfgClient,
+			configClient,
+		),
+		render.New(
+			informerFactory.Machineconfiguration().V1().MachineConfigPools(),
+			informerFactory.Machineconfiguration().V1().MachineConfigs(),
+			informerFactory.Machineconfiguration().V1().ControllerConfigs(),
+			kubeClient,
+			mcfgClient,
+		),
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	openShiftConfigInformerFactory.Start(stopCh)
+	configInformerFactory.Start(stopCh)
+	operatorInformerFactory.Start(stopCh)
+	for _, c := range controllers {
+		go c.Run(1, stopCh)
+	}
+
+	rendered := make(map[string]string)
+	var matchingSince time.Time
+	pollErr := wait.PollImmediate(verifyPollInterval, timeout, func() (bool, error) {
+		matching := true
+		for pool, mc := range expected {
+			p, err := mcfgClient.MachineconfigurationV1().MachineConfigPools().Get(context.TODO(), pool, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			if rendered[pool] != p.Spec.Configuration.Name {
+				glog.Infof("Pool %s rendered in-cluster as %s", pool, p.Spec.Configuration.Name)
+				rendered[pool] = p.Spec.Configuration.Name
+			}
+			if p.Spec.Configuration.Name != mc.Name {
+				matching = false
+			}
+		}
+		if !matching {
+			matchingSince = time.Time{}
+			return false, nil
+		}
+		if matchingSince.IsZero() {
+			matchingSince = time.Now()
+		}
+		return time.Since(matchingSince) >= verifySettlePeriod, nil
+	})
+	if pollErr != nil && pollErr != wait.ErrWaitTimeout {
+		return nil, pollErr
+	}
+
+	configs := make(map[string]*mcfgv1.MachineConfig)
+	for pool, name := range rendered {
+		if name == "" {
+			return nil, fmt.Errorf("pool %s was not rendered within %v", pool, timeout)
+		}
+		mc, err := mcfgClient.MachineconfigurationV1().MachineConfigs().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		configs[pool] = mc
+	}
+	return configs, nil
+}
+
+// diffRenderedConfigs compares the files, units, kernel arguments and other
+// settings of the bootstrap and in-cluster rendered configs of a pool.
+func diffRenderedConfigs(bootstrapMC, clusterMC *mcfgv1.MachineConfig) (*PoolDiff, error) {
+	bootstrapIgn, err := ctrlcommon.ParseAndConvertConfig(bootstrapMC.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing bootstrap rendered config: %w", err)
+	}
+	clusterIgn, err := ctrlcommon.ParseAndConvertConfig(clusterMC.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing in-cluster rendered config: %w", err)
+	}
+
+	diff := &PoolDiff{
+		BootstrapConfig: bootstrapMC.Name,
+		ClusterConfig:   clusterMC.Name,
+	}
+
+	bootstrapFiles := make(map[string]ign3types.File)
+	for _, f := range bootstrapIgn.Storage.Files {
+		bootstrapFiles[f.Path] = f
+	}
+	clusterFiles := make(map[string]ign3types.File)
+	for _, f := range clusterIgn.Storage.Files {
+		clusterFiles[f.Path] = f
+	}
+	for _, path := range sortedKeys(bootstrapFiles, clusterFiles) {
+		bf, inBootstrap := bootstrapFiles[path]
+		cf, inCluster := clusterFiles[path]
+		if item := diffItem(path, inBootstrap, inCluster, func() string { return fileDetail(bf, cf) }); item != nil {
+			diff.Files = append(diff.Files, *item)
+		}
+	}
+
+	bootstrapUnits := make(map[string]ign3types.Unit)
+	for _, u := range bootstrapIgn.Systemd.Units {
+		bootstrapUnits[u.Name] = u
+	}
+	clusterUnits := make(map[string]ign3types.Unit)
+	for _, u := range clusterIgn.Systemd.Units {
+		clusterUnits[u.Name] = u
+	}
+	for _, name := range sortedKeys(bootstrapUnits, clusterUnits) {
+		bu, inBootstrap := bootstrapUnits[name]
+		cu, inCluster := clusterUnits[name]
+		if item := diffItem(name, inBootstrap, inCluster, func() string { return unitDetail(bu, cu) }); item != nil {
+			diff.Units = append(diff.Units, *item)
+		}
+	}
+
+	bootstrapKargs := countKernelArguments(bootstrapMC.Spec.KernelArguments)
+	clusterKargs := countKernelArguments(clusterMC.Spec.KernelArguments)
+	for _, karg := range sortedKeys(bootstrapKargs, clusterKargs) {
+		switch b, c := bootstrapKargs[karg], clusterKargs[karg]; {
+		case b > c:
+			diff.KernelArguments = append(diff.KernelArguments, ConfigDiffItem{Name: karg, Change: ConfigDiffBootstrapOnly})
+		case c > b:
+			diff.KernelArguments = append(diff.KernelArguments, ConfigDiffItem{Name: karg, Change: ConfigDiffClusterOnly})
+		}
+	}
+
+	other := []struct {
+		name               string
+		bootstrap, cluster interface{}
+	}{
+		{"osImageURL", bootstrapMC.Spec.OSImageURL, clusterMC.Spec.OSImageURL},
+		{"kernelType", bootstrapMC.Spec.KernelType, clusterMC.Spec.KernelType},
+		{"fips", bootstrapMC.Spec.FIPS, clusterMC.Spec.FIPS},
+		{"extensions", sortedStrings(bootstrapMC.Spec.Extensions), sortedStrings(clusterMC.Spec.Extensions)},
+		{"directories", bootstrapIgn.Storage.Directories, clusterIgn.Storage.Directories},
+		{"links", bootstrapIgn.Storage.Links, clusterIgn.Storage.Links},
+		{"passwd", bootstrapIgn.Passwd, clusterIgn.Passwd},
+	}
+	for _, o := range other {
+		if !reflect.DeepEqual(o.bootstrap, o.cluster) {
+			diff.Other = append(diff.Other, ConfigDiffItem{Name: o.name, Change: ConfigDiffChanged})
+		}
+	}
+
+	// The names hash the whole spec, so something not compared above must differ
+	if len(diff.Files) == 0 && len(diff.Units) == 0 && len(diff.KernelArguments) == 0 && len(diff.Other) == 0 {
+		diff.Other = append(diff.Other, ConfigDiffItem{Name: "config", Change: ConfigDiffChanged, Detail: "fields not compared individually"})
+	}
+	return diff, nil
+}
+
+func diffItem(name string, inBootstrap, inCluster bool, detail func() string) *ConfigDiffItem {
+	switch {
+	case inBootstrap && !inCluster:
+		return &ConfigDiffItem{Name: name, Change: ConfigDiffBootstrapOnly}
+	case inCluster && !inBootstrap:
+		return &ConfigDiffItem{Name: name, Change: ConfigDiffClusterOnly}
+	}
+	if d := detail(); d != "" {
+		return &ConfigDiffItem{Name: name, Change: ConfigDiffChanged, Detail: d}
+	}
+	return nil
+}
+
+// fileDetail names the attributes that differ between two versions of a file.
+func fileDetail(a, b ign3types.File) string {
+	var detail []string
+	if !reflect.DeepEqual(a.Contents, b.Contents) || !reflect.DeepEqual(a.Append, b.Append) {
+		detail = append(detail, "contents")
+	}
+	if !reflect.DeepEqual(a.Mode, b.Mode) {
+		detail = append(detail, "mode")
+	}
+	if !reflect.DeepEqual(a.User, b.User) || !reflect.DeepEqual(a.Group, b.Group) {
+		detail = append(detail, "owner")
+	}
+	if len(detail) == 0 && !reflect.DeepEqual(a, b) {
+		detail = append(detail, "attributes")
+	}
+	return strings.Join(detail, ", ")
+}
+
+// unitDetail names the attributes that differ between two versions of a unit.
+func unitDetail(a, b ign3types.Unit) string {
+	var detail []string
+	if !reflect.DeepEqual(a.Contents, b.Contents) {
+		detail = append(detail, "contents")
+	}
+	if !reflect.DeepEqual(a.Enabled, b.Enabled) || !reflect.DeepEqual(a.Mask, b.Mask) {
+		detail = append(detail, "enablement")
+	}
+	if !reflect.DeepEqual(a.Dropins, b.Dropins) {
+		detail = append(detail, "dropins")
+	}
+	return strings.Join(detail, ", ")
+}
+
+func countKernelArguments(kargs []string) map[string]int {
+	counts := make(map[string]int)
+	for _, arg := range ctrlcommon.ParseKernelArguments(kargs) {
+		counts[arg]++
+	}
+	return counts
+}
+
+func sortedStrings(in []string) []string {
+	out := append([]string{}, in...)
+	sort.Strings(out)
+	return out
+}
+
+// sortedKeys returns the union of the keys of a and b, sorted.
+func sortedKeys(a, b interface{}) []string {
+	seen := make(map[string]bool)
+	for _, m := range []interface{}{a, b} {
+		for _, key := range reflect.ValueOf(m).MapKeys() {
+			seen[key.String()] = true
+		}
+	}
+	var keys []string
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,108 @@
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestDiffRenderedConfigs(t *testing.T) {
+	newRendered := func(name string, files []ign3types.File, units []ign3types.Unit, kargs ...string) *mcfgv1.MachineConfig {
+		ignCfg := ctrlcommon.NewIgnConfig()
+		ignCfg.Storage.Files = files
+		ignCfg.Systemd.Units = units
+		mc := helpers.CreateMachineConfigFromIgnition(ignCfg)
+		mc.Name = name
+		mc.Spec.KernelArguments = kargs
+		return mc
+	}
+	enabled := true
+
+	bootstrapMC := newRendered("rendered-worker-a",
+		[]ign3types.File{
+			helpers.CreateEncodedIgn3File("/etc/same", "same", 0644),
+			helpers.CreateEncodedIgn3File("/etc/contents", "old", 0644),
+			helpers.CreateEncodedIgn3File("/etc/mode", "mode", 0644),
+			helpers.CreateEncodedIgn3File("/etc/bootstrap-only", "bootstrap", 0644),
+		},
+		[]ign3types.Unit{
+			{Name: "same.service", Contents: helpers.StrToPtr("[Unit]")},
+			{Name: "enabled.service", Contents: helpers.StrToPtr("[Unit]")},
+		},
+		"foo=bar baz", "nosmt", `dyndbg="file drivers/foo.c +p"`,
+	)
+	clusterMC := newRendered("rendered-worker-b",
+		[]ign3types.File{
+			helpers.CreateEncodedIgn3File("/etc/same", "same", 0644),
+			helpers.CreateEncodedIgn3File("/etc/contents", "new", 0644),
+			helpers.CreateEncodedIgn3File("/etc/mode", "mode", 0600),
+			helpers.CreateEncodedIgn3File("/etc/cluster-only", "cluster", 0644),
+		},
+		[]ign3types.Unit{
+			{Name: "same.service", Contents: helpers.StrToPtr("[Unit]")},
+			{Name: "enabled.service", Contents: helpers.StrToPtr("[Unit]"), Enabled: &enabled},
+			{Name: "cluster-only.service", Contents: helpers.StrToPtr("[Unit]")},
+		},
+		"foo=bar", "baz", "nosmt", "nosmt", `dyndbg="file drivers/foo.c +p"`,
+	)
+	clusterMC.Spec.KernelType = "realtime"
+
+	diff, err := diffRenderedConfigs(bootstrapMC, clusterMC)
+	require.NoError(t, err)
+	assert.Equal(t, "rendered-worker-a", diff.BootstrapConfig)
+	assert.Equal(t, "rendered-worker-b", diff.ClusterConfig)
+	assert.Equal(t, []ConfigDiffItem{
+		{Name: "/etc/bootstrap-only", Change: ConfigDiffBootstrapOnly},
+		{Name: "/etc/cluster-only", Change: ConfigDiffClusterOnly},
+		{Name: "/etc/contents", Change: ConfigDiffChanged, Detail: "contents"},
+		{Name: "/etc/mode", Change: ConfigDiffChanged, Detail: "mode"},
+	}, diff.Files)
+	assert.Equal(t, []ConfigDiffItem{
+		{Name: "cluster-only.service", Change: ConfigDiffClusterOnly},
+		{Name: "enabled.service", Change: ConfigDiffChanged, Detail: "enablement"},
+	}, diff.Units)
+	// Kernel arguments are compared argument by argument, honoring quotes, so only
+	// the repeated nosmt differs
+	assert.Equal(t, []ConfigDiffItem{
+		{Name: "nosmt", Change: ConfigDiffClusterOnly},
+	}, diff.KernelArguments)
+	assert.Equal(t, []ConfigDiffItem{
+		{Name: "kernelType", Change: ConfigDiffChanged},
+	}, diff.Other)
+	assert.Contains(t, diff.String(), "file /etc/contents: Changed (contents)")
+
+	// Only a field that isn't compared individually differs
+	clusterMC = bootstrapMC.DeepCopy()
+	clusterMC.Name = "rendered-worker-c"
+	diff, err = diffRenderedConfigs(bootstrapMC, clusterMC)
+	require.NoError(t, err)
+	assert.Empty(t, diff.Files)
+	assert.Len(t, diff.Other, 1)
+}
+
+func TestBootstrapVerify(t *testing.T) {
+	defer func(period time.Duration) { verifySettlePeriod = period }(verifySettlePeriod)
+	verifySettlePeriod = 2 * time.Second
+
+	destDir, err := ioutil.TempDir("", "controller-bootstrap-verify")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+
+	bootstrap := New("../../../templates", "testdata/bootstrap", "testdata/bootstrap/machineconfigcontroller-pull-secret")
+	require.NoError(t, bootstrap.Run(destDir))
+
+	diffs, err := bootstrap.Verify(destDir, time.Minute)
+	require.NoError(t, err)
+	for _, diff := range diffs {
+		t.Errorf("bootstrap and in-cluster renders differ:\n%s", diff.String())
+	}
+}
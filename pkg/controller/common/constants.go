@@ -1,6 +1,9 @@
 package common
 
 const (
+	// MachineConfigControllerFieldManager is the field manager of the controller's writes to nodes
+	MachineConfigControllerFieldManager = "machine-config-controller"
+
 	// MCONamespace is the namespace that should be used for all API objects owned by the MCO by default
 	MCONamespace = "openshift-machine-config-operator"
 
@@ -10,6 +13,14 @@ const (
 	// ReleaseImageVersionAnnotationKey is used to tag the rendered machineconfigs & controller config with the release image version.
 	ReleaseImageVersionAnnotationKey = "machineconfiguration.openshift.io/release-image-version"
 
+	// OSImageURLTagAnnotationKey records on a rendered machineconfig the OS image reference its MachineConfigs specified,
+	// when the controller resolved it to a digest.
+	OSImageURLTagAnnotationKey = "machineconfiguration.openshift.io/os-image-url-tag"
+
+	// UnresolvedConfigHashAnnotationKey records on a rendered machineconfig the hash of the config its MachineConfigs
+	// merge to, before image references were resolved to digests.
+	UnresolvedConfigHashAnnotationKey = "machineconfiguration.openshift.io/unresolved-config-hash"
+
 	// ControllerConfigName is the name of the ControllerConfig object that controllers use
 	ControllerConfigName = "machine-config-controller"
 
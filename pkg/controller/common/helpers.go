@@ -54,15 +54,23 @@ func strToPtr(s string) *string {
 }
 
 // MergeMachineConfigs combines multiple machineconfig objects into one object.
-// It sorts all the configs in increasing order of their name.
+// It sorts all the configs in increasing order of their name and merges them
+// with MergeMachineConfigsInOrder.
+func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, osImageURL string) (*mcfgv1.MachineConfig, error) {
+	sort.SliceStable(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return MergeMachineConfigsInOrder(configs, osImageURL)
+}
+
+// MergeMachineConfigsInOrder combines multiple machineconfig objects into one
+// object, in the given order.
 // It uses the Ignition config from first object as base and appends all the rest.
-// Kernel arguments are concatenated.
+// Kernel arguments are merged by key, and configs setting different values
+// for the same key are rejected; see mergeKernelArguments.
 // It defaults to the OSImageURL provided by the CVO but allows a MC provided OSImageURL to take precedence.
-func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, osImageURL string) (*mcfgv1.MachineConfig, error) {
+func MergeMachineConfigsInOrder(configs []*mcfgv1.MachineConfig, osImageURL string) (*mcfgv1.MachineConfig, error) {
 	if len(configs) == 0 {
 		return nil, nil
 	}
-	sort.SliceStable(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
 
 	var fips bool
 	var kernelType string
@@ -111,9 +119,9 @@ func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, osImageURL string) (*m
 		kernelType = KernelTypeDefault
 	}
 
-	kargs := []string{}
-	for _, cfg := range configs {
-		kargs = append(kargs, cfg.Spec.KernelArguments...)
+	kargs, kargsToDelete, err := mergeKernelArguments(configs)
+	if err != nil {
+		return nil, err
 	}
 
 	extensions := []string{}
@@ -142,8 +150,9 @@ func MergeMachineConfigs(configs []*mcfgv1.MachineConfig, osImageURL string) (*m
 
 	return &mcfgv1.MachineConfig{
 		Spec: mcfgv1.MachineConfigSpec{
-			OSImageURL:      osImageURL,
-			KernelArguments: kargs,
+			OSImageURL:              osImageURL,
+			KernelArguments:         kargs,
+			KernelArgumentsToDelete: kargsToDelete,
 			Config: runtime.RawExtension{
 				Raw: rawOutIgn,
 			},
@@ -391,8 +400,8 @@ func validateIgn3FileModes(cfg ign3types.Config) error {
 }
 
 // DecodeIgnitionFileContents returns uncompressed, decoded inline file contents.
-// This function does not handle remote resources; it assumes they have already
-// been fetched.
+// This function does not handle remote resources, see
+// RemoteFileFetcher.DecodeIgnitionFileContents for those.
 func DecodeIgnitionFileContents(source, compression *string) ([]byte, error) {
 	var contentsBytes []byte
 
@@ -402,30 +411,39 @@ func DecodeIgnitionFileContents(source, compression *string) ([]byte, error) {
 		if err != nil {
 			return []byte{}, fmt.Errorf("could not decode file content string: %w", err)
 		}
-		if compression != nil {
-			switch *compression {
-			case "":
-				contentsBytes = source.Data
-			case "gzip":
-				reader, err := gzip.NewReader(bytes.NewReader(source.Data))
-				if err != nil {
-					return []byte{}, fmt.Errorf("could not create gzip reader: %w", err)
-				}
-				defer reader.Close()
-				contentsBytes, err = io.ReadAll(reader)
-				if err != nil {
-					return []byte{}, fmt.Errorf("failed decompressing: %w", err)
-				}
-			default:
-				return []byte{}, fmt.Errorf("unsupported compression type %q", *compression)
-			}
-		} else {
-			contentsBytes = source.Data
+		contentsBytes, err = decompressIgnitionFileContents(source.Data, compression)
+		if err != nil {
+			return []byte{}, err
 		}
 	}
 	return contentsBytes, nil
 }
 
+// decompressIgnitionFileContents applies the compression of an Ignition file
+// resource to its raw contents.
+func decompressIgnitionFileContents(data []byte, compression *string) ([]byte, error) {
+	if compression == nil {
+		return data, nil
+	}
+	switch *compression {
+	case "":
+		return data, nil
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return []byte{}, fmt.Errorf("could not create gzip reader: %w", err)
+		}
+		defer reader.Close()
+		contentsBytes, err := io.ReadAll(reader)
+		if err != nil {
+			return []byte{}, fmt.Errorf("failed decompressing: %w", err)
+		}
+		return contentsBytes, nil
+	default:
+		return []byte{}, fmt.Errorf("unsupported compression type %q", *compression)
+	}
+}
+
 // InSlice search for an element in slice and return true if found, otherwise return false
 func InSlice(elem string, slice []string) bool {
 	for _, k := range slice {
@@ -442,6 +460,10 @@ func ValidateMachineConfig(cfg mcfgv1.MachineConfigSpec) error {
 		return fmt.Errorf("kernelType=%s is invalid", cfg.KernelType)
 	}
 
+	if err := validateKernelArguments(cfg); err != nil {
+		return err
+	}
+
 	if cfg.Config.Raw != nil {
 		ignCfg, err := IgnParseWrapper(cfg.Config.Raw)
 		if err != nil {
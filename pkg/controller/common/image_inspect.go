@@ -0,0 +1,183 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+)
+
+// imageRetriesCount is the number of times a failed registry request is retried
+const imageRetriesCount = 2
+
+// RetryIfNecessary runs operation, retrying it with an exponential backoff if it fails.
+func RetryIfNecessary(ctx context.Context, operation func() error) error {
+	err := operation()
+	for attempt := 0; err != nil && attempt < imageRetriesCount; attempt++ {
+		delay := time.Duration(int(math.Pow(2, float64(attempt)))) * time.Second
+		glog.Warningf("failed, retrying in %s ... (%d/%d): %v", delay, attempt+1, imageRetriesCount, err)
+		select {
+		case <-time.After(delay):
+			break
+		case <-ctx.Done():
+			return err
+		}
+		err = operation()
+	}
+	return err
+}
+
+// NewDockerImageSource creates an image source for an image reference.
+// The caller must call .Close() on the returned ImageSource.
+func NewDockerImageSource(ctx context.Context, sys *types.SystemContext, name string) (types.ImageSource, error) {
+	var imageName string
+	if !strings.HasPrefix(name, "//") {
+		imageName = "//" + name
+	} else {
+		imageName = name
+	}
+	ref, err := docker.ParseReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ref.NewImageSource(ctx, sys)
+}
+
+// ImageInspect returns the metadata of an image along with the digest of the manifest
+// its reference points to, which for a multi-arch image is that of the manifest list.
+// This function has been inspired from upstream skopeo inspect, see https://github.com/containers/skopeo/blob/master/cmd/skopeo/inspect.go
+// We can use skopeo inspect directly once fetching RepoTags becomes optional in skopeo.
+func ImageInspect(imageName string, sys *types.SystemContext) (*types.ImageInspectInfo, digest.Digest, error) {
+	var (
+		src         types.ImageSource
+		rawManifest []byte
+		imgInspect  *types.ImageInspectInfo
+		err         error
+	)
+
+	ctx := context.Background()
+
+	if err := RetryIfNecessary(ctx, func() error {
+		src, err = NewDockerImageSource(ctx, sys, imageName)
+		return err
+	}); err != nil {
+		return nil, "", fmt.Errorf("error parsing image name %q: %w", imageName, err)
+	}
+
+	defer src.Close()
+
+	if err := RetryIfNecessary(ctx, func() error {
+		rawManifest, _, err = src.GetManifest(ctx, nil)
+		return err
+	}); err != nil {
+		return nil, "", fmt.Errorf("error retrieving manifest for image: %w", err)
+	}
+	manifestDigest, err := manifest.Digest(rawManifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("error computing manifest digest: %w", err)
+	}
+
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing manifest for image: %w", err)
+	}
+
+	if err := RetryIfNecessary(ctx, func() error {
+		imgInspect, err = img.Inspect(ctx)
+		return err
+	}); err != nil {
+		return nil, "", err
+	}
+
+	return imgInspect, manifestDigest, nil
+}
+
+// IsImageDigestPinned returns whether an image reference carries a digest.
+func IsImageDigestPinned(imageURL string) (bool, error) {
+	ref, err := reference.ParseNormalizedNamed(imageURL)
+	if err != nil {
+		return false, err
+	}
+	_, ok := ref.(reference.Canonical)
+	return ok, nil
+}
+
+// ResolveImageDigest returns the reference of the image imageURL currently points to by
+// digest, so that it doesn't change when a tag moves. References that already carry a
+// digest are returned unchanged. pullSecret is the docker config JSON used to access the
+// registry and registriesConf the registries.conf the nodes pull with, so the image is
+// looked up on the same mirrors; either may be empty. Registries are reached through the
+// proxy set in the environment, which the operator sets to the cluster proxy.
+func ResolveImageDigest(imageURL string, pullSecret, registriesConf []byte) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(imageURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing image %q: %w", imageURL, err)
+	}
+	if _, ok := ref.(reference.Canonical); ok {
+		return imageURL, nil
+	}
+
+	sys := &types.SystemContext{}
+	if len(pullSecret) > 0 {
+		authFile, err := writeTempFile("mco-pull-secret-", pullSecret)
+		if err != nil {
+			return "", fmt.Errorf("writing pull secret: %w", err)
+		}
+		defer os.Remove(authFile)
+		sys.AuthFilePath = authFile
+	}
+	if len(registriesConf) > 0 {
+		confFile, err := writeTempFile("mco-registries-", registriesConf)
+		if err != nil {
+			return "", fmt.Errorf("writing registries.conf: %w", err)
+		}
+		defer func() {
+			os.Remove(confFile)
+			// The parsed config is cached by path, don't let temporary files pile up there
+			sysregistriesv2.InvalidateCache()
+		}()
+		sys.SystemRegistriesConfPath = confFile
+		// Only the mirrors of registriesConf apply
+		sys.SystemRegistriesConfDirPath = os.DevNull
+	}
+
+	_, imageDigest, err := ImageInspect(imageURL, sys)
+	if err != nil {
+		return "", fmt.Errorf("resolving image %q to a digest: %w", imageURL, err)
+	}
+	canonical, err := reference.WithDigest(reference.TrimNamed(ref), imageDigest)
+	if err != nil {
+		return "", err
+	}
+	return canonical.String(), nil
+}
+
+// writeTempFile writes data to a new temporary file and returns its path.
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveImageDigest(t *testing.T) {
+	pinned := "quay.io/openshift/os@sha256:" + strings.Repeat("a", 64)
+
+	isPinned, err := IsImageDigestPinned(pinned)
+	require.NoError(t, err)
+	assert.True(t, isPinned)
+	isPinned, err = IsImageDigestPinned("quay.io/openshift/os:latest")
+	require.NoError(t, err)
+	assert.False(t, isPinned)
+
+	// Pinned references are returned without contacting the registry
+	resolved, err := ResolveImageDigest(pinned, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, pinned, resolved)
+	resolved, err = ResolveImageDigest("quay.io/openshift/os:4.11@sha256:"+strings.Repeat("b", 64), nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/openshift/os:4.11@sha256:"+strings.Repeat("b", 64), resolved)
+
+	_, err = ResolveImageDigest("Not A Valid Reference", nil, nil)
+	assert.Error(t, err)
+}
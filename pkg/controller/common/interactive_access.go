@@ -0,0 +1,21 @@
+package common
+
+import (
+	"fmt"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// ValidateInteractiveAccessPolicy checks that an interactive access policy is well-formed.
+// A nil policy is valid.
+func ValidateInteractiveAccessPolicy(policy *mcfgv1.InteractiveAccessPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	switch policy.Action {
+	case mcfgv1.InteractiveAccessActionNone, mcfgv1.InteractiveAccessActionRevalidate, mcfgv1.InteractiveAccessActionReprovision:
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q", policy.Action)
+	}
+}
@@ -0,0 +1,16 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func TestValidateInteractiveAccessPolicy(t *testing.T) {
+	assert.NoError(t, ValidateInteractiveAccessPolicy(nil))
+	assert.NoError(t, ValidateInteractiveAccessPolicy(&mcfgv1.InteractiveAccessPolicy{Action: mcfgv1.InteractiveAccessActionRevalidate}))
+	assert.Error(t, ValidateInteractiveAccessPolicy(&mcfgv1.InteractiveAccessPolicy{}))
+	assert.Error(t, ValidateInteractiveAccessPolicy(&mcfgv1.InteractiveAccessPolicy{Action: "Reboot"}))
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"fmt"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// ValidateIOTuning checks that an I/O tuning is well-formed. A nil tuning is valid.
+func ValidateIOTuning(tuning *mcfgv1.IOTuning) error {
+	if tuning == nil {
+		return nil
+	}
+	for i, sched := range tuning.Schedulers {
+		switch sched.DeviceClass {
+		case "", mcfgv1.BlockDeviceClassNVMe, mcfgv1.BlockDeviceClassSSD, mcfgv1.BlockDeviceClassHDD:
+		default:
+			return fmt.Errorf("scheduler %d: unknown device class %q", i, sched.DeviceClass)
+		}
+		if sched.Scheduler == "" {
+			return fmt.Errorf("scheduler %d: empty scheduler", i)
+		}
+	}
+	if tuning.QueueDepth != nil && *tuning.QueueDepth < 1 {
+		return fmt.Errorf("invalid queue depth %d", *tuning.QueueDepth)
+	}
+	return nil
+}
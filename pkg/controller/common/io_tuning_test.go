@@ -0,0 +1,22 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func TestValidateIOTuning(t *testing.T) {
+	zero := int32(0)
+	depth := int32(64)
+	assert.NoError(t, ValidateIOTuning(nil))
+	assert.NoError(t, ValidateIOTuning(&mcfgv1.IOTuning{
+		Schedulers: []mcfgv1.IOScheduler{{DeviceClass: mcfgv1.BlockDeviceClassNVMe, Scheduler: "none"}, {Scheduler: "bfq"}},
+		QueueDepth: &depth,
+	}))
+	assert.Error(t, ValidateIOTuning(&mcfgv1.IOTuning{Schedulers: []mcfgv1.IOScheduler{{DeviceClass: "Tape", Scheduler: "bfq"}}}))
+	assert.Error(t, ValidateIOTuning(&mcfgv1.IOTuning{Schedulers: []mcfgv1.IOScheduler{{DeviceClass: mcfgv1.BlockDeviceClassSSD}}}))
+	assert.Error(t, ValidateIOTuning(&mcfgv1.IOTuning{QueueDepth: &zero}))
+}
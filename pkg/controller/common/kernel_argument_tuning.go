@@ -0,0 +1,41 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// ValidateKernelArgumentTuning checks that every allowlist entry is a single kernel
+// argument. A nil allowlist is valid.
+func ValidateKernelArgumentTuning(tuning *mcfgv1.KernelArgumentTuning) error {
+	if tuning == nil {
+		return nil
+	}
+	for _, entry := range tuning.AllowedArguments {
+		if args := SplitKernelArguments(entry); len(args) != 1 || args[0] != entry {
+			return fmt.Errorf("allowed argument %q must be exactly one kernel argument", entry)
+		}
+		if KernelArgumentKey(entry) == "" {
+			return fmt.Errorf("allowed argument %q has an empty key", entry)
+		}
+	}
+	return nil
+}
+
+// IsKernelArgumentAllowed reports whether arg is allowed by one of the entries of
+// allowed. An entry with a value only allows that exact argument, a bare key allows
+// the key on its own and with any value.
+func IsKernelArgumentAllowed(arg string, allowed []string) bool {
+	for _, entry := range allowed {
+		if strings.Contains(entry, "=") {
+			if arg == entry {
+				return true
+			}
+		} else if KernelArgumentKey(arg) == entry {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func TestValidateKernelArgumentTuning(t *testing.T) {
+	assert.NoError(t, ValidateKernelArgumentTuning(nil))
+	assert.NoError(t, ValidateKernelArgumentTuning(&mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"nosmt", "mitigations=auto"}}))
+	assert.Error(t, ValidateKernelArgumentTuning(&mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"nosmt mitigations=auto"}}))
+	assert.Error(t, ValidateKernelArgumentTuning(&mcfgv1.KernelArgumentTuning{AllowedArguments: []string{" nosmt"}}))
+	assert.Error(t, ValidateKernelArgumentTuning(&mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"=off"}}))
+}
+
+func TestIsKernelArgumentAllowed(t *testing.T) {
+	allowed := []string{"nosmt", "mitigations=auto"}
+
+	assert.True(t, IsKernelArgumentAllowed("nosmt", allowed))
+	assert.True(t, IsKernelArgumentAllowed("nosmt=force", allowed))
+	assert.True(t, IsKernelArgumentAllowed("mitigations=auto", allowed))
+	assert.False(t, IsKernelArgumentAllowed("mitigations=off", allowed))
+	assert.False(t, IsKernelArgumentAllowed("mitigations", allowed))
+	assert.False(t, IsKernelArgumentAllowed("nosmtx", allowed))
+	assert.False(t, IsKernelArgumentAllowed("nosmt", nil))
+}
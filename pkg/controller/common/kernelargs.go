@@ -0,0 +1,240 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/glog"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// checks for white-space characters in "C" and "POSIX" locales.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\f' || b == '\n' || b == '\r' || b == '\t' || b == '\v'
+}
+
+// You can use " around spaces, but can't escape ". See next_arg() in kernel code /lib/cmdline.c
+// Gives the start and stop index for the next arg in the string, beyond the provided `begin` index
+func nextArg(args string, begin int) (int, int) {
+	var (
+		start, stop int
+		inQuote     bool
+	)
+	// Skip leading spaces
+	for start = begin; start < len(args) && isSpace(args[start]); start++ {
+	}
+	stop = start
+	for ; stop < len(args); stop++ {
+		if isSpace(args[stop]) && !inQuote {
+			break
+		}
+
+		if args[stop] == '"' {
+			inQuote = !inQuote
+		}
+	}
+
+	return start, stop
+}
+
+// SplitKernelArguments splits a single kernel command line string into its
+// individual arguments, honouring double quotes the same way the kernel does.
+func SplitKernelArguments(args string) []string {
+	var (
+		start, stop int
+		split       []string
+	)
+	for stop < len(args) {
+		start, stop = nextArg(args, stop)
+		if start != stop {
+			split = append(split, args[start:stop])
+		}
+	}
+	return split
+}
+
+// ParseKernelArguments separates out kargs from each entry and returns them
+// as a flat list, preserving their order.
+func ParseKernelArguments(kargs []string) []string {
+	parsed := []string{}
+	for _, k := range kargs {
+		for _, arg := range SplitKernelArguments(k) {
+			parsed = append(parsed, strings.TrimSpace(arg))
+		}
+	}
+	return parsed
+}
+
+// KernelArgumentKey returns the key of a kernel argument, i.e. everything
+// before the first '='. Bare arguments such as nosmt are their own key.
+func KernelArgumentKey(arg string) string {
+	if idx := strings.Index(arg, "="); idx != -1 {
+		return arg[:idx]
+	}
+	return arg
+}
+
+// KernelArgumentMatchesDeletion reports whether arg is removed by the
+// deletion entry del. An entry with a value only matches that exact
+// argument, a bare key matches every argument with that key.
+func KernelArgumentMatchesDeletion(arg, del string) bool {
+	if strings.Contains(del, "=") {
+		return arg == del
+	}
+	return KernelArgumentKey(arg) == del
+}
+
+// validateKernelArguments checks the kernel arguments of a single
+// MachineConfig for malformed quoting and for arguments that the config
+// both adds and deletes.
+func validateKernelArguments(cfg mcfgv1.MachineConfigSpec) error {
+	added := ParseKernelArguments(cfg.KernelArguments)
+	for _, arg := range added {
+		if strings.Count(arg, `"`)%2 != 0 {
+			return fmt.Errorf("kernel argument %q has unbalanced quotes", arg)
+		}
+	}
+
+	for _, entry := range cfg.KernelArgumentsToDelete {
+		dels := SplitKernelArguments(entry)
+		if len(dels) != 1 {
+			return fmt.Errorf("kernelArgumentsToDelete entry %q must contain exactly one argument", entry)
+		}
+		for _, arg := range added {
+			if KernelArgumentMatchesDeletion(arg, dels[0]) {
+				return fmt.Errorf("conflicting kernel arguments: %q is both added and deleted", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// repeatableKernelArgumentKeys are the keys the kernel accepts several times,
+// so MachineConfigs may each add their own values for them, e.g. a console on
+// tty0 in one config and on a serial port in another.
+var repeatableKernelArgumentKeys = []string{
+	"console",
+	"hugepagesz",
+	"hugepages",
+	"ip",
+	"memmap",
+	"nameserver",
+}
+
+// kargEntry tracks a single KernelArguments entry of a MachineConfig while
+// merging, so unmodified entries can be emitted verbatim.
+type kargEntry struct {
+	source  string
+	raw     string
+	args    []string
+	changed bool
+}
+
+// mergeKernelArguments merges the kernel arguments of configs, which must
+// already be sorted. Arguments with one of the repeatableKernelArgumentKeys
+// are kept from every config, in order. For any other key, a config may only
+// set the values that earlier configs already set for it, unless it first
+// deletes them with kernelArgumentsToDelete; setting different values is a
+// conflict and returns an error. Multiple values for the same key within one
+// config are kept in order. Deletions remove matching arguments set by
+// earlier configs and are carried over to the returned deletion list so the
+// daemon can also remove them from the booted command line.
+func mergeKernelArguments(configs []*mcfgv1.MachineConfig) (kargs, deletions []string, err error) {
+	var entries []*kargEntry
+
+	dropMatching := func(match func(string) bool) {
+		for _, e := range entries {
+			kept := e.args[:0]
+			for _, arg := range e.args {
+				if match(arg) {
+					e.changed = true
+					continue
+				}
+				kept = append(kept, arg)
+			}
+			e.args = kept
+		}
+	}
+
+	for _, cfg := range configs {
+		var cfgEntries []*kargEntry
+		// values maps each key this config sets to its arguments with that key
+		values := map[string][]string{}
+		var keys []string
+		for _, raw := range cfg.Spec.KernelArguments {
+			args := SplitKernelArguments(raw)
+			for i := range args {
+				args[i] = strings.TrimSpace(args[i])
+				key := KernelArgumentKey(args[i])
+				if _, ok := values[key]; !ok {
+					keys = append(keys, key)
+				}
+				values[key] = append(values[key], args[i])
+			}
+			cfgEntries = append(cfgEntries, &kargEntry{source: cfg.Name, raw: raw, args: args})
+		}
+
+		// A config re-adding a key wins over earlier deletions of it
+		kept := deletions[:0]
+		for _, del := range deletions {
+			if _, ok := values[KernelArgumentKey(del)]; !ok {
+				kept = append(kept, del)
+			}
+		}
+		deletions = kept
+
+		for _, entry := range cfg.Spec.KernelArgumentsToDelete {
+			del := strings.TrimSpace(entry)
+			glog.V(4).Infof("Kernel argument %q deleted by %s", del, cfg.Name)
+			dropMatching(func(arg string) bool { return KernelArgumentMatchesDeletion(arg, del) })
+			if !InSlice(del, deletions) {
+				deletions = append(deletions, del)
+			}
+		}
+
+		for _, key := range keys {
+			if InSlice(key, repeatableKernelArgumentKeys) {
+				continue
+			}
+			var earlier []string
+			var sources []string
+			for _, e := range entries {
+				for _, arg := range e.args {
+					if KernelArgumentKey(arg) == key {
+						earlier = append(earlier, arg)
+						if !InSlice(e.source, sources) {
+							sources = append(sources, e.source)
+						}
+					}
+				}
+			}
+			if len(earlier) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(earlier, values[key]) {
+				return nil, nil, fmt.Errorf("conflicting kernel arguments: %s sets %s while %s sets %s; delete the earlier values with kernelArgumentsToDelete to replace them",
+					strings.Join(sources, ", "), strings.Join(earlier, " "), cfg.Name, strings.Join(values[key], " "))
+			}
+			// The same values again, keep them only once
+			dropMatching(func(arg string) bool { return KernelArgumentKey(arg) == key })
+		}
+
+		entries = append(entries, cfgEntries...)
+	}
+
+	kargs = []string{}
+	for _, e := range entries {
+		switch {
+		case !e.changed:
+			kargs = append(kargs, e.raw)
+		case len(e.args) > 0:
+			kargs = append(kargs, strings.Join(e.args, " "))
+		}
+	}
+	if len(deletions) == 0 {
+		deletions = nil
+	}
+	return kargs, deletions, nil
+}
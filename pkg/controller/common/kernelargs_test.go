@@ -0,0 +1,157 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func newKargsMachineConfig(name string, kargs, deletions []string) *mcfgv1.MachineConfig {
+	return &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: mcfgv1.MachineConfigSpec{
+			KernelArguments:         kargs,
+			KernelArgumentsToDelete: deletions,
+		},
+	}
+}
+
+func TestMergeKernelArguments(t *testing.T) {
+	tests := []struct {
+		name          string
+		configs       []*mcfgv1.MachineConfig
+		expectedKargs []string
+		expectedDels  []string
+		wantErr       bool
+	}{
+		{
+			name: "distinct keys are concatenated verbatim",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"foo bar=1"}, nil),
+				newKargsMachineConfig("99-b", []string{"baz"}, nil),
+			},
+			expectedKargs: []string{"foo bar=1", "baz"},
+		},
+		{
+			name: "repeatable keys from several configs are kept",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"foo console=tty0 hugepagesz=2M hugepages=4"}, nil),
+				newKargsMachineConfig("99-b", []string{"console=ttyS0,115200", "hugepagesz=1G hugepages=2"}, nil),
+			},
+			expectedKargs: []string{"foo console=tty0 hugepagesz=2M hugepages=4", "console=ttyS0,115200", "hugepagesz=1G hugepages=2"},
+		},
+		{
+			name: "the same values from several configs are kept once",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"foo mitigations=auto"}, nil),
+				newKargsMachineConfig("99-b", []string{"mitigations=auto"}, nil),
+			},
+			expectedKargs: []string{"foo", "mitigations=auto"},
+		},
+		{
+			name: "conflicting values for a key are rejected",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"foo mitigations=auto"}, nil),
+				newKargsMachineConfig("99-b", []string{"mitigations=off"}, nil),
+			},
+			wantErr: true,
+		},
+		{
+			name: "deleting the earlier value replaces it",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"foo mitigations=auto"}, nil),
+				newKargsMachineConfig("99-b", []string{"mitigations=off"}, []string{"mitigations=auto"}),
+			},
+			expectedKargs: []string{"foo", "mitigations=off"},
+			expectedDels:  []string{"mitigations=auto"},
+		},
+		{
+			name: "repeated keys within one config are kept",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("99-b", []string{"isolcpus=1 isolcpus=2"}, nil),
+			},
+			expectedKargs: []string{"isolcpus=1 isolcpus=2"},
+		},
+		{
+			name: "deletion by key removes earlier arguments and is propagated",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"nosmt", "foo=1"}, nil),
+				newKargsMachineConfig("99-b", nil, []string{"nosmt"}),
+			},
+			expectedKargs: []string{"foo=1"},
+			expectedDels:  []string{"nosmt"},
+		},
+		{
+			name: "deletion by value only removes the exact argument",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", []string{"console=tty0 console=ttyS0"}, nil),
+				newKargsMachineConfig("99-b", nil, []string{"console=tty0"}),
+			},
+			expectedKargs: []string{"console=ttyS0"},
+			expectedDels:  []string{"console=tty0"},
+		},
+		{
+			name: "later config re-adds a deleted key",
+			configs: []*mcfgv1.MachineConfig{
+				newKargsMachineConfig("00-a", nil, []string{"mitigations"}),
+				newKargsMachineConfig("99-b", []string{"mitigations=off"}, nil),
+			},
+			expectedKargs: []string{"mitigations=off"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kargs, dels, err := mergeKernelArguments(test.configs)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedKargs, kargs)
+			assert.Equal(t, test.expectedDels, dels)
+		})
+	}
+}
+
+func TestValidateKernelArguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    mcfgv1.MachineConfigSpec
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			spec: mcfgv1.MachineConfigSpec{KernelArguments: []string{`foo bar="a b"`}, KernelArgumentsToDelete: []string{"baz"}},
+		},
+		{
+			name:    "unbalanced quotes",
+			spec:    mcfgv1.MachineConfigSpec{KernelArguments: []string{`bar="a b`}},
+			wantErr: true,
+		},
+		{
+			name:    "added and deleted in the same config",
+			spec:    mcfgv1.MachineConfigSpec{KernelArguments: []string{"hugepages=4"}, KernelArgumentsToDelete: []string{"hugepages"}},
+			wantErr: true,
+		},
+		{
+			name:    "multiple arguments in one deletion",
+			spec:    mcfgv1.MachineConfigSpec{KernelArgumentsToDelete: []string{"foo bar"}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateKernelArguments(test.spec)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/opencontainers/go-digest"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// ValidateOSImageVerification checks that a policy is complete and well-formed.
+// A nil policy is valid.
+func ValidateOSImageVerification(verification *mcfgv1.OSImageVerification) error {
+	if verification == nil {
+		return nil
+	}
+	switch verification.Policy {
+	case mcfgv1.OSImageVerificationUnverified:
+	case mcfgv1.OSImageVerificationDigestPinned:
+		for _, d := range verification.AllowedDigests {
+			if err := digest.Digest(d).Validate(); err != nil {
+				return fmt.Errorf("invalid allowed digest %q: %w", d, err)
+			}
+		}
+	case mcfgv1.OSImageVerificationSigned:
+		if len(verification.Keys) == 0 {
+			return fmt.Errorf("the %s policy requires at least one key", verification.Policy)
+		}
+		for i, key := range verification.Keys {
+			if key.Type != mcfgv1.OSImageVerificationKeyGPG && key.Type != mcfgv1.OSImageVerificationKeySigstore {
+				return fmt.Errorf("key %d: unknown key type %q", i, key.Type)
+			}
+			if strings.TrimSpace(key.PublicKey) == "" {
+				return fmt.Errorf("key %d: empty public key", i)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown OS image verification policy %q", verification.Policy)
+	}
+	return nil
+}
+
+// CheckOSImageURL checks what can be checked about an OS image reference without
+// fetching it: that it is pinned by digest, and that the digest is allowed. The
+// signatures required by the Signed policy are checked by the daemon.
+func CheckOSImageURL(osImageURL string, verification *mcfgv1.OSImageVerification) error {
+	if verification == nil || verification.Policy == mcfgv1.OSImageVerificationUnverified {
+		return nil
+	}
+	ref, err := reference.ParseNormalizedNamed(osImageURL)
+	if err != nil {
+		return fmt.Errorf("OS image %q can't be verified: %w", osImageURL, err)
+	}
+	canonical, ok := ref.(reference.Canonical)
+	if !ok {
+		return fmt.Errorf("OS image %q can't be verified: the %s policy requires it to be referenced by digest", osImageURL, verification.Policy)
+	}
+	if verification.Policy == mcfgv1.OSImageVerificationDigestPinned && len(verification.AllowedDigests) > 0 &&
+		!InSlice(canonical.Digest().String(), verification.AllowedDigests) {
+		return fmt.Errorf("OS image %q is not one of the allowed digests", osImageURL)
+	}
+	return nil
+}
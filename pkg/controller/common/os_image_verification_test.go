@@ -0,0 +1,91 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func TestValidateOSImageVerification(t *testing.T) {
+	tests := []struct {
+		name         string
+		verification *mcfgv1.OSImageVerification
+		wantErr      bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:         "unverified",
+			verification: &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationUnverified},
+		},
+		{
+			name:         "unknown policy",
+			verification: &mcfgv1.OSImageVerification{Policy: "Trusted"},
+			wantErr:      true,
+		},
+		{
+			name: "invalid digest",
+			verification: &mcfgv1.OSImageVerification{
+				Policy:         mcfgv1.OSImageVerificationDigestPinned,
+				AllowedDigests: []string{"sha256:abc"},
+			},
+			wantErr: true,
+		},
+		{
+			name:         "signed without keys",
+			verification: &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationSigned},
+			wantErr:      true,
+		},
+		{
+			name: "signed with an unknown key type",
+			verification: &mcfgv1.OSImageVerification{
+				Policy: mcfgv1.OSImageVerificationSigned,
+				Keys:   []mcfgv1.OSImageVerificationKey{{Type: "X509", PublicKey: "key"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed",
+			verification: &mcfgv1.OSImageVerification{
+				Policy: mcfgv1.OSImageVerificationSigned,
+				Keys:   []mcfgv1.OSImageVerificationKey{{Type: mcfgv1.OSImageVerificationKeySigstore, PublicKey: "key"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateOSImageVerification(test.verification)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckOSImageURL(t *testing.T) {
+	digestA := "sha256:" + strings.Repeat("a", 64)
+	digestB := "sha256:" + strings.Repeat("b", 64)
+	pinned := "quay.io/openshift/os@" + digestA
+	tagged := "quay.io/openshift/os:4.11"
+
+	pinnedPolicy := &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationDigestPinned}
+	signedPolicy := &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationSigned}
+
+	assert.NoError(t, CheckOSImageURL(tagged, nil))
+	assert.NoError(t, CheckOSImageURL(tagged, &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationUnverified}))
+	assert.Error(t, CheckOSImageURL(tagged, pinnedPolicy))
+	assert.Error(t, CheckOSImageURL(tagged, signedPolicy))
+	assert.NoError(t, CheckOSImageURL(pinned, pinnedPolicy))
+	assert.NoError(t, CheckOSImageURL(pinned, signedPolicy))
+
+	pinnedPolicy.AllowedDigests = []string{digestB}
+	assert.Error(t, CheckOSImageURL(pinned, pinnedPolicy))
+	pinnedPolicy.AllowedDigests = append(pinnedPolicy.AllowedDigests, digestA)
+	assert.NoError(t, CheckOSImageURL(pinned, pinnedPolicy))
+}
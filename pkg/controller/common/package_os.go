@@ -0,0 +1,85 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// packageRepoNameRegexp matches the repository IDs dnf accepts.
+var packageRepoNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// ValidatePackageOS checks that a package mapping is well-formed. A nil mapping is valid.
+func ValidatePackageOS(packageOS *mcfgv1.PackageOS) error {
+	if packageOS == nil {
+		return nil
+	}
+	osImages := map[string]bool{}
+	for i, set := range packageOS.OSImages {
+		if set.OSImageURL == "" {
+			return fmt.Errorf("osImages %d: empty osImageURL", i)
+		}
+		if osImages[set.OSImageURL] {
+			return fmt.Errorf("osImages %d: duplicate osImageURL %s", i, set.OSImageURL)
+		}
+		osImages[set.OSImageURL] = true
+		repos := map[string]bool{}
+		for _, repo := range set.Repos {
+			if !packageRepoNameRegexp.MatchString(repo.Name) {
+				return fmt.Errorf("osImages %d: invalid repo name %q", i, repo.Name)
+			}
+			if repos[repo.Name] {
+				return fmt.Errorf("osImages %d: duplicate repo %s", i, repo.Name)
+			}
+			repos[repo.Name] = true
+			if repo.BaseURL == "" {
+				return fmt.Errorf("osImages %d: repo %s has an empty baseURL", i, repo.Name)
+			}
+		}
+		if err := validatePackageNames(set.Packages); err != nil {
+			return fmt.Errorf("osImages %d: %w", i, err)
+		}
+	}
+	if err := validatePackageMappings(packageOS.Extensions, nil); err != nil {
+		return fmt.Errorf("extensions: %w", err)
+	}
+	if err := validatePackageMappings(packageOS.KernelTypes, []string{KernelTypeDefault, KernelTypeRealtime}); err != nil {
+		return fmt.Errorf("kernelTypes: %w", err)
+	}
+	return nil
+}
+
+// validatePackageMappings checks that mappings map distinct names, out of names if set,
+// to at least one package each.
+func validatePackageMappings(mappings []mcfgv1.PackageMapping, names []string) error {
+	seen := map[string]bool{}
+	for _, mapping := range mappings {
+		if mapping.Name == "" {
+			return fmt.Errorf("empty name")
+		}
+		if names != nil && !InSlice(mapping.Name, names) {
+			return fmt.Errorf("unknown name %q, must be one of %v", mapping.Name, names)
+		}
+		if seen[mapping.Name] {
+			return fmt.Errorf("duplicate name %s", mapping.Name)
+		}
+		seen[mapping.Name] = true
+		if len(mapping.Packages) == 0 {
+			return fmt.Errorf("%s maps to no packages", mapping.Name)
+		}
+		if err := validatePackageNames(mapping.Packages); err != nil {
+			return fmt.Errorf("%s: %w", mapping.Name, err)
+		}
+	}
+	return nil
+}
+
+func validatePackageNames(packages []string) error {
+	for _, pkg := range packages {
+		if pkg == "" || pkg[0] == '-' {
+			return fmt.Errorf("invalid package %q", pkg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func TestValidatePackageOS(t *testing.T) {
+	snapshot := []mcfgv1.PackageRepo{{Name: "rhel-8.6-baseos", BaseURL: "https://mirror.example.com/8.6/baseos"}}
+	assert.NoError(t, ValidatePackageOS(nil))
+	assert.NoError(t, ValidatePackageOS(&mcfgv1.PackageOS{
+		OSImages:    []mcfgv1.PackageSet{{OSImageURL: "quay.io/openshift/os@sha256:abc", Repos: snapshot, Packages: []string{"cri-o-1.25.1-5.el8"}}},
+		Extensions:  []mcfgv1.PackageMapping{{Name: "usbguard", Packages: []string{"usbguard"}}},
+		KernelTypes: []mcfgv1.PackageMapping{{Name: "realtime", Packages: []string{"kernel-rt-core", "kernel-rt-modules"}}},
+	}))
+
+	for _, packageOS := range []*mcfgv1.PackageOS{
+		{OSImages: []mcfgv1.PackageSet{{Packages: []string{"cri-o"}}}},
+		{OSImages: []mcfgv1.PackageSet{{OSImageURL: "os:1"}, {OSImageURL: "os:1"}}},
+		{OSImages: []mcfgv1.PackageSet{{OSImageURL: "os:1", Repos: []mcfgv1.PackageRepo{{Name: "a b", BaseURL: "https://mirror.example.com"}}}}},
+		{OSImages: []mcfgv1.PackageSet{{OSImageURL: "os:1", Repos: []mcfgv1.PackageRepo{{Name: "baseos"}}}}},
+		{OSImages: []mcfgv1.PackageSet{{OSImageURL: "os:1", Packages: []string{"--nogpgcheck"}}}},
+		{Extensions: []mcfgv1.PackageMapping{{Name: "usbguard"}}},
+		{KernelTypes: []mcfgv1.PackageMapping{{Name: "64k-pages", Packages: []string{"kernel-64k"}}}},
+	} {
+		assert.Error(t, ValidatePackageOS(packageOS), "%+v", packageOS)
+	}
+}
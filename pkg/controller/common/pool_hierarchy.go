@@ -0,0 +1,55 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// GetPoolHierarchy returns pool followed by the pools it inherits from, nearest
+// parent first. getPool looks up pools by name, e.g. a MachineConfigPoolLister's Get.
+// It is an error for a parent to be missing or for the parents to form a cycle.
+func GetPoolHierarchy(pool *mcfgv1.MachineConfigPool, getPool func(string) (*mcfgv1.MachineConfigPool, error)) ([]*mcfgv1.MachineConfigPool, error) {
+	hierarchy := []*mcfgv1.MachineConfigPool{pool}
+	seen := map[string]bool{pool.Name: true}
+	for cur := pool; cur.Spec.Parent != ""; {
+		if seen[cur.Spec.Parent] {
+			names := []string{}
+			for _, p := range hierarchy {
+				names = append(names, p.Name)
+			}
+			return nil, fmt.Errorf("pool inheritance cycle: %s -> %s", strings.Join(names, " -> "), cur.Spec.Parent)
+		}
+		parent, err := getPool(cur.Spec.Parent)
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("parent pool %s of pool %s not found", cur.Spec.Parent, cur.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seen[parent.Name] = true
+		hierarchy = append(hierarchy, parent)
+		cur = parent
+	}
+	return hierarchy, nil
+}
+
+// IsPoolDescendant returns whether pool inherits from ancestor, directly or
+// through other pools. Only the part of the hierarchy that resolves is considered.
+func IsPoolDescendant(pool *mcfgv1.MachineConfigPool, ancestor string, getPool func(string) (*mcfgv1.MachineConfigPool, error)) bool {
+	seen := map[string]bool{pool.Name: true}
+	for cur := pool; cur.Spec.Parent != "" && !seen[cur.Spec.Parent]; {
+		if cur.Spec.Parent == ancestor {
+			return true
+		}
+		parent, err := getPool(cur.Spec.Parent)
+		if err != nil {
+			return false
+		}
+		seen[parent.Name] = true
+		cur = parent
+	}
+	return false
+}
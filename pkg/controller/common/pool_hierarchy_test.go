@@ -0,0 +1,87 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func newPoolWithParent(name, parent string) *mcfgv1.MachineConfigPool {
+	pool := &mcfgv1.MachineConfigPool{}
+	pool.Name = name
+	pool.Spec.Parent = parent
+	return pool
+}
+
+func poolGetter(pools ...*mcfgv1.MachineConfigPool) func(string) (*mcfgv1.MachineConfigPool, error) {
+	return func(name string) (*mcfgv1.MachineConfigPool, error) {
+		for _, pool := range pools {
+			if pool.Name == name {
+				return pool, nil
+			}
+		}
+		return nil, apierrors.NewNotFound(mcfgv1.Resource("machineconfigpool"), name)
+	}
+}
+
+func poolNames(pools []*mcfgv1.MachineConfigPool) []string {
+	var names []string
+	for _, pool := range pools {
+		names = append(names, pool.Name)
+	}
+	return names
+}
+
+func TestGetPoolHierarchy(t *testing.T) {
+	worker := newPoolWithParent("worker", "")
+	gpu := newPoolWithParent("gpu", "worker")
+	a100 := newPoolWithParent("gpu-a100", "gpu")
+	getPool := poolGetter(worker, gpu, a100)
+
+	hierarchy, err := GetPoolHierarchy(worker, getPool)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"worker"}, poolNames(hierarchy))
+
+	hierarchy, err = GetPoolHierarchy(a100, getPool)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gpu-a100", "gpu", "worker"}, poolNames(hierarchy))
+
+	_, err = GetPoolHierarchy(newPoolWithParent("orphan", "missing"), getPool)
+	assert.EqualError(t, err, "parent pool missing of pool orphan not found")
+
+	a := newPoolWithParent("a", "b")
+	b := newPoolWithParent("b", "c")
+	c := newPoolWithParent("c", "a")
+	_, err = GetPoolHierarchy(a, poolGetter(a, b, c))
+	assert.EqualError(t, err, "pool inheritance cycle: a -> b -> c -> a")
+
+	self := newPoolWithParent("self", "self")
+	_, err = GetPoolHierarchy(self, poolGetter(self))
+	assert.EqualError(t, err, "pool inheritance cycle: self -> self")
+}
+
+func TestIsPoolDescendant(t *testing.T) {
+	worker := newPoolWithParent("worker", "")
+	gpu := newPoolWithParent("gpu", "worker")
+	a100 := newPoolWithParent("gpu-a100", "gpu")
+	getPool := poolGetter(worker, gpu, a100)
+
+	assert.True(t, IsPoolDescendant(a100, "gpu", getPool))
+	assert.True(t, IsPoolDescendant(a100, "worker", getPool))
+	assert.False(t, IsPoolDescendant(a100, "gpu-a100", getPool))
+	assert.False(t, IsPoolDescendant(worker, "gpu", getPool))
+
+	// A missing grandparent doesn't hide the parent
+	orphan := newPoolWithParent("orphan", "gpu-missing")
+	assert.True(t, IsPoolDescendant(orphan, "gpu-missing", getPool))
+
+	// Cycles terminate
+	a := newPoolWithParent("a", "b")
+	b := newPoolWithParent("b", "a")
+	assert.True(t, IsPoolDescendant(a, "b", poolGetter(a, b)))
+	assert.False(t, IsPoolDescendant(a, "c", poolGetter(a, b)))
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	// OSImageVerificationPath is where a rendered config carries the OS image
+	// verification policy of its pool, for the daemon to check before rebasing.
+	OSImageVerificationPath = "/etc/machine-config-daemon/os-image-verification.json"
+	// KernelArgumentTuningPath is where a rendered config carries the kernel argument
+	// tuning allowlist of its pool, for the daemon to check per-node tuning against.
+	KernelArgumentTuningPath = "/etc/machine-config-daemon/kernel-argument-tuning.json"
+	// IOTuningPath is where a rendered config carries the I/O tuning of its pool,
+	// for the daemon to apply.
+	IOTuningPath = "/etc/machine-config-daemon/io-tuning.json"
+	// InteractiveAccessPolicyPath is where a rendered config carries the interactive
+	// access policy of its pool, for the daemon to enforce.
+	InteractiveAccessPolicyPath = "/etc/machine-config-daemon/interactive-access-policy.json"
+	// PackageOSPath is where a rendered config carries the package mapping of its pool,
+	// for the daemon of package-based nodes to install.
+	PackageOSPath = "/etc/machine-config-daemon/package-os.json"
+)
+
+// PoolPolicy is a setting of MachineConfigPoolSpec that pools inherit from their
+// parents and that rendered configs carry to the daemon as a JSON file at Path.
+type PoolPolicy[T any] struct {
+	// Path is where a rendered config carries the policy.
+	Path string
+	// fromSpec returns the policy set on a pool itself, or nil.
+	fromSpec func(spec *mcfgv1.MachineConfigPoolSpec) *T
+}
+
+var (
+	// OSImageVerificationPolicy is the OS image verification policy of a pool.
+	OSImageVerificationPolicy = PoolPolicy[mcfgv1.OSImageVerification]{
+		Path: OSImageVerificationPath,
+		fromSpec: func(spec *mcfgv1.MachineConfigPoolSpec) *mcfgv1.OSImageVerification {
+			return spec.OSImageVerification
+		},
+	}
+	// KernelArgumentTuningPolicy is the kernel argument tuning allowlist of a pool.
+	// An empty allowlist on a pool disallows everything its parent allows.
+	KernelArgumentTuningPolicy = PoolPolicy[mcfgv1.KernelArgumentTuning]{
+		Path: KernelArgumentTuningPath,
+		fromSpec: func(spec *mcfgv1.MachineConfigPoolSpec) *mcfgv1.KernelArgumentTuning {
+			return spec.KernelArgumentTuning
+		},
+	}
+	// IOTuningPolicy is the I/O tuning of a pool.
+	IOTuningPolicy = PoolPolicy[mcfgv1.IOTuning]{
+		Path: IOTuningPath,
+		fromSpec: func(spec *mcfgv1.MachineConfigPoolSpec) *mcfgv1.IOTuning {
+			return spec.IOTuning
+		},
+	}
+	// InteractiveAccessPolicy is the interactive access policy of a pool.
+	InteractiveAccessPolicy = PoolPolicy[mcfgv1.InteractiveAccessPolicy]{
+		Path: InteractiveAccessPolicyPath,
+		fromSpec: func(spec *mcfgv1.MachineConfigPoolSpec) *mcfgv1.InteractiveAccessPolicy {
+			return spec.InteractiveAccessPolicy
+		},
+	}
+	// PackageOSPolicy is the package mapping of a pool.
+	PackageOSPolicy = PoolPolicy[mcfgv1.PackageOS]{
+		Path: PackageOSPath,
+		fromSpec: func(spec *mcfgv1.MachineConfigPoolSpec) *mcfgv1.PackageOS {
+			return spec.PackageOS
+		},
+	}
+)
+
+// Get returns the policy in effect for the first pool of a hierarchy, as returned by
+// GetPoolHierarchy: that of the nearest pool that has one, or nil.
+func (p PoolPolicy[T]) Get(hierarchy []*mcfgv1.MachineConfigPool) *T {
+	for _, pool := range hierarchy {
+		if policy := p.fromSpec(&pool.Spec); policy != nil {
+			return policy
+		}
+	}
+	return nil
+}
+
+// Set adds the policy to the Ignition config of a rendered config, replacing any policy
+// already there. A nil policy leaves the config untouched, so rendered configs of pools
+// without a policy are the same as before the policy existed.
+func (p PoolPolicy[T]) Set(mc *mcfgv1.MachineConfig, policy *T) error {
+	if policy == nil {
+		return nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return setIgnitionFileData(mc, p.Path, data)
+}
+
+// GetFromIgnition returns the policy a rendered config carries, or nil.
+func (p PoolPolicy[T]) GetFromIgnition(ignCfg *ign3types.Config) (*T, error) {
+	data, err := GetIgnitionFileDataByPath(ignCfg, p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+	policy := new(T)
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", p.Path, err)
+	}
+	return policy, nil
+}
+
+// setIgnitionFileData writes data to path in the Ignition config of mc, replacing any file already there.
+func setIgnitionFileData(mc *mcfgv1.MachineConfig, path string, data []byte) error {
+	ignCfg, err := ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return err
+	}
+	var files []ign3types.File
+	for _, f := range ignCfg.Storage.Files {
+		if f.Path != path {
+			files = append(files, f)
+		}
+	}
+	ignCfg.Storage.Files = append(files, NewIgnFileBytesOverwriting(path, data))
+	rawIgn, err := json.Marshal(ignCfg)
+	if err != nil {
+		return err
+	}
+	mc.Spec.Config.Raw = rawIgn
+	return nil
+}
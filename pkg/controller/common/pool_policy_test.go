@@ -0,0 +1,108 @@
+package common
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestPoolPolicies(t *testing.T) {
+	depth := int32(64)
+	tests := []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{
+			name: "OS image verification",
+			test: func(t *testing.T) {
+				testPoolPolicy(t, OSImageVerificationPolicy,
+					func(spec *mcfgv1.MachineConfigPoolSpec, p *mcfgv1.OSImageVerification) { spec.OSImageVerification = p },
+					&mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationDigestPinned},
+					&mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationUnverified})
+			},
+		},
+		{
+			name: "kernel argument tuning",
+			test: func(t *testing.T) {
+				// An empty allowlist on the pool itself disallows everything its parent allows
+				testPoolPolicy(t, KernelArgumentTuningPolicy,
+					func(spec *mcfgv1.MachineConfigPoolSpec, p *mcfgv1.KernelArgumentTuning) {
+						spec.KernelArgumentTuning = p
+					},
+					&mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"nosmt", "mitigations=auto"}},
+					&mcfgv1.KernelArgumentTuning{})
+			},
+		},
+		{
+			name: "I/O tuning",
+			test: func(t *testing.T) {
+				testPoolPolicy(t, IOTuningPolicy,
+					func(spec *mcfgv1.MachineConfigPoolSpec, p *mcfgv1.IOTuning) { spec.IOTuning = p },
+					&mcfgv1.IOTuning{Schedulers: []mcfgv1.IOScheduler{{DeviceClass: mcfgv1.BlockDeviceClassNVMe, Scheduler: "none"}}},
+					&mcfgv1.IOTuning{QueueDepth: &depth})
+			},
+		},
+		{
+			name: "interactive access policy",
+			test: func(t *testing.T) {
+				testPoolPolicy(t, InteractiveAccessPolicy,
+					func(spec *mcfgv1.MachineConfigPoolSpec, p *mcfgv1.InteractiveAccessPolicy) {
+						spec.InteractiveAccessPolicy = p
+					},
+					&mcfgv1.InteractiveAccessPolicy{Action: mcfgv1.InteractiveAccessActionRevalidate},
+					&mcfgv1.InteractiveAccessPolicy{Action: mcfgv1.InteractiveAccessActionReprovision})
+			},
+		},
+		{
+			name: "package mapping",
+			test: func(t *testing.T) {
+				testPoolPolicy(t, PackageOSPolicy,
+					func(spec *mcfgv1.MachineConfigPoolSpec, p *mcfgv1.PackageOS) { spec.PackageOS = p },
+					&mcfgv1.PackageOS{OSImages: []mcfgv1.PackageSet{{OSImageURL: "os:1", Packages: []string{"cri-o-1.25.1-5.el8"}}}},
+					&mcfgv1.PackageOS{Extensions: []mcfgv1.PackageMapping{{Name: "usbguard", Packages: []string{"usbguard"}}}})
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, test.test)
+	}
+}
+
+// testPoolPolicy checks that a pool inherits parent from its parent unless it sets own,
+// and that the policy round-trips through a rendered config.
+func testPoolPolicy[T any](t *testing.T, policy PoolPolicy[T], setOnSpec func(*mcfgv1.MachineConfigPoolSpec, *T), parent, own *T) {
+	parentPool := &mcfgv1.MachineConfigPool{}
+	setOnSpec(&parentPool.Spec, parent)
+	child := &mcfgv1.MachineConfigPool{Spec: mcfgv1.MachineConfigPoolSpec{Parent: "parent"}}
+
+	assert.Nil(t, policy.Get([]*mcfgv1.MachineConfigPool{child}))
+	assert.Equal(t, parent, policy.Get([]*mcfgv1.MachineConfigPool{child, parentPool}))
+	setOnSpec(&child.Spec, own)
+	assert.Equal(t, own, policy.Get([]*mcfgv1.MachineConfigPool{child, parentPool}))
+
+	mc := helpers.NewMachineConfig("rendered-worker", nil, "", []ign3types.File{})
+	raw := mc.Spec.Config.Raw
+	require.NoError(t, policy.Set(mc, nil))
+	assert.Equal(t, raw, mc.Spec.Config.Raw)
+
+	ignCfg, err := ParseAndConvertConfig(mc.Spec.Config.Raw)
+	require.NoError(t, err)
+	got, err := policy.GetFromIgnition(&ignCfg)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// Setting it again replaces the policy rather than adding a second file
+	require.NoError(t, policy.Set(mc, parent))
+	require.NoError(t, policy.Set(mc, own))
+	ignCfg, err = ParseAndConvertConfig(mc.Spec.Config.Raw)
+	require.NoError(t, err)
+	assert.Len(t, ignCfg.Storage.Files, 1)
+	got, err = policy.GetFromIgnition(&ignCfg)
+	require.NoError(t, err)
+	assert.Equal(t, own, got)
+}
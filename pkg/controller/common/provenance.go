@@ -0,0 +1,157 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	// ProvenanceAnnotationKey is set on rendered MachineConfigs and holds a JSON
+	// encoded ConfigProvenance describing which source MachineConfig set each path,
+	// unit and kernel argument.
+	ProvenanceAnnotationKey = "machineconfiguration.openshift.io/provenance"
+
+	// maxProvenanceAnnotationSize keeps the provenance well below the 256KiB limit
+	// on the total size of an object's annotations.
+	maxProvenanceAnnotationSize = 128 * 1024
+)
+
+// ProvenanceEntry records the MachineConfig that won for a single item of a
+// rendered config and the MachineConfigs it overrode, in merge order.
+type ProvenanceEntry struct {
+	Source     string   `json:"source"`
+	Overridden []string `json:"overridden,omitempty"`
+	// Deleted is set for kernel arguments removed via kernelArgumentsToDelete
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// ConfigProvenance maps the items of a rendered MachineConfig to the source
+// MachineConfigs that set them.
+type ConfigProvenance struct {
+	// Paths is keyed by the path of Ignition files, directories and links
+	Paths map[string]ProvenanceEntry `json:"paths,omitempty"`
+	// Units is keyed by systemd unit name, dropins are keyed as <unit>/<dropin>
+	Units map[string]ProvenanceEntry `json:"units,omitempty"`
+	// KernelArguments is keyed by kernel argument key, e.g. hugepages, or by
+	// the full argument for deletions of a single value
+	KernelArguments map[string]ProvenanceEntry `json:"kernelArguments,omitempty"`
+}
+
+func (p *ConfigProvenance) record(m map[string]ProvenanceEntry, key, source string, deleted bool) {
+	entry, ok := m[key]
+	if ok && entry.Source != source {
+		entry.Overridden = append(entry.Overridden, entry.Source)
+	}
+	entry.Source = source
+	entry.Deleted = deleted
+	m[key] = entry
+}
+
+// ComputeProvenance walks configs in the same order MergeMachineConfigs merges
+// them and records for each path, unit and kernel argument key the last
+// MachineConfig that sets it.
+func ComputeProvenance(configs []*mcfgv1.MachineConfig) (*ConfigProvenance, error) {
+	sorted := make([]*mcfgv1.MachineConfig, len(configs))
+	copy(sorted, configs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return ComputeProvenanceInOrder(sorted)
+}
+
+// ComputeProvenanceInOrder is ComputeProvenance for configs in the order
+// MergeMachineConfigsInOrder merges them.
+func ComputeProvenanceInOrder(configs []*mcfgv1.MachineConfig) (*ConfigProvenance, error) {
+	p := &ConfigProvenance{
+		Paths:           map[string]ProvenanceEntry{},
+		Units:           map[string]ProvenanceEntry{},
+		KernelArguments: map[string]ProvenanceEntry{},
+	}
+	for _, cfg := range configs {
+		if cfg.Spec.Config.Raw != nil {
+			ign, err := ParseAndConvertConfig(cfg.Spec.Config.Raw)
+			if err != nil {
+				return nil, fmt.Errorf("parsing Ignition config of %s: %w", cfg.Name, err)
+			}
+			recordIgnitionProvenance(p, cfg.Name, &ign)
+		}
+
+		for _, arg := range ParseKernelArguments(cfg.Spec.KernelArguments) {
+			p.record(p.KernelArguments, KernelArgumentKey(arg), cfg.Name, false)
+		}
+		// deletions of a single value (e.g. console=tty0) are keyed by the full
+		// argument so they don't hide other values of the same key
+		for _, del := range cfg.Spec.KernelArgumentsToDelete {
+			p.record(p.KernelArguments, strings.TrimSpace(del), cfg.Name, true)
+		}
+	}
+	return p, nil
+}
+
+func recordIgnitionProvenance(p *ConfigProvenance, source string, ign *ign3types.Config) {
+	for _, f := range ign.Storage.Files {
+		p.record(p.Paths, f.Path, source, false)
+	}
+	for _, d := range ign.Storage.Directories {
+		p.record(p.Paths, d.Path, source, false)
+	}
+	for _, l := range ign.Storage.Links {
+		p.record(p.Paths, l.Path, source, false)
+	}
+	for _, u := range ign.Systemd.Units {
+		// a unit listed only to carry dropins does not set the unit itself
+		if u.Contents != nil || u.Enabled != nil || u.Mask != nil || len(u.Dropins) == 0 {
+			p.record(p.Units, u.Name, source, false)
+		}
+		for _, d := range u.Dropins {
+			p.record(p.Units, u.Name+"/"+d.Name, source, false)
+		}
+	}
+}
+
+// SetProvenanceAnnotation stores the provenance on the rendered MachineConfig. It
+// returns an error and leaves mc untouched if the encoded provenance would make
+// the object too large.
+func SetProvenanceAnnotation(mc *mcfgv1.MachineConfig, p *ConfigProvenance) error {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if len(raw) > maxProvenanceAnnotationSize {
+		return fmt.Errorf("provenance of %s is %d bytes, exceeding the limit of %d", mc.Name, len(raw), maxProvenanceAnnotationSize)
+	}
+	if mc.Annotations == nil {
+		mc.Annotations = map[string]string{}
+	}
+	mc.Annotations[ProvenanceAnnotationKey] = string(raw)
+	return nil
+}
+
+// GetProvenance returns the provenance recorded on a rendered MachineConfig, or
+// nil if it has none.
+func GetProvenance(mc *mcfgv1.MachineConfig) (*ConfigProvenance, error) {
+	raw, ok := mc.Annotations[ProvenanceAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	p := &ConfigProvenance{}
+	if err := json.Unmarshal([]byte(raw), p); err != nil {
+		return nil, fmt.Errorf("parsing provenance of %s: %w", mc.Name, err)
+	}
+	return p, nil
+}
+
+// Lookup returns the provenance of a path, unit, unit dropin (<unit>/<dropin>)
+// or kernel argument key, trying them in that order.
+func (p *ConfigProvenance) Lookup(item string) (ProvenanceEntry, bool) {
+	for _, m := range []map[string]ProvenanceEntry{p.Paths, p.Units, p.KernelArguments} {
+		if entry, ok := m[item]; ok {
+			return entry, true
+		}
+	}
+	return ProvenanceEntry{}, false
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestComputeProvenance(t *testing.T) {
+	crio := ign3types.Unit{Name: "crio.service", Contents: helpers.StrToPtr("[Unit]")}
+	dropin := ign3types.Unit{Name: "crio.service", Dropins: []ign3types.Dropin{{Name: "10-foo.conf", Contents: helpers.StrToPtr("[Service]")}}}
+
+	base := helpers.NewMachineConfigExtended("00-worker", nil,
+		[]ign3types.File{NewIgnFile("/etc/foo", "base"), NewIgnFile("/etc/bar", "base")},
+		[]ign3types.Unit{crio}, nil, nil, false, []string{"hugepages=2 nosmt"}, "", "")
+	kubelet := helpers.NewMachineConfigExtended("01-worker-kubelet", nil,
+		[]ign3types.File{NewIgnFile("/etc/foo", "kubelet")},
+		[]ign3types.Unit{dropin}, nil, nil, false, nil, "", "")
+	user := helpers.NewMachineConfigExtended("99-worker-user", nil,
+		[]ign3types.File{NewIgnFile("/etc/foo", "user")},
+		nil, nil, nil, false, []string{"hugepages=8"}, "", "")
+	user.Spec.KernelArgumentsToDelete = []string{"nosmt", "console=tty0"}
+
+	// the order of the input does not matter, configs are merged by name
+	p, err := ComputeProvenance([]*mcfgv1.MachineConfig{user, base, kubelet})
+	require.NoError(t, err)
+
+	assert.Equal(t, ProvenanceEntry{Source: "99-worker-user", Overridden: []string{"00-worker", "01-worker-kubelet"}}, p.Paths["/etc/foo"])
+	assert.Equal(t, ProvenanceEntry{Source: "00-worker"}, p.Paths["/etc/bar"])
+	assert.Equal(t, ProvenanceEntry{Source: "00-worker"}, p.Units["crio.service"])
+	assert.Equal(t, ProvenanceEntry{Source: "01-worker-kubelet"}, p.Units["crio.service/10-foo.conf"])
+	assert.Equal(t, ProvenanceEntry{Source: "99-worker-user", Overridden: []string{"00-worker"}}, p.KernelArguments["hugepages"])
+	assert.Equal(t, ProvenanceEntry{Source: "99-worker-user", Overridden: []string{"00-worker"}, Deleted: true}, p.KernelArguments["nosmt"])
+	assert.Equal(t, ProvenanceEntry{Source: "99-worker-user", Deleted: true}, p.KernelArguments["console=tty0"])
+
+	// round trip through the annotation
+	mc := &mcfgv1.MachineConfig{}
+	require.NoError(t, SetProvenanceAnnotation(mc, p))
+	got, err := GetProvenance(mc)
+	require.NoError(t, err)
+	assert.Equal(t, p, got)
+
+	entry, ok := got.Lookup("/etc/foo")
+	assert.True(t, ok)
+	assert.Equal(t, "99-worker-user", entry.Source)
+	_, ok = got.Lookup("/etc/missing")
+	assert.False(t, ok)
+
+	got, err = GetProvenance(&mcfgv1.MachineConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -0,0 +1,368 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/golang/glog"
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+const (
+	// remoteFileFetchTimeout bounds a single download of a remote file source
+	remoteFileFetchTimeout = 10 * time.Minute
+	// remoteFileMaxSize bounds the size of a remote file source
+	remoteFileMaxSize = 1 << 30
+)
+
+// IsRemoteFileSource returns true if an Ignition file source has to be fetched
+// over the network, i.e. it is an http:// or https:// URL rather than an inline
+// data: URL.
+func IsRemoteFileSource(source *string) bool {
+	if source == nil {
+		return false
+	}
+	u, err := url.Parse(*source)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// RemoteFileFetcher downloads remote Ignition file sources, verifies them
+// against their verification hash and caches them on disk keyed by that hash.
+type RemoteFileFetcher struct {
+	client *http.Client
+	// cacheDir holds fetched contents as <algorithm>-<sum>, caching is disabled
+	// if empty
+	cacheDir string
+	// maxSize is the largest remote file source fetched, in bytes
+	maxSize int64
+}
+
+// NewRemoteFileFetcher returns a fetcher that uses the given cluster proxy and
+// trusts additionalTrustBundle on top of the system roots. With a nil proxy the
+// standard proxy environment variables are used instead.
+func NewRemoteFileFetcher(proxy *configv1.ProxyStatus, additionalTrustBundle []byte, cacheDir string) (*RemoteFileFetcher, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		glog.Warningf("Failed to load system trust store, only trusting the additional trust bundle: %v", err)
+		roots = x509.NewCertPool()
+	}
+	if len(additionalTrustBundle) > 0 && !roots.AppendCertsFromPEM(additionalTrustBundle) {
+		return nil, fmt.Errorf("additional trust bundle does not contain any PEM encoded certificates")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(proxy)
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+
+	return &RemoteFileFetcher{
+		client:   &http.Client{Transport: transport, Timeout: remoteFileFetchTimeout},
+		cacheDir: cacheDir,
+		maxSize:  remoteFileMaxSize,
+	}, nil
+}
+
+// proxyFunc selects a proxy for a request the same way the proxy environment
+// variables are interpreted, but from the cluster proxy status.
+func proxyFunc(proxy *configv1.ProxyStatus) func(*http.Request) (*url.URL, error) {
+	if proxy == nil {
+		return http.ProxyFromEnvironment
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if matchesNoProxy(req.URL.Hostname(), proxy.NoProxy) {
+			return nil, nil
+		}
+		p := proxy.HTTPProxy
+		if req.URL.Scheme == "https" {
+			p = proxy.HTTPSProxy
+		}
+		if p == "" {
+			return nil, nil
+		}
+		return url.Parse(p)
+	}
+}
+
+// matchesNoProxy reports whether host is excluded from proxying by a NO_PROXY
+// style list of hosts, domain suffixes and CIDRs.
+func matchesNoProxy(host, noProxy string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case ip != nil && strings.Contains(entry, "/"):
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseVerificationHash splits an Ignition verification hash of the form
+// <sha512|sha256>-<hex sum> and returns a hash to check the contents with.
+func parseVerificationHash(verification ign3types.Verification) (hash.Hash, string, error) {
+	if verification.Hash == nil || *verification.Hash == "" {
+		return nil, "", fmt.Errorf("remote file sources require a verification hash")
+	}
+	parts := strings.SplitN(*verification.Hash, "-", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("malformed verification hash %q", *verification.Hash)
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return nil, "", fmt.Errorf("malformed verification hash %q: %w", *verification.Hash, err)
+	}
+	switch parts[0] {
+	case "sha512":
+		return sha512.New(), strings.ToLower(parts[1]), nil
+	case "sha256":
+		return sha256.New(), strings.ToLower(parts[1]), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported verification hash algorithm %q", parts[0])
+	}
+}
+
+func verifyContents(contents []byte, verification ign3types.Verification) error {
+	h, sum, err := parseVerificationHash(verification)
+	if err != nil {
+		return err
+	}
+	h.Write(contents)
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("verification hash mismatch: expected %s, got %s", sum, actual)
+	}
+	return nil
+}
+
+func (f *RemoteFileFetcher) cachePath(verification ign3types.Verification) string {
+	if f.cacheDir == "" {
+		return ""
+	}
+	return filepath.Join(f.cacheDir, strings.ToLower(*verification.Hash))
+}
+
+// Fetch returns the raw, still compressed, contents of a remote file source
+// after verifying them against the verification hash. Contents are served from
+// the cache if present.
+func (f *RemoteFileFetcher) Fetch(res ign3types.Resource) ([]byte, error) {
+	if err := checkRemoteFileSource(res); err != nil {
+		return nil, err
+	}
+
+	cachePath := f.cachePath(res.Verification)
+	if cachePath == "" {
+		var buf bytes.Buffer
+		if err := f.download(res, &buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if contents, err := ioutil.ReadFile(cachePath); err == nil {
+		if err := verifyContents(contents, res.Verification); err == nil {
+			return contents, nil
+		}
+		glog.Warningf("Discarding corrupted cache entry %s for %s", cachePath, *res.Source)
+	}
+	if err := f.downloadToCache(res, cachePath); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(cachePath)
+}
+
+// Verify fetches a remote file source and checks it against its verification
+// hash without keeping it in memory. With a cache, the contents are stored
+// there and sources already in the cache aren't fetched again.
+func (f *RemoteFileFetcher) Verify(res ign3types.Resource) error {
+	if err := checkRemoteFileSource(res); err != nil {
+		return err
+	}
+	cachePath := f.cachePath(res.Verification)
+	if cachePath == "" {
+		return f.download(res, ioutil.Discard)
+	}
+	// Entries are only renamed into place once verified
+	if _, err := os.Stat(cachePath); err == nil {
+		return nil
+	}
+	return f.downloadToCache(res, cachePath)
+}
+
+func checkRemoteFileSource(res ign3types.Resource) error {
+	if !IsRemoteFileSource(res.Source) {
+		return fmt.Errorf("not a remote file source")
+	}
+	if _, _, err := parseVerificationHash(res.Verification); err != nil {
+		return fmt.Errorf("%s: %w", *res.Source, err)
+	}
+	return nil
+}
+
+// download streams a remote file source to w while hashing it, and fails if
+// it is larger than maxSize or doesn't match its verification hash.
+func (f *RemoteFileFetcher) download(res ign3types.Resource, w io.Writer) error {
+	h, sum, err := parseVerificationHash(res.Verification)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *res.Source, err)
+	}
+	req, err := http.NewRequest(http.MethodGet, *res.Source, nil)
+	if err != nil {
+		return err
+	}
+	for _, header := range res.HTTPHeaders {
+		if header.Value != nil {
+			req.Header.Set(header.Name, *header.Value)
+		}
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", *res.Source, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: unexpected status %s", *res.Source, resp.Status)
+	}
+	if resp.ContentLength > f.maxSize {
+		return fmt.Errorf("%s: size %d exceeds the limit of %d bytes", *res.Source, resp.ContentLength, f.maxSize)
+	}
+	n, err := io.Copy(io.MultiWriter(h, w), io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *res.Source, err)
+	}
+	if n > f.maxSize {
+		return fmt.Errorf("%s: exceeds the limit of %d bytes", *res.Source, f.maxSize)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("%s: verification hash mismatch: expected %s, got %s", *res.Source, sum, actual)
+	}
+	glog.Infof("Fetched %d bytes from %s", n, *res.Source)
+	return nil
+}
+
+// downloadToCache streams a remote file source to a temporary file next to
+// path and renames it into place once it is verified.
+func (f *RemoteFileFetcher) downloadToCache(res ign3types.Resource, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := f.download(res, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// DecodeIgnitionFileContents returns the uncompressed contents of a file
+// resource, fetching and verifying it first if it has a remote source.
+func (f *RemoteFileFetcher) DecodeIgnitionFileContents(res ign3types.Resource) ([]byte, error) {
+	if !IsRemoteFileSource(res.Source) {
+		return DecodeIgnitionFileContents(res.Source, res.Compression)
+	}
+	raw, err := f.Fetch(res)
+	if err != nil {
+		return []byte{}, err
+	}
+	return decompressIgnitionFileContents(raw, res.Compression)
+}
+
+// fileResources returns the contents and append fragments of a file.
+func fileResources(file ign3types.File) []ign3types.Resource {
+	return append([]ign3types.Resource{file.Contents}, file.Append...)
+}
+
+// HasRemoteFileSources returns true if any file contents or append fragment of
+// an Ignition config has a remote source.
+func HasRemoteFileSources(ign *ign3types.Config) bool {
+	for _, file := range ign.Storage.Files {
+		for _, res := range fileResources(file) {
+			if IsRemoteFileSource(res.Source) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyRemoteFileSources fetches every remote file source of an Ignition
+// config and checks it against its verification hash.
+func (f *RemoteFileFetcher) VerifyRemoteFileSources(ign *ign3types.Config) error {
+	for _, file := range ign.Storage.Files {
+		for _, res := range fileResources(file) {
+			if !IsRemoteFileSource(res.Source) {
+				continue
+			}
+			if err := f.Verify(res); err != nil {
+				return fmt.Errorf("file %q: %w", file.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PruneCache removes cached contents that none of the given configs reference.
+func (f *RemoteFileFetcher) PruneCache(configs ...*ign3types.Config) error {
+	if f.cacheDir == "" {
+		return nil
+	}
+	keep := map[string]bool{}
+	for _, ign := range configs {
+		for _, file := range ign.Storage.Files {
+			for _, res := range fileResources(file) {
+				if IsRemoteFileSource(res.Source) && res.Verification.Hash != nil {
+					keep[filepath.Base(f.cachePath(res.Verification))] = true
+				}
+			}
+		}
+	}
+	entries, err := ioutil.ReadDir(f.cacheDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if keep[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(f.cacheDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		glog.V(2).Infof("Pruned remote file cache entry %s", entry.Name())
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha512Verification(contents []byte) ign3types.Verification {
+	sum := sha512.Sum512(contents)
+	return ign3types.Verification{Hash: strToPtr("sha512-" + hex.EncodeToString(sum[:]))}
+}
+
+func TestRemoteFileFetcher(t *testing.T) {
+	contents := []byte("large binary blob")
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err := gw.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/blob":
+			w.Write(contents)
+		case "/blob.gz":
+			w.Write(gzipped.Bytes())
+		case "/auth":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write(contents)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	trustBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	cacheDir := t.TempDir()
+	fetcher, err := NewRemoteFileFetcher(nil, trustBundle, cacheDir)
+	require.NoError(t, err)
+
+	sha256Sum := sha256.Sum256(contents)
+	tests := []struct {
+		name     string
+		res      ign3types.Resource
+		expected []byte
+		errorMsg string
+	}{{
+		name:     "sha512",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: sha512Verification(contents)},
+		expected: contents,
+	}, {
+		name:     "sha256",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: ign3types.Verification{Hash: strToPtr("sha256-" + hex.EncodeToString(sha256Sum[:]))}},
+		expected: contents,
+	}, {
+		name:     "gzip compressed, hash covers the compressed contents",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/blob.gz"), Compression: strToPtr("gzip"), Verification: sha512Verification(gzipped.Bytes())},
+		expected: contents,
+	}, {
+		name: "http headers",
+		res: ign3types.Resource{
+			Source:       strToPtr(server.URL + "/auth"),
+			HTTPHeaders:  ign3types.HTTPHeaders{{Name: "Authorization", Value: strToPtr("Bearer token")}},
+			Verification: sha512Verification(contents),
+		},
+		expected: contents,
+	}, {
+		name:     "hash mismatch",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: sha512Verification([]byte("other"))},
+		errorMsg: "verification hash mismatch",
+	}, {
+		name:     "missing hash",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/blob")},
+		errorMsg: "require a verification hash",
+	}, {
+		name:     "unsupported algorithm",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: ign3types.Verification{Hash: strToPtr("md5-abcd")}},
+		errorMsg: "unsupported verification hash algorithm",
+	}, {
+		name:     "not found",
+		res:      ign3types.Resource{Source: strToPtr(server.URL + "/missing"), Verification: sha512Verification([]byte("missing"))},
+		errorMsg: "unexpected status",
+	}, {
+		name:     "data url",
+		res:      ign3types.Resource{Source: strToPtr("data:,inline")},
+		expected: []byte("inline"),
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := fetcher.DecodeIgnitionFileContents(test.res)
+			if test.errorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, decoded)
+		})
+	}
+
+	// verified contents are served from the cache
+	before := requests
+	decoded, err := fetcher.DecodeIgnitionFileContents(ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: sha512Verification(contents)})
+	require.NoError(t, err)
+	assert.Equal(t, contents, decoded)
+	assert.Equal(t, before, requests)
+
+	// entries not referenced by any config are pruned
+	keep := ign3types.Config{Storage: ign3types.Storage{Files: []ign3types.File{{
+		Node:          ign3types.Node{Path: "/etc/blob"},
+		FileEmbedded1: ign3types.FileEmbedded1{Contents: ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: sha512Verification(contents)}},
+	}}}}
+	require.NoError(t, fetcher.PruneCache(&keep))
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, filepath.Base(fetcher.cachePath(sha512Verification(contents))), entries[0].Name())
+}
+
+func TestRemoteFileFetcherVerify(t *testing.T) {
+	contents := []byte("large binary blob")
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(contents)
+	}))
+	defer server.Close()
+	trustBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	res := ign3types.Resource{Source: strToPtr(server.URL + "/blob"), Verification: sha512Verification(contents)}
+
+	// without a cache the contents are only verified
+	fetcher, err := NewRemoteFileFetcher(nil, trustBundle, "")
+	require.NoError(t, err)
+	require.NoError(t, fetcher.Verify(res))
+	assert.Error(t, fetcher.Verify(ign3types.Resource{Source: res.Source, Verification: sha512Verification([]byte("other"))}))
+
+	// sources larger than the limit are rejected
+	fetcher.maxSize = int64(len(contents)) - 1
+	err = fetcher.Verify(res)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds the limit")
+
+	// with a cache, verified sources are stored and not fetched again
+	cacheDir := t.TempDir()
+	fetcher, err = NewRemoteFileFetcher(nil, trustBundle, cacheDir)
+	require.NoError(t, err)
+	require.NoError(t, fetcher.Verify(res))
+	before := requests
+	require.NoError(t, fetcher.Verify(res))
+	assert.Equal(t, before, requests)
+	cached, err := os.ReadFile(fetcher.cachePath(res.Verification))
+	require.NoError(t, err)
+	assert.Equal(t, contents, cached)
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRemoteFileFetcherUntrusted(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	fetcher, err := NewRemoteFileFetcher(nil, nil, "")
+	require.NoError(t, err)
+	_, err = fetcher.Fetch(ign3types.Resource{Source: strToPtr(server.URL), Verification: sha512Verification(nil)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+}
+
+func TestMatchesNoProxy(t *testing.T) {
+	noProxy := "localhost,.cluster.local,example.com,10.0.0.0/16"
+	for host, expected := range map[string]bool{
+		"localhost":          true,
+		"svc.cluster.local":  true,
+		"example.com":        true,
+		"mirror.example.com": true,
+		"notexample.com":     false,
+		"10.0.1.2":           true,
+		"10.1.0.1":           false,
+		"quay.io":            false,
+	} {
+		assert.Equal(t, expected, matchesNoProxy(host, noProxy), host)
+	}
+	assert.True(t, matchesNoProxy("quay.io", "*"))
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"sync"
 	"time"
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
@@ -99,6 +100,15 @@ type Controller struct {
 	schedulerList         cligolistersv1.SchedulerLister
 	schedulerListerSynced cache.InformerSynced
 
+	cmLister       corelisterv1.ConfigMapLister
+	cmListerSynced cache.InformerSynced
+
+	// updateBudgetLock serializes use of the node update budget shared by all pools
+	updateBudgetLock sync.Mutex
+	// updateBudgetReservations maps nodes targeted by this controller to the change
+	// it made until the node lister catches up, so the budget isn't handed out twice
+	updateBudgetReservations map[string]updateBudgetReservation
+
 	queue workqueue.RateLimitingInterface
 }
 
@@ -109,6 +119,7 @@ func New(
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
 	nodeInformer coreinformersv1.NodeInformer,
 	schedulerInformer cligoinformersv1.SchedulerInformer,
+	cmInformer coreinformersv1.ConfigMapInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 ) *Controller {
@@ -121,6 +132,8 @@ func New(
 		kubeClient:    kubeClient,
 		eventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-nodecontroller"}),
 		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-nodecontroller"),
+
+		updateBudgetReservations: make(map[string]updateBudgetReservation),
 	}
 
 	mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -138,6 +151,11 @@ func New(
 		UpdateFunc: ctrl.checkMasterNodesOnUpdate,
 		DeleteFunc: ctrl.checkMasterNodesOnDelete,
 	})
+	cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addConfigMap,
+		UpdateFunc: ctrl.updateConfigMap,
+		DeleteFunc: ctrl.deleteConfigMap,
+	})
 	ctrl.syncHandler = ctrl.syncMachineConfigPool
 	ctrl.enqueueMachineConfigPool = ctrl.enqueueDefault
 
@@ -153,6 +171,9 @@ func New(
 	ctrl.schedulerList = schedulerInformer.Lister()
 	ctrl.schedulerListerSynced = schedulerInformer.Informer().HasSynced
 
+	ctrl.cmLister = cmInformer.Lister()
+	ctrl.cmListerSynced = cmInformer.Informer().HasSynced
+
 	return ctrl
 }
 
@@ -161,7 +182,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.schedulerListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.schedulerListerSynced, ctrl.cmListerSynced) {
 		return
 	}
 
@@ -248,7 +269,7 @@ func (ctrl *Controller) makeMastersUnSchedulable(currentMasters []*corev1.Node)
 // makeMasterNodeUnSchedulable makes master node unschedulable by removing worker label and adding `NoSchedule`
 // master taint to the master node
 func (ctrl *Controller) makeMasterNodeUnSchedulable(node *corev1.Node) error {
-	_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, func(node *corev1.Node) {
+	_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, ctrlcommon.MachineConfigControllerFieldManager, func(node *corev1.Node) {
 		// Remove worker label
 		newLabels := node.Labels
 		if _, hasWorkerLabel := newLabels[WorkerLabel]; hasWorkerLabel {
@@ -278,7 +299,7 @@ func (ctrl *Controller) makeMasterNodeUnSchedulable(node *corev1.Node) error {
 // makeMasterNodeSchedulable makes master node schedulable by removing NoSchedule master taint and
 // adding worker label
 func (ctrl *Controller) makeMasterNodeSchedulable(node *corev1.Node) error {
-	_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, func(node *corev1.Node) {
+	_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, ctrlcommon.MachineConfigControllerFieldManager, func(node *corev1.Node) {
 		// Add worker label
 		newLabels := node.Labels
 		if _, hasWorkerLabel := newLabels[WorkerLabel]; !hasWorkerLabel {
@@ -502,6 +523,7 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 			daemonconsts.CurrentMachineConfigAnnotationKey,
 			daemonconsts.DesiredMachineConfigAnnotationKey,
 			daemonconsts.MachineConfigDaemonStateAnnotationKey,
+			daemonconsts.KernelArgumentTuningPendingAnnotationKey,
 		}
 		for _, anno := range annos {
 			newValue := curNode.Annotations[anno]
@@ -528,6 +550,14 @@ func (ctrl *Controller) updateNode(old, cur interface{}) {
 		return
 	}
 
+	// Pools waiting on the shared update budget can proceed once a node is available again
+	if isNodeUnavailable(oldNode) && !isNodeUnavailable(curNode) {
+		if budget, err := ctrl.getUpdateBudget(); err == nil && budget != nil {
+			ctrl.enqueueAllPools()
+			return
+		}
+	}
+
 	pools, err := ctrl.getPoolsForNode(curNode)
 	if err != nil {
 		glog.Errorf("error finding pools for node: %v", err)
@@ -620,14 +650,21 @@ func (ctrl *Controller) getPoolsForNode(node *corev1.Node) ([]*mcfgv1.MachineCon
 	}
 
 	if len(custom) > 1 {
-		return nil, fmt.Errorf("node %s belongs to %d custom roles, cannot proceed with this Node", node.Name, len(custom))
-	} else if len(custom) == 1 {
+		// Several custom roles are fine if the pools inherit from one another,
+		// the node then belongs to the most specific of them.
+		chain := getPoolChain(custom, ctrl.mcpLister.Get)
+		if chain == nil {
+			return nil, fmt.Errorf("node %s belongs to %d custom roles, cannot proceed with this Node", node.Name, len(custom))
+		}
+		custom = chain
+	}
+	if len(custom) > 0 {
 		// We don't support making custom pools for masters
 		if master != nil {
 			return nil, fmt.Errorf("node %s has both master role and custom role %s", node.Name, custom[0].Name)
 		}
-		// One custom role, let's use its pool
-		pls := []*mcfgv1.MachineConfigPool{custom[0]}
+		// Use the most specific custom pool
+		pls := custom
 		if worker != nil {
 			pls = append(pls, worker)
 		}
@@ -643,6 +680,31 @@ func (ctrl *Controller) getPoolsForNode(node *corev1.Node) ([]*mcfgv1.MachineCon
 	return []*mcfgv1.MachineConfigPool{worker}, nil
 }
 
+// getPoolChain orders pools from the most to the least specific if each of them
+// inherits from the next, and returns nil otherwise.
+func getPoolChain(pools []*mcfgv1.MachineConfigPool, getPool func(string) (*mcfgv1.MachineConfigPool, error)) []*mcfgv1.MachineConfigPool {
+	matched := make(map[string]bool)
+	for _, pool := range pools {
+		matched[pool.Name] = true
+	}
+	for _, pool := range pools {
+		hierarchy, err := ctrlcommon.GetPoolHierarchy(pool, getPool)
+		if err != nil {
+			continue
+		}
+		var chain []*mcfgv1.MachineConfigPool
+		for _, p := range hierarchy {
+			if matched[p.Name] {
+				chain = append(chain, p)
+			}
+		}
+		if len(chain) == len(pools) {
+			return chain
+		}
+	}
+	return nil
+}
+
 // getPrimaryPoolForNode uses getPoolsForNode and returns the first one which is the one the node targets
 func (ctrl *Controller) getPrimaryPoolForNode(node *corev1.Node) (*mcfgv1.MachineConfigPool, error) {
 	pools, err := ctrl.getPoolsForNode(node)
@@ -843,7 +905,7 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 			}
 		}
 		ctrl.logPool(pool, "%d candidate nodes in %d zones for update, capacity: %d", len(candidates), len(zones), capacity)
-		if err := ctrl.updateCandidateMachines(pool, candidates, capacity); err != nil {
+		if err := ctrl.updateCandidateMachinesWithinBudget(pool, candidates, capacity); err != nil {
 			if syncErr := ctrl.syncStatusOnly(pool); syncErr != nil {
 				errs := kubeErrs.NewAggregate([]error{syncErr, err})
 				return fmt.Errorf("error setting desired machine config annotation for pool %q, sync error: %w", pool.Name, errs)
@@ -905,8 +967,8 @@ func (ctrl *Controller) setClusterConfigAnnotation(nodes []*corev1.Node) error {
 	for _, node := range nodes {
 		if node.Annotations[daemonconsts.ClusterControlPlaneTopologyAnnotationKey] != string(cc.Spec.Infra.Status.ControlPlaneTopology) {
 			oldAnn := node.Annotations[daemonconsts.ClusterControlPlaneTopologyAnnotationKey]
-			_, err := internal.UpdateNodeRetry(ctrl.kubeClient.CoreV1().Nodes(), ctrl.nodeLister, node.Name, func(node *corev1.Node) {
-				node.Annotations[daemonconsts.ClusterControlPlaneTopologyAnnotationKey] = string(cc.Spec.Infra.Status.ControlPlaneTopology)
+			_, err := internal.PatchNodeAnnotations(ctrl.kubeClient.CoreV1().Nodes(), node.Name, ctrlcommon.MachineConfigControllerFieldManager, map[string]string{
+				daemonconsts.ClusterControlPlaneTopologyAnnotationKey: string(cc.Spec.Infra.Status.ControlPlaneTopology),
 			})
 			if err != nil {
 				return err
@@ -918,41 +980,22 @@ func (ctrl *Controller) setClusterConfigAnnotation(nodes []*corev1.Node) error {
 }
 
 func (ctrl *Controller) setDesiredMachineConfigAnnotation(nodeName, currentConfig string) error {
-	return clientretry.RetryOnConflict(constants.NodeUpdateBackoff, func() error {
-		oldNode, err := ctrl.kubeClient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-		oldData, err := json.Marshal(oldNode)
-		if err != nil {
-			return err
-		}
-
-		newNode := oldNode.DeepCopy()
-		if newNode.Annotations == nil {
-			newNode.Annotations = map[string]string{}
-		}
-
-		if newNode.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == currentConfig {
-			return nil
-		}
-		newNode.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] = currentConfig
-		newData, err := json.Marshal(newNode)
-		if err != nil {
-			return err
-		}
-
-		patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, corev1.Node{})
-		if err != nil {
-			return fmt.Errorf("failed to create patch for node %q: %w", nodeName, err)
-		}
-		_, err = ctrl.kubeClient.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	node, err := ctrl.nodeLister.Get(nodeName)
+	if err != nil {
 		return err
+	}
+	if node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == currentConfig {
+		return nil
+	}
+	_, err = internal.PatchNodeAnnotations(ctrl.kubeClient.CoreV1().Nodes(), nodeName, ctrlcommon.MachineConfigControllerFieldManager, map[string]string{
+		daemonconsts.DesiredMachineConfigAnnotationKey: currentConfig,
 	})
+	return err
 }
 
-// getAllCandidateMachines returns all possible nodes which can be updated to the target config, along with a maximum
-// capacity.  It is the reponsibility of the caller to choose a subset of the nodes given the capacity.
+// getAllCandidateMachines returns all possible nodes which can be updated to the target config, or rebooted for
+// their kernel argument tuning, along with a maximum capacity.  It is the reponsibility of the caller to choose a
+// subset of the nodes given the capacity.
 func getAllCandidateMachines(pool *mcfgv1.MachineConfigPool, nodesInPool []*corev1.Node, maxUnavailable int) ([]*corev1.Node, uint) {
 	targetConfig := pool.Spec.Configuration.Name
 	unavail := getUnavailableMachines(nodesInPool)
@@ -968,6 +1011,8 @@ func getAllCandidateMachines(pool *mcfgv1.MachineConfigPool, nodesInPool []*core
 		if node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == targetConfig {
 			if isNodeMCDFailing(node) {
 				failingThisConfig++
+			} else if needsKernelArgumentTuning(node) {
+				nodes = append(nodes, node)
 			}
 			continue
 		}
@@ -1023,15 +1068,6 @@ func (ctrl *Controller) filterControlPlaneCandidateNodes(pool *mcfgv1.MachineCon
 
 // updateCandidateMachines sets the desiredConfig annotation the candidate machines
 func (ctrl *Controller) updateCandidateMachines(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint) error {
-	if pool.Name == ctrlcommon.MachineConfigPoolMaster {
-		var err error
-		candidates, capacity, err = ctrl.filterControlPlaneCandidateNodes(pool, candidates, capacity)
-		if err != nil {
-			return err
-		}
-		// In practice right now these counts will be 1 but let's stay general to support 5 etcd nodes in the future
-		ctrl.logPool(pool, "filtered to %d candidate nodes for update, capacity: %d", len(candidates), capacity)
-	}
 	if capacity < uint(len(candidates)) {
 		// when list is longer than maxUnavailable, rollout nodes in zone order, zones without zone label
 		// are done last from oldest to youngest. this reduces likelihood of randomly picking nodes
@@ -1041,21 +1077,43 @@ func (ctrl *Controller) updateCandidateMachines(pool *mcfgv1.MachineConfigPool,
 		candidates = candidates[:capacity]
 	}
 	targetConfig := pool.Spec.Configuration.Name
+	var targeted []*corev1.Node
 	for _, node := range candidates {
+		if needsKernelArgumentTuning(node) {
+			pending := node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey]
+			ctrl.logPool(pool, "Allowing node %s to reboot for kernel argument tuning: %s", node.Name, pending)
+			if err := ctrl.approveKernelArgumentTuning(node.Name, pending); err != nil {
+				return fmt.Errorf("approving kernel argument tuning for node %s: %w", node.Name, err)
+			}
+			ctrl.reserveUpdateBudget(node.Name, kernelArgumentTuningReservation(pending))
+			ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "ApprovedKernelArgumentTuning", "Allowed node %s to reboot for kernel argument tuning", node.Name)
+			continue
+		}
 		ctrl.logPool(pool, "Setting node %s target to %s", node.Name, targetConfig)
 		if err := ctrl.setDesiredMachineConfigAnnotation(node.Name, targetConfig); err != nil {
 			return fmt.Errorf("setting desired config for node %s: %w", node.Name, err)
 		}
+		ctrl.reserveUpdateBudget(node.Name, desiredConfigReservation(targetConfig))
+		targeted = append(targeted, node)
 	}
-	if len(candidates) == 1 {
-		candidate := candidates[0]
+	if len(targeted) == 1 {
+		candidate := targeted[0]
 		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "SetDesiredConfig", "Targeted node %s to config %s", candidate.Name, targetConfig)
-	} else {
-		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "SetDesiredConfig", "Set target for %d nodes to config %s", len(candidates), targetConfig)
+	} else if len(targeted) > 1 {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "SetDesiredConfig", "Set target for %d nodes to config %s", len(targeted), targetConfig)
 	}
 	return nil
 }
 
+// approveKernelArgumentTuning allows the daemon of a node to drain and reboot it
+// for the kernel argument tuning it has pending.
+func (ctrl *Controller) approveKernelArgumentTuning(nodeName, pending string) error {
+	_, err := internal.PatchNodeAnnotations(ctrl.kubeClient.CoreV1().Nodes(), nodeName, ctrlcommon.MachineConfigControllerFieldManager, map[string]string{
+		daemonconsts.KernelArgumentTuningApprovedAnnotationKey: pending,
+	})
+	return err
+}
+
 // sortNodeList sorts the list of candidate nodes by label topology.kubernetes.io/zone
 // nodes without label are at end of list and sorted by age (oldest to youngest)
 func sortNodeList(nodes []*corev1.Node) []*corev1.Node {
@@ -1111,7 +1169,7 @@ func (ctrl *Controller) setUpdateInProgressTaint(ctx context.Context, nodeName s
 		if err != nil {
 			return fmt.Errorf("failed to create patch for node %q: %v", nodeName, err)
 		}
-		_, err = ctrl.kubeClient.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+		_, err = ctrl.kubeClient.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{FieldManager: ctrlcommon.MachineConfigControllerFieldManager})
 		return err
 	})
 }
@@ -1151,7 +1209,7 @@ func (ctrl *Controller) removeUpdateInProgressTaint(ctx context.Context, nodeNam
 		if err != nil {
 			return fmt.Errorf("failed to create patch for node %q: %w", nodeName, err)
 		}
-		_, err = ctrl.kubeClient.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+		_, err = ctrl.kubeClient.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{FieldManager: ctrlcommon.MachineConfigControllerFieldManager})
 		return err
 	})
 }
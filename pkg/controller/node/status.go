@@ -20,6 +20,7 @@ func (ctrl *Controller) syncStatusOnly(pool *mcfgv1.MachineConfigPool) error {
 	}
 
 	newStatus := calculateStatus(pool, nodes)
+	newStatus.UpdateBudget = ctrl.getUpdateBudgetStatus()
 	if equality.Semantic.DeepEqual(pool.Status, newStatus) {
 		return nil
 	}
@@ -65,6 +66,7 @@ func calculateStatus(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) mcfgv
 		ReadyMachineCount:       readyMachineCount,
 		UnavailableMachineCount: unavailableMachineCount,
 		DegradedMachineCount:    degradedMachineCount,
+		UpdatePhaseCounts:       getUpdatePhaseCounts(pool.Spec.Configuration.Name, nodes),
 	}
 
 	status.Configuration = pool.Status.Configuration
@@ -165,6 +167,20 @@ func isNodeDoneAt(node *corev1.Node, targetConfig string) bool {
 	return isNodeDone(node) && node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey] == targetConfig
 }
 
+// needsKernelArgumentTuning returns whether a node that is done updating waits
+// for the node controller to allow a reboot for its kernel argument tuning.
+func needsKernelArgumentTuning(node *corev1.Node) bool {
+	return isNodeDone(node) && node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey] != "" &&
+		!isNodeKernelArgumentTuningApproved(node)
+}
+
+// isNodeKernelArgumentTuningApproved returns whether the node controller allowed
+// the node to reboot for the kernel argument tuning it has pending.
+func isNodeKernelArgumentTuningApproved(node *corev1.Node) bool {
+	pending := node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey]
+	return pending != "" && node.Annotations[daemonconsts.KernelArgumentTuningApprovedAnnotationKey] == pending
+}
+
 // isNodeMCDState checks the MCD state against the state parameter
 func isNodeMCDState(node *corev1.Node, state string) bool {
 	dstate, ok := node.Annotations[daemonconsts.MachineConfigDaemonStateAnnotationKey]
@@ -245,9 +261,9 @@ func isNodeUnavailable(node *corev1.Node) bool {
 	if !isNodeReady(node) {
 		return true
 	}
-	// Ready nodes are not unavailable
+	// Ready nodes are not unavailable, unless they are about to reboot for kernel argument tuning
 	if isNodeDone(node) {
-		return false
+		return isNodeKernelArgumentTuningApproved(node)
 	}
 	// Now we know the node isn't ready - the current config must not
 	// equal target.  We want to further filter down on the MCD state.
@@ -273,6 +289,37 @@ func getUnavailableMachines(nodes []*corev1.Node) []*corev1.Node {
 	return unavail
 }
 
+// updatePhases are the update phases reported by the daemon in the order they are passed
+var updatePhases = []string{
+	daemonconsts.UpdatePhaseOSUpdateStaged,
+	daemonconsts.UpdatePhaseDrained,
+	daemonconsts.UpdatePhaseFilesWritten,
+	daemonconsts.UpdatePhaseRebooting,
+	daemonconsts.UpdatePhaseValidating,
+	daemonconsts.UpdatePhaseDone,
+}
+
+// getUpdatePhaseCounts counts the nodes updating to currentConfig by the update
+// phase they report. Nodes that target another config haven't started this
+// update yet and are not counted, nor are nodes that don't report a phase.
+func getUpdatePhaseCounts(currentConfig string, nodes []*corev1.Node) []mcfgv1.MachineConfigPoolUpdatePhaseCount {
+	counts := map[string]int32{}
+	for _, node := range nodes {
+		if node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] != currentConfig {
+			continue
+		}
+		if phase := node.Annotations[daemonconsts.UpdatePhaseAnnotationKey]; phase != "" {
+			counts[phase]++
+		}
+	}
+
+	phaseCounts := make([]mcfgv1.MachineConfigPoolUpdatePhaseCount, 0, len(updatePhases))
+	for _, phase := range updatePhases {
+		phaseCounts = append(phaseCounts, mcfgv1.MachineConfigPoolUpdatePhaseCount{Phase: phase, MachineCount: counts[phase]})
+	}
+	return phaseCounts
+}
+
 func getDegradedMachines(nodes []*corev1.Node) []*corev1.Node {
 	var degraded []*corev1.Node
 	for _, node := range nodes {
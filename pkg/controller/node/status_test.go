@@ -306,6 +306,37 @@ func TestGetUnavailableMachines(t *testing.T) {
 	}
 }
 
+func TestGetUpdatePhaseCounts(t *testing.T) {
+	withPhase := func(node *corev1.Node, phase string) *corev1.Node {
+		node.Annotations[daemonconsts.UpdatePhaseAnnotationKey] = phase
+		return node
+	}
+	nodes := []*corev1.Node{
+		withPhase(newNode("node-0", "v0", "v1"), daemonconsts.UpdatePhaseDrained),
+		withPhase(newNode("node-1", "v0", "v1"), daemonconsts.UpdatePhaseOSUpdateStaged),
+		withPhase(newNode("node-2", "v0", "v1"), daemonconsts.UpdatePhaseOSUpdateStaged),
+		withPhase(newNode("node-3", "v1", "v1"), daemonconsts.UpdatePhaseDone),
+		// still staging, no phase reached yet
+		withPhase(newNode("node-4", "v0", "v1"), ""),
+		// done with an older update, not started on v1
+		withPhase(newNode("node-5", "v0", "v0"), daemonconsts.UpdatePhaseDone),
+		// daemon that doesn't report phases
+		newNode("node-6", "v0", "v1"),
+	}
+
+	expected := []mcfgv1.MachineConfigPoolUpdatePhaseCount{
+		{Phase: daemonconsts.UpdatePhaseOSUpdateStaged, MachineCount: 2},
+		{Phase: daemonconsts.UpdatePhaseDrained, MachineCount: 1},
+		{Phase: daemonconsts.UpdatePhaseFilesWritten, MachineCount: 0},
+		{Phase: daemonconsts.UpdatePhaseRebooting, MachineCount: 0},
+		{Phase: daemonconsts.UpdatePhaseValidating, MachineCount: 0},
+		{Phase: daemonconsts.UpdatePhaseDone, MachineCount: 1},
+	}
+	if got := getUpdatePhaseCounts("v1", nodes); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mismatch expected: %v got %v", expected, got)
+	}
+}
+
 func TestCalculateStatus(t *testing.T) {
 	tests := []struct {
 		nodes         []*corev1.Node
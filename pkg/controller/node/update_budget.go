@@ -0,0 +1,423 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	intstrutil "k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// updateBudgetConfigMapNamespace and updateBudgetConfigMapName locate the
+	// ConfigMap configuring the node update budget shared by all pools.
+	updateBudgetConfigMapNamespace = "openshift-config"
+	updateBudgetConfigMapName      = "machine-config-update-budget"
+
+	// updateBudgetMaxUnavailableKey limits the machines unavailable across all pools,
+	// as an integer or a percentage of all machines in all pools.
+	updateBudgetMaxUnavailableKey = "maxUnavailable"
+	// updateBudgetMaxUnavailablePerZoneKey limits the machines unavailable in each zone,
+	// as an integer or a percentage of the machines in that zone.
+	updateBudgetMaxUnavailablePerZoneKey = "maxUnavailablePerZone"
+	// updateBudgetFailureDomainLabelKey names the node label that defines failure domains.
+	updateBudgetFailureDomainLabelKey = "failureDomainLabel"
+	// updateBudgetMaxUnavailablePerFailureDomainKey limits the machines unavailable in each
+	// failure domain, as an integer or a percentage of the machines in that failure domain.
+	updateBudgetMaxUnavailablePerFailureDomainKey = "maxUnavailablePerFailureDomain"
+)
+
+// updateBudget is the parsed node update budget configuration.
+type updateBudget struct {
+	// maxUnavailable is nil if only domain limits are configured
+	maxUnavailable *intstrutil.IntOrString
+	domainLimits   []updateBudgetDomainLimit
+}
+
+// updateBudgetDomainLimit limits the unavailable machines sharing a value of label.
+type updateBudgetDomainLimit struct {
+	label          string
+	maxUnavailable intstrutil.IntOrString
+}
+
+// parseUpdateBudget parses the update budget ConfigMap data.
+func parseUpdateBudget(data map[string]string) (*updateBudget, error) {
+	budget := &updateBudget{}
+	parse := func(key string) (*intstrutil.IntOrString, error) {
+		value, ok := data[key]
+		if !ok {
+			return nil, nil
+		}
+		intOrPercent := intstrutil.Parse(value)
+		// Validate against an arbitrary total so invalid percentages are caught early
+		scaled, err := intstrutil.GetScaledValueFromIntOrPercent(&intOrPercent, 100, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		if scaled < 0 {
+			return nil, fmt.Errorf("invalid %s %q: must not be negative", key, value)
+		}
+		return &intOrPercent, nil
+	}
+
+	var err error
+	if budget.maxUnavailable, err = parse(updateBudgetMaxUnavailableKey); err != nil {
+		return nil, err
+	}
+	perZone, err := parse(updateBudgetMaxUnavailablePerZoneKey)
+	if err != nil {
+		return nil, err
+	}
+	if perZone != nil {
+		budget.domainLimits = append(budget.domainLimits, updateBudgetDomainLimit{label: zoneLabel, maxUnavailable: *perZone})
+	}
+	perFailureDomain, err := parse(updateBudgetMaxUnavailablePerFailureDomainKey)
+	if err != nil {
+		return nil, err
+	}
+	failureDomainLabel := data[updateBudgetFailureDomainLabelKey]
+	switch {
+	case perFailureDomain != nil && failureDomainLabel == "":
+		return nil, fmt.Errorf("%s requires %s to be set", updateBudgetMaxUnavailablePerFailureDomainKey, updateBudgetFailureDomainLabelKey)
+	case perFailureDomain == nil && failureDomainLabel != "":
+		return nil, fmt.Errorf("%s requires %s to be set", updateBudgetFailureDomainLabelKey, updateBudgetMaxUnavailablePerFailureDomainKey)
+	case perFailureDomain != nil:
+		if failureDomainLabel == zoneLabel && perZone != nil {
+			return nil, fmt.Errorf("%s %s is already limited by %s", updateBudgetFailureDomainLabelKey, zoneLabel, updateBudgetMaxUnavailablePerZoneKey)
+		}
+		budget.domainLimits = append(budget.domainLimits, updateBudgetDomainLimit{label: failureDomainLabel, maxUnavailable: *perFailureDomain})
+	}
+
+	if budget.maxUnavailable == nil && len(budget.domainLimits) == 0 {
+		return nil, fmt.Errorf("none of %s, %s or %s are set", updateBudgetMaxUnavailableKey, updateBudgetMaxUnavailablePerZoneKey, updateBudgetMaxUnavailablePerFailureDomainKey)
+	}
+	return budget, nil
+}
+
+// scaledMaxUnavailable scales intOrPercent to total the same way pool maxUnavailable is,
+// so a budget never drops below a single machine.
+func scaledMaxUnavailable(intOrPercent intstrutil.IntOrString, total int) int {
+	maxunavail, err := intstrutil.GetScaledValueFromIntOrPercent(&intOrPercent, total, false)
+	if err != nil || maxunavail < 1 {
+		// parseUpdateBudget already validated the value
+		return 1
+	}
+	return maxunavail
+}
+
+// getUpdateBudget returns the configured node update budget, or nil if none is configured.
+func (ctrl *Controller) getUpdateBudget() (*updateBudget, error) {
+	if ctrl.cmLister == nil {
+		return nil, nil
+	}
+	cm, err := ctrl.cmLister.ConfigMaps(updateBudgetConfigMapNamespace).Get(updateBudgetConfigMapName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	budget, err := parseUpdateBudget(cm.Data)
+	if err != nil {
+		return nil, fmt.Errorf("configmap %s/%s: %w", updateBudgetConfigMapNamespace, updateBudgetConfigMapName, err)
+	}
+	return budget, nil
+}
+
+// getAllPoolNodes returns every node that belongs to a pool, once.
+func (ctrl *Controller) getAllPoolNodes() ([]*corev1.Node, error) {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var nodes []*corev1.Node
+	for _, pool := range pools {
+		if pool.Spec.NodeSelector == nil {
+			continue
+		}
+		poolNodes, err := ctrl.getNodesForPool(pool)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range poolNodes {
+			if seen[node.Name] {
+				continue
+			}
+			seen[node.Name] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// updateBudgetReservation reports whether the node lister observed the change
+// this controller made to a node to make it unavailable.
+type updateBudgetReservation func(node *corev1.Node) bool
+
+// desiredConfigReservation is observed once the node has the desired config set.
+func desiredConfigReservation(config string) updateBudgetReservation {
+	return func(node *corev1.Node) bool {
+		return node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey] == config
+	}
+}
+
+// kernelArgumentTuningReservation is observed once the node has the approval of
+// its pending kernel argument tuning set. The daemon clears the approval as soon
+// as it starts to reboot for it, so the lister may miss it; the reservation is
+// also observed once the node is working or no longer has that tuning pending.
+func kernelArgumentTuningReservation(pending string) updateBudgetReservation {
+	return func(node *corev1.Node) bool {
+		return node.Annotations[daemonconsts.KernelArgumentTuningApprovedAnnotationKey] == pending ||
+			node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey] != pending ||
+			isNodeMCDState(node, daemonconsts.MachineConfigDaemonStateWorking)
+	}
+}
+
+// isNodeUnavailableForBudget is isNodeUnavailable, except that nodes this
+// controller has just targeted count as unavailable even before the node
+// lister observes the change it made. Observed reservations are dropped.
+// The caller must hold updateBudgetLock.
+func (ctrl *Controller) isNodeUnavailableForBudget(node *corev1.Node) bool {
+	if observed, ok := ctrl.updateBudgetReservations[node.Name]; ok {
+		if !observed(node) {
+			return true
+		}
+		delete(ctrl.updateBudgetReservations, node.Name)
+	}
+	return isNodeUnavailable(node)
+}
+
+// reserveUpdateBudget records that node was just made unavailable.
+// The caller must hold updateBudgetLock.
+func (ctrl *Controller) reserveUpdateBudget(node string, reservation updateBudgetReservation) {
+	ctrl.updateBudgetReservations[node] = reservation
+}
+
+// pruneUpdateBudgetReservations drops the reservations of nodes that are no
+// longer in any pool, e.g. because they were deleted before the node lister
+// observed the reservation. The caller must hold updateBudgetLock.
+func (ctrl *Controller) pruneUpdateBudgetReservations(nodes []*corev1.Node) {
+	current := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		current[node.Name] = true
+	}
+	for name := range ctrl.updateBudgetReservations {
+		if !current[name] {
+			delete(ctrl.updateBudgetReservations, name)
+		}
+	}
+}
+
+// calculateUpdateBudget returns the state of the budget given all nodes in all pools,
+// or nil if no budget is configured. The caller must hold updateBudgetLock.
+func (ctrl *Controller) calculateUpdateBudget() (*mcfgv1.MachineConfigPoolUpdateBudget, error) {
+	budget, err := ctrl.getUpdateBudget()
+	if err != nil || budget == nil {
+		return nil, err
+	}
+	nodes, err := ctrl.getAllPoolNodes()
+	if err != nil {
+		return nil, err
+	}
+	ctrl.pruneUpdateBudgetReservations(nodes)
+	unavailable := make(map[string]bool)
+	for _, node := range nodes {
+		if ctrl.isNodeUnavailableForBudget(node) {
+			unavailable[node.Name] = true
+		}
+	}
+	return calculateUpdateBudget(budget, nodes, unavailable), nil
+}
+
+// calculateUpdateBudget returns the state of budget given all nodes in all pools
+// and the names of those which are unavailable.
+func calculateUpdateBudget(budget *updateBudget, nodes []*corev1.Node, unavailable map[string]bool) *mcfgv1.MachineConfigPoolUpdateBudget {
+	status := &mcfgv1.MachineConfigPoolUpdateBudget{
+		MaxUnavailable:          int32(len(nodes)),
+		UnavailableMachineCount: int32(len(unavailable)),
+	}
+	if budget.maxUnavailable != nil {
+		status.MaxUnavailable = int32(scaledMaxUnavailable(*budget.maxUnavailable, len(nodes)))
+	}
+
+	for _, limit := range budget.domainLimits {
+		total := make(map[string]int)
+		unavail := make(map[string]int)
+		for _, node := range nodes {
+			value, ok := node.Labels[limit.label]
+			if !ok {
+				continue
+			}
+			total[value]++
+			if unavailable[node.Name] {
+				unavail[value]++
+			}
+		}
+		var values []string
+		for value := range total {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			status.Domains = append(status.Domains, mcfgv1.MachineConfigPoolUpdateBudgetDomain{
+				Label:                   limit.label,
+				Value:                   value,
+				MaxUnavailable:          int32(scaledMaxUnavailable(limit.maxUnavailable, total[value])),
+				UnavailableMachineCount: int32(unavail[value]),
+			})
+		}
+	}
+	return status
+}
+
+// applyUpdateBudget narrows the candidates and capacity of a pool down to what the
+// budget allows. Candidates are considered in zone order, so the same candidates
+// are chosen as without a budget wherever the budget permits.
+func applyUpdateBudget(status *mcfgv1.MachineConfigPoolUpdateBudget, candidates []*corev1.Node, capacity uint) ([]*corev1.Node, uint) {
+	remaining := int(status.MaxUnavailable - status.UnavailableMachineCount)
+	if remaining <= 0 {
+		return nil, 0
+	}
+	if uint(remaining) < capacity {
+		capacity = uint(remaining)
+	}
+
+	domainRemaining := make(map[string]map[string]int)
+	for _, domain := range status.Domains {
+		if domainRemaining[domain.Label] == nil {
+			domainRemaining[domain.Label] = make(map[string]int)
+		}
+		domainRemaining[domain.Label][domain.Value] = int(domain.MaxUnavailable - domain.UnavailableMachineCount)
+	}
+
+	var allowed []*corev1.Node
+	for _, node := range sortNodeList(candidates) {
+		if uint(len(allowed)) >= capacity {
+			break
+		}
+		fits := true
+		for label, values := range domainRemaining {
+			if value, ok := node.Labels[label]; ok && values[value] <= 0 {
+				fits = false
+				break
+			}
+		}
+		if !fits {
+			continue
+		}
+		for label, values := range domainRemaining {
+			if value, ok := node.Labels[label]; ok {
+				values[value]--
+			}
+		}
+		allowed = append(allowed, node)
+	}
+	return allowed, uint(len(allowed))
+}
+
+// updateCandidateMachinesWithinBudget is updateCandidateMachines, after narrowing the
+// candidates down to what the update budget allows. Pools share the budget, so this
+// is serialized across pools. Control plane candidates are filtered first so that the
+// budget isn't spent on nodes that are deferred anyway.
+func (ctrl *Controller) updateCandidateMachinesWithinBudget(pool *mcfgv1.MachineConfigPool, candidates []*corev1.Node, capacity uint) error {
+	if pool.Name == ctrlcommon.MachineConfigPoolMaster {
+		var err error
+		candidates, capacity, err = ctrl.filterControlPlaneCandidateNodes(pool, candidates, capacity)
+		if err != nil {
+			return err
+		}
+		// In practice right now these counts will be 1 but let's stay general to support 5 etcd nodes in the future
+		ctrl.logPool(pool, "filtered to %d candidate nodes for update, capacity: %d", len(candidates), capacity)
+	}
+
+	ctrl.updateBudgetLock.Lock()
+	defer ctrl.updateBudgetLock.Unlock()
+
+	budget, err := ctrl.calculateUpdateBudget()
+	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "InvalidUpdateBudget", "Not updating nodes: %v", err)
+		return fmt.Errorf("calculating update budget: %w", err)
+	}
+	if budget != nil {
+		poolCapacity := capacity
+		candidates, capacity = applyUpdateBudget(budget, candidates, capacity)
+		if capacity < poolCapacity {
+			ctrl.logPool(pool, "update budget limits capacity to %d (%d of %d machines unavailable across all pools)", capacity, budget.UnavailableMachineCount, budget.MaxUnavailable)
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+	return ctrl.updateCandidateMachines(pool, candidates, capacity)
+}
+
+// getUpdateBudgetStatus returns the budget state to report in pool status. Errors
+// are logged rather than returned so a misconfigured budget doesn't block status updates.
+func (ctrl *Controller) getUpdateBudgetStatus() *mcfgv1.MachineConfigPoolUpdateBudget {
+	ctrl.updateBudgetLock.Lock()
+	defer ctrl.updateBudgetLock.Unlock()
+	status, err := ctrl.calculateUpdateBudget()
+	if err != nil {
+		glog.Warningf("Failed to calculate node update budget: %v", err)
+		return nil
+	}
+	return status
+}
+
+// enqueueAllPools enqueues every pool, e.g. because budget they are waiting for was freed.
+func (ctrl *Controller) enqueueAllPools() {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("error listing pools: %v", err)
+		return
+	}
+	for _, pool := range pools {
+		ctrl.enqueueMachineConfigPool(pool)
+	}
+}
+
+func (ctrl *Controller) isUpdateBudgetConfigMap(obj interface{}) bool {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return false
+		}
+		if cm, ok = tombstone.Obj.(*corev1.ConfigMap); !ok {
+			return false
+		}
+	}
+	return cm.Namespace == updateBudgetConfigMapNamespace && cm.Name == updateBudgetConfigMapName
+}
+
+func (ctrl *Controller) addConfigMap(obj interface{}) {
+	if ctrl.isUpdateBudgetConfigMap(obj) {
+		glog.Infof("Node update budget configured")
+		ctrl.enqueueAllPools()
+	}
+}
+
+func (ctrl *Controller) updateConfigMap(old, cur interface{}) {
+	if old.(*corev1.ConfigMap).ResourceVersion == cur.(*corev1.ConfigMap).ResourceVersion {
+		return
+	}
+	if ctrl.isUpdateBudgetConfigMap(cur) {
+		glog.Infof("Node update budget changed")
+		ctrl.enqueueAllPools()
+	}
+}
+
+func (ctrl *Controller) deleteConfigMap(obj interface{}) {
+	if ctrl.isUpdateBudgetConfigMap(obj) {
+		glog.Infof("Node update budget removed")
+		ctrl.enqueueAllPools()
+	}
+}
@@ -0,0 +1,226 @@
+package node
+
+import (
+	"encoding/json"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/constants"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+func TestParseUpdateBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    *updateBudget
+		wantErr bool
+	}{
+		{
+			name:    "empty",
+			data:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name: "cluster only",
+			data: map[string]string{"maxUnavailable": "2"},
+			want: &updateBudget{maxUnavailable: intStrPtr(intstr.FromInt(2))},
+		},
+		{
+			name: "cluster percentage and zones",
+			data: map[string]string{"maxUnavailable": "10%", "maxUnavailablePerZone": "1"},
+			want: &updateBudget{
+				maxUnavailable: intStrPtr(intstr.FromString("10%")),
+				domainLimits:   []updateBudgetDomainLimit{{label: zoneLabel, maxUnavailable: intstr.FromInt(1)}},
+			},
+		},
+		{
+			name: "failure domains only",
+			data: map[string]string{"failureDomainLabel": "rack", "maxUnavailablePerFailureDomain": "50%"},
+			want: &updateBudget{
+				domainLimits: []updateBudgetDomainLimit{{label: "rack", maxUnavailable: intstr.FromString("50%")}},
+			},
+		},
+		{
+			name:    "failure domain limit without label",
+			data:    map[string]string{"maxUnavailablePerFailureDomain": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "failure domain label without limit",
+			data:    map[string]string{"maxUnavailable": "1", "failureDomainLabel": "rack"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid percentage",
+			data:    map[string]string{"maxUnavailable": "ten%"},
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			data:    map[string]string{"maxUnavailable": "-1"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseUpdateBudget(test.data)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestApplyUpdateBudget(t *testing.T) {
+	zoned := func(name, current, desired, zone string) *corev1.Node {
+		node := newNodeWithLabel(name, current, desired, map[string]string{zoneLabel: zone})
+		node.CreationTimestamp = metav1.Now()
+		return node
+	}
+	nodes := []*corev1.Node{
+		zoned("a-updating", "v0", "v1", "a"),
+		zoned("a-1", "v0", "v0", "a"),
+		zoned("a-2", "v0", "v0", "a"),
+		zoned("b-1", "v0", "v0", "b"),
+		zoned("b-2", "v0", "v0", "b"),
+		zoned("c-1", "v0", "v0", "c"),
+	}
+	unavailable := map[string]bool{"a-updating": true}
+	candidates := func() []*corev1.Node {
+		return append([]*corev1.Node{}, nodes[1:]...)
+	}
+	names := func(nodes []*corev1.Node) []string {
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		return names
+	}
+
+	// Only the cluster-wide limit applies
+	budget := &updateBudget{maxUnavailable: intStrPtr(intstr.FromInt(3))}
+	status := calculateUpdateBudget(budget, nodes, unavailable)
+	assert.Equal(t, &mcfgv1.MachineConfigPoolUpdateBudget{MaxUnavailable: 3, UnavailableMachineCount: 1}, status)
+	allowed, capacity := applyUpdateBudget(status, candidates(), 5)
+	assert.Equal(t, uint(2), capacity)
+	assert.Equal(t, []string{"a-1", "a-2"}, names(allowed))
+
+	// The pool's own capacity is still respected
+	allowed, capacity = applyUpdateBudget(status, candidates(), 1)
+	assert.Equal(t, uint(1), capacity)
+	assert.Equal(t, []string{"a-1"}, names(allowed))
+
+	// One machine per zone, zone a is already spent
+	budget.domainLimits = []updateBudgetDomainLimit{{label: zoneLabel, maxUnavailable: intstr.FromInt(1)}}
+	status = calculateUpdateBudget(budget, nodes, unavailable)
+	assert.Equal(t, []mcfgv1.MachineConfigPoolUpdateBudgetDomain{
+		{Label: zoneLabel, Value: "a", MaxUnavailable: 1, UnavailableMachineCount: 1},
+		{Label: zoneLabel, Value: "b", MaxUnavailable: 1},
+		{Label: zoneLabel, Value: "c", MaxUnavailable: 1},
+	}, status.Domains)
+	allowed, capacity = applyUpdateBudget(status, candidates(), 5)
+	assert.Equal(t, uint(2), capacity)
+	assert.Equal(t, []string{"b-1", "c-1"}, names(allowed))
+
+	// No cluster-wide limit, so every machine could go
+	budget.maxUnavailable = nil
+	status = calculateUpdateBudget(budget, nodes, unavailable)
+	assert.Equal(t, int32(len(nodes)), status.MaxUnavailable)
+
+	// The cluster-wide budget is exhausted
+	budget.maxUnavailable = intStrPtr(intstr.FromInt(1))
+	status = calculateUpdateBudget(budget, nodes, unavailable)
+	allowed, capacity = applyUpdateBudget(status, candidates(), 5)
+	assert.Equal(t, uint(0), capacity)
+	assert.Empty(t, allowed)
+}
+
+func TestUpdateBudgetExhausted(t *testing.T) {
+	f := newFixture(t)
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName, configv1.TopologyMode(""))
+	mcp := helpers.NewMachineConfigPool("test-cluster-infra", nil, helpers.InfraSelector, "v1")
+	mcpWorker := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v1")
+	mcp.Spec.MaxUnavailable = intStrPtr(intstr.FromInt(1))
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: updateBudgetConfigMapNamespace, Name: updateBudgetConfigMapName},
+		Data:       map[string]string{updateBudgetMaxUnavailableKey: "1"},
+	}
+
+	// The infra node could update as far as its pool is concerned, but a worker is already updating
+	infraNode := newNodeWithLabel("infra-0", "v0", "v0", map[string]string{"node-role/worker": "", "node-role/infra": ""})
+	workerNode := newNodeWithLabel("worker-0", "v0", "v1", map[string]string{"node-role/worker": ""})
+
+	f.ccLister = append(f.ccLister, cc)
+	f.mcpLister = append(f.mcpLister, mcp, mcpWorker)
+	f.objects = append(f.objects, mcp, mcpWorker)
+	f.nodeLister = append(f.nodeLister, infraNode, workerNode)
+	f.kubeobjects = append(f.kubeobjects, infraNode, workerNode)
+	f.cmLister = append(f.cmLister, cm)
+
+	// The in progress taint is still applied, but the desired config is left alone
+	expNode := infraNode.DeepCopy()
+	expNode.Spec.Taints = append(expNode.Spec.Taints, *constants.NodeUpdateInProgressTaint)
+	oldData, err := json.Marshal(infraNode)
+	require.NoError(t, err)
+	newData, err := json.Marshal(expNode)
+	require.NoError(t, err)
+	exppatch, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, corev1.Node{})
+	require.NoError(t, err)
+	f.expectGetNodeAction(infraNode)
+	f.expectPatchNodeAction(expNode, exppatch)
+
+	expMcp := mcp.DeepCopy()
+	expMcp.Status = calculateStatus(mcp, []*corev1.Node{infraNode})
+	expMcp.Status.UpdateBudget = &mcfgv1.MachineConfigPoolUpdateBudget{MaxUnavailable: 1, UnavailableMachineCount: 1}
+	f.expectUpdateMachineConfigPoolStatus(expMcp)
+	f.run(getKey(mcp, t))
+}
+
+func TestPruneUpdateBudgetReservations(t *testing.T) {
+	ctrl := &Controller{updateBudgetReservations: map[string]updateBudgetReservation{}}
+	ctrl.reserveUpdateBudget("worker-0", desiredConfigReservation("v1"))
+	ctrl.reserveUpdateBudget("worker-1", desiredConfigReservation("v1"))
+
+	// worker-1 was deleted before the lister observed its desired config
+	ctrl.pruneUpdateBudgetReservations([]*corev1.Node{newNode("worker-0", "v0", "v0")})
+	assert.Contains(t, ctrl.updateBudgetReservations, "worker-0")
+	assert.NotContains(t, ctrl.updateBudgetReservations, "worker-1")
+}
+
+func TestKernelArgumentTuningReservation(t *testing.T) {
+	ctrl := &Controller{updateBudgetReservations: map[string]updateBudgetReservation{}}
+	node := newNode("worker-0", "v1", "v1")
+	node.Annotations[daemonconsts.MachineConfigDaemonStateAnnotationKey] = daemonconsts.MachineConfigDaemonStateDone
+	node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey] = "--append=nosmt"
+	ctrl.reserveUpdateBudget(node.Name, kernelArgumentTuningReservation("--append=nosmt"))
+
+	// the approval isn't observed yet
+	assert.True(t, ctrl.isNodeUnavailableForBudget(node))
+
+	// the lister never saw the approval, the daemon already cleared it and
+	// the node rebooted without any tuning pending
+	node.Annotations[daemonconsts.KernelArgumentTuningApprovedAnnotationKey] = ""
+	node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey] = ""
+	assert.False(t, ctrl.isNodeUnavailableForBudget(node))
+	assert.Empty(t, ctrl.updateBudgetReservations)
+
+	// the daemon is rebooting for it, unavailable because it is working
+	ctrl.reserveUpdateBudget(node.Name, kernelArgumentTuningReservation("--append=nosmt"))
+	node.Annotations[daemonconsts.KernelArgumentTuningPendingAnnotationKey] = "--append=nosmt"
+	node.Annotations[daemonconsts.MachineConfigDaemonStateAnnotationKey] = daemonconsts.MachineConfigDaemonStateWorking
+	assert.True(t, ctrl.isNodeUnavailableForBudget(node))
+	assert.Empty(t, ctrl.updateBudgetReservations)
+}
@@ -24,6 +24,15 @@ var (
 // Given a config from a pool, generate a name for the config
 // of the form rendered-<poolname>-<hash>
 func getMachineConfigHashedName(pool *mcfgv1.MachineConfigPool, config *mcfgv1.MachineConfig) (string, error) {
+	h, err := getMachineConfigSpecHash(config)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rendered-%s-%s", pool.GetName(), h), nil
+}
+
+// getMachineConfigSpecHash returns the hash of the spec of a config.
+func getMachineConfigSpecHash(config *mcfgv1.MachineConfig) (string, error) {
 	if config == nil {
 		return "", fmt.Errorf("empty machineconfig object")
 	}
@@ -37,7 +46,7 @@ func getMachineConfigHashedName(pool *mcfgv1.MachineConfigPool, config *mcfgv1.M
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("rendered-%s-%x", pool.GetName(), h), nil
+	return fmt.Sprintf("%x", h), nil
 }
 
 func hashData(data []byte) ([]byte, error) {
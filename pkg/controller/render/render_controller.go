@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
 	"github.com/golang/glog"
 	mcoResourceApply "github.com/openshift/machine-config-operator/lib/resourceapply"
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
@@ -50,9 +53,14 @@ var (
 	machineconfigKind = mcfgv1.SchemeGroupVersion.WithKind("MachineConfig")
 )
 
+// osImageResolver returns an OS image reference pinned by digest, looking it up on
+// the mirrors of registriesConf, if any.
+type osImageResolver func(imageURL string, registriesConf []byte) (string, error)
+
 // Controller defines the render controller.
 type Controller struct {
 	client        mcfgclientset.Interface
+	kubeClient    clientset.Interface
 	eventRecorder record.EventRecorder
 
 	syncHandler              func(mcp string) error
@@ -67,6 +75,14 @@ type Controller struct {
 	ccLister       mcfglistersv1.ControllerConfigLister
 	ccListerSynced cache.InformerSynced
 
+	// verifiedRemoteSources holds the remote file sources, as <source>@<hash>,
+	// that were already verified, so rendering doesn't fetch them again
+	verifiedRemoteSources     map[string]bool
+	verifiedRemoteSourcesLock sync.Mutex
+
+	// resolveImageDigest pins an image reference by digest using a pull secret
+	resolveImageDigest func(imageURL string, pullSecret, registriesConf []byte) (string, error)
+
 	queue workqueue.RateLimitingInterface
 }
 
@@ -84,8 +100,12 @@ func New(
 
 	ctrl := &Controller{
 		client:        mcfgClient,
+		kubeClient:    kubeClient,
 		eventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-rendercontroller"}),
 		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-rendercontroller"),
+
+		verifiedRemoteSources: map[string]bool{},
+		resolveImageDigest:    ctrlcommon.ResolveImageDigest,
 	}
 
 	mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -144,6 +164,10 @@ func (ctrl *Controller) updateMachineConfigPool(old, cur interface{}) {
 
 	glog.V(4).Infof("Updating MachineConfigPool %s", oldPool.Name)
 	ctrl.enqueueMachineConfigPool(curPool)
+	// Pools inheriting from this one may render differently now too
+	for _, p := range ctrl.getDescendantPools(curPool.Name) {
+		ctrl.enqueueMachineConfigPool(p)
+	}
 }
 
 func (ctrl *Controller) deleteMachineConfigPool(obj interface{}) {
@@ -162,6 +186,10 @@ func (ctrl *Controller) deleteMachineConfigPool(obj interface{}) {
 	}
 	glog.V(4).Infof("Deleting MachineConfigPool %s", pool.Name)
 	// TODO(abhinavdahiya): handle deletes.
+	// Pools inheriting from this one will now fail to render
+	for _, p := range ctrl.getDescendantPools(pool.Name) {
+		ctrl.enqueueMachineConfigPool(p)
+	}
 }
 
 func (ctrl *Controller) addMachineConfig(obj interface{}) {
@@ -308,9 +336,43 @@ func (ctrl *Controller) getPoolsForMachineConfig(config *mcfgv1.MachineConfig) (
 	if len(pools) == 0 {
 		return nil, fmt.Errorf("could not find any MachineConfigPool set for MachineConfig %s with labels: %v", config.Name, config.Labels)
 	}
+
+	// Pools inheriting from a matching pool inherit the MachineConfig too
+	seen := make(map[string]bool)
+	for _, p := range pools {
+		seen[p.Name] = true
+	}
+	for _, p := range pList {
+		if seen[p.Name] {
+			continue
+		}
+		for _, matched := range pools {
+			if ctrlcommon.IsPoolDescendant(p, matched.Name, ctrl.mcpLister.Get) {
+				seen[p.Name] = true
+				pools = append(pools, p)
+				break
+			}
+		}
+	}
 	return pools, nil
 }
 
+// getDescendantPools returns the pools inheriting from the named pool, directly or indirectly.
+func (ctrl *Controller) getDescendantPools(name string) []*mcfgv1.MachineConfigPool {
+	pList, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("error listing pools: %v", err)
+		return nil
+	}
+	var pools []*mcfgv1.MachineConfigPool
+	for _, p := range pList {
+		if ctrlcommon.IsPoolDescendant(p, name, ctrl.mcpLister.Get) {
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
+
 func (ctrl *Controller) enqueue(pool *mcfgv1.MachineConfigPool) {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(pool)
 	if err != nil {
@@ -426,7 +488,11 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return err
 	}
 
-	mcs, err := ctrl.mcLister.List(selector)
+	hierarchy, err := ctrlcommon.GetPoolHierarchy(pool, ctrl.mcpLister.Get)
+	if err != nil {
+		return ctrl.syncFailingStatus(pool, err)
+	}
+	mcs, err := getMachineConfigsForHierarchy(hierarchy, ctrl.mcLister.List)
 	if err != nil {
 		return err
 	}
@@ -434,7 +500,7 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return ctrl.syncFailingStatus(pool, fmt.Errorf("no MachineConfigs found matching selector %v", selector))
 	}
 
-	if err := ctrl.syncGeneratedMachineConfig(pool, mcs); err != nil {
+	if err := ctrl.syncGeneratedMachineConfig(pool, mcs, getPoolPolicies(hierarchy)); err != nil {
 		return ctrl.syncFailingStatus(pool, err)
 	}
 
@@ -472,7 +538,7 @@ func (ctrl *Controller) garbageCollectRenderedConfigs(pool *mcfgv1.MachineConfig
 	return nil
 }
 
-func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig) error {
+func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, policies poolPolicies) error {
 	if len(configs) == 0 {
 		return nil
 	}
@@ -482,14 +548,22 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 		return err
 	}
 
-	generated, err := generateRenderedMachineConfig(pool, configs, cc)
+	// The rendered config the pool targets may be gone, e.g. deleted by hand
+	var current *mcfgv1.MachineConfig
+	if pool.Spec.Configuration.Name != "" {
+		current, err = ctrl.mcLister.Get(pool.Spec.Configuration.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	generated, err := generateRenderedMachineConfig(pool, configs, cc, policies, ctrl.resolveOSImage(cc), current)
 	if err != nil {
 		return err
 	}
 
 	// Emit an event so it's more visible that OSImageURL was overridden.
-	if generated.Spec.OSImageURL != cc.Spec.OSImageURL {
-		ctrl.eventRecorder.Eventf(generated, corev1.EventTypeNormal, "OSImageURLOverridden", "OSImageURL was overridden via machineconfig in %s (was: %s is: %s)", generated.Name, cc.Spec.OSImageURL, generated.Spec.OSImageURL)
+	if osImageURL := getSpecifiedOSImageURL(generated); osImageURL != cc.Spec.OSImageURL {
+		ctrl.eventRecorder.Eventf(generated, corev1.EventTypeNormal, "OSImageURLOverridden", "OSImageURL was overridden via machineconfig in %s (was: %s is: %s)", generated.Name, cc.Spec.OSImageURL, osImageURL)
 	}
 
 	source := []corev1.ObjectReference{}
@@ -499,6 +573,12 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 
 	_, err = ctrl.mcLister.Get(generated.Name)
 	if apierrors.IsNotFound(err) {
+		// Fail the render rather than the node update if a remote file source
+		// can't be fetched or doesn't match its hash.
+		if err := ctrl.verifyRemoteFileSources(generated, cc); err != nil {
+			ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "RemoteFileSourceFailed", "Failed to verify remote file sources of %s: %v", generated.Name, err)
+			return err
+		}
 		_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(context.TODO(), generated, metav1.CreateOptions{})
 		if err != nil {
 			return err
@@ -538,8 +618,142 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 	return nil
 }
 
+// resolveOSImage returns a resolver that pins OS images by digest, reading the
+// cluster pull secret only if an image needs resolving.
+func (ctrl *Controller) resolveOSImage(cc *mcfgv1.ControllerConfig) osImageResolver {
+	return func(imageURL string, registriesConf []byte) (string, error) {
+		if pinned, err := ctrlcommon.IsImageDigestPinned(imageURL); err == nil && pinned {
+			return imageURL, nil
+		}
+		var pullSecret []byte
+		if cc.Spec.PullSecret != nil {
+			secret, err := ctrl.kubeClient.CoreV1().Secrets(cc.Spec.PullSecret.Namespace).Get(context.TODO(), cc.Spec.PullSecret.Name, metav1.GetOptions{})
+			if err != nil {
+				return "", fmt.Errorf("getting pull secret to resolve OS image: %w", err)
+			}
+			pullSecret = secret.Data[corev1.DockerConfigJsonKey]
+		}
+		return ctrl.resolveImageDigest(imageURL, pullSecret, registriesConf)
+	}
+}
+
+// getSpecifiedOSImageURL returns the OS image of a rendered config as the MachineConfigs
+// specified it, before it was resolved to a digest.
+func getSpecifiedOSImageURL(mc *mcfgv1.MachineConfig) string {
+	if osImageURL, ok := mc.Annotations[ctrlcommon.OSImageURLTagAnnotationKey]; ok {
+		return osImageURL
+	}
+	return mc.Spec.OSImageURL
+}
+
+// verifyRemoteFileSources fetches the remote file sources of a rendered config
+// through the cluster proxy and checks them against their verification hash.
+// The contents aren't kept, the daemon fetches them again, and each source is
+// only verified once.
+func (ctrl *Controller) verifyRemoteFileSources(mc *mcfgv1.MachineConfig, cc *mcfgv1.ControllerConfig) error {
+	ign, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	if err != nil {
+		return err
+	}
+	if !ctrlcommon.HasRemoteFileSources(&ign) {
+		return nil
+	}
+	fetcher, err := ctrlcommon.NewRemoteFileFetcher(cc.Spec.Proxy, cc.Spec.AdditionalTrustBundle, "")
+	if err != nil {
+		return err
+	}
+	for _, file := range ign.Storage.Files {
+		for _, res := range append([]ign3types.Resource{file.Contents}, file.Append...) {
+			if !ctrlcommon.IsRemoteFileSource(res.Source) {
+				continue
+			}
+			key := *res.Source
+			if res.Verification.Hash != nil {
+				key += "@" + *res.Verification.Hash
+			}
+			ctrl.verifiedRemoteSourcesLock.Lock()
+			verified := ctrl.verifiedRemoteSources[key]
+			ctrl.verifiedRemoteSourcesLock.Unlock()
+			if verified {
+				continue
+			}
+			if err := fetcher.Verify(res); err != nil {
+				return fmt.Errorf("file %q: %w", file.Path, err)
+			}
+			ctrl.verifiedRemoteSourcesLock.Lock()
+			ctrl.verifiedRemoteSources[key] = true
+			ctrl.verifiedRemoteSourcesLock.Unlock()
+		}
+	}
+	return nil
+}
+
+// poolPolicies are the settings of a pool, either its own or inherited from its
+// parents, that its rendered configs carry to the daemon.
+type poolPolicies struct {
+	osImageVerification  *mcfgv1.OSImageVerification
+	kernelArgumentTuning *mcfgv1.KernelArgumentTuning
+	ioTuning             *mcfgv1.IOTuning
+	interactiveAccess    *mcfgv1.InteractiveAccessPolicy
+	packageOS            *mcfgv1.PackageOS
+}
+
+// getPoolPolicies returns the policies in effect for the first pool of a hierarchy.
+func getPoolPolicies(hierarchy []*mcfgv1.MachineConfigPool) poolPolicies {
+	return poolPolicies{
+		osImageVerification:  ctrlcommon.OSImageVerificationPolicy.Get(hierarchy),
+		kernelArgumentTuning: ctrlcommon.KernelArgumentTuningPolicy.Get(hierarchy),
+		ioTuning:             ctrlcommon.IOTuningPolicy.Get(hierarchy),
+		interactiveAccess:    ctrlcommon.InteractiveAccessPolicy.Get(hierarchy),
+		packageOS:            ctrlcommon.PackageOSPolicy.Get(hierarchy),
+	}
+}
+
+func (p poolPolicies) validate() error {
+	if err := ctrlcommon.ValidateOSImageVerification(p.osImageVerification); err != nil {
+		return fmt.Errorf("invalid OS image verification policy: %w", err)
+	}
+	if err := ctrlcommon.ValidateKernelArgumentTuning(p.kernelArgumentTuning); err != nil {
+		return fmt.Errorf("invalid kernel argument tuning: %w", err)
+	}
+	if err := ctrlcommon.ValidateIOTuning(p.ioTuning); err != nil {
+		return fmt.Errorf("invalid I/O tuning: %w", err)
+	}
+	if err := ctrlcommon.ValidateInteractiveAccessPolicy(p.interactiveAccess); err != nil {
+		return fmt.Errorf("invalid interactive access policy: %w", err)
+	}
+	if err := ctrlcommon.ValidatePackageOS(p.packageOS); err != nil {
+		return fmt.Errorf("invalid package mapping: %w", err)
+	}
+	return nil
+}
+
+// setOn adds the policies to the Ignition config of a rendered config.
+func (p poolPolicies) setOn(mc *mcfgv1.MachineConfig) error {
+	if err := ctrlcommon.OSImageVerificationPolicy.Set(mc, p.osImageVerification); err != nil {
+		return err
+	}
+	if err := ctrlcommon.KernelArgumentTuningPolicy.Set(mc, p.kernelArgumentTuning); err != nil {
+		return err
+	}
+	if err := ctrlcommon.IOTuningPolicy.Set(mc, p.ioTuning); err != nil {
+		return err
+	}
+	if err := ctrlcommon.InteractiveAccessPolicy.Set(mc, p.interactiveAccess); err != nil {
+		return err
+	}
+	return ctrlcommon.PackageOSPolicy.Set(mc, p.packageOS)
+}
+
 // generateRenderedMachineConfig takes all MCs for a given pool and returns a single rendered MC. For ex master-XXXX or worker-XXXX
-func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig) (*mcfgv1.MachineConfig, error) {
+// configs are merged in the order given, as returned by getMachineConfigsForHierarchy.
+// The OS image has to satisfy the verification policy of the pool, which the rendered MC carries to the daemon
+// along with the other policies of the pool.
+// An OS image or extensions container referenced by tag is pinned to its current digest with resolve, so that all nodes
+// of the pool get the same build even if the tag moves; a nil resolve leaves them as is. If the configs merge to the same
+// config as they did for current, the rendered config the pool currently targets, its digests are reused rather than
+// resolved again, so the tags are only looked up when the configs change.
+func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig, policies poolPolicies, resolve osImageResolver, current *mcfgv1.MachineConfig) (*mcfgv1.MachineConfig, error) {
 	// Suppress rendered config generation until a corresponding new controller can roll out too.
 	// https://bugzilla.redhat.com/show_bug.cgi?id=1879099
 	if genver, ok := cconfig.Annotations[daemonconsts.GeneratedByVersionAnnotationKey]; ok {
@@ -563,10 +777,60 @@ func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mc
 		}
 	}
 
-	merged, err := ctrlcommon.MergeMachineConfigs(configs, cconfig.Spec.OSImageURL)
+	merged, err := ctrlcommon.MergeMachineConfigsInOrder(configs, cconfig.Spec.OSImageURL)
+	if err != nil {
+		return nil, err
+	}
+	merged.Spec.BaseOSExtensionsContainerImage = cconfig.Spec.BaseOperatingSystemExtensionsContainer
+	if err := policies.validate(); err != nil {
+		return nil, err
+	}
+	if err := policies.setOn(merged); err != nil {
+		return nil, err
+	}
+	unresolvedHash, err := getMachineConfigSpecHash(merged)
 	if err != nil {
 		return nil, err
 	}
+	osImageURL := merged.Spec.OSImageURL
+	reused, err := reuseResolvedImages(pool, merged, unresolvedHash, current)
+	if err != nil {
+		return nil, err
+	}
+	if !reused && resolve != nil && (osImageURL != "" || merged.Spec.BaseOSExtensionsContainerImage != "") {
+		// Look the images up the way the nodes pull them, i.e. on the mirrors of the pool
+		mergedIgn, err := ctrlcommon.ParseAndConvertConfig(merged.Spec.Config.Raw)
+		if err != nil {
+			return nil, err
+		}
+		registriesConf, err := ctrlcommon.GetIgnitionFileDataByPath(&mergedIgn, daemonconsts.ContainerRegistryConfPath)
+		if err != nil {
+			return nil, err
+		}
+		if osImageURL != "" {
+			if merged.Spec.OSImageURL, err = resolve(osImageURL, registriesConf); err != nil {
+				return nil, err
+			}
+		}
+		if merged.Spec.OSImageURL != osImageURL {
+			if merged.Annotations == nil {
+				merged.Annotations = map[string]string{}
+			}
+			merged.Annotations[ctrlcommon.OSImageURLTagAnnotationKey] = osImageURL
+		}
+		// Nodes install packages from the extensions container without GPG checks,
+		// so they only take it pinned by digest
+		if merged.Spec.BaseOSExtensionsContainerImage != "" {
+			if merged.Spec.BaseOSExtensionsContainerImage, err = resolve(merged.Spec.BaseOSExtensionsContainerImage, registriesConf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The policy applies to the image the nodes get, so a tag resolved above passes
+	// a policy that requires a digest
+	if err := ctrlcommon.CheckOSImageURL(merged.Spec.OSImageURL, policies.osImageVerification); err != nil {
+		return nil, err
+	}
 	hashedName, err := getMachineConfigHashedName(pool, merged)
 	if err != nil {
 		return nil, err
@@ -579,32 +843,82 @@ func generateRenderedMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mc
 		merged.Annotations = map[string]string{}
 	}
 	merged.Annotations[ctrlcommon.GeneratedByControllerVersionAnnotationKey] = version.Hash
+	merged.Annotations[ctrlcommon.UnresolvedConfigHashAnnotationKey] = unresolvedHash
 	merged.Annotations[ctrlcommon.ReleaseImageVersionAnnotationKey] = cconfig.Annotations[ctrlcommon.ReleaseImageVersionAnnotationKey]
 
+	// Record which source config set each file, unit and karg. This is only
+	// informational and doesn't affect the rendered name.
+	provenance, err := ctrlcommon.ComputeProvenanceInOrder(configs)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctrlcommon.SetProvenanceAnnotation(merged, provenance); err != nil {
+		glog.Warningf("Not recording provenance for %s: %v", merged.Name, err)
+	}
+
 	// Make it obvious that the OSImageURL has been overridden. If we log this in MergeMachineConfigs, we don't know the name yet, so we're
 	// logging out here instead so it's actually helpful.
-	if merged.Spec.OSImageURL != cconfig.Spec.OSImageURL {
-		glog.Infof("OSImageURL has been overridden via machineconfig in %s (was: %s is: %s)", merged.Name, cconfig.Spec.OSImageURL, merged.Spec.OSImageURL)
+	if osImageURL != cconfig.Spec.OSImageURL {
+		glog.Infof("OSImageURL has been overridden via machineconfig in %s (was: %s is: %s)", merged.Name, cconfig.Spec.OSImageURL, osImageURL)
+	}
+	if osImageURL != merged.Spec.OSImageURL {
+		glog.Infof("OSImageURL %s of %s resolved to %s", osImageURL, merged.Name, merged.Spec.OSImageURL)
 	}
 
 	return merged, nil
 }
 
+// reuseResolvedImages sets the OS image and extensions container of merged, the config
+// MachineConfigs merge to before images are resolved, to the digests current was rendered
+// with, if current was rendered from the same config. unresolvedHash is the hash of merged.
+func reuseResolvedImages(pool *mcfgv1.MachineConfigPool, merged *mcfgv1.MachineConfig, unresolvedHash string, current *mcfgv1.MachineConfig) (bool, error) {
+	if current == nil || current.Annotations[ctrlcommon.UnresolvedConfigHashAnnotationKey] != unresolvedHash {
+		return false, nil
+	}
+	reused := merged.DeepCopy()
+	reused.Spec.OSImageURL = current.Spec.OSImageURL
+	reused.Spec.BaseOSExtensionsContainerImage = current.Spec.BaseOSExtensionsContainerImage
+	// The spec of current may have been edited since it was rendered
+	name, err := getMachineConfigHashedName(pool, reused)
+	if err != nil {
+		return false, err
+	}
+	if name != current.Name {
+		return false, nil
+	}
+	merged.Spec = reused.Spec
+	if tag, ok := current.Annotations[ctrlcommon.OSImageURLTagAnnotationKey]; ok {
+		if merged.Annotations == nil {
+			merged.Annotations = map[string]string{}
+		}
+		merged.Annotations[ctrlcommon.OSImageURLTagAnnotationKey] = tag
+	}
+	return true, nil
+}
+
 // RunBootstrap runs the render controller in bootstrap mode.
 // For each pool, it matches the machineconfigs based on label selector and
 // returns the generated machineconfigs and pool with CurrentMachineConfig status field set.
-func RunBootstrap(pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig) ([]*mcfgv1.MachineConfigPool, []*mcfgv1.MachineConfig, error) {
+// OS images referenced by tag are resolved with pullSecret, the docker config JSON of the cluster pull secret.
+func RunBootstrap(pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, cconfig *mcfgv1.ControllerConfig, pullSecret []byte) ([]*mcfgv1.MachineConfigPool, []*mcfgv1.MachineConfig, error) {
+	resolve := func(imageURL string, registriesConf []byte) (string, error) {
+		return ctrlcommon.ResolveImageDigest(imageURL, pullSecret, registriesConf)
+	}
 	var (
 		opools   []*mcfgv1.MachineConfigPool
 		oconfigs []*mcfgv1.MachineConfig
 	)
 	for _, pool := range pools {
-		pcs, err := getMachineConfigsForPool(pool, configs)
+		pcs, err := getMachineConfigsForPool(pool, pools, configs)
+		if err != nil {
+			return nil, nil, err
+		}
+		hierarchy, err := ctrlcommon.GetPoolHierarchy(pool, getBootstrapPool(pools))
 		if err != nil {
 			return nil, nil, err
 		}
 
-		generated, err := generateRenderedMachineConfig(pool, pcs, cconfig)
+		generated, err := generateRenderedMachineConfig(pool, pcs, cconfig, getPoolPolicies(hierarchy), resolve, nil)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -624,26 +938,84 @@ func RunBootstrap(pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineCo
 	return opools, oconfigs, nil
 }
 
-// getMachineConfigsForPool is called by RunBootstrap and returns configs that match label from configs for a pool.
-func getMachineConfigsForPool(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig) ([]*mcfgv1.MachineConfig, error) {
+// getMachineConfigsForHierarchy returns the MachineConfigs selected by any of the pools in
+// the hierarchy of a pool, as returned by GetPoolHierarchy, in the order they are merged:
+// level by level from the root pool down to the pool itself, and by name within a level,
+// so that the configs of a pool override those it inherits whatever their names.
+// A MachineConfig selected by several pools of the hierarchy is merged at the level of the
+// one nearest the root. list lists MachineConfigs by selector.
+func getMachineConfigsForHierarchy(hierarchy []*mcfgv1.MachineConfigPool, list func(labels.Selector) ([]*mcfgv1.MachineConfig, error)) ([]*mcfgv1.MachineConfig, error) {
+	var mcs []*mcfgv1.MachineConfig
+	seen := make(map[string]bool)
+	for i := len(hierarchy) - 1; i >= 0; i-- {
+		pool := hierarchy[i]
+		selector, err := metav1.LabelSelectorAsSelector(pool.Spec.MachineConfigSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector of pool %s: %w", pool.Name, err)
+		}
+		// If a pool with a nil or empty selector creeps in, it should match nothing
+		if selector.Empty() {
+			continue
+		}
+		selected, err := list(selector)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+		for _, config := range selected {
+			if seen[config.Name] {
+				continue
+			}
+			seen[config.Name] = true
+			mcs = append(mcs, config)
+		}
+	}
+	return mcs, nil
+}
+
+// getMachineConfigsForPool is called by RunBootstrap and returns configs that match label from configs for a pool,
+// including those inherited from its parents among pools.
+func getMachineConfigsForPool(pool *mcfgv1.MachineConfigPool, pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig) ([]*mcfgv1.MachineConfig, error) {
 	selector, err := metav1.LabelSelectorAsSelector(pool.Spec.MachineConfigSelector)
 	if err != nil {
 		return nil, fmt.Errorf("invalid label selector: %w", err)
 	}
-
-	var out []*mcfgv1.MachineConfig
-
 	// If a pool with a nil or empty selector creeps in, it should match nothing
-	if selector.Empty() {
-		return out, nil
+	if selector.Empty() && pool.Spec.Parent == "" {
+		return nil, nil
+	}
+
+	hierarchy, err := ctrlcommon.GetPoolHierarchy(pool, getBootstrapPool(pools))
+	if err != nil {
+		return nil, err
 	}
-	for idx, config := range configs {
-		if selector.Matches(labels.Set(config.Labels)) {
-			out = append(out, configs[idx])
+
+	out, err := getMachineConfigsForHierarchy(hierarchy, func(selector labels.Selector) ([]*mcfgv1.MachineConfig, error) {
+		var matched []*mcfgv1.MachineConfig
+		for idx, config := range configs {
+			if selector.Matches(labels.Set(config.Labels)) {
+				matched = append(matched, configs[idx])
+			}
 		}
+		return matched, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	if len(out) == 0 {
 		return nil, fmt.Errorf("couldn't find any MachineConfigs for pool: %v", pool.Name)
 	}
 	return out, nil
 }
+
+// getBootstrapPool returns a function looking up pools by name among pools, for GetPoolHierarchy.
+func getBootstrapPool(pools []*mcfgv1.MachineConfigPool) func(string) (*mcfgv1.MachineConfigPool, error) {
+	return func(name string) (*mcfgv1.MachineConfigPool, error) {
+		for _, p := range pools {
+			if p.Name == name {
+				return p, nil
+			}
+		}
+		return nil, errors.NewNotFound(mcfgv1.Resource("machineconfigpool"), name)
+	}
+}
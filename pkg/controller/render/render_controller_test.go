@@ -3,6 +3,7 @@ package render
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -71,6 +72,7 @@ func (f *fixture) newController() *Controller {
 	c.mcListerSynced = alwaysReady
 	c.ccListerSynced = alwaysReady
 	c.eventRecorder = &record.FakeRecorder{}
+	c.resolveImageDigest = func(imageURL string, pullSecret, registriesConf []byte) (string, error) { return imageURL, nil }
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
@@ -290,7 +292,7 @@ func TestIgnValidationGenerateRenderedMachineConfig(t *testing.T) {
 	}
 	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
 
-	_, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	_, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	require.Nil(t, err)
 
 	// verify that an invalid ignition config (here a config with content and an empty version,
@@ -302,7 +304,7 @@ func TestIgnValidationGenerateRenderedMachineConfig(t *testing.T) {
 	require.Nil(t, err)
 	mcs[1].Spec.Config.Raw = rawIgnCfg
 
-	_, err = generateRenderedMachineConfig(mcp, mcs, cc)
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	require.NotNil(t, err)
 
 	// verify that a machine config with no ignition content will not fail validation
@@ -311,7 +313,7 @@ func TestIgnValidationGenerateRenderedMachineConfig(t *testing.T) {
 	require.Nil(t, err)
 	mcs[1].Spec.Config.Raw = rawEmptyIgnCfg
 	mcs[1].Spec.KernelArguments = append(mcs[1].Spec.KernelArguments, "test1")
-	_, err = generateRenderedMachineConfig(mcp, mcs, cc)
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	require.Nil(t, err)
 
 }
@@ -334,7 +336,7 @@ func TestUpdatesGeneratedMachineConfig(t *testing.T) {
 	}
 	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
 
-	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -353,7 +355,7 @@ func TestUpdatesGeneratedMachineConfig(t *testing.T) {
 	f.mcLister = append(f.mcLister, gmc)
 	f.objects = append(f.objects, gmc)
 
-	expmc, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	expmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -379,13 +381,138 @@ func TestGenerateMachineConfigOverrideOSImageURL(t *testing.T) {
 
 	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
 
-	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, "dummy-change", gmc.Spec.OSImageURL)
 }
 
+func TestGenerateMachineConfigResolvesOSImageTag(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-worker", helpers.WorkerSelector, nil, "")
+	pinned := "quay.io/openshift/os@sha256:" + strings.Repeat("a", 64)
+	resolved := map[string]string{"quay.io/openshift/os:latest": pinned, pinned: pinned}
+	registriesConf := "[[registry]]\nlocation = \"quay.io/openshift\"\n"
+	resolve := func(imageURL string, conf []byte) (string, error) {
+		// The image is looked up on the mirrors the nodes use
+		if string(conf) != registriesConf {
+			return "", fmt.Errorf("unexpected registries.conf %q", conf)
+		}
+		if r, ok := resolved[imageURL]; ok {
+			return r, nil
+		}
+		return "", fmt.Errorf("manifest unknown")
+	}
+	newConfigs := func(osImageURL string) []*mcfgv1.MachineConfig {
+		return []*mcfgv1.MachineConfig{
+			helpers.NewMachineConfig("00-test-cluster-worker", map[string]string{"node-role/worker": ""}, osImageURL, []ign3types.File{
+				ctrlcommon.NewIgnFile("/etc/containers/registries.conf", registriesConf),
+			}),
+		}
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	gmc, err := generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:latest"), cc, poolPolicies{}, resolve, nil)
+	require.NoError(t, err)
+	assert.Equal(t, pinned, gmc.Spec.OSImageURL)
+	assert.Equal(t, "quay.io/openshift/os:latest", gmc.Annotations[ctrlcommon.OSImageURLTagAnnotationKey])
+
+	// A config naming the digest directly renders the same
+	pinnedGmc, err := generateRenderedMachineConfig(mcp, newConfigs(pinned), cc, poolPolicies{}, resolve, nil)
+	require.NoError(t, err)
+	assert.Equal(t, gmc.Name, pinnedGmc.Name)
+	assert.NotContains(t, pinnedGmc.Annotations, ctrlcommon.OSImageURLTagAnnotationKey)
+
+	_, err = generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:missing"), cc, poolPolicies{}, resolve, nil)
+	assert.Error(t, err)
+
+	// A policy that requires a digest applies to the resolved image
+	policies := poolPolicies{osImageVerification: &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationDigestPinned}}
+	gmc, err = generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:latest"), cc, policies, resolve, nil)
+	require.NoError(t, err)
+	assert.Equal(t, pinned, gmc.Spec.OSImageURL)
+	_, err = generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:latest"), cc, policies, nil, nil)
+	assert.Error(t, err)
+
+	// The tag is only looked up again once the configs change
+	resolveCalls := 0
+	countingResolve := func(imageURL string, conf []byte) (string, error) {
+		resolveCalls++
+		return resolve(imageURL, conf)
+	}
+	current, err := generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:latest"), cc, poolPolicies{}, countingResolve, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resolveCalls)
+	resolved["quay.io/openshift/os:latest"] = "quay.io/openshift/os@sha256:" + strings.Repeat("c", 64)
+	gmc, err = generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:latest"), cc, poolPolicies{}, countingResolve, current)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resolveCalls)
+	assert.Equal(t, current.Name, gmc.Name)
+	assert.Equal(t, "quay.io/openshift/os:latest", gmc.Annotations[ctrlcommon.OSImageURLTagAnnotationKey])
+	changed := newConfigs("quay.io/openshift/os:latest")
+	changed[0].Spec.KernelArguments = []string{"nosmt"}
+	gmc, err = generateRenderedMachineConfig(mcp, changed, cc, poolPolicies{}, countingResolve, current)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resolveCalls)
+	assert.Equal(t, resolved["quay.io/openshift/os:latest"], gmc.Spec.OSImageURL)
+	// A rendered config edited since it was rendered isn't trusted
+	current.Spec.OSImageURL = "quay.io/openshift/os@sha256:" + strings.Repeat("d", 64)
+	gmc, err = generateRenderedMachineConfig(mcp, newConfigs("quay.io/openshift/os:latest"), cc, poolPolicies{}, countingResolve, current)
+	require.NoError(t, err)
+	assert.Equal(t, 3, resolveCalls)
+	assert.Equal(t, resolved["quay.io/openshift/os:latest"], gmc.Spec.OSImageURL)
+	resolved["quay.io/openshift/os:latest"] = pinned
+
+	// The extensions container is pinned too
+	pinnedExtensions := "quay.io/openshift/os-extensions@sha256:" + strings.Repeat("b", 64)
+	resolved["quay.io/openshift/os-extensions:latest"] = pinnedExtensions
+	cc.Spec.BaseOperatingSystemExtensionsContainer = "quay.io/openshift/os-extensions:latest"
+	gmc, err = generateRenderedMachineConfig(mcp, newConfigs(pinned), cc, poolPolicies{}, resolve, nil)
+	require.NoError(t, err)
+	assert.Equal(t, pinnedExtensions, gmc.Spec.BaseOSExtensionsContainerImage)
+}
+
+func TestGenerateMachineConfigBaseOSExtensionsContainerImage(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-worker", helpers.WorkerSelector, nil, "")
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-worker", map[string]string{"node-role/worker": ""}, "dummy-test-1", []ign3types.File{}),
+	}
+
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, gmc.Spec.BaseOSExtensionsContainerImage)
+
+	// Rendered configs carry the extensions container for layered images
+	cc.Spec.BaseOperatingSystemExtensionsContainer = "quay.io/openshift/os-extensions:latest"
+	extGmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/openshift/os-extensions:latest", extGmc.Spec.BaseOSExtensionsContainerImage)
+	assert.NotEqual(t, gmc.Name, extGmc.Name)
+}
+
+func TestGenerateMachineConfigProvenance(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-master", map[string]string{"node-role/master": ""}, "", []ign3types.File{{Node: ign3types.Node{Path: "/etc/foo"}}}),
+		helpers.NewMachineConfig("99-user-master", map[string]string{"node-role/master": ""}, "", []ign3types.File{{Node: ign3types.Node{Path: "/etc/foo"}}}),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
+	require.NoError(t, err)
+	provenance, err := ctrlcommon.GetProvenance(gmc)
+	require.NoError(t, err)
+	require.NotNil(t, provenance)
+	assert.Equal(t, ctrlcommon.ProvenanceEntry{Source: "99-user-master", Overridden: []string{"00-test-cluster-master"}}, provenance.Paths["/etc/foo"])
+
+	// provenance is informational and does not change the rendered name
+	gmc.Annotations = nil
+	name, err := getMachineConfigHashedName(mcp, gmc)
+	require.NoError(t, err)
+	assert.Equal(t, gmc.Name, name)
+}
+
 func TestVersionSkew(t *testing.T) {
 	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
 	mcs := []*mcfgv1.MachineConfig{
@@ -395,12 +522,12 @@ func TestVersionSkew(t *testing.T) {
 
 	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
 	cc.Annotations[daemonconsts.GeneratedByVersionAnnotationKey] = "different-version"
-	_, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	_, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	require.NotNil(t, err)
 
 	// Now the same thing without overriding the version
 	cc = newControllerConfig(ctrlcommon.ControllerConfigName)
-	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	require.Nil(t, err)
 	require.NotNil(t, gmc)
 }
@@ -423,7 +550,7 @@ func TestDoNothing(t *testing.T) {
 	}
 	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
 
-	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc)
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -472,7 +599,7 @@ func TestGetMachineConfigsForPool(t *testing.T) {
 		helpers.NewMachineConfig("05-extra-master", map[string]string{"node-role/master": ""}, "dummy://1", []ign3types.File{files[1]}),
 		helpers.NewMachineConfig("00-test-cluster-worker", map[string]string{"node-role/worker": ""}, "dummy://2", []ign3types.File{files[2]}),
 	}
-	masterConfigs, err := getMachineConfigsForPool(masterPool, mcs)
+	masterConfigs, err := getMachineConfigsForPool(masterPool, nil, mcs)
 	if err != nil {
 		t.Fatalf("expected no error, got: %v", err)
 	}
@@ -483,12 +610,74 @@ func TestGetMachineConfigsForPool(t *testing.T) {
 
 	// search for a worker config in an array of MCs with no worker configs
 	workerPool := helpers.NewMachineConfigPool("test-cluster-worker", helpers.WorkerSelector, nil, "")
-	_, err = getMachineConfigsForPool(workerPool, mcs[:2])
+	_, err = getMachineConfigsForPool(workerPool, nil, mcs[:2])
 	if err == nil {
 		t.Fatalf("expected error, no worker configs found")
 	}
 }
 
+func TestGetMachineConfigsForPoolHierarchy(t *testing.T) {
+	workerPool := helpers.NewMachineConfigPool("worker", helpers.WorkerSelector, nil, "")
+	gpuPool := helpers.NewMachineConfigPool("gpu", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role/gpu", ""), nil, "")
+	gpuPool.Spec.Parent = "worker"
+	a100Pool := helpers.NewMachineConfigPool("gpu-a100", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role/gpu-a100", ""), nil, "")
+	a100Pool.Spec.Parent = "gpu"
+	pools := []*mcfgv1.MachineConfigPool{workerPool, gpuPool, a100Pool}
+
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-worker", map[string]string{"node-role/worker": ""}, "dummy://", nil),
+		helpers.NewMachineConfig("50-gpu", map[string]string{"node-role/gpu": ""}, "", nil),
+		helpers.NewMachineConfig("60-gpu-a100", map[string]string{"node-role/gpu-a100": ""}, "", nil),
+		// selected by two pools of the hierarchy, but only used once
+		helpers.NewMachineConfig("70-shared", map[string]string{"node-role/gpu": "", "node-role/gpu-a100": ""}, "", nil),
+	}
+	names := func(configs []*mcfgv1.MachineConfig) []string {
+		var names []string
+		for _, config := range configs {
+			names = append(names, config.Name)
+		}
+		return names
+	}
+
+	configs, err := getMachineConfigsForPool(workerPool, pools, mcs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00-worker"}, names(configs))
+
+	configs, err = getMachineConfigsForPool(gpuPool, pools, mcs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00-worker", "50-gpu", "70-shared"}, names(configs))
+
+	configs, err = getMachineConfigsForPool(a100Pool, pools, mcs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00-worker", "50-gpu", "70-shared", "60-gpu-a100"}, names(configs))
+
+	// The configs of a pool override those it inherits, whatever their names
+	mcs = append(mcs,
+		helpers.NewMachineConfig("99-gpu-generated-kubelet", map[string]string{"node-role/gpu": ""}, "", []ign3types.File{{Node: ign3types.Node{Path: "/etc/kubernetes/kubelet.conf"}}}),
+		helpers.NewMachineConfig("99-gpu-a100-generated-kubelet", map[string]string{"node-role/gpu-a100": ""}, "", []ign3types.File{{Node: ign3types.Node{Path: "/etc/kubernetes/kubelet.conf"}}}))
+	configs, err = getMachineConfigsForPool(a100Pool, pools, mcs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00-worker", "50-gpu", "70-shared", "99-gpu-generated-kubelet", "60-gpu-a100", "99-gpu-a100-generated-kubelet"}, names(configs))
+	gmc, err := generateRenderedMachineConfig(a100Pool, configs, newControllerConfig(ctrlcommon.ControllerConfigName), poolPolicies{}, nil, nil)
+	require.NoError(t, err)
+	provenance, err := ctrlcommon.GetProvenance(gmc)
+	require.NoError(t, err)
+	require.NotNil(t, provenance)
+	assert.Equal(t, ctrlcommon.ProvenanceEntry{Source: "99-gpu-a100-generated-kubelet", Overridden: []string{"99-gpu-generated-kubelet"}}, provenance.Paths["/etc/kubernetes/kubelet.conf"])
+
+	// A pool selecting no MachineConfigs of its own still inherits its parent's
+	emptyPool := helpers.NewMachineConfigPool("empty", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role/empty", ""), nil, "")
+	emptyPool.Spec.Parent = "worker"
+	configs, err = getMachineConfigsForPool(emptyPool, append(pools, emptyPool), mcs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00-worker"}, names(configs))
+
+	// Cycles are rejected
+	workerPool.Spec.Parent = "gpu-a100"
+	_, err = getMachineConfigsForPool(a100Pool, pools, mcs)
+	assert.EqualError(t, err, "pool inheritance cycle: gpu-a100 -> gpu -> worker -> gpu-a100")
+}
+
 func getKey(config *mcfgv1.MachineConfigPool, t *testing.T) string {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(config)
 	if err != nil {
@@ -515,3 +704,62 @@ func TestMachineConfigsNoBailWithoutPool(t *testing.T) {
 	c.deleteMachineConfig(mc)
 	require.Len(t, queue, 3)
 }
+
+func TestGenerateMachineConfigOSImageVerification(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-master", helpers.MasterSelector, nil, "")
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-master", map[string]string{"node-role/master": ""}, "", nil),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+	pinned := "quay.io/openshift/os@sha256:" + strings.Repeat("a", 64)
+
+	unverified, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
+	require.NoError(t, err)
+
+	// The default OS image isn't pinned, so it can't be verified
+	verification := &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationDigestPinned}
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{osImageVerification: verification}, nil, nil)
+	assert.Error(t, err)
+
+	// Neither can a pinned image with a digest that isn't allowed
+	cc.Spec.OSImageURL = pinned
+	verification.AllowedDigests = []string{"sha256:" + strings.Repeat("b", 64)}
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{osImageVerification: verification}, nil, nil)
+	assert.Error(t, err)
+
+	// The rendered config carries the policy to the daemon
+	verification.AllowedDigests = append(verification.AllowedDigests, "sha256:"+strings.Repeat("a", 64))
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{osImageVerification: verification}, nil, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, unverified.Name, gmc.Name)
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(gmc.Spec.Config.Raw)
+	require.NoError(t, err)
+	got, err := ctrlcommon.OSImageVerificationPolicy.GetFromIgnition(&ignCfg)
+	require.NoError(t, err)
+	assert.Equal(t, verification, got)
+}
+
+func TestGenerateMachineConfigKernelArgumentTuning(t *testing.T) {
+	mcp := helpers.NewMachineConfigPool("test-cluster-worker", helpers.WorkerSelector, nil, "")
+	mcs := []*mcfgv1.MachineConfig{
+		helpers.NewMachineConfig("00-test-cluster-worker", map[string]string{"node-role/worker": ""}, "", nil),
+	}
+	cc := newControllerConfig(ctrlcommon.ControllerConfigName)
+
+	untuned, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{}, nil, nil)
+	require.NoError(t, err)
+
+	_, err = generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{kernelArgumentTuning: &mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"nosmt mitigations=off"}}}, nil, nil)
+	assert.Error(t, err)
+
+	// The rendered config carries the allowlist to the daemon
+	tuning := &mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"nosmt", "mitigations=off"}}
+	gmc, err := generateRenderedMachineConfig(mcp, mcs, cc, poolPolicies{kernelArgumentTuning: tuning}, nil, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, untuned.Name, gmc.Name)
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(gmc.Spec.Config.Raw)
+	require.NoError(t, err)
+	got, err := ctrlcommon.KernelArgumentTuningPolicy.GetFromIgnition(&ignCfg)
+	require.NoError(t, err)
+	assert.Equal(t, tuning, got)
+}
@@ -0,0 +1,140 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+)
+
+const (
+	// TemplateSourceLabelKey marks ConfigMaps in the openshift-config namespace that
+	// hold additional templates to be rendered by the template controller.
+	TemplateSourceLabelKey = "machineconfiguration.openshift.io/template-source"
+
+	// TemplateSourceAnnotationKey is set on MachineConfigs rendered from a template
+	// overlay and records the <namespace>/<name> of the source ConfigMap.
+	TemplateSourceAnnotationKey = "machineconfiguration.openshift.io/template-source"
+
+	// TemplateSourceNamespace is the namespace template overlay ConfigMaps are read from.
+	TemplateSourceNamespace = "openshift-config"
+)
+
+// isTemplateOverlay returns true if the ConfigMap is labeled as a template overlay.
+func isTemplateOverlay(cm *corev1.ConfigMap) bool {
+	_, ok := cm.Labels[TemplateSourceLabelKey]
+	return ok
+}
+
+// templateOverlayPath maps a ConfigMap key of the form <platform>.<files|units>.<file>
+// to the relative path it has in a template directory, i.e. <platform>/<type>/<file>.
+// ConfigMap keys cannot contain '/', hence the dotted layout.
+func templateOverlayPath(key string) (string, error) {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", fmt.Errorf("key %q must be of the form <platform>.<files|units>.<name>", key)
+	}
+	if parts[1] != filesDir && parts[1] != unitsDir {
+		return "", fmt.Errorf("key %q has unknown template type %q, expected %q or %q", key, parts[1], filesDir, unitsDir)
+	}
+	return filepath.Join(parts[0], parts[1], parts[2]), nil
+}
+
+// generateTemplateOverlayMachineConfig renders a template overlay ConfigMap into a
+// MachineConfig named after the ConfigMap, for the role set in its
+// machineconfiguration.openshift.io/role label.
+//
+// The ConfigMap data is laid out in a temporary directory exactly like a single
+// <templatedir>/<role>/<name> directory, so the same platform selection, template
+// funcs and filtering as for the built-in templates apply. Templates from
+// templates/common are not added to overlays.
+//
+//	ex: a ConfigMap 50-worker-dns with the data keys
+//	     _base.files.resolv-override.yaml
+//	     aws.units.dns-check.service.yaml
+//	renders like templates/worker/50-worker-dns/_base/files/resolv-override.yaml
+//	                                           /aws/units/dns-check.service.yaml
+func generateTemplateOverlayMachineConfig(config *RenderConfig, cm *corev1.ConfigMap) (*mcfgv1.MachineConfig, error) {
+	role := cm.Labels[mcfgv1.MachineConfigRoleLabelKey]
+	if role == "" {
+		return nil, fmt.Errorf("template overlay %s/%s is missing the %s label", cm.Namespace, cm.Name, mcfgv1.MachineConfigRoleLabelKey)
+	}
+	if errs := validation.IsDNS1123Label(role); len(errs) > 0 {
+		return nil, fmt.Errorf("template overlay %s/%s has invalid role %q: %s", cm.Namespace, cm.Name, role, strings.Join(errs, ", "))
+	}
+
+	dir, err := ioutil.TempDir("", "template-overlay-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	for key, data := range cm.Data {
+		rel, err := templateOverlayPath(key)
+		if err != nil {
+			return nil, fmt.Errorf("template overlay %s/%s: %w", cm.Namespace, cm.Name, err)
+		}
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0o644); err != nil {
+			return nil, err
+		}
+	}
+
+	commonAdded := true
+	mc, err := generateMachineConfigForName(config, role, cm.Name, dir, dir, &commonAdded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template overlay %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+	// Overlays only carry files and units, the OS image always comes from 00-<role>
+	mc.Spec.OSImageURL = ""
+	mc.Annotations = map[string]string{
+		ctrlcommon.GeneratedByControllerVersionAnnotationKey: version.Hash,
+		TemplateSourceAnnotationKey:                          cm.Namespace + "/" + cm.Name,
+	}
+	return mc, nil
+}
+
+// getTemplateOverlayMachineConfigs renders every template overlay ConfigMap. reserved
+// holds the names of MachineConfigs the overlays must not replace, such as the
+// ones generated from the built-in templates. Overlays that fail to render are
+// returned as errors keyed by ConfigMap name, so a single broken overlay does not
+// hold back the others.
+func getTemplateOverlayMachineConfigs(config *mcfgv1.ControllerConfig, rc *RenderConfig, cms []*corev1.ConfigMap, reserved map[string]bool) ([]*mcfgv1.MachineConfig, map[string]error) {
+	mcs := []*mcfgv1.MachineConfig{}
+	errs := map[string]error{}
+	for _, cm := range cms {
+		if !isTemplateOverlay(cm) {
+			continue
+		}
+		if reserved[cm.Name] {
+			errs[cm.Name] = fmt.Errorf("template overlay %s/%s conflicts with MachineConfig %s", cm.Namespace, cm.Name, cm.Name)
+			continue
+		}
+		mc, err := generateTemplateOverlayMachineConfig(rc, cm)
+		if err != nil {
+			errs[cm.Name] = err
+			continue
+		}
+		oref := metav1.NewControllerRef(config, controllerKind)
+		mc.SetOwnerReferences([]metav1.OwnerReference{*oref})
+		glog.V(4).Infof("Rendered MachineConfig %s from template overlay %s/%s", mc.Name, cm.Namespace, cm.Name)
+		mcs = append(mcs, mc)
+	}
+
+	sort.Slice(mcs, func(i, j int) bool { return mcs[i].Name < mcs[j].Name })
+	return mcs, errs
+}
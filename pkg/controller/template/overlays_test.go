@@ -0,0 +1,65 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestGenerateTemplateOverlayMachineConfig(t *testing.T) {
+	cc := newControllerConfig("test-cluster")
+	rc, err := newRenderConfig(cc, []byte(`{"dummy": "dummy"}`), nil)
+	require.NoError(t, err)
+
+	overlay := newTemplateOverlay("50-worker-dns", "infra", map[string]string{
+		"_base.files.dns.yaml":    "mode: 0644\npath: /etc/dns-ip\ncontents:\n  inline: {{.ClusterDNSIP}}\n",
+		"libvirt.files.dns.yaml":  "mode: 0644\npath: /etc/dns-ip-libvirt\ncontents:\n  inline: {{.ClusterDNSIP}}\n",
+		"aws.files.aws-only.yaml": "mode: 0644\npath: /etc/aws-only\ncontents:\n  inline: aws\n",
+		"_base.units.empty.yaml":  "",
+	})
+	mc, err := generateTemplateOverlayMachineConfig(rc, overlay)
+	require.NoError(t, err)
+	assert.Equal(t, "50-worker-dns", mc.Name)
+	assert.Equal(t, "infra", mc.Labels[mcfgv1.MachineConfigRoleLabelKey])
+	assert.Equal(t, TemplateSourceNamespace+"/50-worker-dns", mc.Annotations[TemplateSourceAnnotationKey])
+	assert.Empty(t, mc.Spec.OSImageURL)
+
+	ign, err := ctrlcommon.ParseAndConvertConfig(mc.Spec.Config.Raw)
+	require.NoError(t, err)
+	// the platform specific template overrides the _base one with the same name
+	require.Len(t, ign.Storage.Files, 1)
+	assert.Equal(t, "/etc/dns-ip-libvirt", ign.Storage.Files[0].Path)
+	assert.Empty(t, ign.Systemd.Units)
+}
+
+func TestGetTemplateOverlayMachineConfigsErrors(t *testing.T) {
+	cc := newControllerConfig("test-cluster")
+	rc, err := newRenderConfig(cc, []byte(`{"dummy": "dummy"}`), nil)
+	require.NoError(t, err)
+
+	valid := "mode: 0644\npath: /etc/foo\ncontents:\n  inline: foo\n"
+	noRole := newTemplateOverlay("50-no-role", "", map[string]string{"_base.files.foo.yaml": valid})
+	badKey := newTemplateOverlay("50-bad-key", "worker", map[string]string{"_base.scripts.foo.yaml": valid})
+	badTemplate := newTemplateOverlay("50-bad-template", "worker", map[string]string{"_base.files.foo.yaml": "{{.DoesNotExist}}"})
+	conflict := newTemplateOverlay("00-worker", "worker", map[string]string{"_base.files.foo.yaml": valid})
+	good := newTemplateOverlay("50-good", "worker", map[string]string{"_base.files.foo.yaml": valid})
+	unlabeled := good.DeepCopy()
+	unlabeled.Name = "50-unlabeled"
+	delete(unlabeled.Labels, TemplateSourceLabelKey)
+
+	mcs, errs := getTemplateOverlayMachineConfigs(cc, rc,
+		[]*corev1.ConfigMap{noRole, badKey, badTemplate, conflict, good, unlabeled},
+		map[string]bool{"00-worker": true})
+	require.Len(t, mcs, 1)
+	assert.Equal(t, "50-good", mcs[0].Name)
+	assert.Len(t, mcs[0].OwnerReferences, 1)
+	for _, name := range []string{"50-no-role", "50-bad-key", "50-bad-template", "00-worker"} {
+		assert.Error(t, errs[name], name)
+	}
+	assert.Len(t, errs, 4)
+}
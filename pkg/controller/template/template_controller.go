@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -25,11 +26,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	corev1clientset "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -61,11 +64,13 @@ type Controller struct {
 	ccLister   mcfglistersv1.ControllerConfigLister
 	mcLister   mcfglistersv1.MachineConfigLister
 	featLister oselistersv1.FeatureGateLister
+	cmLister   corelistersv1.ConfigMapLister
 
 	ccListerSynced        cache.InformerSynced
 	mcListerSynced        cache.InformerSynced
 	secretsInformerSynced cache.InformerSynced
 	featListerSynced      cache.InformerSynced
+	cmListerSynced        cache.InformerSynced
 
 	queue workqueue.RateLimitingInterface
 }
@@ -77,6 +82,7 @@ func New(
 	mcInformer mcfginformersv1.MachineConfigInformer,
 	secretsInformer coreinformersv1.SecretInformer,
 	featureInformer oseinformersv1.FeatureGateInformer,
+	configMapInformer coreinformersv1.ConfigMapInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 ) *Controller {
@@ -117,16 +123,24 @@ func New(
 		DeleteFunc: ctrl.deleteFeature,
 	})
 
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addConfigMap,
+		UpdateFunc: ctrl.updateConfigMap,
+		DeleteFunc: ctrl.deleteConfigMap,
+	})
+
 	ctrl.syncHandler = ctrl.syncControllerConfig
 	ctrl.enqueueControllerConfig = ctrl.enqueue
 
 	ctrl.ccLister = ccInformer.Lister()
 	ctrl.mcLister = mcInformer.Lister()
 	ctrl.featLister = featureInformer.Lister()
+	ctrl.cmLister = configMapInformer.Lister()
 	ctrl.ccListerSynced = ccInformer.Informer().HasSynced
 	ctrl.mcListerSynced = mcInformer.Informer().HasSynced
 	ctrl.secretsInformerSynced = secretsInformer.Informer().HasSynced
 	ctrl.featListerSynced = featureInformer.Informer().HasSynced
+	ctrl.cmListerSynced = configMapInformer.Informer().HasSynced
 
 	return ctrl
 }
@@ -226,12 +240,56 @@ func (ctrl *Controller) deleteFeature(obj interface{}) {
 	ctrl.enqueueController()
 }
 
+func (ctrl *Controller) addConfigMap(obj interface{}) {
+	cm := obj.(*corev1.ConfigMap)
+	if !isTemplateOverlay(cm) {
+		return
+	}
+	glog.V(4).Infof("Adding template overlay %s/%s", cm.Namespace, cm.Name)
+	ctrl.enqueueController()
+}
+
+func (ctrl *Controller) updateConfigMap(old, cur interface{}) {
+	oldCM := old.(*corev1.ConfigMap)
+	curCM := cur.(*corev1.ConfigMap)
+	// also resync when the label is removed so the rendered MachineConfig is cleaned up
+	if !isTemplateOverlay(oldCM) && !isTemplateOverlay(curCM) {
+		return
+	}
+	if reflect.DeepEqual(oldCM.Data, curCM.Data) && reflect.DeepEqual(oldCM.Labels, curCM.Labels) {
+		return
+	}
+	glog.V(4).Infof("Updating template overlay %s/%s", curCM.Namespace, curCM.Name)
+	ctrl.enqueueController()
+}
+
+func (ctrl *Controller) deleteConfigMap(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		cm, ok = tombstone.Obj.(*corev1.ConfigMap)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a ConfigMap %#v", obj))
+			return
+		}
+	}
+	if !isTemplateOverlay(cm) {
+		return
+	}
+	glog.V(4).Infof("Deleting template overlay %s/%s", cm.Namespace, cm.Name)
+	ctrl.enqueueController()
+}
+
 // Run executes the template controller
 func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.secretsInformerSynced, ctrl.featListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.ccListerSynced, ctrl.mcListerSynced, ctrl.secretsInformerSynced, ctrl.featListerSynced, ctrl.cmListerSynced) {
 		return
 	}
 
@@ -495,18 +553,104 @@ func (ctrl *Controller) syncControllerConfig(key string) error {
 		}
 	}
 
+	rc, err := newRenderConfig(cfg, pullSecretRaw, fg)
+	if err != nil {
+		return ctrl.syncFailingStatus(cfg, err)
+	}
+	if err := ctrl.syncTemplateOverlays(cfg, rc, mcs); err != nil {
+		return ctrl.syncFailingStatus(cfg, err)
+	}
+
 	return ctrl.syncCompletedStatus(cfg)
 }
 
-func getMachineConfigsForControllerConfig(templatesDir string, config *mcfgv1.ControllerConfig, pullSecretRaw []byte, featureGate *configv1.FeatureGate) ([]*mcfgv1.MachineConfig, error) {
+// syncTemplateOverlays renders the template overlay ConfigMaps, applies the
+// resulting MachineConfigs and deletes the ones whose ConfigMap is gone.
+// MachineConfigs of overlays that fail to render are left untouched.
+func (ctrl *Controller) syncTemplateOverlays(cfg *mcfgv1.ControllerConfig, rc *RenderConfig, generated []*mcfgv1.MachineConfig) error {
+	cms, err := ctrl.cmLister.ConfigMaps(TemplateSourceNamespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	existing, err := ctrl.mcLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	// overlays must not replace built-in or user provided MachineConfigs
+	reserved := map[string]bool{}
+	for _, mc := range generated {
+		reserved[mc.Name] = true
+	}
+	for _, mc := range existing {
+		if _, ok := mc.Annotations[TemplateSourceAnnotationKey]; !ok {
+			reserved[mc.Name] = true
+		}
+	}
+
+	overlays, renderErrs := getTemplateOverlayMachineConfigs(cfg, rc, cms, reserved)
+	for _, mc := range overlays {
+		_, updated, err := mcoResourceApply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), mc)
+		if err != nil {
+			return err
+		}
+		if updated {
+			glog.V(4).Infof("Machineconfig %s was updated from template overlay %s", mc.Name, mc.Annotations[TemplateSourceAnnotationKey])
+		}
+	}
+
+	rendered := map[string]bool{}
+	for _, mc := range overlays {
+		rendered[mc.Name] = true
+	}
+	for _, mc := range existing {
+		if _, ok := mc.Annotations[TemplateSourceAnnotationKey]; !ok || rendered[mc.Name] {
+			continue
+		}
+		if _, ok := renderErrs[mc.Name]; ok {
+			continue
+		}
+		if ref := metav1.GetControllerOf(mc); ref == nil || ref.UID != cfg.UID {
+			continue
+		}
+		glog.Infof("Deleting MachineConfig %s, its template overlay %s no longer exists", mc.Name, mc.Annotations[TemplateSourceAnnotationKey])
+		if err := ctrl.client.MachineconfigurationV1().MachineConfigs().Delete(context.TODO(), mc.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if len(renderErrs) == 0 {
+		return nil
+	}
+	names := []string{}
+	for name := range renderErrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := []string{}
+	for _, name := range names {
+		ctrl.eventRecorder.Eventf(cfg, corev1.EventTypeWarning, "TemplateOverlayFailed", "%v", renderErrs[name])
+		msgs = append(msgs, renderErrs[name].Error())
+	}
+	return fmt.Errorf("failed to render %d template overlay(s): %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+func newRenderConfig(config *mcfgv1.ControllerConfig, pullSecretRaw []byte, featureGate *configv1.FeatureGate) (*RenderConfig, error) {
 	buf := &bytes.Buffer{}
 	if err := json.Compact(buf, pullSecretRaw); err != nil {
 		return nil, fmt.Errorf("couldn't compact pullsecret %q: %w", string(pullSecretRaw), err)
 	}
-	rc := &RenderConfig{
+	return &RenderConfig{
 		ControllerConfigSpec: &config.Spec,
 		PullSecret:           string(buf.Bytes()),
 		FeatureGate:          featureGate,
+	}, nil
+}
+
+func getMachineConfigsForControllerConfig(templatesDir string, config *mcfgv1.ControllerConfig, pullSecretRaw []byte, featureGate *configv1.FeatureGate) ([]*mcfgv1.MachineConfig, error) {
+	rc, err := newRenderConfig(config, pullSecretRaw, featureGate)
+	if err != nil {
+		return nil, err
 	}
 	mcs, err := generateTemplateMachineConfigs(rc, templatesDir)
 	if err != nil {
@@ -48,6 +48,7 @@ type fixture struct {
 	ccLister   []*mcfgv1.ControllerConfig
 	mcLister   []*mcfgv1.MachineConfig
 	featLister []*osev1.FeatureGate
+	cmLister   []*corev1.ConfigMap
 
 	kubeactions []core.Action
 	actions     []core.Action
@@ -109,11 +110,12 @@ func (f *fixture) newController() *Controller {
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
 	c := New(templateDir,
 		i.Machineconfiguration().V1().ControllerConfigs(), i.Machineconfiguration().V1().MachineConfigs(), cinformer.Core().V1().Secrets(), featinformer.Config().V1().FeatureGates(),
-		f.kubeclient, f.client)
+		cinformer.Core().V1().ConfigMaps(), f.kubeclient, f.client)
 
 	c.ccListerSynced = alwaysReady
 	c.mcListerSynced = alwaysReady
 	c.featListerSynced = alwaysReady
+	c.cmListerSynced = alwaysReady
 	c.eventRecorder = &record.FakeRecorder{}
 
 	stopCh := make(chan struct{})
@@ -133,6 +135,10 @@ func (f *fixture) newController() *Controller {
 		featinformer.Config().V1().FeatureGates().Informer().GetIndexer().Add(c)
 	}
 
+	for _, c := range f.cmLister {
+		cinformer.Core().V1().ConfigMaps().Informer().GetIndexer().Add(c)
+	}
+
 	return c
 }
 
@@ -287,6 +293,10 @@ func (f *fixture) expectUpdateMachineConfigAction(config *mcfgv1.MachineConfig)
 	f.actions = append(f.actions, core.NewRootUpdateAction(schema.GroupVersionResource{Resource: "machineconfigs"}, config))
 }
 
+func (f *fixture) expectDeleteMachineConfigAction(config *mcfgv1.MachineConfig) {
+	f.actions = append(f.actions, core.NewRootDeleteAction(schema.GroupVersionResource{Resource: "machineconfigs"}, config.Name))
+}
+
 func (f *fixture) expectGetSecretAction(secret *corev1.Secret) {
 	f.kubeactions = append(f.kubeactions, core.NewGetAction(schema.GroupVersionResource{Resource: "secrets"}, secret.Namespace, secret.Name))
 }
@@ -499,6 +509,123 @@ func TestUpdateMachineConfig(t *testing.T) {
 	f.run(getKey(cc, t))
 }
 
+func newTemplateOverlay(name, role string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: TemplateSourceNamespace,
+			Labels: map[string]string{
+				TemplateSourceLabelKey:           "",
+				mcfgv1.MachineConfigRoleLabelKey: role,
+			},
+		},
+		Data: data,
+	}
+}
+
+func TestCreatesMachineConfigsFromTemplateOverlay(t *testing.T) {
+	f := newFixture(t)
+	cc := newControllerConfig("test-cluster")
+	ps := newPullSecret("coreos-pull-secret", []byte(`{"dummy": "dummy"}`))
+	feat := newFeatures("cluster", "CustomNoUpgrade", []string{cloudprovider.ExternalCloudProviderFeature}, nil)
+	f.featLister = append(f.featLister, feat)
+	overlay := newTemplateOverlay("50-worker-dns", "worker", map[string]string{
+		"_base.files.dns.yaml": "mode: 0644\npath: /etc/dns-ip\ncontents:\n  inline: {{.ClusterDNSIP}}\n",
+	})
+	f.cmLister = append(f.cmLister, overlay)
+
+	mcs, err := getMachineConfigsForControllerConfig(templateDir, cc, []byte(`{"dummy": "dummy"}`), feat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := newRenderConfig(cc, []byte(`{"dummy": "dummy"}`), feat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlayMCs, errs := getTemplateOverlayMachineConfigs(cc, rc, []*corev1.ConfigMap{overlay}, nil)
+	if len(errs) > 0 || len(overlayMCs) != 1 {
+		t.Fatalf("expected one overlay MachineConfig, got %d (errors: %v)", len(overlayMCs), errs)
+	}
+
+	f.ccLister = append(f.ccLister, cc)
+	f.objects = append(f.objects, cc)
+	f.kubeobjects = append(f.kubeobjects, ps)
+	f.mcLister = append(f.mcLister, mcs...)
+	for idx := range mcs {
+		f.objects = append(f.objects, mcs[idx])
+	}
+
+	rcc := cc.DeepCopy()
+	rcc.Status.ObservedGeneration = 1
+	rcc.Status.Conditions = []mcfgv1.ControllerConfigStatusCondition{{Type: mcfgv1.TemplateControllerRunning, Status: corev1.ConditionTrue, Message: "syncing towards (1) generation using controller version v0.0.0-was-not-built-properly"}}
+	f.expectUpdateControllerConfigStatus(rcc)
+	f.expectGetSecretAction(ps)
+	for idx := range mcs {
+		f.expectGetMachineConfigAction(mcs[idx])
+	}
+	f.expectGetMachineConfigAction(overlayMCs[0])
+	f.expectCreateMachineConfigAction(overlayMCs[0])
+	ccc := cc.DeepCopy()
+	ccc.Status.ObservedGeneration = 1
+	ccc.Status.Conditions = []mcfgv1.ControllerConfigStatusCondition{
+		{Type: mcfgv1.TemplateControllerCompleted, Status: corev1.ConditionTrue, Message: "sync completed towards (1) generation using controller version v0.0.0-was-not-built-properly"},
+		{Type: mcfgv1.TemplateControllerRunning, Status: corev1.ConditionFalse},
+		{Type: mcfgv1.TemplateControllerFailing, Status: corev1.ConditionFalse},
+	}
+	f.expectUpdateControllerConfigStatus(ccc)
+
+	f.run(getKey(cc, t))
+}
+
+func TestDeletesStaleTemplateOverlayMachineConfig(t *testing.T) {
+	f := newFixture(t)
+	cc := newControllerConfig("test-cluster")
+	ps := newPullSecret("coreos-pull-secret", []byte(`{"dummy": "dummy"}`))
+	feat := newFeatures("cluster", "CustomNoUpgrade", []string{cloudprovider.ExternalCloudProviderFeature}, nil)
+	f.featLister = append(f.featLister, feat)
+
+	mcs, err := getMachineConfigsForControllerConfig(templateDir, cc, []byte(`{"dummy": "dummy"}`), feat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := ctrlcommon.MachineConfigFromIgnConfig("worker", "50-worker-dns", ctrlcommon.NewIgnConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Annotations = map[string]string{TemplateSourceAnnotationKey: TemplateSourceNamespace + "/50-worker-dns"}
+	stale.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(cc, controllerKind)})
+
+	f.ccLister = append(f.ccLister, cc)
+	f.objects = append(f.objects, cc, stale)
+	f.kubeobjects = append(f.kubeobjects, ps)
+	f.mcLister = append(f.mcLister, stale)
+	f.mcLister = append(f.mcLister, mcs...)
+	for idx := range mcs {
+		f.objects = append(f.objects, mcs[idx])
+	}
+
+	rcc := cc.DeepCopy()
+	rcc.Status.ObservedGeneration = 1
+	rcc.Status.Conditions = []mcfgv1.ControllerConfigStatusCondition{{Type: mcfgv1.TemplateControllerRunning, Status: corev1.ConditionTrue, Message: "syncing towards (1) generation using controller version v0.0.0-was-not-built-properly"}}
+	f.expectUpdateControllerConfigStatus(rcc)
+	f.expectGetSecretAction(ps)
+	for idx := range mcs {
+		f.expectGetMachineConfigAction(mcs[idx])
+	}
+	f.expectDeleteMachineConfigAction(stale)
+	ccc := cc.DeepCopy()
+	ccc.Status.ObservedGeneration = 1
+	ccc.Status.Conditions = []mcfgv1.ControllerConfigStatusCondition{
+		{Type: mcfgv1.TemplateControllerCompleted, Status: corev1.ConditionTrue, Message: "sync completed towards (1) generation using controller version v0.0.0-was-not-built-properly"},
+		{Type: mcfgv1.TemplateControllerRunning, Status: corev1.ConditionFalse},
+		{Type: mcfgv1.TemplateControllerFailing, Status: corev1.ConditionFalse},
+	}
+	f.expectUpdateControllerConfigStatus(ccc)
+
+	f.run(getKey(cc, t))
+}
+
 func getKey(config *mcfgv1.ControllerConfig, t *testing.T) string {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(config)
 	if err != nil {
@@ -40,6 +40,44 @@ const (
 	MachineConfigDaemonStateDegraded = "Degraded"
 	// MachineConfigDaemonStateUnreconcilable is set by the daemon when a MachineConfig cannot be applied.
 	MachineConfigDaemonStateUnreconcilable = "Unreconcilable"
+	// UpdateHistoryAnnotationKey is set by the daemon to the JSON encoded history of the most recent updates of the node.
+	UpdateHistoryAnnotationKey = "machineconfiguration.openshift.io/updateHistory"
+	// UpdatePhaseAnnotationKey is set by the daemon to the phase of the update it is applying, one of the UpdatePhase values.
+	UpdatePhaseAnnotationKey = "machineconfiguration.openshift.io/updatePhase"
+	// UpdatePhaseTimesAnnotationKey is set by the daemon to a JSON object mapping each phase of the current update to when it was reached.
+	UpdatePhaseTimesAnnotationKey = "machineconfiguration.openshift.io/updatePhaseTimes"
+	// UpdatePhaseOSUpdateStaged is set once rpm-ostree staged the OS, kernel argument and extension changes.
+	// It is normally reached before the node is drained.
+	UpdatePhaseOSUpdateStaged = "OSUpdateStaged"
+	// UpdatePhaseDrained is set once the node was drained for the update.
+	UpdatePhaseDrained = "Drained"
+	// UpdatePhaseFilesWritten is set once files, units and SSH keys were written.
+	UpdatePhaseFilesWritten = "FilesWritten"
+	// UpdatePhaseRebooting is set right before the node reboots into the new config.
+	UpdatePhaseRebooting = "Rebooting"
+	// UpdatePhaseValidating is set while the daemon validates the node against the new config.
+	UpdatePhaseValidating = "Validating"
+	// UpdatePhaseDone is set once the node runs the new config.
+	UpdatePhaseDone = "Done"
+	// KernelArgumentTuningAnnotationKey is set by admins to a JSON object with the kernelArguments to add to, and the
+	// kernelArgumentsToDelete from, a single node. Only the arguments allowed by the kernelArgumentTuning of its pool apply.
+	KernelArgumentTuningAnnotationKey = "machineconfiguration.openshift.io/kernelArgumentTuning"
+	// KernelArgumentTuningStatusAnnotationKey is set by the daemon to the JSON encoded status of the kernel argument tuning of the node.
+	KernelArgumentTuningStatusAnnotationKey = "machineconfiguration.openshift.io/kernelArgumentTuningStatus"
+	// KernelArgumentTuningPendingAnnotationKey is set by the daemon to the rpm-ostree kargs arguments the kernel argument
+	// tuning of the node needs a reboot for, or to the empty string when it needs none.
+	KernelArgumentTuningPendingAnnotationKey = "machineconfiguration.openshift.io/kernelArgumentTuningPending"
+	// KernelArgumentTuningApprovedAnnotationKey is set by the node controller to the pending kernel argument tuning of the
+	// node once it may drain and reboot the node for it, within the maxUnavailable of its pool and the update budget.
+	KernelArgumentTuningApprovedAnnotationKey = "machineconfiguration.openshift.io/kernelArgumentTuningApproved"
+	// IOTuningStatusAnnotationKey is set by the daemon to the JSON encoded I/O tuning state of the node: the scheduler and
+	// queue depth of its root device, and the fsync settings of its ostree repository.
+	IOTuningStatusAnnotationKey = "machineconfiguration.openshift.io/ioTuningStatus"
+	// AccessLogAnnotationKey is set by the daemon to the JSON encoded log of the most recent login sessions on the node.
+	AccessLogAnnotationKey = "machineconfiguration.openshift.io/accessLog"
+	// ReprovisionRequestedAnnotationKey is set by the daemon, under the Reprovision interactive access policy, to the time
+	// of the first login session after which the node should be re-provisioned.
+	ReprovisionRequestedAnnotationKey = "machineconfiguration.openshift.io/reprovisionRequested"
 	// MachineConfigDaemonReasonAnnotationKey is set by the daemon when it needs to report a human readable reason for its state. E.g. when state flips to degraded/unreconcilable.
 	MachineConfigDaemonReasonAnnotationKey = "machineconfiguration.openshift.io/reason"
 	// InitialNodeAnnotationsFilePath defines the path at which it will find the node annotations it needs to set on the node once it comes up for the first time.
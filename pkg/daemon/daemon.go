@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -82,6 +81,8 @@ type Daemon struct {
 
 	updateActive     bool
 	updateActiveLock sync.Mutex
+	// accessLogLock serializes updates of the access log
+	accessLogLock sync.Mutex
 
 	nodeWriter NodeWriter
 
@@ -110,6 +111,18 @@ type Daemon struct {
 	booting bool
 
 	currentConfigPath string
+	updateJournalPath string
+	updateHistoryPath string
+	accessLogPath     string
+	kernelTuningFile  string
+
+	// offlineOSImagesPath records the OS images rebased to from offline bundles
+	offlineOSImagesPath string
+	// offlineBundle is the offline bundle being applied, if any
+	offlineBundle *OfflineBundle
+
+	// updatePhaseTimes records when the running update reached each phase
+	updatePhaseTimes map[string]metav1.Time
 
 	loggerSupportsJournal bool
 
@@ -232,7 +245,25 @@ func New(
 		if err != nil {
 			return nil, fmt.Errorf("error reading osImageURL from rpm-ostree: %w", err)
 		}
+		osImageURL, err = resolveOfflineOSImageURL(OfflineOSImagesPath, osImageURL)
+		if err != nil {
+			return nil, fmt.Errorf("error reading osImageURL of bundled OS image: %w", err)
+		}
 		glog.Infof("Booted osImageURL: %s (%s)", osImageURL, osVersion)
+	} else if !mock {
+		// Package-based hosts, e.g. RHEL workers, install the packages
+		// their pool maps the OS image to with dnf, if they have it
+		dnfClient := NewDnfClient()
+		if err := dnfClient.Initialize(); err != nil {
+			glog.Infof("Not updating packages of this host: %v", err)
+		} else {
+			nodeUpdaterClient = dnfClient
+			osImageURL, _, err = dnfClient.GetBootedOSImageURL()
+			if err != nil {
+				return nil, fmt.Errorf("error reading installed package set: %w", err)
+			}
+			glog.Infof("Installed package set of osImageURL: %q", osImageURL)
+		}
 	}
 
 	bootID := ""
@@ -267,6 +298,11 @@ func New(
 		bootID:                bootID,
 		exitCh:                exitCh,
 		currentConfigPath:     currentConfigPath,
+		updateJournalPath:     updateJournalPath,
+		updateHistoryPath:     UpdateHistoryPath,
+		accessLogPath:         AccessLogPath,
+		kernelTuningFile:      KernelTuningFile,
+		offlineOSImagesPath:   OfflineOSImagesPath,
 		loggerSupportsJournal: loggerSupportsJournal,
 		configDriftMonitor:    NewConfigDriftMonitor(),
 	}, nil
@@ -442,13 +478,22 @@ func (dn *Daemon) initializeNode() error {
 	// Some parts of the MCO dispatch on whether or not we're managing a control plane node
 	if _, isControlPlane := dn.node.Labels[ctrlcommon.MasterLabel]; isControlPlane {
 		glog.Infof("Node %s is part of the control plane", dn.node.Name)
-		if err := dn.initializeControlPlane(); err != nil {
-			return err
-		}
 		dn.isControlPlane = true
 	} else {
 		glog.Infof("Node %s is not labeled %s", dn.node.Name, ctrlcommon.MasterLabel)
 	}
+	// The I/O tuning of the current config; while bootstrapping, the node
+	// has no current config yet and gets the default tuning.
+	var currentConfig *mcfgv1.MachineConfig
+	if currentConfigName, ok := dn.node.Annotations[constants.CurrentMachineConfigAnnotationKey]; ok {
+		var err error
+		if currentConfig, err = dn.mcLister.Get(currentConfigName); err != nil {
+			return err
+		}
+	}
+	if err := dn.applyIOTuning(currentConfig, false); err != nil {
+		return err
+	}
 	dn.nodeInitialized = true
 	return nil
 }
@@ -551,6 +596,8 @@ func (dn *Daemon) syncNode(key string) error {
 		if err := dn.triggerUpdateWithMachineConfig(current, desired); err != nil {
 			return err
 		}
+	} else if err := dn.reconcileKernelArgumentTuning(); err != nil {
+		return fmt.Errorf("tuning kernel arguments: %w", err)
 	}
 	glog.V(2).Infof("Node %s is already synced", node.Name)
 	return nil
@@ -594,28 +641,6 @@ func (dn *Daemon) enqueueDefault(node *corev1.Node) {
 	dn.queue.AddRateLimited(key)
 }
 
-const (
-	logindUnit = "systemd-logind.service"
-	// IDs are taken from https://cgit.freedesktop.org/systemd/systemd/plain/src/systemd/sd-messages.h
-	sdMessageSessionStart = "8d45620c1a4348dbb17410da57c60c66"
-)
-
-// detectEarlySSHAccessesFromBoot annotates the node if we find a login before the daemon started up.
-func (dn *Daemon) detectEarlySSHAccessesFromBoot() error {
-	journalOutput, err := exec.Command("journalctl", "-b", "-o", "cat", "-u", logindUnit, "MESSAGE_ID="+sdMessageSessionStart).CombinedOutput()
-	if err != nil {
-		return err
-	}
-	if len(journalOutput) > 0 {
-		glog.Info("Detected a login session before the daemon took over on first boot")
-		glog.Infof("Applying annotation: %v", machineConfigDaemonSSHAccessAnnotationKey)
-		if err := dn.applySSHAccessedAnnotation(); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 // RunHypershift is the entry point for the simplified Hypershift mode daemon
 func (dn *Daemon) RunHypershift(stopCh <-chan struct{}, exitCh <-chan error) error {
 	glog.Info("Starting MachineConfigDaemon - Hypershift")
@@ -853,9 +878,14 @@ func (dn *Daemon) syncNodeHypershift(key string) error {
 	return nil
 }
 
-// RunOnceFrom is the primary entrypoint for the non-cluster case
-func (dn *Daemon) RunOnceFrom(onceFrom string, skipReboot bool) error {
+// RunOnceFrom is the primary entrypoint for the non-cluster case.
+// allowUnsignedBundle applies offline bundles whose config requires signed OS images.
+func (dn *Daemon) RunOnceFrom(onceFrom string, skipReboot, allowUnsignedBundle bool) error {
 	dn.skipReboot = skipReboot
+	if IsOfflineBundle(onceFrom) {
+		glog.V(2).Info("Daemon running directly from offline bundle")
+		return dn.runOnceFromBundle(onceFrom, allowUnsignedBundle)
+	}
 	configi, contentFrom, err := dn.senseAndLoadOnceFrom(onceFrom)
 	if err != nil {
 		glog.Warningf("Unable to decipher onceFrom config type: %s", err)
@@ -986,56 +1016,6 @@ func (dn *Daemon) Run(stopCh <-chan struct{}, exitCh <-chan error) error {
 	}
 }
 
-func (dn *Daemon) runLoginMonitor(stopCh <-chan struct{}, exitCh chan<- error) {
-	cmd := exec.Command("journalctl", "-b", "-f", "-o", "cat", "-u", logindUnit, "MESSAGE_ID="+sdMessageSessionStart)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		exitCh <- err
-		return
-	}
-	if err := cmd.Start(); err != nil {
-		exitCh <- err
-		return
-	}
-	worker := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case <-worker:
-				return
-			default:
-				buf := make([]byte, 1024)
-				l, err := stdout.Read(buf)
-				if err != nil {
-					if err == io.EOF {
-						return
-					}
-					exitCh <- err
-					return
-				}
-				if l > 0 {
-					line := strings.Split(string(buf), "\n")[0]
-					glog.Infof("Detected a new login session: %s", line)
-					glog.Infof("Login access is discouraged! Applying annotation: %v", machineConfigDaemonSSHAccessAnnotationKey)
-					if err := dn.applySSHAccessedAnnotation(); err != nil {
-						exitCh <- err
-					}
-				}
-			}
-		}
-	}()
-	<-stopCh
-	close(worker)
-	cmd.Process.Kill()
-}
-
-func (dn *Daemon) applySSHAccessedAnnotation() error {
-	if err := dn.nodeWriter.SetSSHAccessed(); err != nil {
-		return fmt.Errorf("error: cannot apply annotation for SSH access due to: %w", err)
-	}
-	return nil
-}
-
 // Called whenever the on-disk config has drifted from the current machineconfig.
 func (dn *Daemon) onConfigDrift(err error) {
 	dn.nodeWriter.Eventf(corev1.EventTypeWarning, "ConfigDriftDetected", err.Error())
@@ -1332,6 +1312,26 @@ func (dn *Daemon) getPendingConfig() (*pendingConfigState, error) {
 	return &p, nil
 }
 
+// getPendingConfigNameAndBootID returns the name of the config we're rebooting
+// into and the boot ID we were on when we started to, or empty strings if
+// there is none.
+func (dn *Daemon) getPendingConfigNameAndBootID() (string, string, error) {
+	pendingState, err := dn.getPendingState()
+	if err != nil {
+		return "", "", err
+	}
+	if pendingState != nil {
+		return pendingState.Message, pendingState.BootID, nil
+	}
+	// XXX: drop this
+	// we need this compatibility layer for now
+	legacyPendingState, err := dn.getPendingConfig()
+	if err != nil || legacyPendingState == nil {
+		return "", "", err
+	}
+	return legacyPendingState.PendingConfig, legacyPendingState.BootID, nil
+}
+
 // getCurrentConfigOnDisk retrieves the serialized MachineConfig written to /etc
 // which exists during the time we're trying to perform an update.
 func (dn *Daemon) getCurrentConfigOnDisk() (*mcfgv1.MachineConfig, error) {
@@ -1402,27 +1402,16 @@ func (dn *Daemon) checkStateOnFirstRun() error {
 	// Update our cached copy
 	dn.node = node
 
-	pendingState, err := dn.getPendingState()
+	// Finish or revert an update of files and units that was interrupted
+	// before anything else looks at the on-disk state.
+	if err := dn.recoverUpdateJournal(); err != nil {
+		return fmt.Errorf("recovering interrupted update: %w", err)
+	}
+
+	pendingConfigName, bootID, err := dn.getPendingConfigNameAndBootID()
 	if err != nil {
 		return err
 	}
-	var pendingConfigName, bootID string
-	if pendingState != nil {
-		pendingConfigName = pendingState.Message
-		bootID = pendingState.BootID
-	}
-	// XXX: drop this
-	// we need this compatibility layer for now
-	if pendingState == nil {
-		legacyPendingState, err := dn.getPendingConfig()
-		if err != nil {
-			return err
-		}
-		if legacyPendingState != nil {
-			pendingConfigName = legacyPendingState.PendingConfig
-			bootID = legacyPendingState.BootID
-		}
-	}
 
 	state, err := dn.getStateAndConfigs(pendingConfigName)
 	if err != nil {
@@ -1526,6 +1515,7 @@ func (dn *Daemon) checkStateOnFirstRun() error {
 	var expectedConfig *mcfgv1.MachineConfig
 	if state.pendingConfig != nil {
 		glog.Infof("Validating against pending config %s", state.pendingConfig.GetName())
+		dn.setUpdatePhase(constants.UpdatePhaseValidating)
 		expectedConfig = state.pendingConfig
 	} else {
 		glog.Infof("Validating against current config %s", state.currentConfig.GetName())
@@ -1576,6 +1566,8 @@ func (dn *Daemon) updateConfigAndState(state *stateAndConfigs) (bool, error) {
 		if out, err := dn.storePendingState(state.pendingConfig, 0); err != nil {
 			return true, fmt.Errorf("failed to reset pending config: %s: %w", string(out), err)
 		}
+		dn.finishUpdateHistory(state.pendingConfig.GetName(), UpdateOutcomeSucceeded, "")
+		dn.setUpdatePhase(constants.UpdatePhaseDone)
 
 		state.currentConfig = state.pendingConfig
 	}
@@ -1808,13 +1800,25 @@ func (dn *CoreOSDaemon) validateKernelArguments(currentConfig *mcfgv1.MachineCon
 	for _, arg := range foundArgsArray {
 		foundArgs[arg] = true
 	}
-	expected := parseKernelArguments(currentConfig.Spec.KernelArguments)
+	expected := ctrlcommon.ParseKernelArguments(currentConfig.Spec.KernelArguments)
 	missing := []string{}
 	for _, karg := range expected {
 		if _, ok := foundArgs[karg]; !ok {
 			missing = append(missing, karg)
 		}
 	}
+	unexpected := []string{}
+	for _, del := range currentConfig.Spec.KernelArgumentsToDelete {
+		for _, karg := range foundArgsArray {
+			if ctrlcommon.KernelArgumentMatchesDeletion(karg, del) {
+				unexpected = append(unexpected, karg)
+			}
+		}
+	}
+	if len(unexpected) > 0 {
+		glog.Infof("Current ostree kargs: %s", rpmostreeKargs)
+		return fmt.Errorf("Unexpected kernel arguments that should have been deleted: %v", unexpected)
+	}
 	if len(missing) > 0 {
 		cmdlinebytes, err := ioutil.ReadFile(CmdLineFile)
 		if err != nil {
@@ -0,0 +1,312 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	// PackageOSStatePath is where the daemon of a package-based host records the
+	// package set it installed
+	PackageOSStatePath = "/etc/machine-config-daemon/package-os-state.json"
+
+	// packageOSRepoFile holds the repositories of the installed package set
+	packageOSRepoFile = "/etc/yum.repos.d/machine-config-daemon.repo"
+
+	// packageOSRepoPrefix is prepended to the names of the repositories of package sets
+	packageOSRepoPrefix = "mco-"
+)
+
+// errNotOSTree is returned by the rpm-ostree specific methods of the DnfClient.
+var errNotOSTree = errors.New("package-based hosts install package sets rather than OS images")
+
+// PackageTransaction is a change of the packages of a package-based host.
+type PackageTransaction struct {
+	// PackageSet is the package set installed once the transaction completes
+	PackageSet *mcfgv1.PackageSet
+	// Sync synchronizes the installed packages with the repositories of PackageSet
+	Sync    bool
+	Install []string
+	Remove  []string
+	// DefaultKernelPackage is the package whose newest kernel becomes the default boot entry
+	DefaultKernelPackage string
+}
+
+// IsEmpty is true if the transaction changes nothing.
+func (t *PackageTransaction) IsEmpty() bool {
+	return !t.Sync && len(t.Install) == 0 && len(t.Remove) == 0 && t.DefaultKernelPackage == ""
+}
+
+// PackageUpdaterClient is a NodeUpdaterClient for package-based hosts, e.g. RHEL
+// workers, which installs package sets with dnf instead of rebasing to OS images.
+type PackageUpdaterClient interface {
+	NodeUpdaterClient
+	// GetInstalledPackageSet returns the package set the last transaction installed, or nil
+	GetInstalledPackageSet() (*mcfgv1.PackageSet, error)
+	// GetLastTransaction returns the ID of the last dnf transaction of the host
+	GetLastTransaction() (int, error)
+	// Apply runs a transaction
+	Apply(PackageTransaction) error
+	// Rollback undoes the dnf transactions after the one with the given ID
+	Rollback(int) error
+}
+
+// DnfClient is the PackageUpdaterClient of hosts with dnf.
+type DnfClient struct {
+	statePath string
+	repoFile  string
+}
+
+// NewDnfClient returns a new DnfClient.
+func NewDnfClient() *DnfClient {
+	return &DnfClient{
+		statePath: PackageOSStatePath,
+		repoFile:  packageOSRepoFile,
+	}
+}
+
+// packageOSState is the state of a package-based host the DnfClient records.
+type packageOSState struct {
+	PackageSet *mcfgv1.PackageSet `json:"packageSet,omitempty"`
+}
+
+func runDnf(args ...string) error {
+	return runCmdSync("dnf", append([]string{"-y"}, args...)...)
+}
+
+// Initialize checks that the host has dnf.
+func (c *DnfClient) Initialize() error {
+	if _, err := exec.LookPath("dnf"); err != nil {
+		return fmt.Errorf("dnf not found: %w", err)
+	}
+	return nil
+}
+
+// GetStatus returns the installed package set and the last dnf transaction.
+func (c *DnfClient) GetStatus() (string, error) {
+	set, err := c.GetInstalledPackageSet()
+	if err != nil {
+		return "", err
+	}
+	status := "No package set installed\n"
+	if set != nil {
+		status = fmt.Sprintf("Package set of %s installed\n", set.OSImageURL)
+	}
+	out, err := runGetOut("dnf", "history", "info", "last")
+	if err != nil {
+		return "", err
+	}
+	return status + string(out), nil
+}
+
+// GetBootedOSImageURL returns the OS image whose package set is installed, if any.
+func (c *DnfClient) GetBootedOSImageURL() (string, string, error) {
+	set, err := c.GetInstalledPackageSet()
+	if err != nil || set == nil {
+		return "", "", err
+	}
+	return set.OSImageURL, "", nil
+}
+
+// Rebase is not supported on package-based hosts.
+func (c *DnfClient) Rebase(string, string) (bool, error) {
+	return false, errNotOSTree
+}
+
+// RebaseLayered is not supported on package-based hosts.
+func (c *DnfClient) RebaseLayered(string) error {
+	return errNotOSTree
+}
+
+// IsBootableImage is false as package-based hosts don't boot OS images.
+func (c *DnfClient) IsBootableImage(string) (bool, error) {
+	return false, nil
+}
+
+// GetBootedAndStagedDeployment is not supported on package-based hosts.
+func (c *DnfClient) GetBootedAndStagedDeployment() (*RpmOstreeDeployment, *RpmOstreeDeployment, error) {
+	return nil, nil, errNotOSTree
+}
+
+// GetInstalledPackageSet returns the package set the last transaction installed, or nil.
+func (c *DnfClient) GetInstalledPackageSet() (*mcfgv1.PackageSet, error) {
+	raw, err := ioutil.ReadFile(c.statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := packageOSState{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", c.statePath, err)
+	}
+	return state.PackageSet, nil
+}
+
+// GetLastTransaction returns the ID of the last dnf transaction of the host, or 0 if there is none.
+func (c *DnfClient) GetLastTransaction() (int, error) {
+	out, err := runGetOut("dnf", "history", "list")
+	if err != nil {
+		return 0, err
+	}
+	return parseDnfHistoryList(out)
+}
+
+// parseDnfHistoryList returns the ID of the latest transaction in `dnf history list`
+// output, or 0 if it lists none.
+func parseDnfHistoryList(out []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 2 {
+			continue
+		}
+		id := strings.TrimSpace(fields[0])
+		if id == "ID" {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, fmt.Errorf("parsing dnf transaction ID %q: %w", id, err)
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
+
+// renderPackageRepos returns the dnf repository file of repos.
+func renderPackageRepos(repos []mcfgv1.PackageRepo) []byte {
+	var buf bytes.Buffer
+	for _, repo := range repos {
+		fmt.Fprintf(&buf, "[%s%s]\nname=%s\nbaseurl=%s\nenabled=1\ngpgcheck=1\n", packageOSRepoPrefix, repo.Name, repo.Name, repo.BaseURL)
+		if repo.GPGKeyURL != "" {
+			fmt.Fprintf(&buf, "gpgkey=%s\n", repo.GPGKeyURL)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// packageRepoArgs returns the dnf arguments that restrict a transaction to repos, if any.
+func packageRepoArgs(repos []mcfgv1.PackageRepo) []string {
+	if len(repos) == 0 {
+		return nil
+	}
+	args := []string{"--disablerepo=*"}
+	for _, repo := range repos {
+		args = append(args, "--enablerepo="+packageOSRepoPrefix+repo.Name)
+	}
+	return args
+}
+
+// newestInstalledVersion returns the version-release.arch of the most recently
+// installed package in `rpm -q --qf '%{INSTALLTIME} %{VERSION}-%{RELEASE}.%{ARCH}\n'` output.
+func newestInstalledVersion(out []byte) (string, error) {
+	var newest string
+	var newestTime int64 = -1
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		t, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parsing install time %q: %w", fields[0], err)
+		}
+		if t > newestTime {
+			newest, newestTime = fields[1], t
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no installed package found")
+	}
+	return newest, nil
+}
+
+// setDefaultKernel makes the newest kernel of pkg the default boot entry.
+func setDefaultKernel(pkg string) error {
+	out, err := runGetOut("rpm", "-q", "--qf", "%{INSTALLTIME} %{VERSION}-%{RELEASE}.%{ARCH}\n", pkg)
+	if err != nil {
+		return err
+	}
+	version, err := newestInstalledVersion(out)
+	if err != nil {
+		return fmt.Errorf("finding kernel of %s: %w", pkg, err)
+	}
+	return runCmdSync("grubby", "--set-default=/boot/vmlinuz-"+version)
+}
+
+// Apply configures the repositories of the package set of t, runs its dnf
+// transactions and records the package set as installed.
+func (c *DnfClient) Apply(t PackageTransaction) error {
+	var repos []mcfgv1.PackageRepo
+	if t.PackageSet != nil {
+		repos = t.PackageSet.Repos
+	}
+	if len(repos) == 0 {
+		if err := os.Remove(c.repoFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := writeFileAtomicallyWithDefaults(c.repoFile, renderPackageRepos(repos)); err != nil {
+		return err
+	}
+
+	repoArgs := packageRepoArgs(repos)
+	if t.Sync {
+		if err := runDnf(append(repoArgs, "distro-sync")...); err != nil {
+			return err
+		}
+	}
+	if len(t.Install) > 0 {
+		if err := runDnf(append(append(repoArgs, "install"), t.Install...)...); err != nil {
+			return err
+		}
+	}
+	if len(t.Remove) > 0 {
+		// The kernel being removed is usually the running one; the node
+		// reboots into the new one once the update completes.
+		if err := runDnf(append([]string{"--setopt=protect_running_kernel=False", "remove"}, t.Remove...)...); err != nil {
+			return err
+		}
+	}
+	if t.DefaultKernelPackage != "" {
+		if err := setDefaultKernel(t.DefaultKernelPackage); err != nil {
+			return err
+		}
+	}
+
+	raw, err := json.Marshal(packageOSState{PackageSet: t.PackageSet})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicallyWithDefaults(c.statePath, raw)
+}
+
+// Rollback undoes the dnf transactions after the one with ID id.
+func (c *DnfClient) Rollback(id int) error {
+	last, err := c.GetLastTransaction()
+	if err != nil {
+		return err
+	}
+	if last == id {
+		return nil
+	}
+	if id == 0 {
+		return fmt.Errorf("cannot roll back to before the first dnf transaction")
+	}
+	glog.Infof("Rolling back dnf transactions %d to %d", id+1, last)
+	return runDnf("history", "rollback", strconv.Itoa(id))
+}
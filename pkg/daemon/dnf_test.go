@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func TestParseDnfHistoryList(t *testing.T) {
+	out := `ID     | Command line                                      | Date and time    | Action(s)      | Altered
+-------------------------------------------------------------------------------------------------------------
+    14 | -y install kernel-rt-core kernel-rt-modules       | 2022-11-02 10:14 | Install        |    4
+    13 | -y distro-sync                                    | 2022-11-01 09:03 | Upgrade        |   37 EE
+`
+	id, err := parseDnfHistoryList([]byte(out))
+	require.NoError(t, err)
+	assert.Equal(t, 14, id)
+
+	id, err = parseDnfHistoryList([]byte("No transactions\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, id)
+}
+
+func TestNewestInstalledVersion(t *testing.T) {
+	out := "1667300000 4.18.0-372.26.1.el8_6.x86_64\n1667400000 4.18.0-372.32.1.el8_6.x86_64\n1667200000 4.18.0-372.19.1.el8_6.x86_64\n"
+	version, err := newestInstalledVersion([]byte(out))
+	require.NoError(t, err)
+	assert.Equal(t, "4.18.0-372.32.1.el8_6.x86_64", version)
+
+	_, err = newestInstalledVersion([]byte("package kernel-rt-core is not installed\n"))
+	assert.Error(t, err)
+}
+
+func TestPackageRepos(t *testing.T) {
+	repos := []mcfgv1.PackageRepo{
+		{Name: "baseos", BaseURL: "https://mirror.example.com/8.6/baseos"},
+		{Name: "rt", BaseURL: "https://mirror.example.com/8.6/rt", GPGKeyURL: "https://mirror.example.com/RPM-GPG-KEY"},
+	}
+	assert.Equal(t, `[mco-baseos]
+name=baseos
+baseurl=https://mirror.example.com/8.6/baseos
+enabled=1
+gpgcheck=1
+
+[mco-rt]
+name=rt
+baseurl=https://mirror.example.com/8.6/rt
+enabled=1
+gpgcheck=1
+gpgkey=https://mirror.example.com/RPM-GPG-KEY
+
+`, string(renderPackageRepos(repos)))
+	assert.Equal(t, []string{"--disablerepo=*", "--enablerepo=mco-baseos", "--enablerepo=mco-rt"}, packageRepoArgs(repos))
+	assert.Nil(t, packageRepoArgs(nil))
+}
@@ -0,0 +1,100 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+)
+
+// osDefaultFileDir holds the pristine /etc shipped with the OS image, i.e. what
+// /etc looked like before Ignition ran
+var osDefaultFileDir = "/usr"
+
+// osDefaultFilePath returns where the OS keeps the default of a file in /etc,
+// or an empty string for files outside /etc.
+func osDefaultFilePath(fpath string) string {
+	if !strings.HasPrefix(fpath, "/etc/") {
+		return ""
+	}
+	return filepath.Join(osDefaultFileDir, fpath)
+}
+
+// appendBaseContents returns the contents append fragments are added to when a
+// file doesn't set its own contents. This is the original file the MCD backed
+// up before it first wrote the path, or the OS default if the MCD never wrote
+// it (e.g. Ignition appended on first boot), or empty if neither exists.
+func appendBaseContents(fpath string) ([]byte, error) {
+	if _, err := os.Stat(noOrigFileStampName(fpath)); err == nil {
+		return []byte{}, nil
+	}
+	base := origFileName(fpath)
+	if _, err := os.Lstat(base); err != nil {
+		base = osDefaultFilePath(fpath)
+	}
+	if base == "" {
+		return []byte{}, nil
+	}
+	contents, err := ioutil.ReadFile(base)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading base of appended file %q: %w", fpath, err)
+	}
+	return contents, nil
+}
+
+// createAppendOrigFile records the base of a file the MCD is about to write
+// with append fragments. Unlike createOrigFile it must not back up the file on
+// disk, which may already contain the fragments appended on first boot.
+func createAppendOrigFile(fpath string) error {
+	if _, err := os.Stat(noOrigFileStampName(fpath)); err == nil {
+		return nil
+	}
+	if _, err := os.Lstat(origFileName(fpath)); err == nil {
+		return nil
+	}
+	if osDefault := osDefaultFilePath(fpath); osDefault != "" {
+		if _, err := os.Stat(osDefault); err == nil {
+			return createOrigFile(osDefault, fpath)
+		}
+	}
+	// Nothing to go back to, the file consists of the appended fragments only
+	if err := os.MkdirAll(filepath.Dir(noOrigFileStampName(fpath)), 0o755); err != nil {
+		return fmt.Errorf("creating no orig parent dir: %w", err)
+	}
+	return writeFileAtomicallyWithDefaults(noOrigFileStampName(fpath), nil)
+}
+
+// resolveFileContents returns what a file of a config has to contain on disk.
+// Append fragments are applied to the file's own contents if it sets any,
+// and to its base contents otherwise, so the result is the same no matter how
+// often the config is applied.
+func resolveFileContents(file ign3types.File) ([]byte, error) {
+	if len(file.Append) == 0 {
+		return decodeIgnitionFileContents(file.Contents)
+	}
+
+	var contents []byte
+	var err error
+	if file.Contents.Source != nil {
+		contents, err = decodeIgnitionFileContents(file.Contents)
+	} else {
+		contents, err = appendBaseContents(file.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, fragment := range file.Append {
+		decoded, err := decodeIgnitionFileContents(fragment)
+		if err != nil {
+			return nil, fmt.Errorf("append fragment %d: %w", i, err)
+		}
+		contents = append(contents, decoded...)
+	}
+	return contents, nil
+}
@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestWriteFilesAppend(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	d := newMockDaemon()
+
+	// use current user so test doesn't try to chown to root
+	currentUser, err := user.Current()
+	require.Nil(t, err)
+	currentUID, err := strconv.Atoi(currentUser.Uid)
+	require.Nil(t, err)
+	currentGID, err := strconv.Atoi(currentUser.Gid)
+	require.Nil(t, err)
+
+	fragments := []ign3types.Resource{
+		{Source: helpers.StrToPtr(dataurl.EncodeBytes([]byte("10.0.0.1 foo\n")))},
+		{Source: helpers.StrToPtr(dataurl.EncodeBytes([]byte("10.0.0.2 bar\n")))},
+	}
+	mode := 420
+	newFile := func(path string, contents *string) ign3types.File {
+		return ign3types.File{
+			Node: ign3types.Node{
+				Path:  path,
+				User:  ign3types.NodeUser{ID: &currentUID},
+				Group: ign3types.NodeGroup{ID: &currentGID},
+			},
+			FileEmbedded1: ign3types.FileEmbedded1{
+				Mode:     &mode,
+				Contents: ign3types.Resource{Source: contents},
+				Append:   fragments,
+			},
+		}
+	}
+
+	// a file backed up before the MCD first wrote it is the base of its fragments
+	existing := filepath.Join(testDir, "hosts")
+	require.Nil(t, ioutil.WriteFile(existing, []byte("127.0.0.1 localhost\n"), 0o644))
+	require.Nil(t, createOrigFile(existing, existing))
+	// a file that didn't exist consists of its fragments only
+	created := filepath.Join(testDir, "sudoers")
+	// a file with its own contents has the fragments appended to those
+	overwritten := filepath.Join(testDir, "overwritten")
+	require.Nil(t, ioutil.WriteFile(overwritten, []byte("dropped\n"), 0o644))
+
+	files := []ign3types.File{
+		newFile(existing, nil),
+		newFile(created, nil),
+		newFile(overwritten, helpers.StrToPtr(dataurl.EncodeBytes([]byte("base\n")))),
+	}
+	expected := map[string]string{
+		existing:    "127.0.0.1 localhost\n10.0.0.1 foo\n10.0.0.2 bar\n",
+		created:     "10.0.0.1 foo\n10.0.0.2 bar\n",
+		overwritten: "base\n10.0.0.1 foo\n10.0.0.2 bar\n",
+	}
+
+	// writing the same config repeatedly must not duplicate the fragments
+	for i := 0; i < 2; i++ {
+		require.Nil(t, d.writeFiles(files))
+		for path, contents := range expected {
+			actual, err := ioutil.ReadFile(path)
+			require.Nil(t, err)
+			assert.Equal(t, contents, string(actual), path)
+		}
+		assert.Nil(t, checkV3Files(files))
+	}
+
+	// dropping a fragment re-derives the file from its base
+	files[0].Append = fragments[1:]
+	require.Nil(t, d.writeFiles(files[:1]))
+	actual, err := ioutil.ReadFile(existing)
+	require.Nil(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n10.0.0.2 bar\n", string(actual))
+}
+
+func TestReconcilableAppend(t *testing.T) {
+	oldConfig := helpers.NewMachineConfig("old", nil, "", nil)
+	file := ign3types.File{
+		Node: ign3types.Node{Path: "/etc/hosts"},
+		FileEmbedded1: ign3types.FileEmbedded1{
+			Append: []ign3types.Resource{{Source: helpers.StrToPtr(dataurl.EncodeBytes([]byte("10.0.0.1 foo\n")))}},
+		},
+	}
+	newConfig := helpers.NewMachineConfig("new", nil, "", []ign3types.File{file})
+
+	diff, err := reconcilable(oldConfig, newConfig)
+	require.Nil(t, err)
+	assert.True(t, diff.files)
+}
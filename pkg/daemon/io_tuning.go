@@ -0,0 +1,275 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// ostreeRepo is the ostree repository of the host
+const ostreeRepo = "/sysroot/ostree/repo"
+
+// IOTuningStatus is the I/O tuning of a node as the daemon found it after
+// applying the tuning of its pool, published in the IOTuningStatusAnnotationKey
+// annotation of the node.
+type IOTuningStatus struct {
+	// Configured is whether the pool configures an I/O tuning; if not, the
+	// defaults for control plane nodes apply
+	Configured  bool                    `json:"configured"`
+	Device      string                  `json:"device,omitempty"`
+	DeviceClass mcfgv1.BlockDeviceClass `json:"deviceClass,omitempty"`
+	Scheduler   string                  `json:"scheduler,omitempty"`
+	QueueDepth  int                     `json:"queueDepth,omitempty"`
+	// OstreeFsync and OstreePerObjectFsync are unset if the repository doesn't set them
+	OstreeFsync          *bool `json:"ostreeFsync,omitempty"`
+	OstreePerObjectFsync *bool `json:"ostreePerObjectFsync,omitempty"`
+	// Message describes tuning that couldn't be applied
+	Message string `json:"message,omitempty"`
+}
+
+// getBlockDeviceClass returns the class of the block device at sysfsDev.
+func getBlockDeviceClass(sysfsDev string) (mcfgv1.BlockDeviceClass, error) {
+	if strings.HasPrefix(filepath.Base(sysfsDev), "nvme") {
+		return mcfgv1.BlockDeviceClassNVMe, nil
+	}
+	rotational, err := ioutil.ReadFile(filepath.Join(sysfsDev, "queue", "rotational"))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(rotational)) == "1" {
+		return mcfgv1.BlockDeviceClassHDD, nil
+	}
+	return mcfgv1.BlockDeviceClassSSD, nil
+}
+
+// getDeviceSchedulers returns the I/O scheduler in use by the block device at
+// sysfsDev, along with all the schedulers it supports.
+func getDeviceSchedulers(sysfsDev string) (string, []string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(sysfsDev, "queue", "scheduler"))
+	if err != nil {
+		return "", nil, err
+	}
+	var current string
+	var supported []string
+	for _, v := range strings.Fields(string(contents)) {
+		if strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]") {
+			v = strings.Trim(v, "[]")
+			current = v
+		}
+		supported = append(supported, v)
+	}
+	return current, supported, nil
+}
+
+// setDeviceScheduler switches the block device at sysfsDev to the sched I/O
+// scheduler. It returns false if the device doesn't support it.
+func setDeviceScheduler(sysfsDev, sched string) (bool, error) {
+	current, supported, err := getDeviceSchedulers(sysfsDev)
+	if err != nil {
+		return false, err
+	}
+	if current == sched {
+		glog.Infof("Device %s already uses scheduler %s", sysfsDev, sched)
+		return true, nil
+	}
+	if !ctrlcommon.InSlice(sched, supported) {
+		glog.Infof("Device %s does not support the %s scheduler", sysfsDev, sched)
+		return false, nil
+	}
+	if err := writeSysfs(filepath.Join(sysfsDev, "queue", "scheduler"), sched); err != nil {
+		return false, err
+	}
+	glog.Infof("Set blockdev %s to use scheduler %v", sysfsDev, sched)
+	return true, nil
+}
+
+// getDeviceQueueDepth returns the number of requests the queue of the block device at sysfsDev holds.
+func getDeviceQueueDepth(sysfsDev string) (int, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(sysfsDev, "queue", "nr_requests"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(contents)))
+}
+
+func writeSysfs(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write([]byte(value))
+	return err
+}
+
+// setRootDeviceSchedulerBFQ switches to the `bfq` I/O scheduler
+// for the root block device to better share I/O between etcd
+// and other processes.  See
+// https://github.com/openshift/machine-config-operator/issues/1897
+// Note this is the current systemd default in Fedora, but not RHEL8,
+// except for NVMe devices.
+func setRootDeviceSchedulerBFQ() error {
+	rootDevSysfs, err := getRootBlockDeviceSysfs()
+	if err != nil {
+		return err
+	}
+	_, err = setDeviceScheduler(rootDevSysfs, "bfq")
+	return err
+}
+
+// applyDeviceIOTuning applies the block device part of an I/O tuning to the device at
+// sysfsDev. It returns the tuning the device doesn't support.
+func applyDeviceIOTuning(sysfsDev string, tuning *mcfgv1.IOTuning) ([]string, error) {
+	class, err := getBlockDeviceClass(sysfsDev)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, sched := range tuning.Schedulers {
+		if sched.DeviceClass != "" && sched.DeviceClass != class {
+			continue
+		}
+		ok, err := setDeviceScheduler(sysfsDev, sched.Scheduler)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("device %s does not support the %s scheduler", filepath.Base(sysfsDev), sched.Scheduler))
+		}
+		break
+	}
+	if tuning.QueueDepth != nil {
+		// The kernel refuses depths the device or scheduler can't do
+		if err := writeSysfs(filepath.Join(sysfsDev, "queue", "nr_requests"), strconv.Itoa(int(*tuning.QueueDepth))); err != nil {
+			problems = append(problems, fmt.Sprintf("setting queue depth %d of device %s: %v", *tuning.QueueDepth, filepath.Base(sysfsDev), err))
+		}
+	}
+	return problems, nil
+}
+
+// getDeviceIOStatus records the class, scheduler and queue depth of the block device at sysfsDev in status.
+func getDeviceIOStatus(sysfsDev string, status *IOTuningStatus) error {
+	var err error
+	status.Device = filepath.Base(sysfsDev)
+	if status.DeviceClass, err = getBlockDeviceClass(sysfsDev); err != nil {
+		return err
+	}
+	if status.Scheduler, _, err = getDeviceSchedulers(sysfsDev); err != nil {
+		return err
+	}
+	if status.QueueDepth, err = getDeviceQueueDepth(sysfsDev); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getOstreeConfig returns a boolean option of the ostree repository, or nil if it isn't set.
+func getOstreeConfig(key string) *bool {
+	out, err := runGetOut("ostree", "--repo="+ostreeRepo, "config", "get", key)
+	if err != nil {
+		return nil
+	}
+	value, err := strconv.ParseBool(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// getIOTuning returns the I/O tuning a config carries, or nil.
+func getIOTuning(config *mcfgv1.MachineConfig) (*mcfgv1.IOTuning, error) {
+	if config == nil {
+		return nil, nil
+	}
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return ctrlcommon.IOTuningPolicy.GetFromIgnition(&ignCfg)
+}
+
+// applyIOTuning applies the I/O tuning config carries to the node. Without one, control
+// plane nodes get the default tuning: ostree per-object fsync when the daemon starts, and
+// the bfq scheduler when an OS update starts. osUpdate is whether an OS update starts;
+// otherwise the resulting state of the node is published.
+func (dn *Daemon) applyIOTuning(config *mcfgv1.MachineConfig, osUpdate bool) error {
+	if !dn.os.IsCoreOSVariant() {
+		return nil
+	}
+	tuning, err := getIOTuning(config)
+	if err != nil {
+		return err
+	}
+	status := IOTuningStatus{Configured: tuning != nil}
+	switch {
+	case tuning == nil && !dn.isControlPlane:
+	case tuning == nil && osUpdate:
+		return setRootDeviceSchedulerBFQ()
+	case tuning == nil:
+		if err := dn.initializeControlPlane(); err != nil {
+			return err
+		}
+	default:
+		rootDevSysfs, err := getRootBlockDeviceSysfs()
+		if err != nil {
+			return err
+		}
+		problems, err := applyDeviceIOTuning(rootDevSysfs, tuning)
+		if err != nil {
+			return err
+		}
+		if tuning.Ostree != nil && tuning.Ostree.Fsync != nil {
+			if err := setOstreeConfig("core.fsync", *tuning.Ostree.Fsync); err != nil {
+				return err
+			}
+		}
+		if tuning.Ostree != nil && tuning.Ostree.PerObjectFsync != nil {
+			if err := setOstreeConfig("core.per-object-fsync", *tuning.Ostree.PerObjectFsync); err != nil {
+				return err
+			}
+		}
+		if len(problems) > 0 {
+			status.Message = strings.Join(problems, "; ")
+			glog.Warningf("I/O tuning not fully applied: %s", status.Message)
+		}
+	}
+	if !osUpdate {
+		dn.publishIOTuningStatus(status)
+	}
+	return nil
+}
+
+// publishIOTuningStatus adds the current I/O state of the node to status and publishes
+// it. The status is informational, so failures to read or publish it are only logged.
+func (dn *Daemon) publishIOTuningStatus(status IOTuningStatus) {
+	if dn.nodeWriter == nil {
+		return
+	}
+	rootDevSysfs, err := getRootBlockDeviceSysfs()
+	if err == nil {
+		err = getDeviceIOStatus(rootDevSysfs, &status)
+	}
+	if err != nil {
+		glog.Warningf("Failed to read I/O state of the root device: %v", err)
+	}
+	status.OstreeFsync = getOstreeConfig("core.fsync")
+	status.OstreePerObjectFsync = getOstreeConfig("core.per-object-fsync")
+	raw, err := json.Marshal(status)
+	if err != nil {
+		glog.Warningf("Failed to encode I/O tuning status: %v", err)
+		return
+	}
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.IOTuningStatusAnnotationKey: string(raw)}); err != nil {
+		glog.Warningf("Failed to publish I/O tuning status: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+func newFakeBlockDevice(t *testing.T, name, rotational, scheduler string) string {
+	dev := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.MkdirAll(filepath.Join(dev, "queue"), 0o755))
+	for file, contents := range map[string]string{"rotational": rotational, "scheduler": scheduler, "nr_requests": "64\n"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dev, "queue", file), []byte(contents), 0o644))
+	}
+	return dev
+}
+
+func TestGetBlockDeviceClass(t *testing.T) {
+	for dev, want := range map[string]mcfgv1.BlockDeviceClass{
+		newFakeBlockDevice(t, "nvme0n1", "0\n", ""): mcfgv1.BlockDeviceClassNVMe,
+		newFakeBlockDevice(t, "sda", "0\n", ""):     mcfgv1.BlockDeviceClassSSD,
+		newFakeBlockDevice(t, "sdb", "1\n", ""):     mcfgv1.BlockDeviceClassHDD,
+	} {
+		class, err := getBlockDeviceClass(dev)
+		require.NoError(t, err)
+		assert.Equal(t, want, class, dev)
+	}
+}
+
+func TestApplyDeviceIOTuning(t *testing.T) {
+	depth := int32(256)
+	tuning := &mcfgv1.IOTuning{
+		Schedulers: []mcfgv1.IOScheduler{
+			{DeviceClass: mcfgv1.BlockDeviceClassNVMe, Scheduler: "none"},
+			{DeviceClass: mcfgv1.BlockDeviceClassHDD, Scheduler: "kyber"},
+			{Scheduler: "bfq"},
+		},
+		QueueDepth: &depth,
+	}
+
+	// The first scheduler matching the device class applies
+	dev := newFakeBlockDevice(t, "nvme0n1", "0\n", "[none] mq-deadline kyber bfq\n")
+	problems, err := applyDeviceIOTuning(dev, tuning)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	depthContents, err := ioutil.ReadFile(filepath.Join(dev, "queue", "nr_requests"))
+	require.NoError(t, err)
+	assert.Equal(t, "256", string(depthContents))
+
+	dev = newFakeBlockDevice(t, "sda", "0\n", "[mq-deadline] kyber bfq none\n")
+	problems, err = applyDeviceIOTuning(dev, tuning)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	schedContents, err := ioutil.ReadFile(filepath.Join(dev, "queue", "scheduler"))
+	require.NoError(t, err)
+	assert.Equal(t, "bfq", string(schedContents))
+
+	// Unsupported schedulers are reported rather than failing
+	dev = newFakeBlockDevice(t, "sdb", "1\n", "[mq-deadline] none\n")
+	problems, err = applyDeviceIOTuning(dev, tuning)
+	require.NoError(t, err)
+	assert.Len(t, problems, 1)
+
+	status := IOTuningStatus{}
+	require.NoError(t, getDeviceIOStatus(dev, &status))
+	assert.Equal(t, IOTuningStatus{Device: "sdb", DeviceClass: mcfgv1.BlockDeviceClassHDD, Scheduler: "mq-deadline", QueueDepth: 256}, status)
+}
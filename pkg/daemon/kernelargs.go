@@ -2,178 +2,381 @@ package daemon
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"reflect"
 	"strings"
 
 	// Enable sha256 in container image references
 	_ "crypto/sha256"
 
 	"github.com/golang/glog"
-	"github.com/openshift/machine-config-operator/pkg/daemon/pivot/types"
+	corev1 "k8s.io/api/core/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 )
 
 const (
-	// KernelTuningFile is a path to the file containing kernel arg changes for tuning
+	// KernelTuningFile is a path to the file containing kernel arg changes for tuning.
+	// It predates the node annotation and is still honoured, subject to the same allowlist.
 	KernelTuningFile = "/etc/pivot/kernel-args"
 	// CmdLineFile is a path to file with kernel cmdline
 	CmdLineFile = "/proc/cmdline"
 )
 
-// TODO: fill out the allowlists
-// tuneableRHCOSArgsAllowlist contains allowed keys for tunable kernel arguments on RHCOS
-var tuneableRHCOSArgsAllowlist = map[string]bool{
-	"nosmt": true,
-}
+// defaultTunableRHCOSArgs are the kernel arguments that can be tuned on RHCOS
+// when the pool doesn't configure an allowlist
+var defaultTunableRHCOSArgs = []string{"nosmt"}
 
-// isArgTuneable returns if the argument provided is allowed to be modified
-func isArgTunable(arg string) (bool, error) {
-	os, err := GetHostRunningOS()
-	if err != nil {
-		return false, fmt.Errorf("failed to get OS for determining whether kernel arg is tuneable: %w", err)
-	}
+// KernelArgumentTuningRequest is the kernel argument tuning requested for a node
+// through its KernelArgumentTuningAnnotationKey annotation, in addition to the
+// kernel arguments of its MachineConfigs.
+type KernelArgumentTuningRequest struct {
+	// KernelArguments are added to the node
+	KernelArguments []string `json:"kernelArguments,omitempty"`
+	// KernelArgumentsToDelete are removed from the node. An entry of the form
+	// key=value removes that exact argument, a bare key every argument with that key.
+	KernelArgumentsToDelete []string `json:"kernelArgumentsToDelete,omitempty"`
+}
 
-	if os.IsEL() {
-		return tuneableRHCOSArgsAllowlist[arg], nil
-	} else if os.IsFCOS() {
-		return true, nil
-	}
-	return false, nil
+// KernelArgumentTuningStatus is published by the daemon in the
+// KernelArgumentTuningStatusAnnotationKey annotation of its node.
+type KernelArgumentTuningStatus struct {
+	// Added are the requested kernel arguments found on the booted command line
+	Added []string `json:"added,omitempty"`
+	// Deleted are the requested deletions that match nothing on the booted command line
+	Deleted []string `json:"deleted,omitempty"`
+	// Rejected maps requested kernel arguments and deletions that are not
+	// applied to the reason why
+	Rejected map[string]string `json:"rejected,omitempty"`
+	// Staged are the rpm-ostree kargs arguments the daemon rebooted the node
+	// for outside of an update, to detect tuning that doesn't take effect
+	Staged []string `json:"staged,omitempty"`
+	// Message describes a problem with the request as a whole
+	Message string `json:"message,omitempty"`
 }
 
-// isArgInUse checks to see if the argument is already in use by the system currently
-func isArgInUse(arg, cmdLinePath string) (bool, error) {
-	if cmdLinePath == "" {
-		cmdLinePath = CmdLineFile
+// GetNodeKernelArgumentTuning returns the kernel argument tuning requested for a node, if any.
+func GetNodeKernelArgumentTuning(node *corev1.Node) (*KernelArgumentTuningRequest, error) {
+	raw, ok := node.Annotations[constants.KernelArgumentTuningAnnotationKey]
+	if !ok || strings.TrimSpace(raw) == "" {
+		return nil, nil
 	}
-	content, err := ioutil.ReadFile(cmdLinePath)
-	if err != nil {
-		return false, err
+	request := &KernelArgumentTuningRequest{}
+	if err := json.Unmarshal([]byte(raw), request); err != nil {
+		return nil, fmt.Errorf("parsing %s annotation of node %s: %w", constants.KernelArgumentTuningAnnotationKey, node.Name, err)
 	}
-
-	checkable := string(content)
-	if strings.Contains(checkable, arg) {
-		return true, nil
-	}
-	return false, nil
+	return request, nil
 }
 
-// parseTuningFile parses the kernel argument tuning file
-func parseTuningFile(tuningFilePath, cmdLinePath string) ([]types.TuneArgument, []types.TuneArgument, error) {
-	addArguments := []types.TuneArgument{}
-	deleteArguments := []types.TuneArgument{}
-	if tuningFilePath == "" {
-		tuningFilePath = KernelTuningFile
+// GetNodeKernelArgumentTuningStatus returns the kernel argument tuning status the
+// daemon published on the node, if any.
+func GetNodeKernelArgumentTuningStatus(node *corev1.Node) (*KernelArgumentTuningStatus, error) {
+	raw, ok := node.Annotations[constants.KernelArgumentTuningStatusAnnotationKey]
+	if !ok {
+		return nil, nil
 	}
-	if cmdLinePath == "" {
-		cmdLinePath = CmdLineFile
+	status := &KernelArgumentTuningStatus{}
+	if err := json.Unmarshal([]byte(raw), status); err != nil {
+		return nil, fmt.Errorf("parsing kernel argument tuning status of node %s: %w", node.Name, err)
 	}
-	// Read and parse the file
+	return status, nil
+}
+
+// parseTuningFile parses the legacy kernel argument tuning file, made of
+// "ADD <arg>" and "DELETE <arg>" lines, into a request.
+func parseTuningFile(tuningFilePath string) (*KernelArgumentTuningRequest, error) {
+	request := &KernelArgumentTuningRequest{}
 	file, err := os.Open(tuningFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// It's ok if the file doesn't exist
-			return addArguments, deleteArguments, nil
+			return request, nil
 		}
-		return addArguments, deleteArguments, fmt.Errorf("reading %s: %w", tuningFilePath, err)
+		return nil, fmt.Errorf("reading %s: %w", tuningFilePath, err)
 	}
-	// Clean up
 	defer file.Close()
 
-	// Parse the tuning lines
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if strings.HasPrefix(line, "ADD ") {
-			// NOTE: Today only specific bare kernel arguments are allowed so
-			// there is not a need to split on =.
-			key := strings.TrimSpace(line[len("ADD "):])
-			tuneableKarg, err := isArgTunable(key)
-			if err != nil {
-				return addArguments, deleteArguments, err
-			}
-			if tuneableKarg {
-				// Find out if the argument is in use
-				inUse, err := isArgInUse(key, cmdLinePath)
-				if err != nil {
-					return addArguments, deleteArguments, err
-				}
-				if !inUse {
-					addArguments = append(addArguments, types.TuneArgument{Key: key, Bare: true})
-				} else {
-					glog.Infof(`skipping "%s" as it is already in use`, key)
-				}
-			} else {
-				glog.Infof("%s not an allowlisted kernel argument", key)
-			}
-		} else if strings.HasPrefix(line, "DELETE ") {
-			// NOTE: Today only specific bare kernel arguments are allowed so
-			// there is not a need to split on =.
-			key := strings.TrimSpace(line[len("DELETE "):])
-			tuneableKarg, err := isArgTunable(key)
-			if err != nil {
-				return addArguments, deleteArguments, err
+		switch {
+		case strings.HasPrefix(line, "ADD "):
+			request.KernelArguments = append(request.KernelArguments, strings.TrimSpace(line[len("ADD "):]))
+		case strings.HasPrefix(line, "DELETE "):
+			request.KernelArgumentsToDelete = append(request.KernelArgumentsToDelete, strings.TrimSpace(line[len("DELETE "):]))
+		default:
+			glog.V(2).Infof(`skipping malformed line in %s: "%s"`, tuningFilePath, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", tuningFilePath, err)
+	}
+	return request, nil
+}
+
+// kernelArgumentTunable returns whether a kernel argument may be tuned under the
+// allowlist a rendered config carries. Without one, the allowlist defaults to
+// nosmt on RHCOS and to every argument on FCOS.
+func kernelArgumentTunable(hostos OperatingSystem, tuning *mcfgv1.KernelArgumentTuning) func(string) bool {
+	switch {
+	case tuning != nil:
+		return func(arg string) bool { return ctrlcommon.IsKernelArgumentAllowed(arg, tuning.AllowedArguments) }
+	case hostos.IsFCOS():
+		return func(string) bool { return true }
+	case hostos.IsEL():
+		return func(arg string) bool { return ctrlcommon.IsKernelArgumentAllowed(arg, defaultTunableRHCOSArgs) }
+	default:
+		return func(string) bool { return false }
+	}
+}
+
+// kernelArgumentSetByConfig returns whether the MachineConfig adds or deletes
+// the key of arg, in which case tuning it per node would fight with the config.
+func kernelArgumentSetByConfig(arg string, config *mcfgv1.MachineConfig) bool {
+	key := ctrlcommon.KernelArgumentKey(arg)
+	for _, karg := range ctrlcommon.ParseKernelArguments(config.Spec.KernelArguments) {
+		if ctrlcommon.KernelArgumentKey(karg) == key {
+			return true
+		}
+	}
+	for _, del := range config.Spec.KernelArgumentsToDelete {
+		if ctrlcommon.KernelArgumentKey(strings.TrimSpace(del)) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// planKernelArgumentTuning compares the requested tuning with the booted command
+// line and returns the rpm-ostree kargs arguments still needed to apply it, along
+// with the tuning status of the node. Arguments are compared as whole tokens, so
+// nosmt is not mistaken for nosmt=force.
+func planKernelArgumentTuning(request *KernelArgumentTuningRequest, tunable func(string) bool, config *mcfgv1.MachineConfig, cmdline []string) ([]string, KernelArgumentTuningStatus) {
+	var kargs []string
+	status := KernelArgumentTuningStatus{Rejected: map[string]string{}}
+
+	reject := func(arg string) bool {
+		switch {
+		case !tunable(arg):
+			status.Rejected[arg] = "not an allowed kernel argument"
+		case kernelArgumentSetByConfig(arg, config):
+			status.Rejected[arg] = "set by MachineConfig " + config.Name
+		default:
+			return false
+		}
+		return true
+	}
+
+	for _, arg := range ctrlcommon.ParseKernelArguments(request.KernelArguments) {
+		if reject(arg) {
+			continue
+		}
+		conflict := false
+		for _, del := range request.KernelArgumentsToDelete {
+			if ctrlcommon.KernelArgumentMatchesDeletion(arg, strings.TrimSpace(del)) {
+				status.Rejected[arg] = "both added and deleted"
+				conflict = true
 			}
-			if tuneableKarg {
-				inUse, err := isArgInUse(key, cmdLinePath)
-				if err != nil {
-					return addArguments, deleteArguments, err
-				}
-				if inUse {
-					deleteArguments = append(deleteArguments, types.TuneArgument{Key: key, Bare: true})
-				} else {
-					glog.Infof(`skipping "%s" as it is not present in the current argument list`, key)
-				}
-			} else {
-				glog.Infof("%s not an allowlisted kernel argument", key)
+		}
+		if conflict {
+			continue
+		}
+		if ctrlcommon.InSlice(arg, cmdline) {
+			status.Added = append(status.Added, arg)
+			continue
+		}
+		kargs = append(kargs, "--append="+arg)
+	}
+
+	for _, entry := range request.KernelArgumentsToDelete {
+		del := strings.TrimSpace(entry)
+		if _, ok := status.Rejected[del]; ok || reject(del) {
+			continue
+		}
+		found := false
+		for _, arg := range cmdline {
+			if ctrlcommon.KernelArgumentMatchesDeletion(arg, del) {
+				kargs = append(kargs, "--delete="+arg)
+				found = true
 			}
-		} else {
-			glog.V(2).Infof(`skipping malformed line in %s: "%s"`, tuningFilePath, line)
 		}
+		if !found {
+			status.Deleted = append(status.Deleted, del)
+		}
+	}
+
+	if len(status.Rejected) == 0 {
+		status.Rejected = nil
 	}
-	return addArguments, deleteArguments, nil
+	return kargs, status
 }
 
-// UpdateTuningArgs executes additions and removals of kernel tuning arguments
-func UpdateTuningArgs(tuningFilePath, cmdLinePath string) error {
-	if cmdLinePath == "" {
-		cmdLinePath = CmdLineFile
+// getKernelArgumentTuning returns the tuning requested for the node through its
+// annotation and the legacy tuning file, merged.
+func (dn *Daemon) getKernelArgumentTuning() (*KernelArgumentTuningRequest, error) {
+	request, err := parseTuningFile(dn.kernelTuningFile)
+	if err != nil {
+		return nil, err
+	}
+	if dn.node == nil {
+		return request, nil
 	}
-	changed := false
-	additions, deletions, err := parseTuningFile(tuningFilePath, cmdLinePath)
+	nodeRequest, err := GetNodeKernelArgumentTuning(dn.node)
 	if err != nil {
+		return nil, err
+	}
+	if nodeRequest != nil {
+		request.KernelArguments = append(request.KernelArguments, nodeRequest.KernelArguments...)
+		request.KernelArgumentsToDelete = append(request.KernelArgumentsToDelete, nodeRequest.KernelArgumentsToDelete...)
+	}
+	return request, nil
+}
+
+// planNodeKernelArgumentTuning plans the tuning of the node against config, see planKernelArgumentTuning.
+// A malformed request is reported in the status rather than returned, as only an admin can fix it.
+func (dn *Daemon) planNodeKernelArgumentTuning(config *mcfgv1.MachineConfig) ([]string, KernelArgumentTuningStatus, error) {
+	request, err := dn.getKernelArgumentTuning()
+	if err != nil {
+		return nil, KernelArgumentTuningStatus{Message: err.Error()}, nil
+	}
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+	if err != nil {
+		return nil, KernelArgumentTuningStatus{}, err
+	}
+	tuning, err := ctrlcommon.KernelArgumentTuningPolicy.GetFromIgnition(&ignCfg)
+	if err != nil {
+		return nil, KernelArgumentTuningStatus{}, err
+	}
+	cmdline, err := readKernelCmdline()
+	if err != nil {
+		return nil, KernelArgumentTuningStatus{}, err
+	}
+	kargs, status := planKernelArgumentTuning(request, kernelArgumentTunable(dn.os, tuning), config, cmdline)
+	return kargs, status, nil
+}
+
+// stageKernelArgumentTuning stages the kernel argument tuning of the node for the
+// reboot into config, which is part of an update.
+func (dn *Daemon) stageKernelArgumentTuning(config *mcfgv1.MachineConfig) error {
+	if !dn.os.IsCoreOSVariant() {
+		return nil
+	}
+	kargs, _, err := dn.planNodeKernelArgumentTuning(config)
+	if err != nil || len(kargs) == 0 {
 		return err
 	}
+	args := append([]string{"kargs"}, kargs...)
+	dn.logSystem("Tuning kernel arguments: rpm-ostree %v", args)
+	if err := runRpmOstree(args...); err != nil {
+		return fmt.Errorf("failed tuning kernel arguments: %w", err)
+	}
+	return nil
+}
 
-	// Execute additions
-	for _, toAdd := range additions {
-		if toAdd.Bare {
-			changed = true
-			err := exec.Command("rpm-ostree", "kargs", fmt.Sprintf("--append=%s", toAdd.Key)).Run()
-			if err != nil {
-				return fmt.Errorf("failed adding karg: %w", err)
-			}
-		} else {
-			panic("Not supported")
+// reconcileKernelArgumentTuning applies a change of the kernel argument tuning of
+// an idle node. It publishes the kernel arguments the node needs a reboot for and,
+// once the node controller approves them, drains the node and reboots it into its
+// current config with the new kernel arguments. It also publishes the tuning status
+// of the node.
+func (dn *Daemon) reconcileKernelArgumentTuning() error {
+	if !dn.os.IsCoreOSVariant() || dn.nodeWriter == nil {
+		return nil
+	}
+	currentConfigName, err := getNodeAnnotation(dn.node, constants.CurrentMachineConfigAnnotationKey)
+	if err != nil {
+		return err
+	}
+	currentConfig, err := dn.mcLister.Get(currentConfigName)
+	if err != nil {
+		return err
+	}
+	kargs, status, err := dn.planNodeKernelArgumentTuning(currentConfig)
+	if err != nil {
+		return err
+	}
+
+	published, err := GetNodeKernelArgumentTuningStatus(dn.node)
+	if err != nil {
+		glog.Warningf("Ignoring malformed kernel argument tuning status: %v", err)
+		published = nil
+	}
+	if len(kargs) > 0 && published != nil && reflect.DeepEqual(kargs, published.Staged) {
+		// We already rebooted for these changes, retrying would loop
+		status.Staged = published.Staged
+		status.Message = fmt.Sprintf("kernel arguments staged before the last reboot are not on the booted command line: %v", kargs)
+		kargs = nil
+	}
+	if published == nil || !reflect.DeepEqual(*published, status) {
+		if err := dn.publishKernelArgumentTuningStatus(status); err != nil {
+			return err
 		}
 	}
-	// Execute deletions
-	for _, toDelete := range deletions {
-		if toDelete.Bare {
-			changed = true
-			err := exec.Command("rpm-ostree", "kargs", fmt.Sprintf("--delete=%s", toDelete.Key)).Run()
-			if err != nil {
-				return fmt.Errorf("failed deleting karg: %w", err)
-			}
-		} else {
-			panic("Not supported")
+	// The node controller decides when the node may reboot, the same way as for
+	// a config change, so tuning respects maxUnavailable and the update budget
+	pending := strings.Join(kargs, " ")
+	if dn.node.Annotations[constants.KernelArgumentTuningPendingAnnotationKey] != pending {
+		if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.KernelArgumentTuningPendingAnnotationKey: pending}); err != nil {
+			return fmt.Errorf("publishing pending kernel argument tuning: %w", err)
 		}
 	}
+	if len(kargs) == 0 {
+		return nil
+	}
+	if dn.node.Annotations[constants.KernelArgumentTuningApprovedAnnotationKey] != pending {
+		glog.Infof("Kernel argument tuning pending, waiting for the node controller to allow a reboot: %v", kargs)
+		return nil
+	}
+
+	dn.logSystem("Kernel argument tuning changed, rebooting into config %s: %v", currentConfigName, kargs)
+	dn.stopConfigDriftMonitor()
+	if err := dn.nodeWriter.SetWorking(); err != nil {
+		return fmt.Errorf("error setting node's state to Working: %w", err)
+	}
+	// The node is now unavailable on its own; consume the approval so a later
+	// request for the same arguments waits for its own
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.KernelArgumentTuningApprovedAnnotationKey: ""}); err != nil {
+		return fmt.Errorf("clearing kernel argument tuning approval: %w", err)
+	}
+	dn.catchIgnoreSIGTERM()
+	defer dn.cancelSIGTERM()
+	dn.startUpdateHistory(currentConfigName, currentConfigName)
+	dn.resetUpdatePhase()
+
+	if err := dn.performDrain(); err != nil {
+		return err
+	}
+	dn.setUpdatePhase(constants.UpdatePhaseDrained)
+	args := append([]string{"kargs"}, kargs...)
+	dn.logSystem("Tuning kernel arguments: rpm-ostree %v", args)
+	if err := runRpmOstree(args...); err != nil {
+		return fmt.Errorf("failed tuning kernel arguments: %w", err)
+	}
+	dn.setUpdatePhase(constants.UpdatePhaseOSUpdateStaged)
+
+	status.Staged = kargs
+	if err := dn.publishKernelArgumentTuningStatus(status); err != nil {
+		return err
+	}
+	// Rebooting with the current config pending completes the update, and uncordons
+	// the node, the same way as for a config change
+	if err := dn.finalizeBeforeReboot(currentConfig); err != nil {
+		return err
+	}
+	dn.setUpdatePhase(constants.UpdatePhaseRebooting)
+	return dn.reboot(fmt.Sprintf("Node will reboot into config %s to tune kernel arguments", currentConfigName))
+}
 
-	if changed {
-		glog.Info("Updated kernel tuning arguments")
+func (dn *Daemon) publishKernelArgumentTuningStatus(status KernelArgumentTuningStatus) error {
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.KernelArgumentTuningStatusAnnotationKey: string(raw)}); err != nil {
+		return fmt.Errorf("publishing kernel argument tuning status: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestPlanKernelArgumentTuning(t *testing.T) {
+	config := helpers.NewMachineConfig("rendered-worker", nil, "", nil)
+	config.Spec.KernelArguments = []string{"hugepages=4"}
+	tunable := kernelArgumentTunable(OperatingSystem{}, &mcfgv1.KernelArgumentTuning{AllowedArguments: []string{"nosmt", "mitigations=auto", "hugepages", "quiet"}})
+	cmdline := []string{"root=UUID=1234", "nosmt=force", "quiet", "hugepages=4"}
+
+	request := &KernelArgumentTuningRequest{
+		KernelArguments:         []string{"nosmt mitigations=auto", "mitigations=off", "hugepages=8"},
+		KernelArgumentsToDelete: []string{"quiet", "loglevel"},
+	}
+	kargs, status := planKernelArgumentTuning(request, tunable, config, cmdline)
+	// nosmt=force on the command line doesn't count as nosmt
+	assert.Equal(t, []string{"--append=nosmt", "--append=mitigations=auto", "--delete=quiet"}, kargs)
+	assert.Equal(t, map[string]string{
+		"mitigations=off": "not an allowed kernel argument",
+		"hugepages=8":     "set by MachineConfig rendered-worker",
+		"loglevel":        "not an allowed kernel argument",
+	}, status.Rejected)
+
+	// Once applied, nothing is left to do
+	cmdline = []string{"root=UUID=1234", "nosmt=force", "hugepages=4", "nosmt", "mitigations=auto"}
+	kargs, status = planKernelArgumentTuning(request, tunable, config, cmdline)
+	assert.Empty(t, kargs)
+	assert.Equal(t, []string{"nosmt", "mitigations=auto"}, status.Added)
+	assert.Equal(t, []string{"quiet"}, status.Deleted)
+
+	// A bare key deletes every value of it
+	request = &KernelArgumentTuningRequest{KernelArgumentsToDelete: []string{"nosmt"}}
+	kargs, _ = planKernelArgumentTuning(request, tunable, config, cmdline)
+	assert.Equal(t, []string{"--delete=nosmt=force", "--delete=nosmt"}, kargs)
+
+	// An argument can't be both added and deleted
+	request = &KernelArgumentTuningRequest{KernelArguments: []string{"nosmt"}, KernelArgumentsToDelete: []string{"nosmt"}}
+	kargs, status = planKernelArgumentTuning(request, tunable, config, []string{})
+	assert.Empty(t, kargs)
+	assert.Contains(t, status.Rejected, "nosmt")
+}
+
+func TestKernelArgumentTunableDefaults(t *testing.T) {
+	rhcos := OperatingSystem{ID: "rhcos"}
+	assert.True(t, kernelArgumentTunable(rhcos, nil)("nosmt"))
+	assert.False(t, kernelArgumentTunable(rhcos, nil)("mitigations=off"))
+	assert.True(t, kernelArgumentTunable(OperatingSystem{ID: "fedora", VariantID: "coreos"}, nil)("mitigations=off"))
+	// A configured allowlist replaces the defaults
+	assert.False(t, kernelArgumentTunable(rhcos, &mcfgv1.KernelArgumentTuning{})("nosmt"))
+}
+
+func TestGetKernelArgumentTuning(t *testing.T) {
+	dn := newMockDaemon()
+	dn.kernelTuningFile = filepath.Join(t.TempDir(), "kernel-args")
+	require.NoError(t, ioutil.WriteFile(dn.kernelTuningFile, []byte("ADD nosmt\nDELETE quiet\nbogus\n"), 0o644))
+	dn.node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{
+		constants.KernelArgumentTuningAnnotationKey: `{"kernelArguments":["mitigations=auto"]}`,
+	}}}
+
+	request, err := dn.getKernelArgumentTuning()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nosmt", "mitigations=auto"}, request.KernelArguments)
+	assert.Equal(t, []string{"quiet"}, request.KernelArgumentsToDelete)
+
+	dn.node.Annotations[constants.KernelArgumentTuningAnnotationKey] = "nosmt"
+	_, err = dn.getKernelArgumentTuning()
+	assert.Error(t, err)
+}
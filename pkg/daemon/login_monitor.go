@@ -0,0 +1,350 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+const (
+	logindUnit = "systemd-logind.service"
+	// IDs are taken from https://cgit.freedesktop.org/systemd/systemd/plain/src/systemd/sd-messages.h
+	sdMessageSessionStart = "8d45620c1a4348dbb17410da57c60c66"
+
+	// AccessLogPath is where the daemon keeps the log of login sessions on the node
+	AccessLogPath = "/etc/machine-config-daemon/access-log.json"
+
+	// maxAccessLogEntries bounds the access log on disk and in the node annotation
+	maxAccessLogEntries = 20
+)
+
+// LoginSession records a login session on the node.
+type LoginSession struct {
+	// ID is the logind session ID, which is only unique within a boot
+	ID   string `json:"id"`
+	User string `json:"user"`
+	// RemoteHost is the address the session came from, if it is remote
+	RemoteHost string `json:"remoteHost,omitempty"`
+	// Type is the logind session type, e.g. tty
+	Type string `json:"type,omitempty"`
+	// Service is the PAM service that opened the session, e.g. sshd
+	Service string      `json:"service,omitempty"`
+	Time    metav1.Time `json:"time"`
+}
+
+// journalSessionStart holds the fields of the journal entry logind writes when
+// a session starts.
+type journalSessionStart struct {
+	SessionID         string `json:"SESSION_ID"`
+	UserID            string `json:"USER_ID"`
+	RealtimeTimestamp string `json:"__REALTIME_TIMESTAMP"`
+}
+
+// parseSessionStart parses a session start entry from `journalctl -o json`.
+func parseSessionStart(line []byte) (LoginSession, error) {
+	var entry journalSessionStart
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return LoginSession{}, fmt.Errorf("parsing journal entry: %w", err)
+	}
+	if entry.SessionID == "" {
+		return LoginSession{}, fmt.Errorf("journal entry has no session ID")
+	}
+	usec, err := strconv.ParseInt(entry.RealtimeTimestamp, 10, 64)
+	if err != nil {
+		return LoginSession{}, fmt.Errorf("parsing journal entry timestamp %q: %w", entry.RealtimeTimestamp, err)
+	}
+	return LoginSession{
+		ID:   entry.SessionID,
+		User: entry.UserID,
+		// the access log keeps times to the second
+		Time: metav1.NewTime(time.Unix(0, usec*int64(time.Microsecond)).Truncate(time.Second).UTC()),
+	}, nil
+}
+
+// parseSessionProperties adds the properties of a session, as printed by
+// `loginctl show-session`, to session.
+func parseSessionProperties(out []byte, session *LoginSession) {
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Name":
+			if session.User == "" {
+				session.User = value
+			}
+		case "RemoteHost":
+			session.RemoteHost = value
+		case "Type":
+			session.Type = value
+		case "Service":
+			session.Service = value
+		}
+	}
+}
+
+// lookupLoginSession adds what logind knows about a session to it. Sessions that
+// already ended are unknown to logind, so failures are only logged.
+func lookupLoginSession(session *LoginSession) {
+	out, err := exec.Command("loginctl", "show-session", session.ID, "-p", "Name", "-p", "RemoteHost", "-p", "Type", "-p", "Service").Output()
+	if err != nil {
+		glog.V(2).Infof("Could not look up login session %s: %v", session.ID, err)
+		return
+	}
+	parseSessionProperties(out, session)
+}
+
+// ReadAccessLog returns the access log stored at path, oldest first.
+func ReadAccessLog(path string) ([]LoginSession, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sessions := []LoginSession{}
+	if err := json.Unmarshal(raw, &sessions); err != nil {
+		return nil, fmt.Errorf("parsing access log %s: %w", path, err)
+	}
+	return sessions, nil
+}
+
+// GetNodeAccessLog returns the access log the daemon published on the node,
+// oldest first.
+func GetNodeAccessLog(node *corev1.Node) ([]LoginSession, error) {
+	raw, ok := node.Annotations[constants.AccessLogAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	sessions := []LoginSession{}
+	if err := json.Unmarshal([]byte(raw), &sessions); err != nil {
+		return nil, fmt.Errorf("parsing access log of node %s: %w", node.Name, err)
+	}
+	return sessions, nil
+}
+
+// recordLoginSessions adds the sessions not in the access log yet to it, then
+// persists and publishes it. It returns the sessions it added. The log is
+// informational, so failures to persist or publish it are only logged.
+func (dn *Daemon) recordLoginSessions(sessions []LoginSession) []LoginSession {
+	dn.accessLogLock.Lock()
+	defer dn.accessLogLock.Unlock()
+
+	accessLog, err := ReadAccessLog(dn.accessLogPath)
+	if err != nil {
+		glog.Warningf("Failed to read access log: %v", err)
+		accessLog = nil
+	}
+	var added []LoginSession
+	for _, session := range sessions {
+		known := false
+		for _, logged := range accessLog {
+			if logged.ID == session.ID && logged.Time.Equal(&session.Time) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			accessLog = append(accessLog, session)
+			added = append(added, session)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if len(accessLog) > maxAccessLogEntries {
+		accessLog = accessLog[len(accessLog)-maxAccessLogEntries:]
+	}
+
+	raw, err := json.Marshal(accessLog)
+	if err != nil {
+		glog.Warningf("Failed to encode access log: %v", err)
+		return added
+	}
+	if err := writeFileAtomicallyWithDefaults(dn.accessLogPath, raw); err != nil {
+		glog.Warningf("Failed to write access log: %v", err)
+	}
+	if dn.nodeWriter != nil {
+		if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.AccessLogAnnotationKey: string(raw)}); err != nil {
+			glog.Warningf("Failed to publish access log on node: %v", err)
+		}
+	}
+	return added
+}
+
+// handleLoginSessions records the sessions, and reports and enforces the interactive
+// access policy of the node for those that weren't recorded before. runtime is
+// whether the sessions started while the daemon was running, rather than before.
+func (dn *Daemon) handleLoginSessions(sessions []LoginSession, runtime bool) error {
+	added := dn.recordLoginSessions(sessions)
+	if len(added) == 0 {
+		return nil
+	}
+	for _, session := range added {
+		remoteHost := session.RemoteHost
+		if remoteHost == "" {
+			remoteHost = "local"
+		}
+		glog.Infof("Detected a new login session %s of user %s from %s (type %q, service %q)", session.ID, session.User, remoteHost, session.Type, session.Service)
+		MCDLoginSessions.WithLabelValues(session.User, session.RemoteHost).Inc()
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeWarning, "LoginSession", "User %s logged in from %s (session %s, type %q, service %q)", session.User, remoteHost, session.ID, session.Type, session.Service)
+		}
+	}
+	glog.Infof("Login access is discouraged! Applying annotation: %v", machineConfigDaemonSSHAccessAnnotationKey)
+	if err := dn.applySSHAccessedAnnotation(); err != nil {
+		return err
+	}
+	return dn.enforceInteractiveAccessPolicy(added[len(added)-1], runtime)
+}
+
+func (dn *Daemon) applySSHAccessedAnnotation() error {
+	if err := dn.nodeWriter.SetSSHAccessed(); err != nil {
+		return fmt.Errorf("error: cannot apply annotation for SSH access due to: %w", err)
+	}
+	return nil
+}
+
+// getInteractiveAccessPolicy returns the interactive access policy config carries, or nil.
+func getInteractiveAccessPolicy(config *mcfgv1.MachineConfig) (*mcfgv1.InteractiveAccessPolicy, error) {
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return ctrlcommon.InteractiveAccessPolicy.GetFromIgnition(&ignCfg)
+}
+
+// enforceInteractiveAccessPolicy takes the action the interactive access policy of
+// the current config of the node prescribes after session.
+func (dn *Daemon) enforceInteractiveAccessPolicy(session LoginSession, runtime bool) error {
+	currentConfig, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		return fmt.Errorf("reading current config: %w", err)
+	}
+	policy, err := getInteractiveAccessPolicy(currentConfig)
+	if err != nil {
+		return fmt.Errorf("reading interactive access policy: %w", err)
+	}
+	if policy == nil {
+		return nil
+	}
+
+	switch policy.Action {
+	case mcfgv1.InteractiveAccessActionRevalidate:
+		// Sessions before the daemon started are covered by the validation on
+		// startup, and while the config drift monitor is stopped an update is
+		// running which validates the node once done.
+		if !runtime || !dn.configDriftMonitor.IsRunning() {
+			return nil
+		}
+		glog.Infof("Revalidating on-disk state against %s after login session %s", currentConfig.Name, session.ID)
+		if err := dn.validateOnDiskState(currentConfig); err != nil {
+			dn.onConfigDrift(fmt.Errorf("on-disk state drifted after login session %s of user %s: %w", session.ID, session.User, err))
+			return nil
+		}
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "LoginRevalidated", "On-disk state still matches %s after login session %s of user %s", currentConfig.Name, session.ID, session.User)
+	case mcfgv1.InteractiveAccessActionReprovision:
+		if dn.node != nil {
+			if _, ok := dn.node.Annotations[constants.ReprovisionRequestedAnnotationKey]; ok {
+				return nil
+			}
+		}
+		dn.logSystem("Login session %s of user %s, marking node for re-provisioning", session.ID, session.User)
+		if _, err := dn.nodeWriter.SetAnnotations(map[string]string{
+			constants.ReprovisionRequestedAnnotationKey: session.Time.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return fmt.Errorf("marking node for re-provisioning: %w", err)
+		}
+		dn.nodeWriter.Eventf(corev1.EventTypeWarning, "ReprovisionRequested", "Node should be re-provisioned after login session %s of user %s", session.ID, session.User)
+	}
+	return nil
+}
+
+// readLoginSessions parses the session start entries of `journalctl -o json` from
+// scanner, calling handle on each. Malformed entries are skipped.
+func readLoginSessions(scanner *bufio.Scanner, handle func(LoginSession) error) error {
+	for scanner.Scan() {
+		session, err := parseSessionStart(scanner.Bytes())
+		if err != nil {
+			glog.Warningf("Ignoring login session: %v", err)
+			continue
+		}
+		lookupLoginSession(&session)
+		if err := handle(session); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// detectEarlySSHAccessesFromBoot records the logins since boot, which includes any
+// before the daemon started up.
+func (dn *Daemon) detectEarlySSHAccessesFromBoot() error {
+	journalOutput, err := exec.Command("journalctl", "-b", "-o", "json", "-u", logindUnit, "MESSAGE_ID="+sdMessageSessionStart).Output()
+	if err != nil {
+		return err
+	}
+	var sessions []LoginSession
+	scanner := bufio.NewScanner(bytes.NewReader(journalOutput))
+	scanner.Buffer(nil, 1024*1024)
+	if err := readLoginSessions(scanner, func(session LoginSession) error {
+		sessions = append(sessions, session)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+	glog.Infof("Detected %d login sessions since boot", len(sessions))
+	return dn.handleLoginSessions(sessions, false)
+}
+
+func (dn *Daemon) runLoginMonitor(stopCh <-chan struct{}, exitCh chan<- error) {
+	cmd := exec.Command("journalctl", "-b", "-f", "-o", "json", "-u", logindUnit, "MESSAGE_ID="+sdMessageSessionStart)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		exitCh <- err
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		exitCh <- err
+		return
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, 1024*1024)
+		err := readLoginSessions(scanner, func(session LoginSession) error {
+			if err := dn.handleLoginSessions([]LoginSession{session}, true); err != nil {
+				exitCh <- err
+			}
+			return nil
+		})
+		select {
+		case <-stopCh:
+		default:
+			if err != nil {
+				exitCh <- err
+			}
+		}
+	}()
+	<-stopCh
+	cmd.Process.Kill()
+}
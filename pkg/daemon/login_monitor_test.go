@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+func TestParseSessionStart(t *testing.T) {
+	session, err := parseSessionStart([]byte(`{"MESSAGE":"New session 3 of user core.","SESSION_ID":"3","USER_ID":"core","LEADER":"1234","__REALTIME_TIMESTAMP":"1700000000123456"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "3", session.ID)
+	assert.Equal(t, "core", session.User)
+	assert.True(t, session.Time.Equal(&metav1.Time{Time: time.Unix(1700000000, 0)}))
+
+	_, err = parseSessionStart([]byte(`{"MESSAGE":"New session of user core."}`))
+	assert.Error(t, err)
+	_, err = parseSessionStart([]byte(`New session 3 of user core.`))
+	assert.Error(t, err)
+}
+
+func TestParseSessionProperties(t *testing.T) {
+	session := LoginSession{ID: "3"}
+	parseSessionProperties([]byte("Name=core\nRemoteHost=10.0.0.1\nType=tty\nService=sshd\n"), &session)
+	assert.Equal(t, LoginSession{ID: "3", User: "core", RemoteHost: "10.0.0.1", Type: "tty", Service: "sshd"}, session)
+
+	// the journal knows the user already
+	session = LoginSession{ID: "4", User: "root"}
+	parseSessionProperties([]byte("Name=core\nType=tty\n"), &session)
+	assert.Equal(t, "root", session.User)
+}
+
+func TestRecordLoginSessions(t *testing.T) {
+	nw := &fakeNodeWriter{annotations: map[string]string{}}
+	dn := newMockDaemon()
+	dn.nodeWriter = nw
+	dn.accessLogPath = filepath.Join(t.TempDir(), "access-log.json")
+
+	start := time.Unix(1700000000, 0)
+	sessions := []LoginSession{}
+	for i := 0; i < maxAccessLogEntries+5; i++ {
+		sessions = append(sessions, LoginSession{ID: fmt.Sprint(i + 1), User: "core", Time: metav1.NewTime(start.Add(time.Duration(i) * time.Minute))})
+	}
+
+	added := dn.recordLoginSessions(sessions[:2])
+	assert.Equal(t, sessions[:2], added)
+	// sessions since boot are seen again when the daemon restarts
+	added = dn.recordLoginSessions(sessions[:3])
+	assert.Equal(t, sessions[2:3], added)
+	// session IDs start over on reboot
+	reused := LoginSession{ID: "1", User: "core", Time: metav1.NewTime(start.Add(time.Hour))}
+	added = dn.recordLoginSessions([]LoginSession{reused})
+	assert.Equal(t, []LoginSession{reused}, added)
+
+	accessLog, err := ReadAccessLog(dn.accessLogPath)
+	require.NoError(t, err)
+	assert.Len(t, accessLog, 4)
+	published, err := GetNodeAccessLog(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		constants.AccessLogAnnotationKey: nw.annotations[constants.AccessLogAnnotationKey],
+	}}})
+	require.NoError(t, err)
+	assert.Equal(t, accessLog, published)
+
+	// the log is bounded
+	dn.recordLoginSessions(sessions)
+	accessLog, err = ReadAccessLog(dn.accessLogPath)
+	require.NoError(t, err)
+	assert.Len(t, accessLog, maxAccessLogEntries)
+	assert.Equal(t, sessions[len(sessions)-1], accessLog[len(accessLog)-1])
+}
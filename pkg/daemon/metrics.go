@@ -27,6 +27,13 @@ var (
 			Help: "indicates a successful SSH login",
 		})
 
+	// MCDLoginSessions counts login sessions on a node by user and remote host
+	MCDLoginSessions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcd_login_sessions_total",
+			Help: "login sessions by user and remote host",
+		}, []string{"user", "remote_host"})
+
 	// MCDDrainErr logs failed drain
 	MCDDrainErr = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -71,6 +78,7 @@ var (
 	metricsList = []prometheus.Collector{
 		HostOS,
 		MCDSSHAccessed,
+		MCDLoginSessions,
 		MCDDrainErr,
 		MCDPivotErr,
 		MCDState,
@@ -0,0 +1,478 @@
+package daemon
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	pivotutils "github.com/openshift/machine-config-operator/pkg/daemon/pivot/utils"
+)
+
+const (
+	// OfflineBundleManifestFile is the manifest of an offline bundle, written last
+	// once everything else is in place
+	OfflineBundleManifestFile = "bundle.json"
+
+	// OfflineOSImagesPath records the OS images the node was rebased to from
+	// offline bundles
+	OfflineOSImagesPath = "/etc/machine-config-daemon/offline-os-images.json"
+
+	offlineBundleVersion           = 1
+	offlineBundleMachineConfigFile = "machineconfig.json"
+	offlineBundleOSImageFile       = "os-image.ociarchive"
+	offlineBundleExtensionsFile    = "extensions.ociarchive"
+
+	// ociArchivePrefix is the containers-transports prefix of OCI archives
+	ociArchivePrefix = "oci-archive:"
+)
+
+// OfflineBundleManifest describes the content of an offline bundle: a directory
+// with a rendered MachineConfig, its OS image and, if it needs them, its
+// extensions container as OCI archives.
+type OfflineBundleManifest struct {
+	Version int `json:"version"`
+	// Config is the name of the rendered MachineConfig
+	Config     string `json:"config"`
+	OSImageURL string `json:"osImageURL"`
+	// ExtensionsImage is the extensions container of the config, if bundled
+	ExtensionsImage string `json:"extensionsImage,omitempty"`
+	// Checksums are the sha256 sums of the files of the bundle
+	Checksums map[string]string `json:"checksums"`
+}
+
+// OfflineBundle is an offline bundle whose checksums were verified.
+type OfflineBundle struct {
+	Dir      string
+	Manifest OfflineBundleManifest
+	Config   *mcfgv1.MachineConfig
+}
+
+// osImageRef is the reference of the bundled OS image.
+func (b *OfflineBundle) osImageRef() string {
+	return ociArchivePrefix + filepath.Join(b.Dir, offlineBundleOSImageFile)
+}
+
+// extensionsImageRef is the reference of the bundled extensions container, or
+// empty if the bundle doesn't have one.
+func (b *OfflineBundle) extensionsImageRef() string {
+	if b.Manifest.ExtensionsImage == "" {
+		return ""
+	}
+	return ociArchivePrefix + filepath.Join(b.Dir, offlineBundleExtensionsFile)
+}
+
+// IsOfflineBundle returns whether path is an offline bundle directory.
+func IsOfflineBundle(path string) bool {
+	_, err := os.Stat(filepath.Join(path, OfflineBundleManifestFile))
+	return err == nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyImageToArchive copies imgURL to an OCI archive at path. Manifests are kept as
+// they are, so the archive can be checked against the digest of imgURL when applied.
+func copyImageToArchive(imgURL, path, authFile string) error {
+	args := []string{"copy", "--all", "--preserve-digests"}
+	if authFile != "" {
+		args = append(args, "--authfile", authFile)
+	}
+	args = append(args, "docker://"+imgURL, ociArchivePrefix+path)
+	if _, err := pivotutils.RunExtBackground(numRetriesNetCommands, "skopeo", args...); err != nil {
+		return fmt.Errorf("copying %s to %s: %w", imgURL, path, err)
+	}
+	return nil
+}
+
+// readOCIArchiveFile calls read with the content of the file name in the OCI archive at path.
+func readOCIArchiveFile(path, name string, read func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("OCI archive %s has no %s", path, name)
+		}
+		if err != nil {
+			return fmt.Errorf("reading OCI archive %s: %w", path, err)
+		}
+		if filepath.Clean(hdr.Name) == name {
+			return read(tr)
+		}
+	}
+}
+
+// ociArchiveManifestDigest returns the digest of the manifest, or manifest list, of
+// the image in the OCI archive at path. It is computed from the manifest itself
+// rather than taken from the index of the archive.
+func ociArchiveManifestDigest(path string) (digest.Digest, error) {
+	var index imgspecv1.Index
+	if err := readOCIArchiveFile(path, "index.json", func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&index)
+	}); err != nil {
+		return "", err
+	}
+	if len(index.Manifests) != 1 {
+		return "", fmt.Errorf("OCI archive %s has %d images, expected one", path, len(index.Manifests))
+	}
+	expected := index.Manifests[0].Digest
+	if err := expected.Validate(); err != nil {
+		return "", fmt.Errorf("OCI archive %s: %w", path, err)
+	}
+	var actual digest.Digest
+	if err := readOCIArchiveFile(path, filepath.Join("blobs", expected.Algorithm().String(), expected.Encoded()), func(r io.Reader) error {
+		var err error
+		actual, err = expected.Algorithm().FromReader(r)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	if actual != expected {
+		return "", fmt.Errorf("manifest of OCI archive %s has digest %s, expected %s", path, actual, expected)
+	}
+	return actual, nil
+}
+
+// checkBundledOSImage checks that the OS image archived at path is the one config
+// pins by digest, and that config satisfies its own OS image verification policy.
+// Signatures required by the Signed policy can't be checked offline; see
+// checkOfflineSignaturePolicy.
+func checkBundledOSImage(config *mcfgv1.MachineConfig, path string) error {
+	verification, err := getOSImageVerification(config)
+	if err != nil {
+		return err
+	}
+	if err := ctrlcommon.CheckOSImageURL(config.Spec.OSImageURL, verification); err != nil {
+		return err
+	}
+	return checkArchivedImage(config, config.Spec.OSImageURL, path)
+}
+
+// checkBundledExtensionsImage checks that the extensions container archived at path
+// is the one config pins by digest.
+func checkBundledExtensionsImage(config *mcfgv1.MachineConfig, path string) error {
+	return checkArchivedImage(config, config.Spec.BaseOSExtensionsContainerImage, path)
+}
+
+// checkArchivedImage checks that the image archived at path is imgURL, which config
+// has to pin by digest.
+func checkArchivedImage(config *mcfgv1.MachineConfig, imgURL, path string) error {
+	ref, err := reference.ParseNormalizedNamed(imgURL)
+	if err != nil {
+		return fmt.Errorf("parsing image %q: %w", imgURL, err)
+	}
+	canonical, ok := ref.(reference.Canonical)
+	if !ok {
+		return fmt.Errorf("image %s is not pinned by digest, so the bundled image can't be checked against it", imgURL)
+	}
+	archived, err := ociArchiveManifestDigest(path)
+	if err != nil {
+		return err
+	}
+	if archived != canonical.Digest() {
+		return fmt.Errorf("bundled image has digest %s, but config %s pins %s", archived, config.Name, imgURL)
+	}
+	return nil
+}
+
+// bundledExtensionsImage returns the extensions container config needs offline,
+// or empty if it needs none.
+func bundledExtensionsImage(config *mcfgv1.MachineConfig) (string, error) {
+	if !needsLayeredExtensionsRepo(config, config) {
+		return "", nil
+	}
+	if config.Spec.BaseOSExtensionsContainerImage == "" {
+		return "", fmt.Errorf("config %s has no extensions container to install extensions or switch kernel from", config.Name)
+	}
+	return config.Spec.BaseOSExtensionsContainerImage, nil
+}
+
+// CreateOfflineBundle writes an offline bundle of config to dir. The OS image and
+// extensions container are verified against the OS image verification policy of
+// config before they're bundled, which needs access to the registries of the images.
+func CreateOfflineBundle(config *mcfgv1.MachineConfig, dir, authFile string) error {
+	if config.Spec.OSImageURL == "" {
+		return fmt.Errorf("config %s has no OS image to bundle", config.Name)
+	}
+	if authFile == "" {
+		if _, err := os.Stat(kubeletAuthFile); err == nil {
+			authFile = kubeletAuthFile
+		}
+	}
+	if err := verifyOSImage(config); err != nil {
+		return fmt.Errorf("refusing to bundle OS image %s: %w", config.Spec.OSImageURL, err)
+	}
+	isLayeredImage, err := NewNodeUpdaterClient().IsBootableImage(config.Spec.OSImageURL)
+	if err != nil {
+		return fmt.Errorf("checking type of OS image: %w", err)
+	}
+	if !isLayeredImage {
+		return fmt.Errorf("OS image %s is not bootable; only bootable OS images can be applied offline", config.Spec.OSImageURL)
+	}
+	extensionsImage, err := bundledExtensionsImage(config)
+	if err != nil {
+		return err
+	}
+	if extensionsImage != "" {
+		if err := verifyExtensionsImage(config); err != nil {
+			return fmt.Errorf("refusing to bundle extensions container %s: %w", extensionsImage, err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if IsOfflineBundle(dir) {
+		return fmt.Errorf("%s already contains an offline bundle", dir)
+	}
+	// Clients leave the type of the objects they get empty
+	config = config.DeepCopy()
+	config.TypeMeta = metav1.TypeMeta{Kind: "MachineConfig", APIVersion: mcfgv1.SchemeGroupVersion.String()}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, offlineBundleMachineConfigFile), raw, 0o644); err != nil {
+		return err
+	}
+	glog.Infof("Bundling OS image %s", config.Spec.OSImageURL)
+	if err := copyImageToArchive(config.Spec.OSImageURL, filepath.Join(dir, offlineBundleOSImageFile), authFile); err != nil {
+		return err
+	}
+	if extensionsImage != "" {
+		glog.Infof("Bundling extensions container %s", extensionsImage)
+		if err := copyImageToArchive(extensionsImage, filepath.Join(dir, offlineBundleExtensionsFile), authFile); err != nil {
+			return err
+		}
+	}
+	return writeOfflineBundleManifest(dir, config, extensionsImage)
+}
+
+// writeOfflineBundleManifest checksums the files of the bundle in dir and
+// writes its manifest.
+func writeOfflineBundleManifest(dir string, config *mcfgv1.MachineConfig, extensionsImage string) error {
+	manifest := OfflineBundleManifest{
+		Version:         offlineBundleVersion,
+		Config:          config.Name,
+		OSImageURL:      config.Spec.OSImageURL,
+		ExtensionsImage: extensionsImage,
+		Checksums:       map[string]string{},
+	}
+	files := []string{offlineBundleMachineConfigFile, offlineBundleOSImageFile}
+	if extensionsImage != "" {
+		files = append(files, offlineBundleExtensionsFile)
+	}
+	for _, name := range files {
+		sum, err := sha256File(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		manifest.Checksums[name] = sum
+	}
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, OfflineBundleManifestFile), raw, 0o644)
+}
+
+// LoadOfflineBundle reads the offline bundle in dir and verifies its checksums.
+func LoadOfflineBundle(dir string) (*OfflineBundle, error) {
+	dir, err := filepath.Abs(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, OfflineBundleManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	b := &OfflineBundle{Dir: dir}
+	if err := json.Unmarshal(raw, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("parsing bundle manifest: %w", err)
+	}
+	if b.Manifest.Version != offlineBundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Manifest.Version)
+	}
+
+	required := []string{offlineBundleMachineConfigFile, offlineBundleOSImageFile}
+	if b.Manifest.ExtensionsImage != "" {
+		required = append(required, offlineBundleExtensionsFile)
+	}
+	for _, name := range required {
+		if _, ok := b.Manifest.Checksums[name]; !ok {
+			return nil, fmt.Errorf("bundle has no checksum of %s", name)
+		}
+	}
+	names := make([]string, 0, len(b.Manifest.Checksums))
+	for name := range b.Manifest.Checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sum, err := sha256File(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if sum != b.Manifest.Checksums[name] {
+			return nil, fmt.Errorf("checksum of %s is %s, expected %s", name, sum, b.Manifest.Checksums[name])
+		}
+	}
+
+	raw, err = ioutil.ReadFile(filepath.Join(dir, offlineBundleMachineConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	b.Config = &mcfgv1.MachineConfig{}
+	if err := json.Unmarshal(raw, b.Config); err != nil {
+		return nil, fmt.Errorf("parsing bundled MachineConfig: %w", err)
+	}
+	if b.Config.Name != b.Manifest.Config || b.Config.Spec.OSImageURL != b.Manifest.OSImageURL {
+		return nil, fmt.Errorf("bundled MachineConfig %s (%s) doesn't match the manifest %s (%s)",
+			b.Config.Name, b.Config.Spec.OSImageURL, b.Manifest.Config, b.Manifest.OSImageURL)
+	}
+	extensionsImage, err := bundledExtensionsImage(b.Config)
+	if err != nil {
+		return nil, err
+	}
+	if extensionsImage != "" && b.Manifest.ExtensionsImage == "" {
+		return nil, fmt.Errorf("bundle lacks the extensions container %s of config %s", extensionsImage, b.Config.Name)
+	}
+	return b, nil
+}
+
+// readOfflineOSImages returns the OS image URLs of the bundled OS images the
+// node was rebased to, by reference.
+func readOfflineOSImages(path string) (map[string]string, error) {
+	images := map[string]string{}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return images, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &images); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return images, nil
+}
+
+// recordOfflineOSImage records that ref, a bundled OS image, is osImageURL.
+func recordOfflineOSImage(path, ref, osImageURL string) error {
+	images, err := readOfflineOSImages(path)
+	if err != nil {
+		return err
+	}
+	images[ref] = osImageURL
+	raw, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicallyWithDefaults(path, raw)
+}
+
+// resolveOfflineOSImageURL returns the OS image URL the node booted into. rpm-ostree
+// reports the archives of bundled OS images, which are mapped back to the OS
+// image URL they were bundled from.
+func resolveOfflineOSImageURL(path, bootedOSImageURL string) (string, error) {
+	if !strings.HasPrefix(bootedOSImageURL, ociArchivePrefix) {
+		return bootedOSImageURL, nil
+	}
+	images, err := readOfflineOSImages(path)
+	if err != nil {
+		return "", err
+	}
+	osImageURL, ok := images[bootedOSImageURL]
+	if !ok {
+		return "", fmt.Errorf("booted into unknown bundled OS image %s", bootedOSImageURL)
+	}
+	return osImageURL, nil
+}
+
+// updateOSFromBundle rebases to the bundled OS image of config. The bundle may have
+// been created or altered anywhere, so the archived image is checked against the
+// digest config pins and its OS image verification policy before it's used.
+func (dn *Daemon) updateOSFromBundle(config *mcfgv1.MachineConfig) error {
+	if config.Spec.OSImageURL != dn.offlineBundle.Manifest.OSImageURL {
+		return fmt.Errorf("bundle %s has OS image %s, not %s", dn.offlineBundle.Dir, dn.offlineBundle.Manifest.OSImageURL, config.Spec.OSImageURL)
+	}
+	if err := checkBundledOSImage(config, filepath.Join(dn.offlineBundle.Dir, offlineBundleOSImageFile)); err != nil {
+		return fmt.Errorf("refusing bundled OS image: %w", err)
+	}
+	ref := dn.offlineBundle.osImageRef()
+	glog.Infof("Updating OS to %s from bundled image %s", config.Spec.OSImageURL, ref)
+	if err := dn.NodeUpdaterClient.RebaseLayered(ref); err != nil {
+		return fmt.Errorf("failed to update OS to %s : %w", config.Spec.OSImageURL, err)
+	}
+	return recordOfflineOSImage(dn.offlineOSImagesPath, ref, config.Spec.OSImageURL)
+}
+
+// checkOfflineSignaturePolicy refuses a config whose OS image verification policy
+// requires signatures, which can't be checked offline, unless allowUnsigned is set.
+// The bundle would otherwise only vouch for itself.
+func checkOfflineSignaturePolicy(config *mcfgv1.MachineConfig, allowUnsigned bool) error {
+	verification, err := getOSImageVerification(config)
+	if err != nil {
+		return err
+	}
+	if verification == nil || verification.Policy != mcfgv1.OSImageVerificationSigned {
+		return nil
+	}
+	if !allowUnsigned {
+		return fmt.Errorf("config %s requires signed OS images, whose signatures can't be checked offline; pass --allow-unsigned-bundle to apply it anyway", config.Name)
+	}
+	glog.Warningf("Applying config %s offline without checking the signatures its %s policy requires", config.Name, verification.Policy)
+	return nil
+}
+
+// runOnceFromBundle applies the offline bundle in dir without pulling any image.
+// allowUnsigned applies bundles whose config requires signed OS images.
+func (dn *Daemon) runOnceFromBundle(dir string, allowUnsigned bool) error {
+	if !dn.os.IsCoreOSVariant() {
+		return fmt.Errorf("offline bundles can only be applied to CoreOS nodes")
+	}
+	b, err := LoadOfflineBundle(dir)
+	if err != nil {
+		return fmt.Errorf("loading offline bundle %s: %w", dir, err)
+	}
+	if err := checkOfflineSignaturePolicy(b.Config, allowUnsigned); err != nil {
+		return fmt.Errorf("refusing offline bundle %s: %w", dir, err)
+	}
+	glog.Infof("Applying config %s from offline bundle %s", b.Config.Name, b.Dir)
+	dn.offlineBundle = b
+	defer func() {
+		dn.offlineBundle = nil
+	}()
+	// Execute update without hitting the cluster
+	return dn.update(nil, b.Config)
+}
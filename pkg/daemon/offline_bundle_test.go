@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+// writeTestOfflineBundle writes a bundle of config with fake image archives to a new directory.
+func writeTestOfflineBundle(t *testing.T, config *mcfgv1.MachineConfig, extensionsImage string) string {
+	t.Helper()
+	dir := t.TempDir()
+	raw, err := json.Marshal(config)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, offlineBundleMachineConfigFile), raw, 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, offlineBundleOSImageFile), []byte("os image"), 0o644))
+	if extensionsImage != "" {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, offlineBundleExtensionsFile), []byte("extensions"), 0o644))
+	}
+	require.NoError(t, writeOfflineBundleManifest(dir, config, extensionsImage))
+	return dir
+}
+
+func TestLoadOfflineBundle(t *testing.T) {
+	config := helpers.NewMachineConfig("rendered-1", nil, "registry.example.com/os@sha256:abc", []ign3types.File{})
+	dir := writeTestOfflineBundle(t, config, "")
+	assert.True(t, IsOfflineBundle(dir))
+	assert.False(t, IsOfflineBundle(t.TempDir()))
+
+	b, err := LoadOfflineBundle(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "rendered-1", b.Config.Name)
+	assert.Equal(t, "registry.example.com/os@sha256:abc", b.Config.Spec.OSImageURL)
+	assert.Equal(t, "oci-archive:"+filepath.Join(dir, offlineBundleOSImageFile), b.osImageRef())
+	assert.Equal(t, "", b.extensionsImageRef())
+
+	// corrupted archives are rejected
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, offlineBundleOSImageFile), []byte("corrupted"), 0o644))
+	_, err = LoadOfflineBundle(dir)
+	assert.Error(t, err)
+
+	// configs with extensions need the extensions container
+	config.Spec.Extensions = []string{"usbguard"}
+	config.Spec.BaseOSExtensionsContainerImage = "registry.example.com/extensions@sha256:def"
+	_, err = LoadOfflineBundle(writeTestOfflineBundle(t, config, ""))
+	assert.Error(t, err)
+
+	dir = writeTestOfflineBundle(t, config, config.Spec.BaseOSExtensionsContainerImage)
+	b, err = LoadOfflineBundle(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "oci-archive:"+filepath.Join(dir, offlineBundleExtensionsFile), b.extensionsImageRef())
+}
+
+func TestResolveOfflineOSImageURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline-os-images.json")
+
+	osImageURL, err := resolveOfflineOSImageURL(path, "registry.example.com/os@sha256:abc")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/os@sha256:abc", osImageURL)
+
+	_, err = resolveOfflineOSImageURL(path, "oci-archive:/mnt/usb/os-image.ociarchive")
+	assert.Error(t, err)
+
+	require.NoError(t, recordOfflineOSImage(path, "oci-archive:/mnt/usb/os-image.ociarchive", "registry.example.com/os@sha256:abc"))
+	osImageURL, err = resolveOfflineOSImageURL(path, "oci-archive:/mnt/usb/os-image.ociarchive")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/os@sha256:abc", osImageURL)
+}
+
+// writeTestOCIArchive writes an OCI archive with a single image whose manifest is
+// manifest and whose index claims it has digest indexed.
+func writeTestOCIArchive(t *testing.T, path string, manifest []byte, indexed digest.Digest) {
+	t.Helper()
+	index, err := json.Marshal(imgspecv1.Index{Manifests: []imgspecv1.Descriptor{{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    indexed,
+		Size:      int64(len(manifest)),
+	}}})
+	require.NoError(t, err)
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"oci-layout":                          []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"./blobs/sha256/" + indexed.Encoded(): manifest,
+		"index.json":                          index,
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
+
+func TestCheckBundledOSImage(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), offlineBundleOSImageFile)
+	manifest := []byte(`{"schemaVersion":2}`)
+	manifestDigest := digest.FromBytes(manifest)
+	writeTestOCIArchive(t, archive, manifest, manifestDigest)
+
+	pinned := "registry.example.com/os@" + manifestDigest.String()
+	require.NoError(t, checkBundledOSImage(helpers.NewMachineConfig("rendered-1", nil, pinned, nil), archive))
+
+	// The image has to be pinned by digest, to the archived one
+	assert.Error(t, checkBundledOSImage(helpers.NewMachineConfig("rendered-1", nil, "registry.example.com/os:latest", nil), archive))
+	other := "registry.example.com/os@" + digest.FromString("other").String()
+	assert.Error(t, checkBundledOSImage(helpers.NewMachineConfig("rendered-1", nil, other, nil), archive))
+
+	// The config has to satisfy its own policy
+	config := helpers.NewMachineConfig("rendered-1", nil, pinned, nil)
+	require.NoError(t, ctrlcommon.OSImageVerificationPolicy.Set(config, &mcfgv1.OSImageVerification{
+		Policy:         mcfgv1.OSImageVerificationDigestPinned,
+		AllowedDigests: []string{digest.FromString("other").String()},
+	}))
+	assert.Error(t, checkBundledOSImage(config, archive))
+
+	// The index of the archive isn't trusted
+	writeTestOCIArchive(t, archive, []byte(`{"schemaVersion":2,"tampered":true}`), manifestDigest)
+	assert.Error(t, checkBundledOSImage(helpers.NewMachineConfig("rendered-1", nil, pinned, nil), archive))
+}
+
+func TestCheckOfflineSignaturePolicy(t *testing.T) {
+	config := helpers.NewMachineConfig("rendered-1", nil, "registry.example.com/os@"+digest.FromString("os").String(), nil)
+	require.NoError(t, checkOfflineSignaturePolicy(config, false))
+
+	// Signatures can't be checked offline, so the bundle is refused unless explicitly allowed
+	require.NoError(t, ctrlcommon.OSImageVerificationPolicy.Set(config, &mcfgv1.OSImageVerification{
+		Policy: mcfgv1.OSImageVerificationSigned,
+		Keys:   []mcfgv1.OSImageVerificationKey{{Type: mcfgv1.OSImageVerificationKeyGPG, PublicKey: "key"}},
+	}))
+	assert.Error(t, checkOfflineSignaturePolicy(config, false))
+	assert.NoError(t, checkOfflineSignaturePolicy(config, true))
+}
@@ -142,14 +142,11 @@ func checkV2Units(units []ign2types.Unit, systemdPath string) error {
 // check for overwrites.
 func checkV3Files(files []ign3types.File) error {
 	for _, f := range files {
-		if len(f.Append) > 0 {
-			return fmt.Errorf("found an append section when checking files. Append is not supported")
-		}
 		mode := defaultFilePermissions
 		if f.Mode != nil {
 			mode = os.FileMode(*f.Mode)
 		}
-		contents, err := ctrlcommon.DecodeIgnitionFileContents(f.Contents.Source, f.Contents.Compression)
+		contents, err := resolveFileContents(f)
 		if err != nil {
 			return fmt.Errorf("couldn't decode file %q: %w", f.Path, err)
 		}
@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/golang/glog"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// verifyOSImage checks the OS image of a config against the verification policy the
+// config carries. The render controller already checked what it could, but the config
+// may not have come from it, and only the daemon checks signatures.
+func verifyOSImage(config *mcfgv1.MachineConfig) error {
+	verification, err := getOSImageVerification(config)
+	if err != nil {
+		return err
+	}
+	if err := ctrlcommon.CheckOSImageURL(config.Spec.OSImageURL, verification); err != nil {
+		return err
+	}
+	if verification == nil || verification.Policy != mcfgv1.OSImageVerificationSigned {
+		return nil
+	}
+	if err := verifyOSImageSignature(config.Spec.OSImageURL, verification.Keys); err != nil {
+		return err
+	}
+	glog.Infof("Verified signature of OS image %s", config.Spec.OSImageURL)
+	return nil
+}
+
+// verifyExtensionsImage checks the extensions container of a config. Its repo is
+// installed from without GPG checks, so it has to be pinned by digest, and signed
+// if the OS image verification policy of the config requires signatures. The
+// digests the policy allows are those of OS images and don't apply to it.
+func verifyExtensionsImage(config *mcfgv1.MachineConfig) error {
+	imgURL := config.Spec.BaseOSExtensionsContainerImage
+	pinned, err := ctrlcommon.IsImageDigestPinned(imgURL)
+	if err != nil {
+		return fmt.Errorf("parsing extensions container %q: %w", imgURL, err)
+	}
+	if !pinned {
+		return fmt.Errorf("extensions container %s is not pinned by digest", imgURL)
+	}
+	verification, err := getOSImageVerification(config)
+	if err != nil {
+		return err
+	}
+	if verification == nil || verification.Policy != mcfgv1.OSImageVerificationSigned {
+		return nil
+	}
+	if err := verifyOSImageSignature(imgURL, verification.Keys); err != nil {
+		return err
+	}
+	glog.Infof("Verified signature of extensions container %s", imgURL)
+	return nil
+}
+
+// getOSImageVerification returns the OS image verification policy config carries, or nil.
+func getOSImageVerification(config *mcfgv1.MachineConfig) (*mcfgv1.OSImageVerification, error) {
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+	if err != nil {
+		return nil, err
+	}
+	verification, err := ctrlcommon.OSImageVerificationPolicy.GetFromIgnition(&ignCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctrlcommon.ValidateOSImageVerification(verification); err != nil {
+		return nil, fmt.Errorf("invalid OS image verification policy: %w", err)
+	}
+	return verification, nil
+}
+
+// verifyOSImageSignature checks that an image has a valid signature from any one of keys.
+// Signatures are found through the registries.d configuration of the host.
+func verifyOSImageSignature(imgURL string, keys []mcfgv1.OSImageVerificationKey) error {
+	ctx := context.Background()
+	sys := &types.SystemContext{AuthFilePath: kubeletAuthFile}
+
+	var src types.ImageSource
+	if err := ctrlcommon.RetryIfNecessary(ctx, func() error {
+		var err error
+		src, err = ctrlcommon.NewDockerImageSource(ctx, sys, imgURL)
+		return err
+	}); err != nil {
+		return fmt.Errorf("error parsing image name %q: %w", imgURL, err)
+	}
+	defer src.Close()
+
+	var errs []string
+	for i, key := range keys {
+		err := checkOSImageSignature(ctx, src, key)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("key %d (%s): %v", i, key.Type, err))
+	}
+	return fmt.Errorf("OS image %s is not signed by any trusted key: %s", imgURL, strings.Join(errs, "; "))
+}
+
+func checkOSImageSignature(ctx context.Context, src types.ImageSource, key mcfgv1.OSImageVerificationKey) error {
+	requirement, err := newOSImageSignatureRequirement(key)
+	if err != nil {
+		return err
+	}
+	policy := &signature.Policy{Default: signature.PolicyRequirements{requirement}}
+	pc, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return err
+	}
+	defer pc.Destroy()
+
+	allowed, err := pc.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("signature not accepted")
+	}
+	return nil
+}
+
+// newOSImageSignatureRequirement returns the containers-policy requirement for a
+// signature from key. The signature has to name the repository of the image.
+func newOSImageSignatureRequirement(key mcfgv1.OSImageVerificationKey) (signature.PolicyRequirement, error) {
+	identity := signature.NewPRMMatchRepoDigestOrExact()
+	switch key.Type {
+	case mcfgv1.OSImageVerificationKeyGPG:
+		return signature.NewPRSignedByKeyData(signature.SBKeyTypeGPGKeys, []byte(key.PublicKey), identity)
+	case mcfgv1.OSImageVerificationKeySigstore:
+		return signature.NewPRSigstoreSignedKeyData([]byte(key.PublicKey), identity)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", key.Type)
+	}
+}
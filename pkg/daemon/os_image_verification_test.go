@@ -0,0 +1,29 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestVerifyOSImage(t *testing.T) {
+	pinned := "quay.io/openshift/os@sha256:" + strings.Repeat("a", 64)
+	config := helpers.NewMachineConfig("rendered-worker", nil, "quay.io/openshift/os:4.11", nil)
+
+	// Without a policy anything goes
+	assert.NoError(t, verifyOSImage(config))
+
+	require.NoError(t, ctrlcommon.OSImageVerificationPolicy.Set(config, &mcfgv1.OSImageVerification{Policy: mcfgv1.OSImageVerificationDigestPinned}))
+	assert.Error(t, verifyOSImage(config))
+	config.Spec.OSImageURL = pinned
+	assert.NoError(t, verifyOSImage(config))
+
+	_, err := newOSImageSignatureRequirement(mcfgv1.OSImageVerificationKey{Type: "X509", PublicKey: "key"})
+	assert.Error(t, err)
+}
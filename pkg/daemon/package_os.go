@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// defaultKernelTypePackages are the packages that provide each kernel type, with
+// the one that provides the kernel image first.
+var defaultKernelTypePackages = map[string][]string{
+	ctrlcommon.KernelTypeDefault:  {"kernel-core", "kernel-modules", "kernel-modules-extra", "kernel"},
+	ctrlcommon.KernelTypeRealtime: {"kernel-rt-core", "kernel-rt-modules", "kernel-rt-modules-extra", "kernel-rt-kvm"},
+}
+
+// packageJournal records the package state an update of a package-based host
+// started from, for the update journal to roll it back.
+type packageJournal struct {
+	// Transaction is the last dnf transaction before the update
+	Transaction int                `json:"transaction"`
+	PackageSet  *mcfgv1.PackageSet `json:"packageSet,omitempty"`
+}
+
+// getPackageOS returns the package mapping config carries, or nil.
+func getPackageOS(config *mcfgv1.MachineConfig) (*mcfgv1.PackageOS, error) {
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(config.Spec.Config.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return ctrlcommon.PackageOSPolicy.GetFromIgnition(&ignCfg)
+}
+
+// findPackageSet returns the package set of osImageURL in packageOS, or nil.
+func findPackageSet(packageOS *mcfgv1.PackageOS, osImageURL string) *mcfgv1.PackageSet {
+	if packageOS == nil {
+		return nil
+	}
+	for i := range packageOS.OSImages {
+		if packageOS.OSImages[i].OSImageURL == osImageURL {
+			return &packageOS.OSImages[i]
+		}
+	}
+	return nil
+}
+
+// findPackageMapping returns the packages mappings map name to, or those of defaults.
+func findPackageMapping(mappings []mcfgv1.PackageMapping, defaults map[string][]string, name string) []string {
+	for _, mapping := range mappings {
+		if mapping.Name == name {
+			return mapping.Packages
+		}
+	}
+	return defaults[name]
+}
+
+func extensionPackages(packageOS *mcfgv1.PackageOS, ext string) ([]string, error) {
+	var mappings []mcfgv1.PackageMapping
+	if packageOS != nil {
+		mappings = packageOS.Extensions
+	}
+	pkgs := findPackageMapping(mappings, getSupportedExtensions(), ext)
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages provide extension %q", ext)
+	}
+	return pkgs, nil
+}
+
+func kernelTypePackages(packageOS *mcfgv1.PackageOS, kernelType string) []string {
+	var mappings []mcfgv1.PackageMapping
+	if packageOS != nil {
+		mappings = packageOS.KernelTypes
+	}
+	return findPackageMapping(mappings, defaultKernelTypePackages, canonicalizeKernelType(kernelType))
+}
+
+// planPackageTransaction returns the transaction that moves a package-based host
+// with the installed package set from oldConfig to newConfig.
+func planPackageTransaction(oldConfig, newConfig *mcfgv1.MachineConfig, installed *mcfgv1.PackageSet) (*PackageTransaction, error) {
+	oldPackageOS, err := getPackageOS(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newPackageOS, err := getPackageOS(newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &PackageTransaction{PackageSet: findPackageSet(newPackageOS, newConfig.Spec.OSImageURL)}
+	if t.PackageSet == nil {
+		// the OS packages are left alone
+		t.PackageSet = installed
+	} else if !reflect.DeepEqual(t.PackageSet, installed) {
+		t.Sync = len(t.PackageSet.Repos) > 0
+		t.Install = append(t.Install, t.PackageSet.Packages...)
+	}
+
+	oldExts := sets.NewString(oldConfig.Spec.Extensions...)
+	newExts := sets.NewString(newConfig.Spec.Extensions...)
+	for _, ext := range newConfig.Spec.Extensions {
+		if oldExts.Has(ext) {
+			continue
+		}
+		pkgs, err := extensionPackages(newPackageOS, ext)
+		if err != nil {
+			return nil, err
+		}
+		t.Install = append(t.Install, pkgs...)
+	}
+	for _, ext := range oldConfig.Spec.Extensions {
+		if newExts.Has(ext) {
+			continue
+		}
+		pkgs, err := extensionPackages(oldPackageOS, ext)
+		if err != nil {
+			return nil, err
+		}
+		t.Remove = append(t.Remove, pkgs...)
+	}
+
+	oldKernelType := canonicalizeKernelType(oldConfig.Spec.KernelType)
+	newKernelType := canonicalizeKernelType(newConfig.Spec.KernelType)
+	if oldKernelType != newKernelType {
+		pkgs := kernelTypePackages(newPackageOS, newKernelType)
+		if len(pkgs) == 0 {
+			return nil, fmt.Errorf("no packages provide kernel type %q", newKernelType)
+		}
+		t.Install = append(t.Install, pkgs...)
+		t.DefaultKernelPackage = pkgs[0]
+		// The default kernel stays installed as a fallback
+		if oldKernelType != ctrlcommon.KernelTypeDefault {
+			t.Remove = append(t.Remove, kernelTypePackages(oldPackageOS, oldKernelType)...)
+		}
+	}
+	sort.Strings(t.Remove)
+	return t, nil
+}
+
+// getPackageUpdaterClient returns the client that updates the packages of the
+// host, or nil if it isn't package-based or its pool maps nothing to packages.
+func (dn *Daemon) getPackageUpdaterClient(newConfig *mcfgv1.MachineConfig) (PackageUpdaterClient, error) {
+	client, ok := dn.NodeUpdaterClient.(PackageUpdaterClient)
+	if !ok {
+		return nil, nil
+	}
+	packageOS, err := getPackageOS(newConfig)
+	if err != nil || packageOS == nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// applyPackageOSChanges installs the packages the OS image, extensions and kernel
+// type of newConfig map to on a package-based host. It returns the package state
+// the host started from for rollbackPackageOSChanges, or nil if nothing changed.
+func (dn *Daemon) applyPackageOSChanges(journal *updateJournal, oldConfig, newConfig *mcfgv1.MachineConfig) (*packageJournal, error) {
+	client, err := dn.getPackageUpdaterClient(newConfig)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		glog.Info("updating the OS on non-CoreOS nodes is not supported without a package mapping")
+		return nil, nil
+	}
+	installed, err := client.GetInstalledPackageSet()
+	if err != nil {
+		return nil, err
+	}
+	t, err := planPackageTransaction(oldConfig, newConfig, installed)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsEmpty() && reflect.DeepEqual(t.PackageSet, installed) {
+		return nil, nil
+	}
+	last, err := client.GetLastTransaction()
+	if err != nil {
+		return nil, err
+	}
+
+	// Record where we started before touching any package, so the update
+	// journal can roll back if we die halfway through.
+	pj := &packageJournal{Transaction: last, PackageSet: installed}
+	journal.Packages = pj
+	if err := writeUpdateJournal(dn.updateJournalPath, journal); err != nil {
+		return nil, fmt.Errorf("writing update journal: %w", err)
+	}
+
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "OSUpdateStarted", "Installing packages: sync=%v install=%v remove=%v", t.Sync, t.Install, t.Remove)
+	}
+	dn.logSystem("Applying package transaction: sync=%v install=%v remove=%v default kernel=%q", t.Sync, t.Install, t.Remove, t.DefaultKernelPackage)
+	if err := client.Apply(*t); err != nil {
+		if rbErr := dn.rollbackPackageOSChanges(oldConfig, pj); rbErr != nil {
+			return nil, fmt.Errorf("%v; rolling back packages also failed: %w", err, rbErr)
+		}
+		return nil, fmt.Errorf("applying package transaction: %w", err)
+	}
+	return pj, nil
+}
+
+// rollbackPackageOSChanges undoes the dnf transactions since pj was recorded and
+// restores the package set and default kernel of oldConfig.
+func (dn *Daemon) rollbackPackageOSChanges(oldConfig *mcfgv1.MachineConfig, pj *packageJournal) error {
+	client, ok := dn.NodeUpdaterClient.(PackageUpdaterClient)
+	if !ok {
+		return fmt.Errorf("cannot roll back packages without a package updater")
+	}
+	dn.logSystem("Rolling back packages to dnf transaction %d", pj.Transaction)
+	if err := client.Rollback(pj.Transaction); err != nil {
+		return err
+	}
+	oldPackageOS, err := getPackageOS(oldConfig)
+	if err != nil {
+		return err
+	}
+	var kernelPackage string
+	if pkgs := kernelTypePackages(oldPackageOS, oldConfig.Spec.KernelType); len(pkgs) > 0 {
+		kernelPackage = pkgs[0]
+	}
+	return client.Apply(PackageTransaction{PackageSet: pj.PackageSet, DefaultKernelPackage: kernelPackage})
+}
@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func newPackageOSConfig(t *testing.T, name, osImageURL string, packageOS *mcfgv1.PackageOS, kernelType string, extensions ...string) *mcfgv1.MachineConfig {
+	t.Helper()
+	mc := helpers.NewMachineConfig(name, nil, osImageURL, []ign3types.File{})
+	mc.Spec.KernelType = kernelType
+	mc.Spec.Extensions = extensions
+	require.NoError(t, ctrlcommon.PackageOSPolicy.Set(mc, packageOS))
+	return mc
+}
+
+func TestPlanPackageTransaction(t *testing.T) {
+	snapshot1 := mcfgv1.PackageSet{OSImageURL: "os:1", Repos: []mcfgv1.PackageRepo{{Name: "baseos", BaseURL: "https://mirror.example.com/1"}}}
+	snapshot2 := mcfgv1.PackageSet{OSImageURL: "os:2", Repos: []mcfgv1.PackageRepo{{Name: "baseos", BaseURL: "https://mirror.example.com/2"}}, Packages: []string{"cri-o-1.25.1-5.el8"}}
+	packageOS := &mcfgv1.PackageOS{
+		OSImages:    []mcfgv1.PackageSet{snapshot1, snapshot2},
+		Extensions:  []mcfgv1.PackageMapping{{Name: "usbguard", Packages: []string{"usbguard", "usbguard-selinux"}}},
+		KernelTypes: []mcfgv1.PackageMapping{{Name: ctrlcommon.KernelTypeRealtime, Packages: []string{"kernel-rt-core", "kernel-rt-modules"}}},
+	}
+
+	// OS update
+	old := newPackageOSConfig(t, "rendered-1", "os:1", packageOS, "")
+	tx, err := planPackageTransaction(old, newPackageOSConfig(t, "rendered-2", "os:2", packageOS, ""), &snapshot1)
+	require.NoError(t, err)
+	assert.Equal(t, &PackageTransaction{PackageSet: &snapshot2, Sync: true, Install: []string{"cri-o-1.25.1-5.el8"}}, tx)
+
+	// the package set is installed already
+	tx, err = planPackageTransaction(old, newPackageOSConfig(t, "rendered-2", "os:1", packageOS, ""), &snapshot1)
+	require.NoError(t, err)
+	assert.True(t, tx.IsEmpty())
+	assert.Equal(t, &snapshot1, tx.PackageSet)
+
+	// an OS image without a package set leaves the OS packages alone
+	tx, err = planPackageTransaction(old, newPackageOSConfig(t, "rendered-2", "os:3", packageOS, "", "kerberos"), &snapshot1)
+	require.NoError(t, err)
+	assert.Equal(t, &PackageTransaction{PackageSet: &snapshot1, Install: []string{"krb5-workstation", "libkadm5"}}, tx)
+
+	// extensions and kernel types map to packages, or those of RHCOS
+	old = newPackageOSConfig(t, "rendered-1", "os:1", packageOS, "", "kerberos")
+	tx, err = planPackageTransaction(old, newPackageOSConfig(t, "rendered-2", "os:1", packageOS, ctrlcommon.KernelTypeRealtime, "usbguard"), &snapshot1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"usbguard", "usbguard-selinux", "kernel-rt-core", "kernel-rt-modules"}, tx.Install)
+	assert.Equal(t, []string{"krb5-workstation", "libkadm5"}, tx.Remove)
+	assert.Equal(t, "kernel-rt-core", tx.DefaultKernelPackage)
+
+	old = newPackageOSConfig(t, "rendered-1", "os:1", packageOS, ctrlcommon.KernelTypeRealtime)
+	tx, err = planPackageTransaction(old, newPackageOSConfig(t, "rendered-2", "os:1", packageOS, ""), &snapshot1)
+	require.NoError(t, err)
+	assert.Equal(t, defaultKernelTypePackages[ctrlcommon.KernelTypeDefault], tx.Install)
+	assert.Equal(t, []string{"kernel-rt-core", "kernel-rt-modules"}, tx.Remove)
+	assert.Equal(t, "kernel-core", tx.DefaultKernelPackage)
+
+	_, err = planPackageTransaction(old, newPackageOSConfig(t, "rendered-2", "os:1", packageOS, "", "unknown"), &snapshot1)
+	assert.Error(t, err)
+}
+
+type fakePackageUpdaterClient struct {
+	NodeUpdaterClient
+	installed    *mcfgv1.PackageSet
+	transaction  int
+	applied      []PackageTransaction
+	applyErr     error
+	rolledBackTo []int
+}
+
+func (c *fakePackageUpdaterClient) GetInstalledPackageSet() (*mcfgv1.PackageSet, error) {
+	return c.installed, nil
+}
+
+func (c *fakePackageUpdaterClient) GetLastTransaction() (int, error) {
+	return c.transaction, nil
+}
+
+func (c *fakePackageUpdaterClient) Apply(t PackageTransaction) error {
+	c.applied = append(c.applied, t)
+	if c.applyErr != nil && !t.IsEmpty() && t.DefaultKernelPackage == "" {
+		return c.applyErr
+	}
+	c.installed = t.PackageSet
+	return nil
+}
+
+func (c *fakePackageUpdaterClient) Rollback(id int) error {
+	c.rolledBackTo = append(c.rolledBackTo, id)
+	return nil
+}
+
+func TestApplyPackageOSChanges(t *testing.T) {
+	snapshot1 := mcfgv1.PackageSet{OSImageURL: "os:1", Packages: []string{"cri-o-1.24.3-6.el8"}}
+	snapshot2 := mcfgv1.PackageSet{OSImageURL: "os:2", Packages: []string{"cri-o-1.25.1-5.el8"}}
+	packageOS := &mcfgv1.PackageOS{OSImages: []mcfgv1.PackageSet{snapshot1, snapshot2}}
+	oldConfig := newPackageOSConfig(t, "rendered-1", "os:1", packageOS, "")
+	newConfig := newPackageOSConfig(t, "rendered-2", "os:2", packageOS, "")
+
+	dn := newMockDaemon()
+	dn.updateJournalPath = filepath.Join(t.TempDir(), "update-journal.json")
+	journal := &updateJournal{Phase: journalPhaseApplying, OldConfig: oldConfig, NewConfig: newConfig}
+
+	// hosts that aren't package-based are left alone
+	pj, err := dn.applyPackageOSChanges(journal, oldConfig, newConfig)
+	require.NoError(t, err)
+	assert.Nil(t, pj)
+
+	client := &fakePackageUpdaterClient{installed: &snapshot1, transaction: 7}
+	dn.NodeUpdaterClient = client
+	pj, err = dn.applyPackageOSChanges(journal, oldConfig, newConfig)
+	require.NoError(t, err)
+	assert.Equal(t, &packageJournal{Transaction: 7, PackageSet: &snapshot1}, pj)
+	assert.Equal(t, &snapshot2, client.installed)
+	recorded, err := readUpdateJournal(dn.updateJournalPath)
+	require.NoError(t, err)
+	assert.Equal(t, pj, recorded.Packages)
+
+	// a failed transaction is rolled back
+	client = &fakePackageUpdaterClient{installed: &snapshot1, transaction: 7, applyErr: errors.New("no space left on device")}
+	dn.NodeUpdaterClient = client
+	_, err = dn.applyPackageOSChanges(journal, oldConfig, newConfig)
+	assert.Error(t, err)
+	assert.Equal(t, []int{7}, client.rolledBackTo)
+	assert.Equal(t, &snapshot1, client.installed)
+	assert.Equal(t, "kernel-core", client.applied[len(client.applied)-1].DefaultKernelPackage)
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/golang/glog"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+const (
+	// remoteFileCacheDir holds verified contents of remote file sources, so they
+	// aren't fetched again for validation or rollbacks
+	remoteFileCacheDir = "/var/lib/machine-config-daemon/remote-files"
+
+	// additionalTrustBundlePath is where the cluster's additional trust bundle is
+	// written by templates/common/_base/files/additional-trust-bundle.yaml
+	additionalTrustBundlePath = "/etc/pki/ca-trust/source/anchors/openshift-config-user-ca-bundle.crt"
+)
+
+// newRemoteFileFetcher returns a fetcher for remote file sources. The cluster
+// proxy is injected into the MCD through the proxy environment variables, see
+// the MCD daemonset.
+func newRemoteFileFetcher() (*ctrlcommon.RemoteFileFetcher, error) {
+	trustBundle, err := ioutil.ReadFile(additionalTrustBundlePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading additional trust bundle: %w", err)
+	}
+	return ctrlcommon.NewRemoteFileFetcher(nil, trustBundle, remoteFileCacheDir)
+}
+
+// decodeIgnitionFileContents returns the uncompressed contents of a file,
+// fetching and verifying remote sources.
+func decodeIgnitionFileContents(contents ign3types.Resource) ([]byte, error) {
+	if !ctrlcommon.IsRemoteFileSource(contents.Source) {
+		return ctrlcommon.DecodeIgnitionFileContents(contents.Source, contents.Compression)
+	}
+	fetcher, err := newRemoteFileFetcher()
+	if err != nil {
+		return nil, err
+	}
+	return fetcher.DecodeIgnitionFileContents(contents)
+}
+
+// prefetchRemoteFiles fetches and verifies the remote file sources of the new
+// config into the cache and drops cache entries no longer needed.
+func (dn *Daemon) prefetchRemoteFiles(oldIgn, newIgn *ign3types.Config) error {
+	fetcher, err := newRemoteFileFetcher()
+	if err != nil {
+		return err
+	}
+	if ctrlcommon.HasRemoteFileSources(newIgn) {
+		if err := fetcher.VerifyRemoteFileSources(newIgn); err != nil {
+			return fmt.Errorf("fetching remote file sources: %w", err)
+		}
+	}
+	if err := fetcher.PruneCache(oldIgn, newIgn); err != nil {
+		glog.Warningf("Failed to prune remote file cache: %v", err)
+	}
+	return nil
+}
@@ -343,6 +343,11 @@ func (r *RpmOstreeClient) IsBootableImage(imgURL string) (bool, error) {
 func (r *RpmOstreeClient) RebaseLayered(imgURL string) (err error) {
 	glog.Infof("Executing rebase to %s", imgURL)
 
+	// The OS images of offline bundles are imported from local OCI archives
+	if strings.HasPrefix(imgURL, ociArchivePrefix) {
+		return runRpmOstree("rebase", "--experimental", "ostree-unverified-image:"+imgURL)
+	}
+
 	// For now, just let ostree use the kublet config.json,
 	err = useKubeletConfigSecrets()
 	if err != nil {
@@ -0,0 +1,209 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// NodeStatus is the state of a node as seen by its daemon.
+type NodeStatus struct {
+	Node string `json:"node,omitempty"`
+	// Booted and Staged are the deployments of rpm-ostree based hosts
+	Booted *RpmOstreeDeployment `json:"booted,omitempty"`
+	Staged *RpmOstreeDeployment `json:"staged,omitempty"`
+	// PackageSet is the package set installed on package-based hosts
+	PackageSet *mcfgv1.PackageSet `json:"packageSet,omitempty"`
+	// CurrentConfig, DesiredConfig, State and Reason are the node annotations
+	CurrentConfig string `json:"currentConfig,omitempty"`
+	DesiredConfig string `json:"desiredConfig,omitempty"`
+	State         string `json:"state,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	// CurrentConfigOnDisk is the config the daemon last wrote to disk
+	CurrentConfigOnDisk string `json:"currentConfigOnDisk,omitempty"`
+	// PendingConfig is the config the node is rebooting into, if any
+	PendingConfig string `json:"pendingConfig,omitempty"`
+	// PendingBootID is the boot ID the reboot into PendingConfig started from
+	PendingBootID string       `json:"pendingBootID,omitempty"`
+	BootID        string       `json:"bootID,omitempty"`
+	Drift         *DriftStatus `json:"drift,omitempty"`
+}
+
+// DriftStatus is the result of validating the on-disk state against CurrentConfigOnDisk.
+type DriftStatus struct {
+	Drifted bool   `json:"drifted"`
+	Message string `json:"message,omitempty"`
+}
+
+// StringChange is a field of a MachineConfig that changes between two configs.
+type StringChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MachineConfigDiff is the difference between two MachineConfigs as the daemon
+// applies it.
+type MachineConfigDiff struct {
+	From       string        `json:"from"`
+	To         string        `json:"to"`
+	OSImageURL *StringChange `json:"osImageURL,omitempty"`
+	KernelType *StringChange `json:"kernelType,omitempty"`
+	FIPS       bool          `json:"fips,omitempty"`
+	Passwd     bool          `json:"passwd,omitempty"`
+	// Files and Units are the paths and names added, changed or removed
+	Files                   []string `json:"files,omitempty"`
+	Units                   []string `json:"units,omitempty"`
+	KernelArgumentsAdded    []string `json:"kernelArgumentsAdded,omitempty"`
+	KernelArgumentsRemoved  []string `json:"kernelArgumentsRemoved,omitempty"`
+	KernelArgumentsToDelete []string `json:"kernelArgumentsToDelete,omitempty"`
+	ExtensionsAdded         []string `json:"extensionsAdded,omitempty"`
+	ExtensionsRemoved       []string `json:"extensionsRemoved,omitempty"`
+	// Actions are what the daemon does to complete the update, e.g. reboot
+	Actions []string `json:"actions"`
+	// Unreconcilable says why the daemon can't apply the update in place, if it can't
+	Unreconcilable string `json:"unreconcilable,omitempty"`
+}
+
+// added returns the elements of newList not in oldList, in order.
+func added(oldList, newList []string) []string {
+	old := sets.NewString(oldList...)
+	var ret []string
+	for _, s := range newList {
+		if !old.Has(s) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// unitDiffs returns the names of the units added, changed or removed from oldIgn to newIgn.
+func unitDiffs(oldIgn, newIgn *ign3types.Config) []string {
+	oldUnits := make(map[string]ign3types.Unit)
+	for _, u := range oldIgn.Systemd.Units {
+		oldUnits[u.Name] = u
+	}
+	names := sets.NewString()
+	for _, u := range newIgn.Systemd.Units {
+		old, ok := oldUnits[u.Name]
+		if !ok || !reflect.DeepEqual(old, u) {
+			names.Insert(u.Name)
+		}
+		delete(oldUnits, u.Name)
+	}
+	for name := range oldUnits {
+		names.Insert(name)
+	}
+	return names.List()
+}
+
+// DiffMachineConfigs returns the changes the daemon makes to update a node from
+// oldConfig to newConfig.
+func DiffMachineConfigs(oldConfig, newConfig *mcfgv1.MachineConfig) (*MachineConfigDiff, error) {
+	oldConfig = canonicalizeEmptyMC(oldConfig)
+	mcDiff, err := newMachineConfigDiff(oldConfig, newConfig)
+	if err != nil {
+		return nil, err
+	}
+	oldIgn, err := ctrlcommon.ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Ignition config failed with error: %w", err)
+	}
+	newIgn, err := ctrlcommon.ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Ignition config failed with error: %w", err)
+	}
+
+	files := ctrlcommon.CalculateConfigFileDiffs(&oldIgn, &newIgn)
+	sort.Strings(files)
+	diff := &MachineConfigDiff{
+		From:    oldConfig.GetName(),
+		To:      newConfig.GetName(),
+		FIPS:    mcDiff.fips,
+		Passwd:  mcDiff.passwd,
+		Files:   files,
+		Actions: postConfigChangeActions(mcDiff, files),
+	}
+	if mcDiff.osUpdate {
+		diff.OSImageURL = &StringChange{From: oldConfig.Spec.OSImageURL, To: newConfig.Spec.OSImageURL}
+	}
+	if mcDiff.kernelType {
+		diff.KernelType = &StringChange{From: canonicalizeKernelType(oldConfig.Spec.KernelType), To: canonicalizeKernelType(newConfig.Spec.KernelType)}
+	}
+	if mcDiff.units {
+		diff.Units = unitDiffs(&oldIgn, &newIgn)
+	}
+	if mcDiff.kargs {
+		oldKargs := ctrlcommon.ParseKernelArguments(oldConfig.Spec.KernelArguments)
+		newKargs := ctrlcommon.ParseKernelArguments(newConfig.Spec.KernelArguments)
+		diff.KernelArgumentsAdded = added(oldKargs, newKargs)
+		diff.KernelArgumentsRemoved = added(newKargs, oldKargs)
+		diff.KernelArgumentsToDelete = newConfig.Spec.KernelArgumentsToDelete
+	}
+	if mcDiff.extensions {
+		diff.ExtensionsAdded = added(oldConfig.Spec.Extensions, newConfig.Spec.Extensions)
+		diff.ExtensionsRemoved = added(newConfig.Spec.Extensions, oldConfig.Spec.Extensions)
+	}
+	if _, err := reconcilable(oldConfig, newConfig); err != nil {
+		diff.Unreconcilable = err.Error()
+	}
+	return diff, nil
+}
+
+// DiffConfigOnDisk returns the changes the daemon makes to update the node from
+// the config it last wrote to disk to config.
+func (dn *Daemon) DiffConfigOnDisk(config *mcfgv1.MachineConfig) (*MachineConfigDiff, error) {
+	current, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		return nil, fmt.Errorf("reading current config on disk: %w", err)
+	}
+	return DiffMachineConfigs(current, config)
+}
+
+// GetNodeStatus returns the state of the host and, if node isn't nil, the state
+// the daemon published on it.
+func (dn *Daemon) GetNodeStatus(node *corev1.Node) (*NodeStatus, error) {
+	status := &NodeStatus{BootID: dn.bootID}
+	if node != nil {
+		status.Node = node.Name
+		status.CurrentConfig = node.Annotations[constants.CurrentMachineConfigAnnotationKey]
+		status.DesiredConfig = node.Annotations[constants.DesiredMachineConfigAnnotationKey]
+		status.State = node.Annotations[constants.MachineConfigDaemonStateAnnotationKey]
+		status.Reason = node.Annotations[constants.MachineConfigDaemonReasonAnnotationKey]
+	}
+
+	var err error
+	if client, ok := dn.NodeUpdaterClient.(PackageUpdaterClient); ok {
+		if status.PackageSet, err = client.GetInstalledPackageSet(); err != nil {
+			return nil, fmt.Errorf("reading installed package set: %w", err)
+		}
+	} else if dn.os.IsCoreOSVariant() {
+		if status.Booted, status.Staged, err = dn.NodeUpdaterClient.GetBootedAndStagedDeployment(); err != nil {
+			return nil, fmt.Errorf("reading rpm-ostree status: %w", err)
+		}
+	}
+
+	if status.PendingConfig, status.PendingBootID, err = dn.getPendingConfigNameAndBootID(); err != nil {
+		return nil, fmt.Errorf("reading pending config: %w", err)
+	}
+
+	current, err := dn.getCurrentConfigOnDisk()
+	if err != nil {
+		// The daemon hasn't completed its first run yet
+		return status, nil
+	}
+	status.CurrentConfigOnDisk = current.GetName()
+	status.Drift = &DriftStatus{}
+	if err := dn.validateOnDiskState(current); err != nil {
+		status.Drift.Drifted = true
+		status.Drift.Message = err.Error()
+	}
+	return status, nil
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestDiffMachineConfigs(t *testing.T) {
+	registries1 := ctrlcommon.NewIgnFile("/etc/containers/registries.conf", "registries content 1\n")
+	registries2 := ctrlcommon.NewIgnFile("/etc/containers/registries.conf", "registries content 2\n")
+	random := ctrlcommon.NewIgnFile("/etc/random-reboot-file", "test\n")
+	kubelet := ign3types.Unit{Name: "kubelet.service", Enabled: helpers.BoolToPtr(true), Contents: helpers.StrToPtr("[Service]\nExecStart=/usr/bin/kubelet\n")}
+	kubelet2 := ign3types.Unit{Name: "kubelet.service", Enabled: helpers.BoolToPtr(true), Contents: helpers.StrToPtr("[Service]\nExecStart=/usr/bin/kubelet --v=4\n")}
+	crio := ign3types.Unit{Name: "crio.service", Enabled: helpers.BoolToPtr(true)}
+	foo := ign3types.Unit{Name: "foo.service", Enabled: helpers.BoolToPtr(true)}
+
+	oldConfig := helpers.NewMachineConfigExtended("rendered-1", nil, []ign3types.File{registries1, random}, []ign3types.Unit{kubelet, crio},
+		[]ign3types.SSHAuthorizedKey{}, []string{"usbguard"}, false, []string{"nosmt", "quiet"}, "", "os:1")
+
+	// only a file that crio reloads
+	newConfig := helpers.NewMachineConfigExtended("rendered-2", nil, []ign3types.File{registries2, random}, []ign3types.Unit{kubelet, crio},
+		[]ign3types.SSHAuthorizedKey{}, []string{"usbguard"}, false, []string{"nosmt", "quiet"}, "", "os:1")
+	diff, err := DiffMachineConfigs(oldConfig, newConfig)
+	require.NoError(t, err)
+	assert.Equal(t, &MachineConfigDiff{
+		From:    "rendered-1",
+		To:      "rendered-2",
+		Files:   []string{"/etc/containers/registries.conf"},
+		Actions: []string{postConfigChangeActionReloadCrio},
+	}, diff)
+
+	// everything else
+	newConfig = helpers.NewMachineConfigExtended("rendered-3", nil, []ign3types.File{registries1}, []ign3types.Unit{kubelet2, foo},
+		[]ign3types.SSHAuthorizedKey{}, []string{"kerberos"}, false, []string{"quiet", "mitigations=off"}, ctrlcommon.KernelTypeRealtime, "os:2")
+	newConfig.Spec.KernelArgumentsToDelete = []string{"nosmt"}
+	diff, err = DiffMachineConfigs(oldConfig, newConfig)
+	require.NoError(t, err)
+	assert.Equal(t, &MachineConfigDiff{
+		From:                    "rendered-1",
+		To:                      "rendered-3",
+		OSImageURL:              &StringChange{From: "os:1", To: "os:2"},
+		KernelType:              &StringChange{From: ctrlcommon.KernelTypeDefault, To: ctrlcommon.KernelTypeRealtime},
+		Files:                   []string{"/etc/random-reboot-file"},
+		Units:                   []string{"crio.service", "foo.service", "kubelet.service"},
+		KernelArgumentsAdded:    []string{"mitigations=off"},
+		KernelArgumentsRemoved:  []string{"nosmt"},
+		KernelArgumentsToDelete: []string{"nosmt"},
+		ExtensionsAdded:         []string{"kerberos"},
+		ExtensionsRemoved:       []string{"usbguard"},
+		Actions:                 []string{postConfigChangeActionReboot},
+	}, diff)
+
+	// changes the daemon can't apply in place
+	ignCfg := ctrlcommon.NewIgnConfig()
+	ignCfg.Passwd.Groups = []ign3types.PasswdGroup{{Name: "wheel"}}
+	newConfig = helpers.CreateMachineConfigFromIgnition(ignCfg)
+	newConfig.Name = "rendered-4"
+	diff, err = DiffMachineConfigs(oldConfig, newConfig)
+	require.NoError(t, err)
+	assert.True(t, diff.Passwd)
+	assert.Equal(t, "ignition Passwd Groups section contains changes", diff.Unreconcilable)
+}
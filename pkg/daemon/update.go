@@ -43,8 +43,9 @@ const (
 	coreUserSSHPath = "/home/core/.ssh/"
 	// fipsFile is the file to check if FIPS is enabled
 	fipsFile              = "/proc/sys/crypto/fips_enabled"
-	extensionsRepo        = "/etc/yum.repos.d/coreos-extensions.repo"
 	osImageContentBaseDir = "/run/mco-machine-os-content/"
+	// layeredExtensionsRepoDir is where the extensions container keeps its repo
+	layeredExtensionsRepoDir = "/usr/share/rpm-ostree/extensions/"
 
 	// These are the actions for a node to take after applying config changes. (e.g. a new machineconfig is applied)
 	// "None" means no special action needs to be taken
@@ -63,6 +64,9 @@ const (
 var (
 	origParentDirPath   = filepath.Join("/etc", "machine-config-daemon", "orig")
 	noOrigParentDirPath = filepath.Join("/etc", "machine-config-daemon", "noorig")
+	extensionsRepo      = "/etc/yum.repos.d/coreos-extensions.repo"
+	// extractOSImage extracts the content of OS images and extensions containers
+	extractOSImage = ExtractOSImage
 )
 
 func writeFileAtomicallyWithDefaults(fpath string, b []byte) error {
@@ -118,6 +122,9 @@ func reloadService(name string) error {
 // If at any point an error occurs, we reboot the node so that node has correct configuration.
 func (dn *Daemon) performPostConfigChangeAction(postConfigChangeActions []string, configName string) error {
 	if ctrlcommon.InSlice(postConfigChangeActionReboot, postConfigChangeActions) {
+		now := metav1.Now()
+		dn.updateInProgressHistory(configName, func(e *UpdateHistoryEntry) { e.RebootTime = &now })
+		dn.setUpdatePhase(constants.UpdatePhaseRebooting)
 		dn.logSystem("Rebooting node")
 		return dn.reboot(fmt.Sprintf("Node will reboot into config %s", configName))
 	}
@@ -231,7 +238,17 @@ func (dn *Daemon) compareMachineConfig(oldConfig, newConfig *mcfgv1.MachineConfi
 // addExtensionsRepo adds a repo into /etc/yum.repos.d/ which we use later to
 // install extensions and rt-kernel
 func addExtensionsRepo(osImageContentDir string) error {
-	repoContent := "[coreos-extensions]\nenabled=1\nmetadata_expire=1m\nbaseurl=" + osImageContentDir + "/extensions/\ngpgcheck=0\nskip_if_unavailable=False\n"
+	return writeExtensionsRepo(osImageContentDir + "/extensions/")
+}
+
+// addLayeredExtensionsRepo adds the repo of an extracted extensions container,
+// which layered images install extensions and rt-kernel from
+func addLayeredExtensionsRepo(osExtensionsContentDir string) error {
+	return writeExtensionsRepo(osExtensionsContentDir + layeredExtensionsRepoDir)
+}
+
+func writeExtensionsRepo(baseURL string) error {
+	repoContent := "[coreos-extensions]\nenabled=1\nmetadata_expire=1m\nbaseurl=" + baseURL + "\ngpgcheck=0\nskip_if_unavailable=False\n"
 	if err := writeFileAtomicallyWithDefaults(extensionsRepo, []byte(repoContent)); err != nil {
 		return err
 	}
@@ -309,6 +326,12 @@ func ExtractOSImage(imgURL string) (osImageContentDir string, err error) {
 		return
 	}
 
+	// oc can't read the OCI archives of offline bundles
+	if strings.HasPrefix(imgURL, ociArchivePrefix) {
+		err = podmanCopy(imgURL, osImageContentDir)
+		return
+	}
+
 	// Extract the image
 	args := []string{"image", "extract", "--path", "/:" + osImageContentDir}
 	args = append(args, registryConfig...)
@@ -327,7 +350,36 @@ func ExtractOSImage(imgURL string) (osImageContentDir string, err error) {
 
 // Remove pending deployment on OSTree based system
 func removePendingDeployment() error {
-	return runRpmOstree("cleanup", "-p")
+	if err := runRpmOstree("cleanup", "-p"); err != nil {
+		return err
+	}
+	return unlockStagedDeployment()
+}
+
+// stagedDeploymentLockPath is checked by ostree-finalize-staged.service at
+// shutdown: while it exists, the staged deployment is not finalized and the
+// next boot is into the booted deployment again. It lives in /run, so a
+// reboot drops it.
+const stagedDeploymentLockPath = "/run/ostree/staged-deployment-locked"
+
+// lockStagedDeployment keeps a shutdown from booting into the staged
+// deployment until unlockStagedDeployment is called.
+func lockStagedDeployment() error {
+	if err := os.MkdirAll(filepath.Dir(stagedDeploymentLockPath), 0o755); err != nil {
+		return fmt.Errorf("locking staged deployment: %w", err)
+	}
+	if err := ioutil.WriteFile(stagedDeploymentLockPath, nil, 0o644); err != nil {
+		return fmt.Errorf("locking staged deployment: %w", err)
+	}
+	return nil
+}
+
+// unlockStagedDeployment lets the next shutdown finalize the staged deployment.
+func unlockStagedDeployment() error {
+	if err := os.Remove(stagedDeploymentLockPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unlocking staged deployment: %w", err)
+	}
+	return nil
 }
 
 func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) (retErr error) {
@@ -338,20 +390,17 @@ func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newC
 	}
 
 	if mcDiff.osUpdate || mcDiff.extensions || mcDiff.kernelType {
-		// When we're going to apply an OS update, switch the block
-		// scheduler to BFQ to apply more fairness between etcd
-		// and the OS update. Only do this on masters since etcd
-		// only operates on masters, and RHEL compute nodes can't
-		// do this.
+		// When we're going to apply an OS update, apply the I/O tuning
+		// of the new config; by default, switch the block scheduler of
+		// masters to BFQ to apply more fairness between etcd and the OS
+		// update. RHEL compute nodes can't do this.
 		// Add nil check since firstboot also goes through this path,
 		// which doesn't have a node object yet.
 		// This is okay because we know if we made it here, we are going
 		// to reboot and this setting does not persist across reboots.
 		if dn.node != nil {
-			if _, isControlPlane := dn.node.Labels[ctrlcommon.MasterLabel]; isControlPlane {
-				if err := setRootDeviceSchedulerBFQ(); err != nil {
-					return err
-				}
+			if err := dn.applyIOTuning(newConfig, true); err != nil {
+				return err
 			}
 		}
 		// We emitted this event before, so keep it
@@ -361,10 +410,15 @@ func (dn *CoreOSDaemon) applyOSChanges(mcDiff machineConfigDiff, oldConfig, newC
 
 	}
 
-	// The steps from here on are different depending on the image type, so check the image type
-	isLayeredImage, err := dn.NodeUpdaterClient.IsBootableImage(newConfig.Spec.OSImageURL)
-	if err != nil {
-		return fmt.Errorf("Error checking type of update image: %w", err)
+	// The steps from here on are different depending on the image type, so check the image type.
+	// Offline bundles only carry bootable images.
+	isLayeredImage := true
+	if dn.offlineBundle == nil {
+		var err error
+		isLayeredImage, err = dn.NodeUpdaterClient.IsBootableImage(newConfig.Spec.OSImageURL)
+		if err != nil {
+			return fmt.Errorf("Error checking type of update image: %w", err)
+		}
 	}
 
 	if isLayeredImage {
@@ -420,6 +474,14 @@ func calculatePostConfigChangeActionFromFileDiffs(diffFileSet []string) (actions
 	filesPostConfigChangeActionNone := []string{
 		"/etc/kubernetes/kubelet-ca.crt",
 		"/var/lib/kubelet/config.json",
+		// Only read by the daemon before it rebases
+		ctrlcommon.OSImageVerificationPath,
+		// Read by the daemon whenever it tunes kernel arguments
+		ctrlcommon.KernelArgumentTuningPath,
+		// Applied by the daemon once the files are written
+		ctrlcommon.IOTuningPath,
+		// Read by the daemon whenever a login session starts
+		ctrlcommon.InteractiveAccessPolicyPath,
 	}
 	filesPostConfigChangeActionReloadCrio := []string{
 		constants.ContainerRegistryConfPath,
@@ -441,6 +503,27 @@ func calculatePostConfigChangeActionFromFileDiffs(diffFileSet []string) (actions
 	return
 }
 
+// osStagingDependsOnFiles returns true if the diff touches a file that
+// pulling the OS image or the rpm-ostree transactions read: the pull secret,
+// the registries and signature configuration, the proxy settings and the
+// additional CA bundle. The OS changes then need to be staged after the files
+// were written.
+func osStagingDependsOnFiles(diffFileSet []string) bool {
+	stagingFiles := []string{
+		kubeletAuthFile,
+		constants.ContainerRegistryConfPath,
+		"/etc/containers/policy.json",
+		"/etc/mco/proxy.env",
+		additionalTrustBundlePath,
+	}
+	for _, path := range diffFileSet {
+		if ctrlcommon.InSlice(path, stagingFiles) || strings.HasPrefix(path, "/etc/containers/registries.d/") {
+			return true
+		}
+	}
+	return false
+}
+
 func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []string) ([]string, error) {
 	// If a machine-config-daemon-force file is present, it means the user wants to
 	// move to desired state without additional validation. We will reboot the node in
@@ -453,13 +536,19 @@ func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []stri
 		return []string{postConfigChangeActionReboot}, nil
 	}
 
+	return postConfigChangeActions(diff, diffFileSet), nil
+}
+
+// postConfigChangeActions returns the actions that complete an update with
+// diff, ignoring the force file.
+func postConfigChangeActions(diff *machineConfigDiff, diffFileSet []string) []string {
 	if diff.osUpdate || diff.kargs || diff.fips || diff.units || diff.kernelType || diff.extensions {
 		// must reboot
-		return []string{postConfigChangeActionReboot}, nil
+		return []string{postConfigChangeActionReboot}
 	}
 
 	// We don't actually have to consider ssh keys changes, which is the only section of passwd that is allowed to change
-	return calculatePostConfigChangeActionFromFileDiffs(diffFileSet), nil
+	return calculatePostConfigChangeActionFromFileDiffs(diffFileSet)
 }
 
 // update the node to the provided node configuration.
@@ -488,6 +577,14 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 	oldConfigName := oldConfig.GetName()
 	newConfigName := newConfig.GetName()
 
+	dn.startUpdateHistory(oldConfigName, newConfigName)
+	dn.resetUpdatePhase()
+	defer func() {
+		if retErr != nil {
+			dn.finishUpdateHistory(newConfigName, UpdateOutcomeFailed, retErr.Error())
+		}
+	}()
+
 	oldIgnConfig, err := ctrlcommon.ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
 	if err != nil {
 		return fmt.Errorf("parsing old Ignition config failed: %w", err)
@@ -517,6 +614,76 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 	if err != nil {
 		return err
 	}
+	dn.updateInProgressHistory(newConfigName, func(e *UpdateHistoryEntry) { e.Actions = actions })
+
+	// Fetch remote file sources before draining, so an unreachable server or a
+	// hash mismatch doesn't leave the node drained
+	if err := dn.prefetchRemoteFiles(&oldIgnConfig, &newIgnConfig); err != nil {
+		return err
+	}
+
+	// Record the update in the journal before staging OS changes or touching
+	// any file, so it can be rolled back or forward if we die halfway through.
+	journal, err := dn.beginUpdateJournal(oldConfig, newConfig, &oldIgnConfig, &newIgnConfig)
+	if err != nil {
+		return err
+	}
+	var rollbackFailed bool
+	// This runs after all the rollbacks below. Keep the journal if one of them
+	// failed so the next start retries the rollback.
+	defer func() {
+		if rollbackFailed {
+			return
+		}
+		if err := dn.clearUpdateJournal(); err != nil {
+			glog.Warningf("%v", err)
+		}
+	}()
+
+	coreOSDaemon := CoreOSDaemon{dn}
+	rollbackOSChanges := func() {
+		if retErr != nil {
+			if err := coreOSDaemon.applyOSChanges(*diff, newConfig, oldConfig); err != nil {
+				rollbackFailed = true
+				errs := kubeErrs.NewAggregate([]error{err, retErr})
+				retErr = fmt.Errorf("error rolling back changes to OS: %w", errs)
+				return
+			}
+		}
+	}
+
+	// Stage the OS changes before draining, so pulling the OS image and the
+	// rpm-ostree transactions happen while the node still runs its workloads,
+	// and a failure leaves the node undrained. The pull reads the pull
+	// secret, registries, proxy and CA configuration on disk, so if the update
+	// changes any of them the OS changes are staged after the files were
+	// written, as before.
+	// The staged deployment is locked until the update is committed, so a
+	// shutdown during the drain doesn't boot the new OS with the old files.
+	// rpm-ostree stages deployments unlocked, so the lock is taken once all
+	// OS changes are staged.
+	osStaged := false
+	if dn.os.IsCoreOSVariant() && !osStagingDependsOnFiles(diffFileSet) {
+		if err := dn.stageOSChanges(diff, oldConfig, newConfig); err != nil {
+			return err
+		}
+		osStaged = true
+		// The staged deployment isn't booted before the reboot, so dropping it
+		// undoes the OS changes
+		defer func() {
+			if retErr != nil {
+				if err := removePendingDeployment(); err != nil {
+					rollbackFailed = true
+					errs := kubeErrs.NewAggregate([]error{err, retErr})
+					retErr = fmt.Errorf("error removing staged deployment: %w", errs)
+					return
+				}
+			}
+		}()
+		if err := lockStagedDeployment(); err != nil {
+			return err
+		}
+	}
 
 	// Check and perform node drain if required
 	drain, err := isDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
@@ -524,9 +691,13 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 		return err
 	}
 	if drain {
+		drainStart := time.Now()
 		if err := dn.performDrain(); err != nil {
 			return err
 		}
+		drainDuration := time.Since(drainStart).Round(time.Second)
+		dn.updateInProgressHistory(newConfigName, func(e *UpdateHistoryEntry) { e.DrainDuration = &metav1.Duration{Duration: drainDuration} })
+		dn.setUpdatePhase(constants.UpdatePhaseDrained)
 	} else {
 		glog.Info("Changes do not require drain, skipping.")
 	}
@@ -539,6 +710,7 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 	defer func() {
 		if retErr != nil {
 			if err := dn.updateFiles(newIgnConfig, oldIgnConfig); err != nil {
+				rollbackFailed = true
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back files writes: %w", errs)
 				return
@@ -553,6 +725,7 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 	defer func() {
 		if retErr != nil {
 			if err := dn.updateSSHKeys(oldIgnConfig.Passwd.Users); err != nil {
+				rollbackFailed = true
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back SSH keys updates: %w", errs)
 				return
@@ -560,29 +733,56 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 		}
 	}()
 
+	dn.setUpdatePhase(constants.UpdatePhaseFilesWritten)
+
 	if dn.os.IsCoreOSVariant() {
-		coreOSDaemon := CoreOSDaemon{dn}
-		if err := coreOSDaemon.applyOSChanges(*diff, oldConfig, newConfig); err != nil {
+		if !osStaged {
+			if err := dn.stageOSChanges(diff, oldConfig, newConfig); err != nil {
+				return err
+			}
+			defer rollbackOSChanges()
+		}
+	} else {
+		// Package-based hosts install the packages the OS fields map to right
+		// away, now that the node is drained
+		pj, err := dn.applyPackageOSChanges(journal, oldConfig, newConfig)
+		if err != nil {
 			return err
 		}
+		if pj != nil {
+			defer func() {
+				if retErr != nil {
+					if err := dn.rollbackPackageOSChanges(oldConfig, pj); err != nil {
+						rollbackFailed = true
+						errs := kubeErrs.NewAggregate([]error{err, retErr})
+						retErr = fmt.Errorf("error rolling back packages: %w", errs)
+						return
+					}
+				}
+			}()
+		}
+	}
 
+	// Kernel arguments tuned on this node alone, on top of the MachineConfigs
+	if err := dn.stageKernelArgumentTuning(newConfig); err != nil {
+		return err
+	}
+
+	// I/O tuning doesn't need a reboot, apply a change right away
+	if ctrlcommon.InSlice(ctrlcommon.IOTuningPath, diffFileSet) {
+		if err := dn.applyIOTuning(newConfig, false); err != nil {
+			return fmt.Errorf("applying I/O tuning: %w", err)
+		}
 		defer func() {
 			if retErr != nil {
-				if err := coreOSDaemon.applyOSChanges(*diff, newConfig, oldConfig); err != nil {
+				if err := dn.applyIOTuning(oldConfig, false); err != nil {
+					rollbackFailed = true
 					errs := kubeErrs.NewAggregate([]error{err, retErr})
-					retErr = fmt.Errorf("error rolling back changes to OS: %w", errs)
+					retErr = fmt.Errorf("error rolling back I/O tuning: %w", errs)
 					return
 				}
 			}
 		}()
-	} else {
-		glog.Info("updating the OS on non-CoreOS nodes is not supported")
-	}
-
-	// Ideally we would want to update kernelArguments only via MachineConfigs.
-	// We are keeping this to maintain compatibility and OKD requirement.
-	if err := UpdateTuningArgs(KernelTuningFile, CmdLineFile); err != nil {
-		return err
 	}
 
 	// At this point, we write the now expected to be "current" config to /etc.
@@ -594,6 +794,7 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 	defer func() {
 		if retErr != nil {
 			if err := dn.storeCurrentConfigOnDisk(oldConfig); err != nil {
+				rollbackFailed = true
 				errs := kubeErrs.NewAggregate([]error{err, retErr})
 				retErr = fmt.Errorf("error rolling back current config on disk: %w", errs)
 				return
@@ -601,6 +802,24 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 		}
 	}()
 
+	// From here on an interrupted update is rolled forward on the next start.
+	if err := dn.setUpdateJournalPhase(journal, journalPhaseCommitted); err != nil {
+		return err
+	}
+	if osStaged {
+		if err := unlockStagedDeployment(); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if retErr != nil {
+			// runs before the rollbacks above
+			if err := dn.setUpdateJournalPhase(journal, journalPhaseRollingBack); err != nil {
+				glog.Warningf("%v", err)
+			}
+		}
+	}()
+
 	if err := dn.finalizeBeforeReboot(newConfig); err != nil {
 		return err
 	}
@@ -608,6 +827,20 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) (retErr err
 	return dn.performPostConfigChangeAction(actions, newConfig.GetName())
 }
 
+// stageOSChanges stages the OS, kernel argument and extension changes of an
+// update with rpm-ostree and reports the node as staged.
+func (dn *Daemon) stageOSChanges(diff *machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	stageStart := time.Now()
+	coreOSDaemon := CoreOSDaemon{dn}
+	if err := coreOSDaemon.applyOSChanges(*diff, oldConfig, newConfig); err != nil {
+		return err
+	}
+	stageDuration := time.Since(stageStart).Round(time.Second)
+	dn.updateInProgressHistory(newConfig.GetName(), func(e *UpdateHistoryEntry) { e.StageDuration = &metav1.Duration{Duration: stageDuration} })
+	dn.setUpdatePhase(constants.UpdatePhaseOSUpdateStaged)
+	return nil
+}
+
 // This is currently a subsection copied over from update() since we need to be more nuanced. Should eventually
 // de-dupe the functions.
 func (dn *Daemon) updateHypershift(oldConfig, newConfig *mcfgv1.MachineConfig, diff *machineConfigDiff) (retErr error) {
@@ -668,7 +901,7 @@ func (dn *Daemon) updateHypershift(oldConfig, newConfig *mcfgv1.MachineConfig, d
 		glog.Info("updating the OS on non-CoreOS nodes is not supported")
 	}
 
-	if err := UpdateTuningArgs(KernelTuningFile, CmdLineFile); err != nil {
+	if err := dn.stageKernelArgumentTuning(newConfig); err != nil {
 		return err
 	}
 
@@ -755,12 +988,15 @@ func newMachineConfigDiff(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineC
 
 	// Both nil and empty slices are of zero length,
 	// consider them as equal while comparing KernelArguments in both MachineConfigs
-	kargsEmpty := len(oldConfig.Spec.KernelArguments) == 0 && len(newConfig.Spec.KernelArguments) == 0
+	kargsEmpty := len(oldConfig.Spec.KernelArguments) == 0 && len(newConfig.Spec.KernelArguments) == 0 &&
+		len(oldConfig.Spec.KernelArgumentsToDelete) == 0 && len(newConfig.Spec.KernelArgumentsToDelete) == 0
+	kargsEqual := reflect.DeepEqual(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments) &&
+		reflect.DeepEqual(oldConfig.Spec.KernelArgumentsToDelete, newConfig.Spec.KernelArgumentsToDelete)
 	extensionsEmpty := len(oldConfig.Spec.Extensions) == 0 && len(newConfig.Spec.Extensions) == 0
 
 	return &machineConfigDiff{
 		osUpdate:   oldConfig.Spec.OSImageURL != newConfig.Spec.OSImageURL,
-		kargs:      !(kargsEmpty || reflect.DeepEqual(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments)),
+		kargs:      !(kargsEmpty || kargsEqual),
 		fips:       oldConfig.Spec.FIPS != newConfig.Spec.FIPS,
 		passwd:     !reflect.DeepEqual(oldIgn.Passwd, newIgn.Passwd),
 		files:      !reflect.DeepEqual(oldIgn.Storage.Files, newIgn.Storage.Files),
@@ -848,13 +1084,8 @@ func reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDif
 		}
 	}
 
-	// Special case files append: if the new config wants us to append, then we
-	// have to force a reprovision since it's not idempotent
-	for _, f := range newIgn.Storage.Files {
-		if len(f.Append) > 0 {
-			return nil, fmt.Errorf("ignition file %v includes append", f.Path)
-		}
-	}
+	// Files with append fragments are fine: writeFiles derives them from their
+	// base contents every time, so applying them again is idempotent.
 
 	// Systemd section
 
@@ -928,87 +1159,105 @@ func checkFIPS(current, desired *mcfgv1.MachineConfig) error {
 	return fmt.Errorf("detected change to FIPS flag; refusing to modify FIPS on a running cluster")
 }
 
-// checks for white-space characters in "C" and "POSIX" locales.
-func isSpace(b byte) bool {
-	return b == ' ' || b == '\f' || b == '\n' || b == '\r' || b == '\t' || b == '\v'
-}
+// generateKargs performs a diff between the old/new MC kernel arguments and the
+// kernel command line the node is currently booted with, and generates the
+// command line arguments suitable for `rpm-ostree kargs`.
+//
+// The leading arguments that the old and new configs have in common and that
+// are still present on the command line are left alone. Everything the old
+// config appended after that is deleted and everything the new config has
+// after that is appended, so that ordering-sensitive arguments such as
+// hugepagesz/hugepages pairs keep their relative order.
+// See https://bugzilla.redhat.com/show_bug.cgi?id=1866546#c10.
+// Arguments listed in the new config's KernelArgumentsToDelete are removed
+// from the command line as well, whether or not the MCO added them.
+func generateKargs(oldConfig, newConfig *mcfgv1.MachineConfig, cmdline []string) []string {
+	oldKargs := ctrlcommon.ParseKernelArguments(oldConfig.Spec.KernelArguments)
+	newKargs := ctrlcommon.ParseKernelArguments(newConfig.Spec.KernelArguments)
+	cmdArgs := []string{}
 
-// You can use " around spaces, but can't escape ". See next_arg() in kernel code /lib/cmdline.c
-// Gives the start and stop index for the next arg in the string, beyond the provided `begin` index
-func nextArg(args string, begin int) (int, int) {
-	var (
-		start, stop int
-		inQuote     bool
-	)
-	// Skip leading spaces
-	for start = begin; start < len(args) && isSpace(args[start]); start++ {
+	present := map[string]int{}
+	for _, arg := range cmdline {
+		present[arg]++
 	}
-	stop = start
-	for ; stop < len(args); stop++ {
-		if isSpace(args[stop]) && !inQuote {
-			break
-		}
 
-		if args[stop] == '"' {
-			inQuote = !inQuote
+	// Find the common prefix that can be kept as-is. It must be fully present
+	// on the command line, and none of its arguments may also appear in the
+	// part we delete, since rpm-ostree cannot tell duplicates apart.
+	prefix := 0
+	seen := map[string]int{}
+	for prefix < len(oldKargs) && prefix < len(newKargs) && oldKargs[prefix] == newKargs[prefix] {
+		arg := oldKargs[prefix]
+		if seen[arg] >= present[arg] {
+			break
 		}
+		seen[arg]++
+		prefix++
 	}
-
-	return start, stop
-}
-
-func splitKernelArguments(args string) []string {
-	var (
-		start, stop int
-		split       []string
-	)
-	for stop < len(args) {
-		start, stop = nextArg(args, stop)
-		if start != stop {
-			split = append(split, args[start:stop])
+	for prefix > 0 {
+		conflict := false
+		for _, arg := range oldKargs[prefix:] {
+			if ctrlcommon.InSlice(arg, oldKargs[:prefix]) {
+				conflict = true
+				break
+			}
 		}
-	}
-	return split
-}
-
-// parseKernelArguments separates out kargs from each entry and returns it as a map for
-// easy comparison
-func parseKernelArguments(kargs []string) []string {
-	parsed := []string{}
-	for _, k := range kargs {
-		for _, arg := range splitKernelArguments(k) {
-			parsed = append(parsed, strings.TrimSpace(arg))
+		for _, del := range newConfig.Spec.KernelArgumentsToDelete {
+			for _, arg := range oldKargs[:prefix] {
+				if ctrlcommon.KernelArgumentMatchesDeletion(arg, del) {
+					conflict = true
+				}
+			}
 		}
+		if !conflict {
+			break
+		}
+		prefix--
+	}
+	for _, arg := range oldKargs[:prefix] {
+		present[arg]--
 	}
-	return parsed
-}
-
-// generateKargs performs a diff between the old/new MC kernelArguments,
-// and generates the command line arguments suitable for `rpm-ostree kargs`.
-// Note what we really should be doing though is also looking at the *current*
-// kernel arguments in case there was drift.  But doing that requires us knowing
-// what the "base" arguments are. See https://github.com/ostreedev/ostree/issues/479
-func generateKargs(oldKernelArguments, newKernelArguments []string) []string {
-	oldKargs := parseKernelArguments(oldKernelArguments)
-	newKargs := parseKernelArguments(newKernelArguments)
-	cmdArgs := []string{}
 
-	// To keep kernel argument processing simpler and bug free, we first delete all
-	// kernel arguments which have been applied by MCO previously and append all of the
-	// kernel arguments present in the new rendered MachineConfig.
-	// See https://bugzilla.redhat.com/show_bug.cgi?id=1866546#c10.
-	for _, arg := range oldKargs {
+	deleteArg := func(arg string) {
+		if present[arg] <= 0 {
+			glog.Infof("Kernel argument %q is not present on the booted command line, skipping deletion", arg)
+			return
+		}
+		present[arg]--
 		cmdArgs = append(cmdArgs, "--delete="+arg)
 	}
-	for _, arg := range newKargs {
+	for _, arg := range oldKargs[prefix:] {
+		deleteArg(arg)
+	}
+	for _, del := range newConfig.Spec.KernelArgumentsToDelete {
+		for _, arg := range cmdline {
+			if ctrlcommon.KernelArgumentMatchesDeletion(arg, del) && present[arg] > 0 {
+				deleteArg(arg)
+			}
+		}
+	}
+	for _, arg := range newKargs[prefix:] {
 		cmdArgs = append(cmdArgs, "--append="+arg)
 	}
 	return cmdArgs
 }
 
+// readKernelCmdline returns the arguments of the booted kernel command line.
+func readKernelCmdline() ([]string, error) {
+	content, err := ioutil.ReadFile(CmdLineFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", CmdLineFile, err)
+	}
+	return ctrlcommon.SplitKernelArguments(strings.TrimSpace(string(content))), nil
+}
+
 // updateKernelArguments adjusts the kernel args
-func (dn *CoreOSDaemon) updateKernelArguments(oldKernelArguments, newKernelArguments []string) error {
-	kargs := generateKargs(oldKernelArguments, newKernelArguments)
+func (dn *CoreOSDaemon) updateKernelArguments(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	cmdline, err := readKernelCmdline()
+	if err != nil {
+		return err
+	}
+	kargs := generateKargs(oldConfig, newConfig, cmdline)
 	if len(kargs) == 0 {
 		return nil
 	}
@@ -1597,18 +1846,20 @@ func (dn *Daemon) writeUnits(units []ign3types.Unit) error {
 }
 
 // writeFiles writes the given files to disk.
-// it doesn't fetch remote files and expects a flattened config file.
+// Remote sources are fetched and verified, or taken from the cache if they were
+// already fetched. It expects a flattened config file.
 func (dn *Daemon) writeFiles(files []ign3types.File) error {
 	for _, file := range files {
 		glog.Infof("Writing file %q", file.Path)
 
-		// We don't support appends in the file section, so instead of waiting to fail validation,
-		// let's explicitly fail here.
+		// The base of appended files has to be known before deriving their contents
 		if len(file.Append) > 0 {
-			return fmt.Errorf("found an append section when writing files. Append is not supported")
+			if err := createAppendOrigFile(file.Path); err != nil {
+				return err
+			}
 		}
 
-		decodedContents, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+		decodedContents, err := resolveFileContents(file)
 		if err != nil {
 			return fmt.Errorf("could not decode file %q: %w", file.Path, err)
 		}
@@ -1851,6 +2102,9 @@ func (dn *Daemon) updateSSHKeys(newUsers []ign3types.PasswdUser) error {
 // updateOS updates the system OS to the one specified in newConfig
 func (dn *Daemon) updateOS(config *mcfgv1.MachineConfig, osImageContentDir string) error {
 	newURL := config.Spec.OSImageURL
+	if err := verifyOSImage(config); err != nil {
+		return fmt.Errorf("refusing to update OS to %s: %w", newURL, err)
+	}
 	glog.Infof("Updating OS to %s", newURL)
 	if _, err := dn.NodeUpdaterClient.Rebase(newURL, osImageContentDir); err != nil {
 		return fmt.Errorf("failed to update OS to %s : %w", newURL, err)
@@ -1861,7 +2115,13 @@ func (dn *Daemon) updateOS(config *mcfgv1.MachineConfig, osImageContentDir strin
 
 // updateLayeredOS updates the system OS to the one specified in newConfig
 func (dn *Daemon) updateLayeredOS(config *mcfgv1.MachineConfig) error {
+	if dn.offlineBundle != nil {
+		return dn.updateOSFromBundle(config)
+	}
 	newURL := config.Spec.OSImageURL
+	if err := verifyOSImage(config); err != nil {
+		return fmt.Errorf("refusing to update OS to %s: %w", newURL, err)
+	}
 	glog.Infof("Updating OS to layered image %s", newURL)
 	if err := dn.NodeUpdaterClient.RebaseLayered(newURL); err != nil {
 		return fmt.Errorf("failed to update OS to %s : %w", newURL, err)
@@ -2074,6 +2334,24 @@ func (dn *Daemon) reboot(rationale string) error {
 }
 
 func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) (retErr error) {
+	// Layered images don't carry extensions or the rt-kernel, so they are
+	// installed as client-side overrides from the extensions container. As for
+	// legacy images, its repo is added before the OS update, since rebasing
+	// resolves the extensions and rt-kernel already installed against it.
+	if (mcDiff.osUpdate || mcDiff.extensions || mcDiff.kernelType) && needsLayeredExtensionsRepo(oldConfig, newConfig) {
+		osExtensionsContentDir, err := dn.extractLayeredExtensions(newConfig)
+		if err != nil {
+			return err
+		}
+		// Delete extracted extensions container once we are done.
+		defer os.RemoveAll(osExtensionsContentDir)
+
+		if err := addLayeredExtensionsRepo(osExtensionsContentDir); err != nil {
+			return err
+		}
+		defer os.Remove(extensionsRepo)
+	}
+
 	// Update OS
 	if mcDiff.osUpdate {
 		if err := dn.updateLayeredOS(newConfig); err != nil {
@@ -2111,25 +2389,59 @@ func (dn *CoreOSDaemon) applyLayeredOSChanges(mcDiff machineConfigDiff, oldConfi
 	}()
 
 	if mcDiff.kargs {
-		if err := dn.updateKernelArguments(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments); err != nil {
+		if err := dn.updateKernelArguments(oldConfig, newConfig); err != nil {
 			return err
 		}
 	}
 
-	// TODO(jkyros): We can't currently switch kernels on layered images, so only allow it if they're both default. We'll come back for this when it's supported.
-	// If you did try to switch kernels when using layered image, you would get a "No enabled repositories" error.
-	if !(canonicalizeKernelType(oldConfig.Spec.KernelType) == ctrlcommon.KernelTypeDefault && canonicalizeKernelType(newConfig.Spec.KernelType) == ctrlcommon.KernelTypeDefault) {
-		return fmt.Errorf("Non-default kernels are not currently supported for layered images. (old: %s new %s)", oldConfig.Spec.KernelType, newConfig.Spec.KernelType)
+	// Switch to real time kernel
+	if err := dn.switchKernel(oldConfig, newConfig); err != nil {
+		return err
 	}
 
-	// TODO(jkyros): This is where we will handle Joseph's extensions container
-	if len(newConfig.Spec.Extensions) > 0 {
-		return fmt.Errorf("Extensions are not currently supported with layered images, but extensions were supplied: %s", strings.Join(newConfig.Spec.Extensions, " "))
+	// Apply extensions
+	if err := dn.applyExtensions(oldConfig, newConfig); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// extractLayeredExtensions extracts the extensions container of config, from the
+// offline bundle if there is one, once it's checked to be the container config pins.
+func (dn *CoreOSDaemon) extractLayeredExtensions(config *mcfgv1.MachineConfig) (string, error) {
+	extensionsImage := config.Spec.BaseOSExtensionsContainerImage
+	if extensionsImage == "" {
+		return "", fmt.Errorf("config %s has no extensions container to install extensions or switch kernel from", config.Name)
+	}
+	if dn.offlineBundle == nil {
+		if err := verifyExtensionsImage(config); err != nil {
+			return "", fmt.Errorf("refusing extensions container %s: %w", extensionsImage, err)
+		}
+		return extractOSImage(extensionsImage)
+	}
+	if dn.offlineBundle.extensionsImageRef() == "" {
+		return "", fmt.Errorf("bundle %s has no extensions container", dn.offlineBundle.Dir)
+	}
+	if err := checkBundledExtensionsImage(config, filepath.Join(dn.offlineBundle.Dir, offlineBundleExtensionsFile)); err != nil {
+		return "", fmt.Errorf("refusing bundled extensions container: %w", err)
+	}
+	return extractOSImage(dn.offlineBundle.extensionsImageRef())
+}
+
+// needsLayeredExtensionsRepo returns whether applying a config to a layered
+// image needs packages from the extensions container: when either config has
+// extensions or the rt-kernel, which are removed, installed or updated to match
+// the new OS image.
+func needsLayeredExtensionsRepo(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	for _, config := range []*mcfgv1.MachineConfig{oldConfig, newConfig} {
+		if len(config.Spec.Extensions) > 0 || canonicalizeKernelType(config.Spec.KernelType) != ctrlcommon.KernelTypeDefault {
+			return true
+		}
+	}
+	return false
+}
+
 func (dn *CoreOSDaemon) applyLegacyOSChanges(mcDiff machineConfigDiff, oldConfig, newConfig *mcfgv1.MachineConfig) (retErr error) {
 	var osImageContentDir string
 	var err error
@@ -2185,7 +2497,7 @@ func (dn *CoreOSDaemon) applyLegacyOSChanges(mcDiff machineConfigDiff, oldConfig
 
 	// Apply kargs
 	if mcDiff.kargs {
-		if err := dn.updateKernelArguments(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments); err != nil {
+		if err := dn.updateKernelArguments(oldConfig, newConfig); err != nil {
 			return err
 		}
 	}
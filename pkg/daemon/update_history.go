@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+const (
+	// UpdateHistoryPath is where the daemon keeps the history of updates of the node
+	UpdateHistoryPath = "/etc/machine-config-daemon/update-history.json"
+
+	// maxUpdateHistoryEntries bounds the history on disk and in the node annotation
+	maxUpdateHistoryEntries = 20
+)
+
+// UpdateOutcome is the result of an update recorded in the update history.
+type UpdateOutcome string
+
+const (
+	// UpdateOutcomeInProgress is set while the update is applied or the node reboots into it
+	UpdateOutcomeInProgress UpdateOutcome = "InProgress"
+	// UpdateOutcomeSucceeded is set once the node runs the new config
+	UpdateOutcomeSucceeded UpdateOutcome = "Succeeded"
+	// UpdateOutcomeFailed is set if the update failed and was rolled back
+	UpdateOutcomeFailed UpdateOutcome = "Failed"
+	// UpdateOutcomeInterrupted is set if the daemon died during the update and
+	// rolled it back on restart
+	UpdateOutcomeInterrupted UpdateOutcome = "Interrupted"
+)
+
+// UpdateHistoryEntry records a single update of the node from one rendered
+// config to another.
+type UpdateHistoryEntry struct {
+	FromConfig string       `json:"fromConfig"`
+	ToConfig   string       `json:"toConfig"`
+	StartTime  metav1.Time  `json:"startTime"`
+	EndTime    *metav1.Time `json:"endTime,omitempty"`
+	// Actions are the post config change actions taken, e.g. Reboot or None
+	Actions []string `json:"actions,omitempty"`
+	// StageDuration is how long staging the OS changes with rpm-ostree took
+	StageDuration *metav1.Duration `json:"stageDuration,omitempty"`
+	DrainDuration *metav1.Duration `json:"drainDuration,omitempty"`
+	// RebootTime is when the reboot into the new config was requested
+	RebootTime     *metav1.Time     `json:"rebootTime,omitempty"`
+	RebootDuration *metav1.Duration `json:"rebootDuration,omitempty"`
+	Outcome        UpdateOutcome    `json:"outcome"`
+	Message        string           `json:"message,omitempty"`
+	// Attempts counts consecutive failed attempts of the same update, which
+	// are folded into one entry
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// ReadUpdateHistory returns the update history stored at path, oldest first.
+func ReadUpdateHistory(path string) ([]UpdateHistoryEntry, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	history := []UpdateHistoryEntry{}
+	if err := json.Unmarshal(raw, &history); err != nil {
+		return nil, fmt.Errorf("parsing update history %s: %w", path, err)
+	}
+	return history, nil
+}
+
+// GetNodeUpdateHistory returns the update history the daemon published on the
+// node, oldest first.
+func GetNodeUpdateHistory(node *corev1.Node) ([]UpdateHistoryEntry, error) {
+	raw, ok := node.Annotations[constants.UpdateHistoryAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	history := []UpdateHistoryEntry{}
+	if err := json.Unmarshal([]byte(raw), &history); err != nil {
+		return nil, fmt.Errorf("parsing update history of node %s: %w", node.Name, err)
+	}
+	return history, nil
+}
+
+// recordUpdateHistory applies mutate to the stored history and persists it,
+// then publishes it on the node if publish is set. The history is
+// informational, so failures are only logged.
+func (dn *Daemon) recordUpdateHistory(publish bool, mutate func([]UpdateHistoryEntry) []UpdateHistoryEntry) {
+	history, err := ReadUpdateHistory(dn.updateHistoryPath)
+	if err != nil {
+		glog.Warningf("Failed to read update history: %v", err)
+		history = nil
+	}
+	history = mutate(history)
+	if len(history) > maxUpdateHistoryEntries {
+		history = history[len(history)-maxUpdateHistoryEntries:]
+	}
+
+	raw, err := json.Marshal(history)
+	if err != nil {
+		glog.Warningf("Failed to encode update history: %v", err)
+		return
+	}
+	if err := writeFileAtomicallyWithDefaults(dn.updateHistoryPath, raw); err != nil {
+		glog.Warningf("Failed to write update history: %v", err)
+	}
+	if publish && dn.nodeWriter != nil {
+		if _, err := dn.nodeWriter.SetAnnotations(map[string]string{constants.UpdateHistoryAnnotationKey: string(raw)}); err != nil {
+			glog.Warningf("Failed to publish update history on node: %v", err)
+		}
+	}
+}
+
+// updateInProgressHistory applies mutate to the latest entry if it is the
+// update to toConfig and still in progress. It only updates the history on
+// disk, the node annotation is published when the update finishes.
+func (dn *Daemon) updateInProgressHistory(toConfig string, mutate func(*UpdateHistoryEntry)) {
+	dn.recordUpdateHistory(false, func(history []UpdateHistoryEntry) []UpdateHistoryEntry {
+		if len(history) == 0 {
+			return history
+		}
+		last := &history[len(history)-1]
+		if last.Outcome != UpdateOutcomeInProgress || (toConfig != "" && last.ToConfig != toConfig) {
+			return history
+		}
+		mutate(last)
+		return history
+	})
+}
+
+// startUpdateHistory records the start of an update.
+func (dn *Daemon) startUpdateHistory(fromConfig, toConfig string) {
+	now := metav1.Now()
+	dn.recordUpdateHistory(true, func(history []UpdateHistoryEntry) []UpdateHistoryEntry {
+		// an update interrupted before it could record its outcome
+		if len(history) > 0 && history[len(history)-1].Outcome == UpdateOutcomeInProgress {
+			history[len(history)-1].Outcome = UpdateOutcomeInterrupted
+		}
+		return append(history, UpdateHistoryEntry{
+			FromConfig: fromConfig,
+			ToConfig:   toConfig,
+			StartTime:  now,
+			Outcome:    UpdateOutcomeInProgress,
+		})
+	})
+}
+
+// finishUpdateHistory records the outcome of the update to toConfig. An empty
+// toConfig finishes whichever update is in progress.
+func (dn *Daemon) finishUpdateHistory(toConfig string, outcome UpdateOutcome, message string) {
+	now := metav1.Now()
+	dn.recordUpdateHistory(true, func(history []UpdateHistoryEntry) []UpdateHistoryEntry {
+		if len(history) == 0 {
+			return history
+		}
+		last := &history[len(history)-1]
+		if last.Outcome != UpdateOutcomeInProgress || (toConfig != "" && last.ToConfig != toConfig) {
+			return history
+		}
+		last.EndTime = &now
+		last.Outcome = outcome
+		last.Message = message
+		if last.RebootTime != nil && outcome == UpdateOutcomeSucceeded {
+			last.RebootDuration = &metav1.Duration{Duration: now.Sub(last.RebootTime.Time).Round(time.Second)}
+		}
+		// fold retries of an update that keeps failing the same way
+		if outcome == UpdateOutcomeFailed && len(history) > 1 {
+			prev := history[len(history)-2]
+			if prev.Outcome == UpdateOutcomeFailed && prev.FromConfig == last.FromConfig && prev.ToConfig == last.ToConfig && prev.Message == last.Message {
+				attempts := prev.Attempts
+				if attempts == 0 {
+					attempts = 1
+				}
+				last.StartTime = prev.StartTime
+				last.Attempts = attempts + 1
+				history = append(history[:len(history)-2], *last)
+			}
+		}
+		return history
+	})
+}
@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+func TestUpdateHistory(t *testing.T) {
+	dn := newMockDaemon()
+	dn.updateHistoryPath = filepath.Join(t.TempDir(), "update-history.json")
+
+	nw := &fakeNodeWriter{annotations: map[string]string{}}
+	dn.nodeWriter = nw
+
+	// a rebooting update
+	dn.startUpdateHistory("rendered-1", "rendered-2")
+	published := nw.annotations[constants.UpdateHistoryAnnotationKey]
+	require.NotEmpty(t, published)
+	dn.updateInProgressHistory("rendered-2", func(e *UpdateHistoryEntry) { e.Actions = []string{postConfigChangeActionReboot} })
+	// progress is only kept on disk until the update finishes
+	assert.Equal(t, published, nw.annotations[constants.UpdateHistoryAnnotationKey])
+	dn.updateInProgressHistory("rendered-2", func(e *UpdateHistoryEntry) { e.DrainDuration = &metav1.Duration{Duration: time.Minute} })
+	rebootTime := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	dn.updateInProgressHistory("rendered-2", func(e *UpdateHistoryEntry) { e.RebootTime = &rebootTime })
+	// finishing another update must not touch the one in progress
+	dn.finishUpdateHistory("rendered-3", UpdateOutcomeSucceeded, "")
+	dn.finishUpdateHistory("rendered-2", UpdateOutcomeSucceeded, "")
+
+	history, err := ReadUpdateHistory(dn.updateHistoryPath)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	annotated, err := GetNodeUpdateHistory(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: nw.annotations}})
+	require.NoError(t, err)
+	assert.Equal(t, history, annotated)
+	entry := history[0]
+	assert.Equal(t, "rendered-1", entry.FromConfig)
+	assert.Equal(t, "rendered-2", entry.ToConfig)
+	assert.Equal(t, []string{postConfigChangeActionReboot}, entry.Actions)
+	assert.Equal(t, time.Minute, entry.DrainDuration.Duration)
+	require.NotNil(t, entry.RebootDuration)
+	assert.InDelta(t, 2*time.Minute, entry.RebootDuration.Duration, float64(10*time.Second))
+	require.NotNil(t, entry.EndTime)
+	assert.Equal(t, UpdateOutcomeSucceeded, entry.Outcome)
+
+	// retries of an update failing the same way are folded into one entry
+	for i := 0; i < 3; i++ {
+		dn.startUpdateHistory("rendered-2", "rendered-3")
+		dn.finishUpdateHistory("rendered-3", UpdateOutcomeFailed, "drain failed")
+	}
+	// an update that never recorded its outcome is interrupted by the next one
+	dn.startUpdateHistory("rendered-2", "rendered-4")
+	dn.startUpdateHistory("rendered-2", "rendered-5")
+
+	history, err = ReadUpdateHistory(dn.updateHistoryPath)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	assert.Equal(t, UpdateOutcomeFailed, history[1].Outcome)
+	assert.Equal(t, 3, history[1].Attempts)
+	assert.Equal(t, "drain failed", history[1].Message)
+	assert.Equal(t, UpdateOutcomeInterrupted, history[2].Outcome)
+	assert.Equal(t, UpdateOutcomeInProgress, history[3].Outcome)
+}
+
+func TestUpdateHistoryIsBounded(t *testing.T) {
+	dn := newMockDaemon()
+	dn.updateHistoryPath = filepath.Join(t.TempDir(), "update-history.json")
+
+	for i := 0; i < maxUpdateHistoryEntries+5; i++ {
+		to := fmt.Sprintf("rendered-%d", i+1)
+		dn.startUpdateHistory(fmt.Sprintf("rendered-%d", i), to)
+		// distinct messages so the failures aren't folded
+		dn.finishUpdateHistory(to, UpdateOutcomeFailed, to)
+	}
+
+	history, err := ReadUpdateHistory(dn.updateHistoryPath)
+	require.NoError(t, err)
+	require.Len(t, history, maxUpdateHistoryEntries)
+	assert.Equal(t, "rendered-6", history[0].ToConfig)
+	assert.Equal(t, fmt.Sprintf("rendered-%d", maxUpdateHistoryEntries+5), history[len(history)-1].ToConfig)
+}
+
+func TestGetNodeUpdateHistory(t *testing.T) {
+	history, err := GetNodeUpdateHistory(&corev1.Node{})
+	require.NoError(t, err)
+	assert.Nil(t, history)
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node",
+		Annotations: map[string]string{constants.UpdateHistoryAnnotationKey: `[{"fromConfig":"a","toConfig":"b","startTime":null,"outcome":"Succeeded"}]`},
+	}}
+	history, err = GetNodeUpdateHistory(node)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, UpdateOutcomeSucceeded, history[0].Outcome)
+
+	node.Annotations[constants.UpdateHistoryAnnotationKey] = "garbage"
+	_, err = GetNodeUpdateHistory(node)
+	assert.Error(t, err)
+}
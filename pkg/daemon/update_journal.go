@@ -0,0 +1,306 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/golang/glog"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// updateJournalPhase is the phase of an update recorded in the update journal.
+type updateJournalPhase string
+
+const (
+	// updateJournalPath is where the write-ahead journal of an in-flight update is kept
+	updateJournalPath = "/etc/machine-config-daemon/update-journal.json"
+
+	// journalPhaseApplying is set before OS changes are staged or any file is
+	// touched. An update found in this phase on startup did not finish and is
+	// rolled back.
+	journalPhaseApplying updateJournalPhase = "Applying"
+	// journalPhaseCommitted is set once the new config was stored as current
+	// config on disk. An update found in this phase on startup is rolled forward.
+	journalPhaseCommitted updateJournalPhase = "Committed"
+	// journalPhaseRollingBack is set when a committed update failed afterwards
+	// and its changes are being reverted. It is rolled back on startup.
+	journalPhaseRollingBack updateJournalPhase = "RollingBack"
+
+	journalActionWrite  = "write"
+	journalActionDelete = "delete"
+)
+
+// journalChange is a single path the update intends to write or delete.
+type journalChange struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// Backup is the .mcdorig copy that holds the original content of Path
+	Backup string `json:"backup,omitempty"`
+	// NoOrig is set if Path did not exist before the MCD first wrote it
+	NoOrig bool `json:"noOrig,omitempty"`
+}
+
+// updateJournal records an in-flight update of files, units and SSH keys so
+// that it can be completed or reverted if the MCD dies halfway through.
+type updateJournal struct {
+	Phase     updateJournalPhase    `json:"phase"`
+	BootID    string                `json:"bootID"`
+	OldConfig *mcfgv1.MachineConfig `json:"oldConfig"`
+	NewConfig *mcfgv1.MachineConfig `json:"newConfig"`
+	Changes   []journalChange       `json:"changes"`
+	// Packages is set on package-based hosts once the update starts changing packages
+	Packages *packageJournal `json:"packages,omitempty"`
+}
+
+// ignConfigPaths returns the paths of the files, units and dropins an Ignition
+// config writes.
+func ignConfigPaths(ign *ign3types.Config) map[string]struct{} {
+	paths := map[string]struct{}{}
+	for _, f := range ign.Storage.Files {
+		paths[f.Path] = struct{}{}
+	}
+	for _, u := range ign.Systemd.Units {
+		if u.Contents != nil || u.Mask != nil {
+			paths[filepath.Join(pathSystemd, u.Name)] = struct{}{}
+		}
+		for _, d := range u.Dropins {
+			paths[filepath.Join(pathSystemd, u.Name+".d", d.Name)] = struct{}{}
+		}
+	}
+	return paths
+}
+
+// journalChangeFor describes how path is changed and which backup the MCD uses
+// to restore it, mirroring createOrigFile and deleteStaleData.
+func journalChangeFor(path, action string) journalChange {
+	change := journalChange{Path: path, Action: action}
+	if _, err := os.Stat(noOrigFileStampName(path)); err == nil {
+		change.NoOrig = true
+		return change
+	}
+	if _, err := os.Lstat(origFileName(path)); err == nil {
+		change.Backup = origFileName(path)
+		return change
+	}
+	if action == journalActionWrite {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			change.NoOrig = true
+		} else {
+			change.Backup = origFileName(path)
+		}
+	}
+	return change
+}
+
+func newUpdateJournal(oldConfig, newConfig *mcfgv1.MachineConfig, oldIgn, newIgn *ign3types.Config, bootID string) *updateJournal {
+	oldPaths := ignConfigPaths(oldIgn)
+	newPaths := ignConfigPaths(newIgn)
+
+	changes := []journalChange{}
+	for path := range newPaths {
+		changes = append(changes, journalChangeFor(path, journalActionWrite))
+	}
+	for path := range oldPaths {
+		if _, ok := newPaths[path]; !ok {
+			changes = append(changes, journalChangeFor(path, journalActionDelete))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return &updateJournal{
+		Phase:     journalPhaseApplying,
+		BootID:    bootID,
+		OldConfig: oldConfig,
+		NewConfig: newConfig,
+		Changes:   changes,
+	}
+}
+
+// rollBack reports whether an interrupted update found in this journal has to be
+// reverted (true) or completed (false).
+func (j *updateJournal) rollBack() bool {
+	return j.Phase != journalPhaseCommitted
+}
+
+func writeUpdateJournal(path string, j *updateJournal) error {
+	raw, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicallyWithDefaults(path, raw)
+}
+
+// readUpdateJournal returns the journal at path, or nil if there is none.
+func readUpdateJournal(path string) (*updateJournal, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j := &updateJournal{}
+	if err := json.Unmarshal(raw, j); err != nil {
+		return nil, fmt.Errorf("parsing update journal %s: %w", path, err)
+	}
+	if j.OldConfig == nil || j.NewConfig == nil {
+		return nil, fmt.Errorf("update journal %s is missing the old or new config", path)
+	}
+	return j, nil
+}
+
+// beginUpdateJournal records the changes the update is about to make. It must be
+// called before any file is written.
+func (dn *Daemon) beginUpdateJournal(oldConfig, newConfig *mcfgv1.MachineConfig, oldIgn, newIgn *ign3types.Config) (*updateJournal, error) {
+	j := newUpdateJournal(oldConfig, newConfig, oldIgn, newIgn, dn.bootID)
+	if err := writeUpdateJournal(dn.updateJournalPath, j); err != nil {
+		return nil, fmt.Errorf("writing update journal: %w", err)
+	}
+	glog.Infof("Recorded %d pending changes from %s to %s in update journal", len(j.Changes), oldConfig.GetName(), newConfig.GetName())
+	return j, nil
+}
+
+func (dn *Daemon) setUpdateJournalPhase(j *updateJournal, phase updateJournalPhase) error {
+	j.Phase = phase
+	if err := writeUpdateJournal(dn.updateJournalPath, j); err != nil {
+		return fmt.Errorf("updating update journal to phase %s: %w", phase, err)
+	}
+	return nil
+}
+
+// clearUpdateJournal removes the journal once an update completed or was fully
+// rolled back.
+func (dn *Daemon) clearUpdateJournal() error {
+	if err := os.Remove(dn.updateJournalPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing update journal: %w", err)
+	}
+	return nil
+}
+
+// revertJournalChange restores a path the interrupted update added to the
+// config from its journal record.
+func revertJournalChange(change journalChange) error {
+	switch {
+	case change.NoOrig:
+		// The path did not exist before the MCD first wrote it
+		if err := os.Remove(change.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing %s: %w", change.Path, err)
+		}
+		if err := os.Remove(noOrigFileStampName(change.Path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing noorig file stamp of %s: %w", change.Path, err)
+		}
+		glog.V(2).Infof("Removed %s", change.Path)
+	case change.Backup != "":
+		if _, err := os.Lstat(change.Backup); os.IsNotExist(err) {
+			// The path is only written once it was backed up
+			glog.V(2).Infof("Leaving %s alone: it was not backed up, so the update never wrote it", change.Path)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := restorePath(change.Path); err != nil {
+			return err
+		}
+		glog.V(2).Infof("Restored %s from %s", change.Path, change.Backup)
+	}
+	return nil
+}
+
+// recoverUpdateJournal completes an update that was interrupted by the MCD
+// being killed or the node crashing. Updates that were not committed yet are
+// rolled back the same way update() does on failure; committed updates are
+// rolled forward, i.e. left in place to be validated against the current
+// config on disk.
+func (dn *Daemon) recoverUpdateJournal() error {
+	j, err := readUpdateJournal(dn.updateJournalPath)
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		return nil
+	}
+
+	oldName, newName := j.OldConfig.GetName(), j.NewConfig.GetName()
+	if !j.rollBack() {
+		dn.logSystem("Found committed update from %s to %s in update journal, rolling forward", oldName, newName)
+		if dn.os.IsCoreOSVariant() {
+			// the update may have died before it unlocked the OS changes it staged
+			if err := unlockStagedDeployment(); err != nil {
+				return err
+			}
+		}
+		return dn.clearUpdateJournal()
+	}
+
+	dn.logSystem("Found interrupted update from %s to %s in phase %s, rolling back %d changes", oldName, newName, j.Phase, len(j.Changes))
+
+	oldIgn, err := ctrlcommon.ParseAndConvertConfig(j.OldConfig.Spec.Config.Raw)
+	if err != nil {
+		return fmt.Errorf("parsing old Ignition config from update journal: %w", err)
+	}
+	newIgn, err := ctrlcommon.ParseAndConvertConfig(j.NewConfig.Spec.Config.Raw)
+	if err != nil {
+		return fmt.Errorf("parsing new Ignition config from update journal: %w", err)
+	}
+
+	// Paths of the old config are rewritten from it. The others are restored
+	// from their journal record rather than the state the update left them in,
+	// which may lack the backups a restore relies on.
+	oldUnits := map[string]struct{}{}
+	for _, u := range oldIgn.Systemd.Units {
+		oldUnits[u.Name] = struct{}{}
+	}
+	for _, u := range newIgn.Systemd.Units {
+		if _, ok := oldUnits[u.Name]; ok {
+			continue
+		}
+		if err := dn.presetUnit(u); err != nil {
+			glog.Infof("Did not restore preset for %s (may not exist): %s", u.Name, err)
+		}
+	}
+	oldPaths := ignConfigPaths(&oldIgn)
+	for _, change := range j.Changes {
+		if _, ok := oldPaths[change.Path]; ok {
+			continue
+		}
+		if err := revertJournalChange(change); err != nil {
+			return fmt.Errorf("rolling back %s from update journal: %w", change.Path, err)
+		}
+	}
+	if err := dn.writeFiles(oldIgn.Storage.Files); err != nil {
+		return fmt.Errorf("rolling back files from update journal: %w", err)
+	}
+	if err := dn.writeUnits(oldIgn.Systemd.Units); err != nil {
+		return fmt.Errorf("rolling back units from update journal: %w", err)
+	}
+	if err := dn.updateSSHKeys(oldIgn.Passwd.Users); err != nil {
+		return fmt.Errorf("rolling back SSH keys from update journal: %w", err)
+	}
+	if dn.os.IsCoreOSVariant() {
+		// drop any OS, kernel argument or extension changes staged by the update
+		if err := removePendingDeployment(); err != nil {
+			return fmt.Errorf("removing pending deployment: %w", err)
+		}
+	} else if j.Packages != nil {
+		if err := dn.rollbackPackageOSChanges(j.OldConfig, j.Packages); err != nil {
+			return fmt.Errorf("rolling back packages from update journal: %w", err)
+		}
+	}
+	// the current config on disk is only replaced once the update is committed
+	if j.Phase == journalPhaseRollingBack {
+		if err := dn.storeCurrentConfigOnDisk(j.OldConfig); err != nil {
+			return fmt.Errorf("rolling back current config on disk: %w", err)
+		}
+	}
+
+	dn.finishUpdateHistory(newName, UpdateOutcomeInterrupted, fmt.Sprintf("rolled back on restart from phase %s", j.Phase))
+	dn.logSystem("Rolled back interrupted update from %s to %s", oldName, newName)
+	return dn.clearUpdateJournal()
+}
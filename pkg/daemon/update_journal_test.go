@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestNewUpdateJournal(t *testing.T) {
+	tmpDir := t.TempDir()
+	existing := filepath.Join(tmpDir, "existing")
+	require.NoError(t, ioutil.WriteFile(existing, []byte("orig"), 0o644))
+	created := filepath.Join(tmpDir, "created")
+	stale := filepath.Join(tmpDir, "stale")
+
+	oldMC := helpers.NewMachineConfig("rendered-old", nil, "", []ign3types.File{
+		ctrlcommon.NewIgnFile(existing, "old"),
+		ctrlcommon.NewIgnFile(stale, "old"),
+	})
+	newMC := helpers.NewMachineConfigExtended("rendered-new", nil,
+		[]ign3types.File{ctrlcommon.NewIgnFile(existing, "new"), ctrlcommon.NewIgnFile(created, "new")},
+		[]ign3types.Unit{{Name: "foo.service", Contents: helpers.StrToPtr("[Unit]"), Dropins: []ign3types.Dropin{{Name: "10-foo.conf"}}}},
+		nil, nil, false, nil, "", "")
+	oldIgn, err := ctrlcommon.ParseAndConvertConfig(oldMC.Spec.Config.Raw)
+	require.NoError(t, err)
+	newIgn, err := ctrlcommon.ParseAndConvertConfig(newMC.Spec.Config.Raw)
+	require.NoError(t, err)
+
+	j := newUpdateJournal(oldMC, newMC, &oldIgn, &newIgn, "boot-1")
+	assert.Equal(t, journalPhaseApplying, j.Phase)
+	assert.Equal(t, "boot-1", j.BootID)
+	assert.Equal(t, []journalChange{
+		{Path: "/etc/systemd/system/foo.service", Action: journalActionWrite, NoOrig: true},
+		{Path: "/etc/systemd/system/foo.service.d/10-foo.conf", Action: journalActionWrite, NoOrig: true},
+		{Path: created, Action: journalActionWrite, NoOrig: true},
+		{Path: existing, Action: journalActionWrite, Backup: origFileName(existing)},
+		{Path: stale, Action: journalActionDelete},
+	}, j.Changes)
+}
+
+func TestUpdateJournalRecovery(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldMC := helpers.NewMachineConfig("rendered-old", nil, "", nil)
+	newMC := helpers.NewMachineConfig("rendered-new", nil, "", nil)
+	oldIgn, err := ctrlcommon.ParseAndConvertConfig(oldMC.Spec.Config.Raw)
+	require.NoError(t, err)
+	newIgn, err := ctrlcommon.ParseAndConvertConfig(newMC.Spec.Config.Raw)
+	require.NoError(t, err)
+
+	tests := []struct {
+		phase           updateJournalPhase
+		rollBack        bool
+		expectedCurrent string
+	}{
+		// the current config on disk is left alone before the update is committed
+		{phase: journalPhaseApplying, rollBack: true, expectedCurrent: "rendered-new"},
+		{phase: journalPhaseCommitted, rollBack: false, expectedCurrent: "rendered-new"},
+		{phase: journalPhaseRollingBack, rollBack: true, expectedCurrent: "rendered-old"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.phase), func(t *testing.T) {
+			dn := newMockDaemon()
+			dn.updateJournalPath = filepath.Join(tmpDir, "update-journal.json")
+			dn.currentConfigPath = filepath.Join(tmpDir, "currentconfig")
+			require.NoError(t, dn.storeCurrentConfigOnDisk(newMC))
+
+			j, err := dn.beginUpdateJournal(oldMC, newMC, &oldIgn, &newIgn)
+			require.NoError(t, err)
+			require.NoError(t, dn.setUpdateJournalPhase(j, test.phase))
+
+			read, err := readUpdateJournal(dn.updateJournalPath)
+			require.NoError(t, err)
+			assert.Equal(t, test.phase, read.Phase)
+			assert.Equal(t, test.rollBack, read.rollBack())
+
+			require.NoError(t, dn.recoverUpdateJournal())
+			_, err = os.Stat(dn.updateJournalPath)
+			assert.True(t, os.IsNotExist(err), "journal should be removed after recovery")
+			current, err := dn.getCurrentConfigOnDisk()
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedCurrent, current.Name)
+
+			// nothing to recover without a journal
+			require.NoError(t, dn.recoverUpdateJournal())
+		})
+	}
+}
+
+func TestUpdateJournalRecoveryRestoresPaths(t *testing.T) {
+	testDir, cleanup := setupTempDirWithEtc(t)
+	defer cleanup()
+
+	// a file the old config writes
+	managed := filepath.Join(testDir, "etc", "managed")
+	// a file of the OS the new config overwrites
+	untouched := filepath.Join(testDir, "etc", "untouched")
+	backedUp := filepath.Join(testDir, "etc", "backed-up")
+	// a file the new config creates
+	created := filepath.Join(testDir, "etc", "created")
+	for _, path := range []string{managed, untouched, backedUp} {
+		require.NoError(t, ioutil.WriteFile(path, []byte("original"), 0o644))
+	}
+
+	oldMC := helpers.NewMachineConfig("rendered-old", nil, "", []ign3types.File{ctrlcommon.NewIgnFile(managed, "old")})
+	newMC := helpers.NewMachineConfig("rendered-new", nil, "", []ign3types.File{
+		ctrlcommon.NewIgnFile(managed, "new"),
+		ctrlcommon.NewIgnFile(untouched, "new"),
+		ctrlcommon.NewIgnFile(backedUp, "new"),
+		ctrlcommon.NewIgnFile(created, "new"),
+	})
+	oldIgn, err := ctrlcommon.ParseAndConvertConfig(oldMC.Spec.Config.Raw)
+	require.NoError(t, err)
+	newIgn, err := ctrlcommon.ParseAndConvertConfig(newMC.Spec.Config.Raw)
+	require.NoError(t, err)
+
+	dn := newMockDaemon()
+	dn.updateJournalPath = filepath.Join(testDir, "update-journal.json")
+	dn.currentConfigPath = filepath.Join(testDir, "currentconfig")
+	require.NoError(t, dn.storeCurrentConfigOnDisk(oldMC))
+	_, err = dn.beginUpdateJournal(oldMC, newMC, &oldIgn, &newIgn)
+	require.NoError(t, err)
+
+	// The MCD died after writing some of the files: untouched was neither
+	// backed up nor written
+	require.NoError(t, dn.writeFiles([]ign3types.File{
+		ctrlcommon.NewIgnFile(managed, "new"),
+		ctrlcommon.NewIgnFile(backedUp, "new"),
+		ctrlcommon.NewIgnFile(created, "new"),
+	}))
+
+	require.NoError(t, dn.recoverUpdateJournal())
+	for path, expected := range map[string]string{managed: "old", untouched: "original", backedUp: "original"} {
+		content, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content), path)
+	}
+	_, err = os.Stat(created)
+	assert.True(t, os.IsNotExist(err), "created file should be removed")
+	_, err = os.Stat(origFileName(backedUp))
+	assert.True(t, os.IsNotExist(err), "backup should be consumed")
+}
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"encoding/json"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// resetUpdatePhase clears the phases published for the previous update when a
+// new update starts.
+func (dn *Daemon) resetUpdatePhase() {
+	dn.updatePhaseTimes = map[string]metav1.Time{}
+	dn.publishUpdatePhase("")
+}
+
+// setUpdatePhase publishes that the update reached phase, along with when it
+// reached each of its earlier phases. Phases are informational, so failures to
+// publish them are only logged.
+func (dn *Daemon) setUpdatePhase(phase string) {
+	if dn.updatePhaseTimes == nil {
+		// the daemon restarted during the update, e.g. for the reboot
+		dn.updatePhaseTimes = map[string]metav1.Time{}
+		if dn.node != nil {
+			if raw, ok := dn.node.Annotations[constants.UpdatePhaseTimesAnnotationKey]; ok {
+				if err := json.Unmarshal([]byte(raw), &dn.updatePhaseTimes); err != nil {
+					glog.Warningf("Ignoring malformed update phase times: %v", err)
+					dn.updatePhaseTimes = map[string]metav1.Time{}
+				}
+			}
+		}
+	}
+	dn.updatePhaseTimes[phase] = metav1.Now()
+	glog.Infof("Update phase: %s", phase)
+	dn.publishUpdatePhase(phase)
+}
+
+func (dn *Daemon) publishUpdatePhase(phase string) {
+	if dn.nodeWriter == nil {
+		return
+	}
+	times, err := json.Marshal(dn.updatePhaseTimes)
+	if err != nil {
+		glog.Warningf("Failed to encode update phase times: %v", err)
+		return
+	}
+	if _, err := dn.nodeWriter.SetAnnotations(map[string]string{
+		constants.UpdatePhaseAnnotationKey:      phase,
+		constants.UpdatePhaseTimesAnnotationKey: string(times),
+	}); err != nil {
+		glog.Warningf("Failed to publish update phase %q: %v", phase, err)
+	}
+}
@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+type fakeNodeWriter struct {
+	NodeWriter
+	annotations map[string]string
+}
+
+func (nw *fakeNodeWriter) SetAnnotations(annos map[string]string) (*corev1.Node, error) {
+	for k, v := range annos {
+		nw.annotations[k] = v
+	}
+	return nil, nil
+}
+
+func TestSetUpdatePhase(t *testing.T) {
+	drained := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	rawTimes, err := json.Marshal(map[string]metav1.Time{constants.UpdatePhaseDrained: drained})
+	require.NoError(t, err)
+
+	nw := &fakeNodeWriter{annotations: map[string]string{}}
+	dn := newMockDaemon()
+	dn.nodeWriter = nw
+	// the daemon restarted after reaching Drained before
+	dn.node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		constants.UpdatePhaseAnnotationKey:      constants.UpdatePhaseDrained,
+		constants.UpdatePhaseTimesAnnotationKey: string(rawTimes),
+	}}}
+
+	dn.setUpdatePhase(constants.UpdatePhaseFilesWritten)
+	assert.Equal(t, constants.UpdatePhaseFilesWritten, nw.annotations[constants.UpdatePhaseAnnotationKey])
+	times := map[string]metav1.Time{}
+	require.NoError(t, json.Unmarshal([]byte(nw.annotations[constants.UpdatePhaseTimesAnnotationKey]), &times))
+	assert.Equal(t, drained.Unix(), times[constants.UpdatePhaseDrained].Unix())
+	assert.Contains(t, times, constants.UpdatePhaseFilesWritten)
+
+	// a new update starts from scratch
+	dn.resetUpdatePhase()
+	assert.Equal(t, "", nw.annotations[constants.UpdatePhaseAnnotationKey])
+	assert.Equal(t, "{}", nw.annotations[constants.UpdatePhaseTimesAnnotationKey])
+}
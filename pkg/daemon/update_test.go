@@ -5,14 +5,13 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"os/user"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
-	"time"
 
 	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
@@ -196,6 +195,77 @@ func TestMachineConfigDiff(t *testing.T) {
 	assert.True(t, diff.isEmpty())
 }
 
+func TestNeedsLayeredExtensionsRepo(t *testing.T) {
+	newConfig := func(kernelType string, extensions ...string) *mcfgv1.MachineConfig {
+		config := helpers.NewMachineConfig("rendered", nil, "quay.io/openshift/os:latest", nil)
+		config.Spec.KernelType = kernelType
+		config.Spec.Extensions = extensions
+		return config
+	}
+
+	assert.False(t, needsLayeredExtensionsRepo(newConfig(""), newConfig(ctrlcommon.KernelTypeDefault)))
+	assert.True(t, needsLayeredExtensionsRepo(newConfig(""), newConfig("", "usbguard")))
+	// removing extensions or switching back to the default kernel needs the repo too
+	assert.True(t, needsLayeredExtensionsRepo(newConfig("", "usbguard"), newConfig("")))
+	assert.True(t, needsLayeredExtensionsRepo(newConfig(ctrlcommon.KernelTypeRealtime), newConfig("")))
+}
+
+type fakeRebaseClient struct {
+	NodeUpdaterClient
+	rebase func(imgURL string) error
+}
+
+func (c *fakeRebaseClient) RebaseLayered(imgURL string) error {
+	return c.rebase(imgURL)
+}
+
+func TestApplyLayeredOSChangesAddsExtensionsRepoBeforeRebase(t *testing.T) {
+	testDir := t.TempDir()
+	oldExtensionsRepo, oldExtractOSImage := extensionsRepo, extractOSImage
+	defer func() {
+		extensionsRepo, extractOSImage = oldExtensionsRepo, oldExtractOSImage
+	}()
+	extensionsRepo = filepath.Join(testDir, "coreos-extensions.repo")
+	var extracted []string
+	extractOSImage = func(imgURL string) (string, error) {
+		extracted = append(extracted, imgURL)
+		return ioutil.TempDir(testDir, "os-content-")
+	}
+
+	newConfig := func(name, osImageURL string) *mcfgv1.MachineConfig {
+		config := helpers.NewMachineConfig(name, nil, osImageURL, nil)
+		config.Spec.KernelType = ctrlcommon.KernelTypeRealtime
+		config.Spec.BaseOSExtensionsContainerImage = "quay.io/openshift/os-extensions@sha256:" + strings.Repeat("b", 64)
+		return config
+	}
+	oldConfig := newConfig("rendered-1", "quay.io/openshift/os@sha256:"+strings.Repeat("1", 64))
+	newConfig2 := newConfig("rendered-2", "quay.io/openshift/os@sha256:"+strings.Repeat("2", 64))
+	diff, err := newMachineConfigDiff(oldConfig, newConfig2)
+	require.NoError(t, err)
+
+	// Rebasing re-resolves the installed rt-kernel, so it needs the repo
+	var rebased []string
+	dn := newMockDaemon()
+	dn.NodeUpdaterClient = &fakeRebaseClient{rebase: func(imgURL string) error {
+		repo, err := ioutil.ReadFile(extensionsRepo)
+		require.NoError(t, err)
+		assert.Contains(t, string(repo), "baseurl="+testDir)
+		rebased = append(rebased, imgURL)
+		return nil
+	}}
+	require.NoError(t, (&CoreOSDaemon{&dn}).applyLayeredOSChanges(*diff, oldConfig, newConfig2))
+	assert.Equal(t, []string{newConfig2.Spec.OSImageURL}, rebased)
+	assert.Equal(t, []string{newConfig2.Spec.BaseOSExtensionsContainerImage}, extracted)
+	assert.NoFileExists(t, extensionsRepo)
+
+	// An extensions container referenced by tag isn't used
+	newConfig2.Spec.BaseOSExtensionsContainerImage = "quay.io/openshift/os-extensions:latest"
+	rebased, extracted = nil, nil
+	assert.Error(t, (&CoreOSDaemon{&dn}).applyLayeredOSChanges(*diff, oldConfig, newConfig2))
+	assert.Empty(t, rebased)
+	assert.Empty(t, extracted)
+}
+
 func newTestIgnitionFile(i uint) ign3types.File {
 	mode := 0644
 	return ign3types.File{Node: ign3types.Node{Path: fmt.Sprintf("/etc/config%d", i)},
@@ -245,49 +315,85 @@ func TestReconcilableDiff(t *testing.T) {
 
 func TestKernelAguments(t *testing.T) {
 	tests := []struct {
-		oldKargs []string
-		newKargs []string
-		out      []string
+		oldKargs   []string
+		newKargs   []string
+		newDeletes []string
+		cmdline    []string
+		out        []string
 	}{
 		{
 			oldKargs: nil,
 			newKargs: []string{"hello=world"},
+			cmdline:  []string{"root=UUID=1234", "rw"},
 			out:      []string{"--append=hello=world"},
 		},
 		{
 			oldKargs: []string{"hello=world"},
 			newKargs: nil,
+			cmdline:  []string{"rw", "hello=world"},
 			out:      []string{"--delete=hello=world"},
 		},
 		{
 			oldKargs: []string{"foo", "bar=1", "hello=world"},
 			newKargs: []string{"hello=world"},
+			cmdline:  []string{"rw", "foo", "bar=1", "hello=world"},
 			out:      []string{"--delete=foo", "--delete=bar=1", "--delete=hello=world", "--append=hello=world"},
 		},
 		{
 			oldKargs: []string{"foo", "bar=1 hello=world", "baz"},
 			newKargs: []string{"foo", "bar=1", "hello=world"},
-			out: []string{"--delete=foo", "--delete=bar=1", "--delete=hello=world", "--delete=baz",
-				"--append=foo", "--append=bar=1", "--append=hello=world"},
+			cmdline:  []string{"rw", "foo", "bar=1", "hello=world", "baz"},
+			out:      []string{"--delete=baz"},
 		},
 		{
 			oldKargs: []string{" baz=test bar=\"hello world\""},
 			newKargs: []string{" baz=test bar=\"hello world\"", "foo"},
-			out: []string{"--delete=baz=test", "--delete=bar=\"hello world\"",
-				"--append=baz=test", "--append=bar=\"hello world\"", "--append=foo"},
+			cmdline:  []string{"rw", "baz=test", "bar=\"hello world\""},
+			out:      []string{"--append=foo"},
 		},
 		{
+			// hugepages=4 appears twice, so the common prefix must stop before its first occurrence
 			oldKargs: []string{"hugepagesz=1G hugepages=4", "hugepagesz=2M hugepages=4"},
 			newKargs: []string{"hugepagesz=1G hugepages=4", "hugepagesz=2M hugepages=6"},
-			out: []string{"--delete=hugepagesz=1G", "--delete=hugepages=4", "--delete=hugepagesz=2M", "--delete=hugepages=4",
-				"--append=hugepagesz=1G", "--append=hugepages=4", "--append=hugepagesz=2M", "--append=hugepages=6"},
+			cmdline:  []string{"rw", "hugepagesz=1G", "hugepages=4", "hugepagesz=2M", "hugepages=4"},
+			out: []string{"--delete=hugepages=4", "--delete=hugepagesz=2M", "--delete=hugepages=4",
+				"--append=hugepages=4", "--append=hugepagesz=2M", "--append=hugepages=6"},
+		},
+		{
+			// drifted arguments that are gone from the command line are not deleted again
+			oldKargs: []string{"foo", "bar=1"},
+			newKargs: []string{"foo", "bar=2"},
+			cmdline:  []string{"rw", "foo"},
+			out:      []string{"--append=bar=2"},
+		},
+		{
+			// a common argument missing from the command line is appended again
+			oldKargs: []string{"foo", "bar=1"},
+			newKargs: []string{"foo", "bar=1"},
+			cmdline:  []string{"rw", "bar=1"},
+			out:      []string{"--delete=bar=1", "--append=foo", "--append=bar=1"},
+		},
+		{
+			// explicit deletions apply to arguments the MCO did not add
+			oldKargs:   []string{"foo"},
+			newKargs:   []string{"foo"},
+			newDeletes: []string{"mitigations", "console=tty0"},
+			cmdline:    []string{"rw", "mitigations=auto,nosmt", "console=tty0", "console=ttyS0,115200n8", "foo"},
+			out:        []string{"--delete=mitigations=auto,nosmt", "--delete=console=tty0"},
+		},
+		{
+			// deletions of arguments that are not present are skipped
+			newDeletes: []string{"nosmt"},
+			cmdline:    []string{"rw"},
+			out:        []string{},
 		},
 	}
 
-	rand.Seed(time.Now().UnixNano())
 	for idx, test := range tests {
 		t.Run(fmt.Sprintf("case#%d", idx), func(t *testing.T) {
-			res := generateKargs(test.oldKargs, test.newKargs)
+			oldConfig := &mcfgv1.MachineConfig{Spec: mcfgv1.MachineConfigSpec{KernelArguments: test.oldKargs}}
+			newConfig := &mcfgv1.MachineConfig{Spec: mcfgv1.MachineConfigSpec{KernelArguments: test.newKargs, KernelArgumentsToDelete: test.newDeletes}}
+			res := generateKargs(oldConfig, newConfig, test.cmdline)
 
 			if !reflect.DeepEqual(test.out, res) {
 				t.Errorf("Failed kernel arguments processing: expected: %v but result is: %v", test.out, res)
@@ -557,6 +663,12 @@ func TestCalculatePostConfigChangeAction(t *testing.T) {
 		"policy2":         ctrlcommon.NewIgnFile("/etc/containers/policy.json", "policy2"),
 		"containers-gpg1": ctrlcommon.NewIgnFile("/etc/machine-config-daemon/no-reboot/containers-gpg.pub", "containers-gpg1"),
 		"containers-gpg2": ctrlcommon.NewIgnFile("/etc/machine-config-daemon/no-reboot/containers-gpg.pub", "containers-gpg2"),
+		"karg-tuning1":    ctrlcommon.NewIgnFile(ctrlcommon.KernelArgumentTuningPath, `{"allowedArguments":["nosmt"]}`),
+		"karg-tuning2":    ctrlcommon.NewIgnFile(ctrlcommon.KernelArgumentTuningPath, `{"allowedArguments":["nosmt","quiet"]}`),
+		"io-tuning1":      ctrlcommon.NewIgnFile(ctrlcommon.IOTuningPath, `{"queueDepth":64}`),
+		"io-tuning2":      ctrlcommon.NewIgnFile(ctrlcommon.IOTuningPath, `{"queueDepth":256}`),
+		"access-policy1":  ctrlcommon.NewIgnFile(ctrlcommon.InteractiveAccessPolicyPath, `{"action":"None"}`),
+		"access-policy2":  ctrlcommon.NewIgnFile(ctrlcommon.InteractiveAccessPolicyPath, `{"action":"Revalidate"}`),
 	}
 
 	tests := []struct {
@@ -636,6 +748,24 @@ func TestCalculatePostConfigChangeAction(t *testing.T) {
 			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["containers-gpg2"]}),
 			expectedAction: []string{postConfigChangeActionReloadCrio},
 		},
+		{
+			// test that updating the kernel argument tuning allowlist is none
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["karg-tuning1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["karg-tuning2"]}),
+			expectedAction: []string{postConfigChangeActionNone},
+		},
+		{
+			// test that updating the I/O tuning is none
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["io-tuning1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["io-tuning2"]}),
+			expectedAction: []string{postConfigChangeActionNone},
+		},
+		{
+			// test that updating the interactive access policy is none
+			oldConfig:      helpers.NewMachineConfig("00-test", nil, "dummy://", []ign3types.File{files["access-policy1"]}),
+			newConfig:      helpers.NewMachineConfig("01-test", nil, "dummy://", []ign3types.File{files["access-policy2"]}),
+			expectedAction: []string{postConfigChangeActionNone},
+		},
 	}
 
 	for idx, test := range tests {
@@ -669,6 +799,26 @@ func checkReconcilableResults(t *testing.T, key string, reconcilableError error)
 	}
 }
 
+func TestOSStagingDependsOnFiles(t *testing.T) {
+	tests := []struct {
+		diffFileSet []string
+		expected    bool
+	}{
+		{nil, false},
+		{[]string{"/etc/kubernetes/kubelet-ca.crt", "/etc/foo.conf"}, false},
+		{[]string{"/etc/foo.conf", "/var/lib/kubelet/config.json"}, true},
+		{[]string{"/etc/containers/registries.conf"}, true},
+		{[]string{"/etc/containers/policy.json"}, true},
+		{[]string{"/etc/containers/registries.d/quay.io.yaml"}, true},
+		{[]string{"/etc/mco/proxy.env"}, true},
+		{[]string{"/etc/pki/ca-trust/source/anchors/openshift-config-user-ca-bundle.crt"}, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, osStagingDependsOnFiles(test.diffFileSet), "%v", test.diffFileSet)
+	}
+}
+
 // checkIrreconcilableResults is a shortcut for verifing results that should be irreconcilable
 func checkIrreconcilableResults(t *testing.T, key string, reconcilableError error) {
 	if reconcilableError == nil {
@@ -2,6 +2,7 @@ package daemon
 
 import (
 	"fmt"
+	"time"
 
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/record"
@@ -19,7 +20,6 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	corev1 "k8s.io/api/core/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
-	corev1lister "k8s.io/client-go/listers/core/v1"
 )
 
 const (
@@ -32,6 +32,13 @@ const (
 	machineConfigDaemonSSHAccessValue = "accessed"
 
 	nodeWriterKubeconfigPath = "/var/lib/kubelet/kubeconfig"
+
+	// nodeWriterFieldManager is the field manager of the daemon's writes to its node
+	nodeWriterFieldManager = "machine-config-daemon"
+
+	// defaultWriterBatchWindow is how long the writer waits for more writes to
+	// send together with the first one
+	defaultWriterBatchWindow = 100 * time.Millisecond
 )
 
 type response struct {
@@ -49,8 +56,8 @@ type message struct {
 type clusterNodeWriter struct {
 	nodeName         string
 	writer           chan message
+	batchWindow      time.Duration
 	client           corev1client.NodeInterface
-	lister           corev1lister.NodeLister
 	nodeListerSynced cache.InformerSynced
 	kubeClient       kubernetes.Interface
 	// cached reference to node object - TODO change the daemon to read this too
@@ -93,7 +100,6 @@ func newNodeWriter(nodeName string, stopCh <-chan struct{}) (NodeWriter, error)
 	glog.Infof("NodeWriter initialized with credentials from %s", nodeWriterKubeconfigPath)
 	informer := informers.NewSharedInformerFactory(kubeClient, ctrlcommon.DefaultResyncPeriod()())
 	nodeInformer := informer.Core().V1().Nodes()
-	nodeLister := nodeInformer.Lister()
 	nodeListerSynced := nodeInformer.Informer().HasSynced
 
 	eventBroadcaster := record.NewBroadcaster()
@@ -103,10 +109,10 @@ func newNodeWriter(nodeName string, stopCh <-chan struct{}) (NodeWriter, error)
 	nw := &clusterNodeWriter{
 		nodeName:         nodeName,
 		client:           kubeClient.CoreV1().Nodes(),
-		lister:           nodeLister,
 		nodeListerSynced: nodeListerSynced,
 		recorder:         eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigdaemon", Host: nodeName}),
 		writer:           make(chan message, defaultWriterQueue),
+		batchWindow:      defaultWriterBatchWindow,
 		kubeClient:       kubeClient,
 	}
 
@@ -132,12 +138,47 @@ func (nw *clusterNodeWriter) Run(stop <-chan struct{}) {
 		case <-stop:
 			return
 		case msg := <-nw.writer:
-			r := implSetNodeAnnotations(nw.client, nw.lister, nw.nodeName, msg.annos)
-			msg.responseChannel <- r
+			nw.writeBatch(nw.collectBatch(msg, stop))
+		}
+	}
+}
+
+// collectBatch returns first along with the messages written within the
+// batch window after it, in the order they were written.
+func (nw *clusterNodeWriter) collectBatch(first message, stop <-chan struct{}) []message {
+	batch := []message{first}
+	timer := time.NewTimer(nw.batchWindow)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-nw.writer:
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch
+		case <-stop:
+			return batch
 		}
 	}
 }
 
+// writeBatch sets the annotations of all messages in one patch, later messages
+// winning, and sends every message the result.
+func (nw *clusterNodeWriter) writeBatch(batch []message) {
+	annos := make(map[string]string)
+	for _, msg := range batch {
+		for k, v := range msg.annos {
+			annos[k] = v
+		}
+	}
+	if len(batch) > 1 {
+		glog.V(4).Infof("Writing %d batched annotation updates to node %s", len(batch), nw.nodeName)
+	}
+	r := implSetNodeAnnotations(nw.client, nw.nodeName, annos)
+	for _, msg := range batch {
+		msg.responseChannel <- r
+	}
+}
+
 // SetDone sets the state to Done.
 func (nw *clusterNodeWriter) SetDone(dcAnnotation string) error {
 	annos := map[string]string{
@@ -263,12 +304,8 @@ func (nw *clusterNodeWriter) Eventf(eventtype, reason, messageFmt string, args .
 	nw.recorder.Eventf(getNodeRef(nw.node), eventtype, reason, messageFmt, args...)
 }
 
-func implSetNodeAnnotations(client corev1client.NodeInterface, lister corev1lister.NodeLister, nodeName string, m map[string]string) response {
-	node, err := internal.UpdateNodeRetry(client, lister, nodeName, func(node *corev1.Node) {
-		for k, v := range m {
-			node.Annotations[k] = v
-		}
-	})
+func implSetNodeAnnotations(client corev1client.NodeInterface, nodeName string, m map[string]string) response {
+	node, err := internal.PatchNodeAnnotations(client, nodeName, nodeWriterFieldManager, m)
 	return response{
 		node: node,
 		err:  err,
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+func TestNodeWriterBatchesWrites(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-0",
+		Annotations: map[string]string{"other-controller": "untouched"},
+	}}
+	kubeClient := k8sfake.NewSimpleClientset(node)
+	nw := &clusterNodeWriter{
+		nodeName:         node.Name,
+		writer:           make(chan message, defaultWriterQueue),
+		batchWindow:      time.Second,
+		client:           kubeClient.CoreV1().Nodes(),
+		nodeListerSynced: func() bool { return true },
+	}
+
+	// Queue the writes before the writer runs, so they land in one batch
+	first, second := make(chan response, 1), make(chan response, 1)
+	nw.writer <- message{
+		annos: map[string]string{
+			constants.MachineConfigDaemonStateAnnotationKey: constants.MachineConfigDaemonStateWorking,
+		},
+		responseChannel: first,
+	}
+	nw.writer <- message{
+		annos: map[string]string{
+			constants.MachineConfigDaemonStateAnnotationKey:  constants.MachineConfigDaemonStateDegraded,
+			constants.MachineConfigDaemonReasonAnnotationKey: "failed",
+		},
+		responseChannel: second,
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go nw.Run(stopCh)
+
+	for _, respChan := range []chan response{first, second} {
+		r := <-respChan
+		require.NoError(t, r.err)
+		// The later write wins, and annotations written by others are kept
+		assert.Equal(t, map[string]string{
+			"other-controller": "untouched",
+			constants.MachineConfigDaemonStateAnnotationKey:  constants.MachineConfigDaemonStateDegraded,
+			constants.MachineConfigDaemonReasonAnnotationKey: "failed",
+		}, r.node.Annotations)
+	}
+
+	actions := kubeClient.Actions()
+	require.Len(t, actions, 1)
+	require.True(t, actions[0].Matches("patch", "nodes"))
+	assert.Equal(t,
+		`{"metadata":{"annotations":{"machineconfiguration.openshift.io/reason":"failed","machineconfiguration.openshift.io/state":"Degraded"}}}`,
+		string(actions[0].(core.PatchAction).GetPatch()))
+}
@@ -112,6 +112,9 @@ type Operator struct {
 	stopCh <-chan struct{}
 
 	renderConfig *renderConfig
+
+	// upgradeHazardChecks overrides defaultUpgradeHazardChecks when set
+	upgradeHazardChecks []upgradeHazardCheck
 }
 
 // New returns a new machine config operator.
@@ -110,6 +110,26 @@ func TestRenderAsset(t *testing.T) {
 			TargetNamespace: "testing-namespace",
 		},
 		Error: true,
+	}, {
+		// Test that the machine-config-controller Deployment is rendered correctly with proxy config
+		Path: "manifests/machineconfigcontroller/deployment.yaml",
+		RenderConfig: &renderConfig{
+			TargetNamespace: "testing-namespace",
+			Images: &RenderConfigImages{
+				MachineConfigOperator: "mco-operator-image",
+				OauthProxy:            "oauth-proxy-image",
+			},
+			ControllerConfig: mcfgv1.ControllerConfigSpec{
+				Proxy: &configv1.ProxyStatus{
+					HTTPSProxy: "https://i.am.a.proxy.server",
+					NoProxy:    "*",
+				},
+			},
+		},
+		FindExpected: []string{
+			"- name: HTTPS_PROXY\n            value: https://i.am.a.proxy.server",
+			"- name: NO_PROXY\n            value: \"*\"",
+		},
 	}, {
 		// Test that machineconfigdaemon DaemonSets are rendered correctly with proxy config
 		Path: "manifests/machineconfigdaemon/daemonset.yaml",
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	configv1 "github.com/openshift/api/config/v1"
@@ -20,6 +22,7 @@ import (
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	v1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 )
 
 // syncVersion handles reporting the version to the clusteroperator
@@ -321,6 +324,13 @@ func (optr *Operator) syncUpgradeableStatus() error {
 
 	// don't overwrite status if updating or degraded
 	if !updating && !degraded {
+		if hazard := optr.findUpgradeHazard(pools); hazard != nil {
+			coStatus.Status = configv1.ConditionFalse
+			coStatus.Reason = hazard.reason
+			coStatus.Message = hazard.message
+			return optr.updateStatus(co, coStatus)
+		}
+
 		skewStatus, status, err := optr.isKubeletSkewSupported(pools)
 		if err != nil {
 			glog.Errorf("Error checking version skew: %v, kubelet skew status: %v, status reason: %v, status message: %v", err, skewStatus, status.Reason, status.Message)
@@ -533,18 +543,68 @@ func (optr *Operator) initializeClusterOperator() (*configv1.ClusterOperator, er
 	return optr.configClient.ConfigV1().ClusterOperators().UpdateStatus(context.TODO(), co, metav1.UpdateOptions{})
 }
 
+// operatorStatusExtension is the structured payload published in the
+// clusteroperator's status.extension field. It is meant to be consumed by
+// tooling such as must-gather, so fields should only ever be added.
+type operatorStatusExtension struct {
+	Pools         map[string]poolStatusExtension `json:"pools"`
+	LastSyncError string                         `json:"lastSyncError,omitempty"`
+}
+
+// poolStatusExtension reports the rollout state of a single pool.
+type poolStatusExtension struct {
+	// Summary is a human readable description of the pool state
+	Summary                 string               `json:"summary"`
+	TargetConfig            string               `json:"targetConfig"`
+	CurrentConfig           string               `json:"currentConfig"`
+	MachineCount            int32                `json:"machineCount"`
+	UpdatedMachineCount     int32                `json:"updatedMachineCount"`
+	ReadyMachineCount       int32                `json:"readyMachineCount"`
+	UnavailableMachineCount int32                `json:"unavailableMachineCount"`
+	DegradedMachineCount    int32                `json:"degradedMachineCount"`
+	DegradedNodes           []degradedNodeStatus `json:"degradedNodes,omitempty"`
+	InProgressNodes         []inProgressNode     `json:"inProgressNodes,omitempty"`
+	// OldestInProgressNode is the in-progress node that has been updating the longest
+	OldestInProgressNode *inProgressNode `json:"oldestInProgressNode,omitempty"`
+}
+
+// degradedNodeStatus is a node whose MCD reported Degraded or Unreconcilable.
+type degradedNodeStatus struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Reason string `json:"reason"`
+}
+
+// inProgressNode is a node that is updating towards TargetConfig. Since is the
+// first time the operator observed it doing so and is carried over from the
+// previous extension, so it survives operator restarts.
+type inProgressNode struct {
+	Name         string      `json:"name"`
+	TargetConfig string      `json:"targetConfig"`
+	Since        metav1.Time `json:"since"`
+}
+
 // setOperatorStatusExtension sets the raw extension field of the clusteroperator. Today, we set
 // the MCPs statuses and an optional error status which we may get during a sync.
 func (optr *Operator) setOperatorStatusExtension(status *configv1.ClusterOperatorStatus, statusErr error) {
-	statuses, err := optr.allMachineConfigPoolStatus()
+	previous := operatorStatusExtension{}
+	if len(status.Extension.Raw) > 0 {
+		// Older operators published a plain map of strings, in which case
+		// there is nothing to carry over.
+		if err := json.Unmarshal(status.Extension.Raw, &previous); err != nil {
+			glog.V(4).Infof("Ignoring unparseable previous clusteroperator extension: %v", err)
+		}
+	}
+	pools, err := optr.allMachineConfigPoolStatus(previous.Pools, time.Now())
 	if err != nil {
 		glog.Error(err)
 		return
 	}
+	ext := operatorStatusExtension{Pools: pools}
 	if statusErr != nil {
-		statuses["lastSyncError"] = statusErr.Error()
+		ext.LastSyncError = statusErr.Error()
 	}
-	raw, err := json.Marshal(statuses)
+	raw, err := json.Marshal(ext)
 	if err != nil {
 		glog.Error(err)
 		return
@@ -552,18 +612,109 @@ func (optr *Operator) setOperatorStatusExtension(status *configv1.ClusterOperato
 	status.Extension.Raw = raw
 }
 
-func (optr *Operator) allMachineConfigPoolStatus() (map[string]string, error) {
+func (optr *Operator) allMachineConfigPoolStatus(previous map[string]poolStatusExtension, now time.Time) (map[string]poolStatusExtension, error) {
 	pools, err := optr.mcpLister.List(labels.Everything())
 	if err != nil {
 		return nil, err
 	}
-	ret := map[string]string{}
+	nodesByPool, err := optr.getNodesByPool(pools)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]poolStatusExtension{}
 	for _, pool := range pools {
-		ret[pool.GetName()] = machineConfigPoolStatus(pool)
+		ret[pool.GetName()] = machineConfigPoolStatusExtension(pool, nodesByPool[pool.GetName()], previous[pool.GetName()], now)
 	}
 	return ret, nil
 }
 
+// getNodesByPool maps pool names to their nodes. Like the node controller, a
+// node selected by a custom pool is only reported there and not under worker.
+func (optr *Operator) getNodesByPool(pools []*mcfgv1.MachineConfigPool) (map[string][]*corev1.Node, error) {
+	poolsByNode := map[string][]string{}
+	nodes := map[string]*corev1.Node{}
+	for _, pool := range pools {
+		selector, err := metav1.LabelSelectorAsSelector(pool.Spec.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("label selector for pool %v failed %w", pool.Name, err)
+		}
+		poolNodes, err := optr.nodeLister.List(selector)
+		if err != nil {
+			return nil, fmt.Errorf("could not list nodes for pool %v with error %w", pool.Name, err)
+		}
+		for _, node := range poolNodes {
+			poolsByNode[node.Name] = append(poolsByNode[node.Name], pool.Name)
+			nodes[node.Name] = node
+		}
+	}
+	ret := map[string][]*corev1.Node{}
+	for nodeName, poolNames := range poolsByNode {
+		for _, poolName := range poolNames {
+			if poolName == ctrlcommon.MachineConfigPoolWorker && len(poolNames) > 1 {
+				continue
+			}
+			ret[poolName] = append(ret[poolName], nodes[nodeName])
+		}
+	}
+	for _, poolNodes := range ret {
+		sort.Slice(poolNodes, func(i, j int) bool { return poolNodes[i].Name < poolNodes[j].Name })
+	}
+	return ret, nil
+}
+
+// machineConfigPoolStatusExtension builds the structured status of a pool. Start
+// times of in-progress nodes are taken from previous when the node is still
+// updating towards the same config.
+func machineConfigPoolStatusExtension(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node, previous poolStatusExtension, now time.Time) poolStatusExtension {
+	ext := poolStatusExtension{
+		Summary:                 machineConfigPoolStatus(pool),
+		TargetConfig:            pool.Spec.Configuration.Name,
+		CurrentConfig:           pool.Status.Configuration.Name,
+		MachineCount:            pool.Status.MachineCount,
+		UpdatedMachineCount:     pool.Status.UpdatedMachineCount,
+		ReadyMachineCount:       pool.Status.ReadyMachineCount,
+		UnavailableMachineCount: pool.Status.UnavailableMachineCount,
+		DegradedMachineCount:    pool.Status.DegradedMachineCount,
+	}
+
+	previousSince := map[string]inProgressNode{}
+	for _, n := range previous.InProgressNodes {
+		previousSince[n.Name] = n
+	}
+
+	for _, node := range nodes {
+		state := node.Annotations[daemonconsts.MachineConfigDaemonStateAnnotationKey]
+		current := node.Annotations[daemonconsts.CurrentMachineConfigAnnotationKey]
+		desired := node.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey]
+		switch {
+		case state == daemonconsts.MachineConfigDaemonStateDegraded || state == daemonconsts.MachineConfigDaemonStateUnreconcilable:
+			ext.DegradedNodes = append(ext.DegradedNodes, degradedNodeStatus{
+				Name:   node.Name,
+				State:  state,
+				Reason: node.Annotations[daemonconsts.MachineConfigDaemonReasonAnnotationKey],
+			})
+		case desired != "" && (desired != current || state == daemonconsts.MachineConfigDaemonStateWorking):
+			since := metav1.NewTime(now)
+			if prev, ok := previousSince[node.Name]; ok && prev.TargetConfig == desired && !prev.Since.IsZero() {
+				since = prev.Since
+			}
+			ext.InProgressNodes = append(ext.InProgressNodes, inProgressNode{
+				Name:         node.Name,
+				TargetConfig: desired,
+				Since:        since,
+			})
+		}
+	}
+
+	for i := range ext.InProgressNodes {
+		n := ext.InProgressNodes[i]
+		if ext.OldestInProgressNode == nil || n.Since.Before(&ext.OldestInProgressNode.Since) {
+			ext.OldestInProgressNode = &n
+		}
+	}
+	return ext
+}
+
 // isMachineConfigPoolConfigurationValid returns nil, or error when the configuration of a `pool` is created by the controller at version `version`,
 // when the osImageURL does not match what's in the configmap or when the rendered-config-xxx does not match the OCP release version.
 func isMachineConfigPoolConfigurationValid(pool *mcfgv1.MachineConfigPool, version, releaseVersion, osURL string, machineConfigGetter func(string) (*mcfgv1.MachineConfig, error)) error {
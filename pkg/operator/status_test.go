@@ -2,10 +2,12 @@ package operator
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	corelisterv1 "k8s.io/client-go/listers/core/v1"
 	clientgotesting "k8s.io/client-go/testing"
@@ -17,6 +19,7 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/util/uuid"
 
 	configv1 "github.com/openshift/api/config/v1"
@@ -24,6 +27,8 @@ import (
 	cov1helpers "github.com/openshift/library-go/pkg/config/clusteroperator/v1helpers"
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	mcfglistersv1 "github.com/openshift/machine-config-operator/pkg/generated/listers/machineconfiguration.openshift.io/v1"
 	"github.com/openshift/machine-config-operator/test/helpers"
 )
 
@@ -1019,3 +1024,127 @@ func TestGetMinorKubeletVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestUpgradeHazardChecks(t *testing.T) {
+	mcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	optr := &Operator{mcLister: mcfglistersv1.NewMachineConfigLister(mcIndexer)}
+	generated := helpers.NewMachineConfig("00-worker", nil, "quay.io/release/os@sha256:1234", nil)
+	generated.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(&mcfgv1.ControllerConfig{ObjectMeta: metav1.ObjectMeta{Name: "machine-config-controller"}},
+		mcfgv1.SchemeGroupVersion.WithKind("ControllerConfig"))}
+	mcIndexer.Add(generated)
+	mcIndexer.Add(helpers.NewMachineConfig("99-worker-os", nil, "quay.io/custom/os@sha256:abcd", nil))
+
+	sourceRefs := func(names ...string) []corev1.ObjectReference {
+		refs := []corev1.ObjectReference{}
+		for _, name := range names {
+			refs = append(refs, corev1.ObjectReference{Name: name})
+		}
+		return refs
+	}
+
+	worker := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-1")
+	worker.Status.Configuration.Name = "rendered-worker-1"
+	worker.Spec.Configuration.Source = sourceRefs("00-worker")
+	hazard := optr.findUpgradeHazard([]*mcfgv1.MachineConfigPool{worker})
+	assert.Nil(t, hazard)
+
+	paused := worker.DeepCopy()
+	paused.Spec.Paused = true
+	paused.Spec.Configuration.Name = "rendered-worker-2"
+	hazard = optr.findUpgradeHazard([]*mcfgv1.MachineConfigPool{paused})
+	if assert.NotNil(t, hazard) {
+		assert.Equal(t, "PausedPoolPendingConfig", hazard.reason)
+		assert.Contains(t, hazard.message, "worker (pending rendered-worker-2)")
+	}
+
+	overridden := worker.DeepCopy()
+	overridden.Spec.Configuration.Source = sourceRefs("00-worker", "99-worker-os", "deleted-mc")
+	hazard = optr.findUpgradeHazard([]*mcfgv1.MachineConfigPool{overridden})
+	if assert.NotNil(t, hazard) {
+		assert.Equal(t, "OSImageURLOverridden", hazard.reason)
+		assert.Contains(t, hazard.message, "worker (via 99-worker-os)")
+	}
+
+	// custom check lists replace the defaults and a failing check does not block the rest
+	optr.upgradeHazardChecks = []upgradeHazardCheck{
+		{name: "failing", fn: func(*Operator, []*mcfgv1.MachineConfigPool) (*upgradeHazard, error) {
+			return nil, errors.New("boom")
+		}},
+		{name: "custom", fn: func(*Operator, []*mcfgv1.MachineConfigPool) (*upgradeHazard, error) {
+			return &upgradeHazard{reason: "Custom", message: "custom hazard"}, nil
+		}},
+	}
+	hazard = optr.findUpgradeHazard([]*mcfgv1.MachineConfigPool{overridden})
+	if assert.NotNil(t, hazard) {
+		assert.Equal(t, "Custom", hazard.reason)
+	}
+}
+
+func TestOperatorStatusExtension(t *testing.T) {
+	newNode := func(name string, labels map[string]string, current, desired, state, reason string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+				Annotations: map[string]string{
+					daemonconsts.CurrentMachineConfigAnnotationKey:      current,
+					daemonconsts.DesiredMachineConfigAnnotationKey:      desired,
+					daemonconsts.MachineConfigDaemonStateAnnotationKey:  state,
+					daemonconsts.MachineConfigDaemonReasonAnnotationKey: reason,
+				},
+			},
+		}
+	}
+	workerLabels := map[string]string{"node-role/worker": ""}
+	infraLabels := map[string]string{"node-role/worker": "", "node-role/infra": ""}
+
+	worker := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "rendered-worker-2")
+	worker.Status.Configuration.Name = "rendered-worker-1"
+	worker.Status.MachineCount = 3
+	worker.Status.UpdatedMachineCount = 1
+	worker.Status.DegradedMachineCount = 1
+	infra := helpers.NewMachineConfigPool("infra", nil, helpers.InfraSelector, "rendered-infra-1")
+
+	optr := &Operator{}
+	optr.mcpLister = &mockMCPLister{pools: []*mcfgv1.MachineConfigPool{worker, infra}}
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	optr.nodeLister = corelisterv1.NewNodeLister(nodeIndexer)
+	nodeIndexer.Add(newNode("worker-0", workerLabels, "rendered-worker-2", "rendered-worker-2", daemonconsts.MachineConfigDaemonStateDone, ""))
+	nodeIndexer.Add(newNode("worker-1", workerLabels, "rendered-worker-1", "rendered-worker-2", daemonconsts.MachineConfigDaemonStateWorking, ""))
+	nodeIndexer.Add(newNode("worker-2", workerLabels, "rendered-worker-1", "rendered-worker-2", daemonconsts.MachineConfigDaemonStateDegraded, "failed to drain"))
+	nodeIndexer.Add(newNode("infra-0", infraLabels, "rendered-infra-1", "rendered-infra-1", daemonconsts.MachineConfigDaemonStateDone, ""))
+
+	since := metav1.NewTime(time.Now().Add(-10 * time.Minute).Truncate(time.Second))
+	previous, err := json.Marshal(operatorStatusExtension{
+		Pools: map[string]poolStatusExtension{
+			"worker": {InProgressNodes: []inProgressNode{{Name: "worker-1", TargetConfig: "rendered-worker-2", Since: since}}},
+		},
+	})
+	require.Nil(t, err)
+	status := &configv1.ClusterOperatorStatus{}
+	status.Extension.Raw = previous
+	optr.setOperatorStatusExtension(status, errors.New("sync failed"))
+
+	ext := operatorStatusExtension{}
+	require.Nil(t, json.Unmarshal(status.Extension.Raw, &ext))
+	assert.Equal(t, "sync failed", ext.LastSyncError)
+	require.Contains(t, ext.Pools, "worker")
+	require.Contains(t, ext.Pools, "infra")
+
+	workerExt := ext.Pools["worker"]
+	assert.Equal(t, "rendered-worker-2", workerExt.TargetConfig)
+	assert.Equal(t, "rendered-worker-1", workerExt.CurrentConfig)
+	assert.Equal(t, int32(3), workerExt.MachineCount)
+	assert.Equal(t, int32(1), workerExt.DegradedMachineCount)
+	assert.Equal(t, []degradedNodeStatus{{Name: "worker-2", State: daemonconsts.MachineConfigDaemonStateDegraded, Reason: "failed to drain"}}, workerExt.DegradedNodes)
+	require.Len(t, workerExt.InProgressNodes, 1)
+	require.NotNil(t, workerExt.OldestInProgressNode)
+	assert.Equal(t, "worker-1", workerExt.OldestInProgressNode.Name)
+	assert.True(t, since.Equal(&workerExt.OldestInProgressNode.Since))
+
+	// infra-0 is only reported under its custom pool
+	assert.Empty(t, ext.Pools["infra"].InProgressNodes)
+	for _, n := range workerExt.InProgressNodes {
+		assert.NotEqual(t, "infra-0", n.Name)
+	}
+}
@@ -0,0 +1,124 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// upgradeHazard is a known hazardous state that makes the cluster
+// Upgradeable=False until an admin resolves it.
+type upgradeHazard struct {
+	// reason is the CamelCase reason reported on the Upgradeable condition
+	reason string
+	// message explains the hazard and how to remediate it
+	message string
+}
+
+// upgradeHazardCheck inspects the pools for a specific hazard. It returns nil
+// if the hazard is not present.
+type upgradeHazardCheck struct {
+	name string
+	fn   func(optr *Operator, pools []*mcfgv1.MachineConfigPool) (*upgradeHazard, error)
+}
+
+// defaultUpgradeHazardChecks are evaluated in order by syncUpgradeableStatus once
+// no pool is degraded or updating. The first hazard found is reported.
+var defaultUpgradeHazardChecks = []upgradeHazardCheck{
+	{name: "PausedPoolPendingConfig", fn: checkPausedPoolsWithPendingConfig},
+	{name: "OSImageURLOverridden", fn: checkOSImageURLOverridden},
+}
+
+// getUpgradeHazardChecks returns the hazard checks configured on the operator,
+// falling back to defaultUpgradeHazardChecks.
+func (optr *Operator) getUpgradeHazardChecks() []upgradeHazardCheck {
+	if optr.upgradeHazardChecks != nil {
+		return optr.upgradeHazardChecks
+	}
+	return defaultUpgradeHazardChecks
+}
+
+// findUpgradeHazard runs the configured hazard checks and returns the first
+// hazard found. A failing check is skipped so it cannot block the others.
+func (optr *Operator) findUpgradeHazard(pools []*mcfgv1.MachineConfigPool) *upgradeHazard {
+	for _, check := range optr.getUpgradeHazardChecks() {
+		hazard, err := check.fn(optr, pools)
+		if err != nil {
+			glog.Errorf("Error running upgrade hazard check %s: %v", check.name, err)
+			continue
+		}
+		if hazard != nil {
+			glog.Infof("Upgrade hazard %s found: %s", check.name, hazard.message)
+			return hazard
+		}
+	}
+	return nil
+}
+
+// checkPausedPoolsWithPendingConfig reports pools that are paused while a newer
+// rendered config is waiting to be rolled out. Upgrading would stack the
+// release's changes on top of the pending ones once the pool is unpaused.
+func checkPausedPoolsWithPendingConfig(_ *Operator, pools []*mcfgv1.MachineConfigPool) (*upgradeHazard, error) {
+	pending := []string{}
+	for _, pool := range pools {
+		if !pool.Spec.Paused || pool.Spec.Configuration.Name == "" {
+			continue
+		}
+		if pool.Spec.Configuration.Name != pool.Status.Configuration.Name {
+			pending = append(pending, fmt.Sprintf("%s (pending %s)", pool.Name, pool.Spec.Configuration.Name))
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	sort.Strings(pending)
+	return &upgradeHazard{
+		reason: "PausedPoolPendingConfig",
+		message: fmt.Sprintf("One or more machine config pools are paused with a pending rendered config: %s. "+
+			"Unpause the pools and let them finish updating, or revert the pending MachineConfig changes, before upgrading",
+			strings.Join(pending, ", ")),
+	}, nil
+}
+
+// checkOSImageURLOverridden reports pools whose rendered config uses an
+// OSImageURL set by a MachineConfig instead of the one from the release payload.
+// Such pools would not pick up the OS shipped with the new release. Controller
+// generated configs such as 00-worker carry the payload OSImageURL and are
+// skipped.
+func checkOSImageURLOverridden(optr *Operator, pools []*mcfgv1.MachineConfigPool) (*upgradeHazard, error) {
+	overrides := []string{}
+	for _, pool := range pools {
+		for _, source := range pool.Spec.Configuration.Source {
+			mc, err := optr.mcLister.Get(source.Name)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if metav1.GetControllerOf(mc) != nil {
+				continue
+			}
+			if mc.Spec.OSImageURL != "" {
+				overrides = append(overrides, fmt.Sprintf("%s (via %s)", pool.Name, mc.Name))
+			}
+		}
+	}
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	sort.Strings(overrides)
+	return &upgradeHazard{
+		reason: "OSImageURLOverridden",
+		message: fmt.Sprintf("One or more machine config pools use a custom OSImageURL: %s. "+
+			"Nodes in these pools will not receive the OS update from the new release; remove the osImageURL "+
+			"from these MachineConfigs, or update it to an image built for the target release, before upgrading",
+			strings.Join(overrides, ", ")),
+	}, nil
+}
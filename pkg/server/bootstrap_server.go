@@ -6,6 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	yaml "github.com/ghodss/yaml"
 	"github.com/golang/glog"
@@ -20,25 +23,62 @@ import (
 // Server interface.
 var _ = Server(&bootstrapServer{})
 
+// AllBootstrapPools in the pool allowlist lets the bootstrap server
+// serve every pool found in its base directory.
+const AllBootstrapPools = "*"
+
 type bootstrapServer struct {
 
 	// serverBaseDir is the root, relative to which
 	// the MachineConfigPool configs will be picked
 	serverBaseDir string
 
+	// allowedPools are the pools the server may serve,
+	// or AllBootstrapPools.
+	allowedPools []string
+
 	kubeconfigFunc kubeconfigFunc
 }
 
 // NewBootstrapServer initializes a new Bootstrap server that implements
-// the Server interface.
-func NewBootstrapServer(dir, kubeconfig string) (Server, error) {
+// the Server interface. It serves the pools in allowedPools that have been
+// rendered into dir.
+func NewBootstrapServer(dir, kubeconfig string, allowedPools []string) (Server, error) {
 	if _, err := os.Stat(kubeconfig); err != nil {
 		return nil, fmt.Errorf("kubeconfig not found at location: %s", kubeconfig)
 	}
-	return &bootstrapServer{
+	if len(allowedPools) == 0 {
+		return nil, fmt.Errorf("no pools allowed to be served")
+	}
+	bsc := &bootstrapServer{
 		serverBaseDir:  dir,
+		allowedPools:   allowedPools,
 		kubeconfigFunc: func() ([]byte, []byte, error) { return kubeconfigFromFile(kubeconfig) },
-	}, nil
+	}
+
+	// Pools can still be rendered after startup, so this is only informational
+	paths, err := filepath.Glob(path.Join(dir, "machine-pools", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var available []string
+	for _, p := range paths {
+		if pool := strings.TrimSuffix(filepath.Base(p), ".yaml"); bsc.poolAllowed(pool) {
+			available = append(available, pool)
+		}
+	}
+	sort.Strings(available)
+	glog.Infof("Bootstrap server allows pools %v, of which %v are rendered in %s", allowedPools, available, dir)
+	return bsc, nil
+}
+
+func (bsc *bootstrapServer) poolAllowed(pool string) bool {
+	for _, allowed := range bsc.allowedPools {
+		if allowed == AllBootstrapPools || allowed == pool {
+			return true
+		}
+	}
+	return false
 }
 
 // GetConfig fetches the machine config(type - Ignition) from the bootstrap server,
@@ -58,8 +98,9 @@ func NewBootstrapServer(dir, kubeconfig string) (Server, error) {
 // 4. Append the machine annotations file.
 // 5. Append the KubeConfig file.
 func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*runtime.RawExtension, error) {
-	if cr.machineConfigPool != "master" {
-		return nil, fmt.Errorf("refusing to serve bootstrap configuration to pool %q", cr.machineConfigPool)
+	if !bsc.poolAllowed(cr.machineConfigPool) {
+		MCSBootstrapRefused.WithLabelValues(refusedNotAllowed).Inc()
+		return nil, fmt.Errorf("refusing to serve bootstrap configuration to pool %q: allowed pools are %v", cr.machineConfigPool, bsc.allowedPools)
 	}
 	// 1. Read the Machine Config Pool object.
 	fileName := path.Join(bsc.serverBaseDir, "machine-pools", cr.machineConfigPool+".yaml")
@@ -67,6 +108,7 @@ func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*runtime.RawExtension, er
 	data, err := ioutil.ReadFile(fileName)
 	if os.IsNotExist(err) {
 		glog.Errorf("could not find file: %s", fileName)
+		MCSBootstrapRefused.WithLabelValues(refusedNotRendered).Inc()
 		return nil, nil
 	}
 	if err != nil {
@@ -87,6 +129,7 @@ func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*runtime.RawExtension, er
 	data, err = ioutil.ReadFile(fileName)
 	if os.IsNotExist(err) {
 		glog.Errorf("could not find file: %s", fileName)
+		MCSBootstrapRefused.WithLabelValues(refusedNotRendered).Inc()
 		return nil, nil
 	}
 	if err != nil {
@@ -114,6 +157,8 @@ func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*runtime.RawExtension, er
 	if err != nil {
 		return nil, err
 	}
+	glog.Infof("Serving bootstrap config %s to pool %s", currConf, cr.machineConfigPool)
+	MCSBootstrapServed.WithLabelValues(cr.machineConfigPool).Inc()
 	return &runtime.RawExtension{Raw: rawConf}, nil
 }
 
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// DefaultBindAddress is the port for the metrics listener
+	DefaultBindAddress = ":8797"
+
+	// refusedNotAllowed means the requested pool is not in the allowlist
+	refusedNotAllowed = "NotAllowed"
+	// refusedNotRendered means the requested pool or its rendered config is not on disk
+	refusedNotRendered = "NotRendered"
+)
+
+var (
+	// MCSBootstrapServed counts the configs served by the bootstrap server per pool
+	MCSBootstrapServed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcs_bootstrap_configs_served_total",
+			Help: "Number of configs served by the bootstrap machine config server, per pool",
+		}, []string{"pool"})
+
+	// MCSBootstrapRefused counts the config requests refused by the bootstrap server. The
+	// pool is not a label, as requests for arbitrary pools would make it unbounded.
+	MCSBootstrapRefused = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcs_bootstrap_configs_refused_total",
+			Help: "Number of config requests refused by the bootstrap machine config server, per reason",
+		}, []string{"reason"})
+
+	metricsList = []prometheus.Collector{
+		MCSBootstrapServed,
+		MCSBootstrapRefused,
+	}
+)
+
+func registerMCSMetrics() error {
+	for _, metric := range metricsList {
+		if err := prometheus.Register(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartMetricsListener is metrics listener via http on localhost
+func StartMetricsListener(addr string, stopCh <-chan struct{}) {
+	if addr == "" {
+		addr = DefaultBindAddress
+	}
+
+	glog.Info("Registering Prometheus metrics")
+	if err := registerMCSMetrics(); err != nil {
+		glog.Errorf("unable to register metrics: %v", err)
+		return
+	}
+
+	glog.Infof("Starting metrics listener on %s", addr)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s := http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("metrics listener exited with error: %v", err)
+		}
+	}()
+	<-stopCh
+	if err := s.Shutdown(context.Background()); err != nil && err != http.ErrServerClosed {
+		glog.Errorf("error stopping metrics listener: %v", err)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	ign3_1 "github.com/coreos/ignition/v2/config/v3_2"
 	ign3types "github.com/coreos/ignition/v2/config/v3_2/types"
 	yaml "github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -172,6 +173,7 @@ func TestBootstrapServer(t *testing.T) {
 	// initialize bootstrap server and get config.
 	bs := &bootstrapServer{
 		serverBaseDir:  testDir,
+		allowedPools:   []string{"master"},
 		kubeconfigFunc: func() ([]byte, []byte, error) { return getKubeConfigContent(t) },
 	}
 	if err != nil {
@@ -196,13 +198,40 @@ func TestBootstrapServer(t *testing.T) {
 	validateIgnitionFiles(t, ignCfg.Storage.Files, resCfg.Storage.Files)
 	validateIgnitionSystemd(t, ignCfg.Systemd.Units, resCfg.Systemd.Units)
 
-	// verify bootstrap cannot serve ignition to other pool than master
+	// verify bootstrap cannot serve ignition to pools that aren't allowed
+	refused := testutil.ToFloat64(MCSBootstrapRefused.WithLabelValues(refusedNotAllowed))
 	res, err = bs.GetConfig(poolRequest{
 		machineConfigPool: testPool,
 	})
 	if err == nil {
-		t.Fatalf("expected bootstrap server to not serve ignition to non-master pools")
+		t.Fatalf("expected bootstrap server to not serve ignition to pools outside the allowlist")
 	}
+	assert.Equal(t, refused+1, testutil.ToFloat64(MCSBootstrapRefused.WithLabelValues(refusedNotAllowed)))
+
+	// once allowed, other rendered pools are served the same way
+	bs.allowedPools = []string{AllBootstrapPools}
+	served := testutil.ToFloat64(MCSBootstrapServed.WithLabelValues(testPool))
+	res, err = bs.GetConfig(poolRequest{
+		machineConfigPool: testPool,
+	})
+	if err != nil {
+		t.Fatalf("expected err to be nil, received: %v", err)
+	}
+	resCfg, err = ctrlcommon.ParseAndConvertConfig(res.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateIgnitionFiles(t, ignCfg.Storage.Files, resCfg.Storage.Files)
+	assert.Equal(t, served+1, testutil.ToFloat64(MCSBootstrapServed.WithLabelValues(testPool)))
+
+	// pools that aren't rendered are not found
+	res, err = bs.GetConfig(poolRequest{
+		machineConfigPool: "infra",
+	})
+	if err != nil {
+		t.Fatalf("expected err to be nil, received: %v", err)
+	}
+	assert.Nil(t, res)
 }
 
 // TestClusterServer tests the behavior of the machine config server